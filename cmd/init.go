@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/remote"
+)
+
+var (
+	initFlag      bool
+	initForceFlag bool
+)
+
+// getInitDir returns the directory --init scaffolds: --dir, or ~/dotfiles
+func getInitDir() (string, error) {
+	if remote.IsGitURL(dirFlag) {
+		return "", fmt.Errorf("--init needs a local directory, not a git URL")
+	}
+	if dirFlag != "" {
+		return dirFlag, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, "dotfiles"), nil
+}
+
+// initDotfiles scaffolds a new dotfiles repository in dir
+func initDotfiles(dir string, force bool) error {
+	log := logger.GetLogger()
+
+	if err := config.InitWithForce(dir, force); err != nil {
+		return fmt.Errorf("init failed: %w", err)
+	}
+
+	log.Info().Str("dotfiles_dir", dir).Msgf("Created a dotfiles repository with the sample module %s; edit it, then run dotman install", config.ExampleModule)
+	return nil
+}
+
+func init() {
+	rootCmd.Flags().BoolVar(&initFlag, "init", false, "Scaffold a new dotfiles repository in --dir (default ~/dotfiles)")
+	rootCmd.Flags().BoolVar(&initForceFlag, "force", false, "With --init, scaffold into a directory that is not empty")
+}
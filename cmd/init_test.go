@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitDotfiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	t.Run("scaffolded repository validates", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "dotfiles")
+		require.NoError(t, initDotfiles(dir, false))
+
+		require.NoError(t, validate(context.Background(), dir, validateOptions{Mkdir: true}))
+	})
+
+	t.Run("git URL is refused", func(t *testing.T) {
+		oldDir := dirFlag
+		dirFlag = "https://github.com/user/dotfiles.git"
+		defer func() { dirFlag = oldDir }()
+
+		_, err := getInitDir()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not a git URL")
+	})
+}
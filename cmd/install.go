@@ -1,20 +1,102 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/logger"
 	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/elmhuangyu/dotman/pkg/watch"
 	"github.com/spf13/cobra"
 )
 
 var (
-	dryRunFlag bool
-	forceFlag  bool
-	mkdirFlag  bool
+	dryRunFlag        bool
+	forceFlag         bool
+	mkdirFlag         bool
+	outFlag           string
+	outFormatFlag     string
+	explainFlag       bool
+	keepGoingFlag     bool
+	repairFlag        bool
+	preflightFlag     bool
+	transactionalFlag bool
+	linkModeFlag      string
+	summaryOnlyFlag   bool
+	privilegedFlag    bool
+	watchFlag         bool
+	excludeTargetFlag []string
+	verifyOnSkipFlag  bool
+	recordVarsFlag    bool
+	noCleanupFlag     bool
+	resumeFlag        bool
+	showDiffFlag      bool
+	skipTemplatesFlag bool
+	checkWritableFlag bool
+	moduleFlag        string
+	targetDirFlag     string
+	sourceDirFlag     string
+	hashCacheFlag     bool
 )
 
+// installOptions contains the command line options of the install command
+type installOptions struct {
+	DryRun    bool
+	Force     bool
+	Mkdir     bool
+	Out       string
+	OutFormat string
+	Explain   bool
+	KeepGoing bool
+	Repair    bool
+	Preflight bool
+	// SkipFailedTemplates leaves out templates that fail to render instead of failing their module
+	SkipFailedTemplates bool
+	// CheckWritable fails validation when a target directory can't be written
+	CheckWritable bool
+	// Transactional undoes every applied operation when the installation fails
+	Transactional bool
+	// LinkMode is how non-template files are installed, symlink or auto
+	LinkMode module.LinkMode
+	// SummaryOut receives the one-line machine summary of the result when set
+	SummaryOut io.Writer
+	// Profile selects the state file, so profiles are installed and uninstalled independently
+	Profile string
+	// AllowPrivileged enables the privileged_cmd fallback for symlinks the user may not create
+	AllowPrivileged bool
+	// ExcludeTargets are absolute target paths or globs this run leaves alone
+	ExcludeTargets []string
+	// VerifyOnSkip hashes the sources of already correct links and checks their mode and owner
+	VerifyOnSkip bool
+	// RecordVars stores the vars of every template and generated file in the state file
+	RecordVars bool
+	// NoReinstallCleanup skips uninstalling the previous installation recorded in the state
+	// file before installing, leaving targets dropped from the configuration in place
+	NoReinstallCleanup bool
+	// Resume continues an interrupted installation, leaving targets it already installed alone;
+	// it implies NoReinstallCleanup
+	Resume bool
+	// DiffOut receives the diff of every regular file replaced with --force when set
+	DiffOut io.Writer
+	// Module installs only the named module, leaving the previous installation of the others
+	// in place
+	Module string
+	// TargetDir replaces the target_dir of Module when set
+	TargetDir string
+	// SourceDir is the directory modules are loaded from when set, instead of the dotfiles
+	// directory, which still holds the DotRoot and state files
+	SourceDir string
+	// HashCache reuses the hashes of generated files cached by earlier cleanups when their size
+	// and mtime are unchanged
+	HashCache bool
+}
+
 // installCmd represents the install command
 var installCmd = &cobra.Command{
 	Use:   "install",
@@ -32,6 +114,50 @@ to ensure a clean state and prevent conflicts from previous installations.`,
 			return fmt.Errorf("only one of --dry-run or --force can be used at a time")
 		}
 
+		if outFlag != "" && !dryRunFlag {
+			return fmt.Errorf("--out can only be used with --dry-run")
+		}
+
+		if explainFlag && !dryRunFlag {
+			return fmt.Errorf("--explain can only be used with --dry-run")
+		}
+
+		if linkModeFlag != string(module.LinkModeSymlink) && linkModeFlag != string(module.LinkModeAuto) {
+			return fmt.Errorf("--link-mode must be %s or %s", module.LinkModeSymlink, module.LinkModeAuto)
+		}
+
+		if repairFlag && (dryRunFlag || keepGoingFlag) {
+			return fmt.Errorf("--repair cannot be used with --dry-run or --keep-going")
+		}
+
+		if summaryOnlyFlag && (dryRunFlag || repairFlag) {
+			return fmt.Errorf("--summary-only cannot be used with --dry-run or --repair")
+		}
+
+		if showDiffFlag && (dryRunFlag || repairFlag) {
+			return fmt.Errorf("--show-diff cannot be used with --dry-run or --repair")
+		}
+
+		if resumeFlag && (dryRunFlag || repairFlag) {
+			return fmt.Errorf("--resume cannot be used with --dry-run or --repair")
+		}
+
+		if watchFlag && repairFlag {
+			return fmt.Errorf("--watch cannot be used with --repair")
+		}
+
+		if watchFlag && sourceDirFlag != "" {
+			return fmt.Errorf("--watch cannot be used with --source-dir")
+		}
+
+		if moduleFlag != "" && repairFlag {
+			return fmt.Errorf("--module cannot be used with --repair")
+		}
+
+		if targetDirFlag != "" && moduleFlag == "" {
+			return fmt.Errorf("--target-dir requires --module")
+		}
+
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -39,13 +165,74 @@ to ensure a clean state and prevent conflicts from previous installations.`,
 		if err != nil {
 			return err
 		}
-		return install(dotfilesDir, dryRunFlag, forceFlag, mkdirFlag)
+		excludeTargets, err := expandExcludeTargets(excludeTargetFlag)
+		if err != nil {
+			return err
+		}
+		var summaryOut io.Writer
+		if summaryOnlyFlag {
+			logger.SetQuietMode()
+			summaryOut = cmd.OutOrStdout()
+		}
+		opts := installOptions{
+			DryRun:              dryRunFlag,
+			Force:               forceFlag,
+			Mkdir:               mkdirFlag,
+			Out:                 outFlag,
+			OutFormat:           outFormatFlag,
+			Explain:             explainFlag,
+			KeepGoing:           keepGoingFlag,
+			Repair:              repairFlag,
+			Preflight:           preflightFlag,
+			SkipFailedTemplates: skipTemplatesFlag,
+			CheckWritable:       checkWritableFlag,
+			Transactional:       transactionalFlag,
+			LinkMode:            module.LinkMode(linkModeFlag),
+			SummaryOut:          summaryOut,
+			Profile:             profileFlag,
+			AllowPrivileged:     privilegedFlag,
+			ExcludeTargets:      excludeTargets,
+			VerifyOnSkip:        verifyOnSkipFlag,
+			RecordVars:          recordVarsFlag,
+			NoReinstallCleanup:  noCleanupFlag,
+			Resume:              resumeFlag,
+			Module:              moduleFlag,
+			TargetDir:           targetDirFlag,
+			SourceDir:           sourceDirFlag,
+			HashCache:           hashCacheFlag,
+		}
+		if showDiffFlag {
+			opts.DiffOut = cmd.OutOrStdout()
+		}
+		if watchFlag {
+			return watchInstall(cmd.Context(), dotfilesDir, opts)
+		}
+		return install(cmd.Context(), dotfilesDir, opts)
 	},
 }
 
+// watchInstall installs once, then reinstalls whenever the sources change until interrupted.
+// A failed installation is reported and watching goes on, so the sources can be fixed.
+func watchInstall(ctx context.Context, dotfilesDir string, opts installOptions) error {
+	log := logger.GetLogger()
+
+	if err := install(ctx, dotfilesDir, opts); err != nil {
+		log.Error().Err(err).Msg("Installation failed, waiting for changes")
+	}
+
+	log.Info().Str("dotfiles_dir", dotfilesDir).Msg("Watching for changes, press Ctrl-C to stop")
+	return watch.Watch(dotfilesDir, watch.Options{
+		Install: func(ctx context.Context) error {
+			return install(ctx, dotfilesDir, opts)
+		},
+		Context: ctx,
+	})
+}
+
 // install performs the dotfiles installation
-func install(dotfilesDir string, dryRun, force, mkdir bool) error {
+func install(ctx context.Context, dotfilesDir string, opts installOptions) error {
 	log := logger.GetLogger()
+	dryRun, force, mkdir := opts.DryRun, opts.Force, opts.Mkdir
 
 	// Log which mode we're running in
 	if dryRun {
@@ -55,19 +242,64 @@ func install(dotfilesDir string, dryRun, force, mkdir bool) error {
 		log.Info().Msg("Running in force mode - existing files will be overwritten")
 	}
 
-	log.Info().Str("dotfiles_dir", dotfilesDir).Msg("Loading configuration")
+	// Repair works from the state file instead of installing from configuration
+	if opts.Repair {
+		return repair(ctx, dotfilesDir, opts.SourceDir, force, opts.Profile)
+	}
+
+	sourceDir := dotfilesDir
+	if opts.SourceDir != "" {
+		sourceDir = opts.SourceDir
+	}
+	log.Info().Str("dotfiles_dir", dotfilesDir).Str("source_dir", sourceDir).Msg("Loading configuration")
 
-	cfg, err := config.LoadDir(dotfilesDir)
+	cfg, err := config.LoadDirWithSourceDir(dotfilesDir, sourceDir)
 	if err != nil {
 		return err
 	}
 
 	log.Info().Int("modules", len(cfg.Modules)).Msg("Configuration loaded successfully")
 
+	if opts.Module != "" && opts.TargetDir != "" {
+		if err := config.OverrideTargetDir(cfg.Modules, opts.Module, opts.TargetDir); err != nil {
+			return err
+		}
+		log.Info().Str("module", opts.Module).Str("target_dir", opts.TargetDir).Msg("Overriding target directory")
+	}
+	// Templates and target directory checks see every module, even when installing only one
+	paths := module.ModulePaths(cfg.Modules)
+	if opts.Module != "" {
+		// Dependencies of the module are checked against all modules, but not installed with it
+		cfg.Modules, err = config.SelectModule(cfg.Modules, opts.Module)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Run cleanup phase (uninstall) before installation if not in dry-run mode
-	if !dryRun {
+	if !dryRun && opts.Resume {
+		log.Info().Msg("Skipping cleanup phase, resuming the previous installation")
+	} else if !dryRun && opts.Module != "" {
+		log.Info().Str("module", opts.Module).Msg("Skipping cleanup phase, installing a single module")
+	} else if !dryRun && opts.NoReinstallCleanup {
+		log.Info().Msg("Skipping cleanup phase, targets of the previous installation are kept")
+	} else if !dryRun {
 		log.Info().Msg("Running cleanup phase - removing previous installations")
-		uninstallResult, err := module.Uninstall(dotfilesDir)
+		uninstallResult, err := module.UninstallWithConfig(&module.UninstallConfig{
+			BackupModified:   true, // Default to backing up modified files
+			StatePath:        dotfilesDir,
+			MaxBackups:       cfg.RootConfig.MaxBackups,
+			CompressBackups:  cfg.RootConfig.CompressBackups,
+			TimestampBackups: cfg.RootConfig.TimestampBackups,
+			BackupSuffix:     cfg.RootConfig.BackupSuffix,
+			Profile:          opts.Profile,
+			HashCache:        opts.HashCache,
+			// Blocks are updated in place by the installation, keeping their position in shared files
+			KeepBlocks: true,
+			// Excluded targets are left as they are, not removed without being reinstalled
+			ExcludeTargets: opts.ExcludeTargets,
+			Context:        ctx,
+		})
 		if err != nil {
 			log.Warn().Err(err).Msg("Cleanup phase failed, proceeding with installation")
 		} else {
@@ -87,17 +319,42 @@ func install(dotfilesDir string, dryRun, force, mkdir bool) error {
 
 	// Perform dry-run validation
 	if dryRun {
-		result, err := module.Validate(cfg.Modules, vars, mkdir, force)
+		result, err := module.ValidateWithConfig(cfg.Modules, &module.ValidateConfig{
+			Mkdir:               mkdir,
+			Force:               force,
+			Vars:                vars,
+			MkdirAllowedRoots:   cfg.RootConfig.MkdirAllowedRoots,
+			StrictTargetDirs:    cfg.RootConfig.StrictTargetDirs,
+			KeepDangling:        !cfg.RootConfig.ReplacesDangling(),
+			StateDir:            dotfilesDir,
+			Profile:             opts.Profile,
+			ExcludeTargets:      opts.ExcludeTargets,
+			SkipFailedTemplates: opts.SkipFailedTemplates,
+			CheckWritable:       opts.CheckWritable,
+			CompressBackups:     cfg.RootConfig.CompressBackups,
+			TimestampBackups:    cfg.RootConfig.TimestampBackups,
+			BackupSuffix:        cfg.RootConfig.BackupSuffix,
+			ModulePaths:         paths,
+			Context:             ctx,
+		})
 		if err != nil {
 			return fmt.Errorf("validation failed: %w", err)
 		}
 
 		// Log the results
-		module.LogValidateResult(result)
+		module.LogValidateResultWithConfig(result, &module.LogValidateConfig{Explain: opts.Explain})
+
+		// Persist the report if requested
+		if opts.Out != "" {
+			if err := module.WriteReport(opts.Out, result, opts.OutFormat); err != nil {
+				return fmt.Errorf("failed to write report: %w", err)
+			}
+			log.Info().Str("path", opts.Out).Msg("Validation report written")
+		}
 
 		// Return error if validation failed
 		if !result.IsValid {
-			forceOps := len(result.ForceLinkOperations) + len(result.ForceTemplateOps)
+			forceOps := len(result.ForceOperations())
 			return fmt.Errorf("validation failed with %d errors and %d conflicts", len(result.Errors), forceOps)
 		}
 
@@ -107,11 +364,39 @@ func install(dotfilesDir string, dryRun, force, mkdir bool) error {
 
 	// Create install configuration
 	installConfig := &module.InstallConfig{
-		Mkdir:     mkdir,
-		Force:     force,
-		DryRun:    false,
-		Vars:      vars,
-		StatePath: dotfilesDir,
+		Mkdir:               mkdir,
+		Force:               force,
+		DryRun:              false,
+		Vars:                vars,
+		StatePath:           dotfilesDir,
+		KeepGoing:           opts.KeepGoing,
+		MaxBackups:          cfg.RootConfig.MaxBackups,
+		CompressBackups:     cfg.RootConfig.CompressBackups,
+		TimestampBackups:    cfg.RootConfig.TimestampBackups,
+		BackupSuffix:        cfg.RootConfig.BackupSuffix,
+		MkdirAllowedRoots:   cfg.RootConfig.MkdirAllowedRoots,
+		StrictTargetDirs:    cfg.RootConfig.StrictTargetDirs,
+		KeepDangling:        !cfg.RootConfig.ReplacesDangling(),
+		PreflightTemplates:  opts.Preflight,
+		SkipFailedTemplates: opts.SkipFailedTemplates,
+		CheckWritable:       opts.CheckWritable,
+		Transactional:       opts.Transactional,
+		LinkMode:            opts.LinkMode,
+		Profile:             opts.Profile,
+		ExcludeTargets:      opts.ExcludeTargets,
+		VerifyOnSkip:        opts.VerifyOnSkip,
+		RecordVars:          opts.RecordVars,
+		Resume:              opts.Resume,
+		ShowDiff:            opts.DiffOut != nil,
+		ModulePaths:         paths,
+		Context:             ctx,
+	}
+	if opts.AllowPrivileged {
+		if cfg.RootConfig.PrivilegedCmd == "" {
+			return fmt.Errorf("--allow-privileged requires privileged_cmd in the DotRoot")
+		}
+		installConfig.PrivilegedCmd = cfg.RootConfig.PrivilegedCmd
+		log.Warn().Str("privileged_cmd", cfg.RootConfig.PrivilegedCmd).Msg("Symlinks that can't be created for lack of permission will be created with the privileged command")
 	}
 
 	// Perform installation using the new configuration
@@ -122,10 +407,109 @@ func install(dotfilesDir string, dryRun, force, mkdir bool) error {
 
 	// Log installation results
 	log.Info().Msg(installResult.Summary)
+	if opts.SummaryOut != nil {
+		fmt.Fprintln(opts.SummaryOut, installResult.OneLine())
+	}
+	if opts.DiffOut != nil {
+		printConflictDiffs(opts.DiffOut, installResult)
+	}
+
+	// Report every failed module when installing with --keep-going
+	for _, name := range installResult.FailedModules {
+		for _, moduleError := range installResult.ModuleErrors[name] {
+			log.Error().Str("module", name).Msg(moduleError)
+		}
+	}
+
+	if installResult.RolledBack {
+		log.Warn().Msg("Changes of the failed installation were rolled back")
+	}
 
 	if !installResult.IsSuccess {
 		return fmt.Errorf("installation failed: %v", installResult.Errors)
 	}
+	if installResult.StateNotSaved {
+		return &exitCodeError{code: installResult.ExitCode(), err: fmt.Errorf("installation succeeded but the state file could not be saved")}
+	}
+
+	return nil
+}
+
+// printConflictDiffs writes the diff of every regular file the installation replaced, or
+// failed to replace, in target order
+func printConflictDiffs(w io.Writer, result *module.InstallResult) {
+	var ops []module.FileOperation
+	for _, group := range [][]module.FileOperation{result.CreatedLinks, result.CopiedFiles, result.CreatedTemplates, result.FailedOperations} {
+		for _, op := range group {
+			if op.ConflictDiff != "" {
+				ops = append(ops, op)
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Target < ops[j].Target })
+	for _, op := range ops {
+		fmt.Fprint(w, op.ConflictDiff)
+	}
+}
+
+// expandExcludeTargets makes --exclude-target values absolute, expanding a leading ~ to the
+// home directory, which the shell leaves alone in --exclude-target=~/...
+func expandExcludeTargets(patterns []string) ([]string, error) {
+	expanded := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "~" || strings.HasPrefix(pattern, "~/") {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to expand %s: %w", pattern, err)
+			}
+			pattern = filepath.Join(homeDir, pattern[1:])
+		}
+		abs, err := filepath.Abs(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve exclude target %s: %w", pattern, err)
+		}
+		expanded = append(expanded, abs)
+	}
+	return expanded, nil
+}
+
+// repair restores drifted symlinks and generated files recorded in the state file. Regenerated
+// templates see the modules of sourceDir when set, as the installation did.
+func repair(ctx context.Context, dotfilesDir, sourceDir string, regenerate bool, profile string) error {
+	log := logger.GetLogger()
+
+	log.Info().Str("dotfiles_dir", dotfilesDir).Msg("Repairing installation from state")
+
+	rootConfig, err := config.LoadRootConfig(dotfilesDir)
+	if err != nil {
+		return err
+	}
+
+	result, err := module.RepairWithConfig(&module.RepairConfig{
+		StatePath:        dotfilesDir,
+		Vars:             rootConfig.Vars,
+		Regenerate:       regenerate,
+		Profile:          profile,
+		SourceDir:        sourceDir,
+		Context:          ctx,
+		MaxBackups:       rootConfig.MaxBackups,
+		CompressBackups:  rootConfig.CompressBackups,
+		TimestampBackups: rootConfig.TimestampBackups,
+		BackupSuffix:     rootConfig.BackupSuffix,
+	})
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+
+	log.Info().Msg(result.Summary)
+
+	for _, skipped := range result.SkippedGenerated {
+		log.Warn().Str("target", skipped.Target).Msg("Modified generated file left as is, rerun with --force to regenerate it")
+	}
+
+	if !result.IsSuccess {
+		return fmt.Errorf("repair failed: %v", result.Errors)
+	}
 
 	return nil
 }
@@ -134,4 +518,27 @@ func init() {
 	installCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Show what would be installed without making changes")
 	installCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "Force installation by overwriting existing files")
 	installCmd.Flags().BoolVar(&mkdirFlag, "mkdir", false, "Create missing target directories during installation")
+	installCmd.Flags().StringVar(&outFlag, "out", "", "Write the dry-run validation report to this file")
+	installCmd.Flags().StringVar(&outFormatFlag, "out-format", module.ReportFormatJSON, "Format of the --out report (json or yaml)")
+	installCmd.Flags().BoolVar(&keepGoingFlag, "keep-going", false, "Install every module independently and report all failed modules at the end")
+	installCmd.Flags().BoolVar(&repairFlag, "repair", false, "Recreate missing or wrong symlinks and missing generated files recorded in state (with --force, also regenerate modified ones)")
+	installCmd.Flags().BoolVar(&preflightFlag, "preflight", false, "Render every template before writing any file, so a failing template leaves nothing installed")
+	installCmd.Flags().BoolVar(&checkWritableFlag, "check-writable", false, "Fail before writing anything when a directory that would receive files isn't writable")
+	installCmd.Flags().BoolVar(&skipTemplatesFlag, "skip-failed-templates", false, "Leave out templates that fail to render, with a warning, and install the rest of their module")
+	installCmd.Flags().BoolVar(&transactionalFlag, "transactional", false, "Undo every applied change, including the state file, when the installation fails")
+	installCmd.Flags().StringVar(&linkModeFlag, "link-mode", string(module.LinkModeSymlink), "How files are installed: symlink, or auto to copy files whose target is on another filesystem")
+	installCmd.Flags().BoolVar(&summaryOnlyFlag, "summary-only", false, "Only print errors and a single machine-readable summary line")
+	installCmd.Flags().BoolVar(&privilegedFlag, "allow-privileged", false, "Create symlinks that fail with a permission error using privileged_cmd from the DotRoot, e.g. sudo")
+	installCmd.Flags().BoolVar(&watchFlag, "watch", false, "Keep running and reinstall whenever files in the dotfiles directory change")
+	installCmd.Flags().StringArrayVar(&excludeTargetFlag, "exclude-target", nil, "Leave targets under this path, or matching this glob, alone (repeatable)")
+	installCmd.Flags().BoolVar(&verifyOnSkipFlag, "verify-on-skip", false, "Record the source hash of links that are already correct and warn about their mode and owner")
+	installCmd.Flags().BoolVar(&recordVarsFlag, "record-vars", false, "Record the vars of every template and generated file in the state file, so repairs reproduce them exactly")
+	installCmd.Flags().BoolVar(&noCleanupFlag, "no-reinstall-cleanup", false, "Don't uninstall the previous installation first; only add and update targets, keeping the ones no longer configured")
+	installCmd.Flags().BoolVar(&showDiffFlag, "show-diff", false, "Print the diff between every regular file replaced with --force and the source or rendered template installed over it")
+	installCmd.Flags().BoolVar(&resumeFlag, "resume", false, "Continue an interrupted installation, leaving targets already installed according to the state file alone")
+	installCmd.Flags().StringVar(&sourceDirFlag, "source-dir", "", "Load the modules from this directory instead of the dotfiles directory, which keeps the DotRoot and state files")
+	installCmd.Flags().BoolVar(&hashCacheFlag, "hash-cache", false, "Skip rehashing generated files unchanged in size and mtime during the cleanup phase, using .dotman-cache.yaml in the dotfiles directory")
+	installCmd.Flags().StringVar(&moduleFlag, "module", "", "Install only this module, keeping the previous installation of the others")
+	installCmd.Flags().StringVar(&targetDirFlag, "target-dir", "", "Install the --module into this absolute directory instead of its target_dir")
+	installCmd.Flags().BoolVar(&explainFlag, "explain", false, "Show every dry-run operation with the reason it was chosen")
 }
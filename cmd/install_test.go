@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/elmhuangyu/dotman/pkg/state"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -49,7 +56,7 @@ ignores: []`)
 		os.Remove(statePath)
 
 		// First, create an existing installation by running install once
-		err := install(dotfilesDir, false, false, true)
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that symlinks were created
@@ -64,7 +71,7 @@ ignores: []`)
 		assert.NoError(t, err)
 
 		// Now run install again - this should call uninstall first
-		err = install(dotfilesDir, false, false, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that symlinks still exist (recreated after uninstall)
@@ -82,7 +89,7 @@ ignores: []`)
 		os.Remove(statePath)
 
 		// Create an initial installation
-		err := install(dotfilesDir, false, false, true)
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify state file exists
@@ -90,7 +97,7 @@ ignores: []`)
 		assert.NoError(t, err)
 
 		// Run install in dry-run mode - should not call uninstall
-		err = install(dotfilesDir, true, false, false)
+		err = install(context.Background(), dotfilesDir, installOptions{DryRun: true})
 		require.NoError(t, err)
 
 		// State file should still exist (uninstall was not called)
@@ -98,6 +105,26 @@ ignores: []`)
 		assert.NoError(t, err)
 	})
 
+	t.Run("dry-run writes report to out file", func(t *testing.T) {
+		reportPath := filepath.Join(tempDir, "reports", "validate.json")
+
+		err := install(context.Background(), dotfilesDir, installOptions{DryRun: true, Out: reportPath, OutFormat: "json"})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(reportPath)
+		require.NoError(t, err)
+
+		var report module.ValidateResult
+		require.NoError(t, json.Unmarshal(data, &report))
+		assert.True(t, report.IsValid)
+		assert.NotEmpty(t, report.Summary)
+	})
+
+	t.Run("dry-run with explain", func(t *testing.T) {
+		err := install(context.Background(), dotfilesDir, installOptions{DryRun: true, Explain: true})
+		assert.NoError(t, err)
+	})
+
 	t.Run("install handles uninstall errors gracefully", func(t *testing.T) {
 		// Clean up any existing state
 		statePath := filepath.Join(dotfilesDir, "state.yaml")
@@ -109,7 +136,7 @@ ignores: []`)
 		require.NoError(t, err)
 
 		// Run install - should handle uninstall error gracefully and proceed
-		err = install(dotfilesDir, false, false, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that installation still succeeded
@@ -132,13 +159,60 @@ ignores: []`)
 		os.Remove(targetFile2)
 
 		// Run install with no previous installation
-		err := install(dotfilesDir, false, false, true)
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that installation succeeded
 		assert.FileExists(t, targetFile1)
 		assert.FileExists(t, targetFile2)
 	})
+
+	t.Run("install repair recreates deleted symlinks from state", func(t *testing.T) {
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
+		require.NoError(t, err)
+
+		targetFile1 := filepath.Join(targetDir, "file1.txt")
+		require.NoError(t, os.Remove(targetFile1))
+
+		err = install(context.Background(), dotfilesDir, installOptions{Repair: true})
+		require.NoError(t, err)
+
+		link, err := os.Readlink(targetFile1)
+		require.NoError(t, err)
+		assert.Equal(t, sourceFile1, link)
+	})
+	t.Run("summary only prints one line per command", func(t *testing.T) {
+		var out bytes.Buffer
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true, SummaryOut: &out})
+		require.NoError(t, err)
+		assert.Equal(t, "dotman install: created=2 copied=0 templates=0 generated=0 skipped=0 errors=0 backups=0\n", out.String())
+
+		out.Reset()
+		err = uninstall(context.Background(), dotfilesDir, uninstallOptions{SummaryOut: &out})
+		require.NoError(t, err)
+		assert.Equal(t, "dotman uninstall: removed=2 generated=0 skipped=0 errors=0 backups=0\n", out.String())
+	})
+	t.Run("uninstall works with a broken DotRoot", func(t *testing.T) {
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{Mkdir: true}))
+		rootPath := filepath.Join(dotfilesDir, "DotRoot")
+		require.NoError(t, os.WriteFile(rootPath, []byte("vars: ["), 0644))
+		defer os.Remove(rootPath)
+
+		require.NoError(t, uninstall(context.Background(), dotfilesDir, uninstallOptions{}))
+		assert.NoFileExists(t, filepath.Join(targetDir, "file1.txt"))
+		assert.NoFileExists(t, filepath.Join(targetDir, "file2.txt"))
+	})
+	t.Run("show diff prints the diff of replaced files", func(t *testing.T) {
+		targetFile1 := filepath.Join(targetDir, "file1.txt")
+		require.NoError(t, os.WriteFile(targetFile1, []byte("local edit"), 0644))
+
+		var out bytes.Buffer
+		err := install(context.Background(), dotfilesDir, installOptions{Mkdir: true, Force: true, DiffOut: &out})
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "--- "+targetFile1+"\n")
+		assert.Contains(t, out.String(), "-local edit\n")
+		assert.Contains(t, out.String(), "+content1\n")
+	})
 }
 
 func TestInstallWithMissingStateFile(t *testing.T) {
@@ -176,7 +250,7 @@ ignores: []`)
 	assert.True(t, os.IsNotExist(err))
 
 	// Run install - should handle missing state file gracefully
-	err = install(dotfilesDir, false, false, true)
+	err = install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 	require.NoError(t, err)
 
 	// Verify that installation succeeded
@@ -224,7 +298,7 @@ ignores: []`)
 		require.NoError(t, err)
 
 		// Run install with force flag - should handle uninstall first then force install
-		err = install(dotfilesDir, false, true, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Force: true, Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that symlink was created (overwriting the existing file)
@@ -243,7 +317,7 @@ ignores: []`)
 		os.RemoveAll(targetDir)
 
 		// Run install with mkdir flag - should create target directory
-		err = install(dotfilesDir, false, false, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that target directory was created and symlink exists
@@ -262,7 +336,7 @@ ignores: []`)
 		os.Remove(statePath)
 
 		// First installation
-		err = install(dotfilesDir, false, false, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify first installation
@@ -277,7 +351,7 @@ ignores: []`)
 
 		// Run install again with force flag - should call uninstall first (which will skip the conflicting file)
 		// then install will handle the conflict with force flag
-		err = install(dotfilesDir, false, true, true)
+		err = install(context.Background(), dotfilesDir, installOptions{Force: true, Mkdir: true})
 		require.NoError(t, err)
 
 		// Verify that symlink was recreated
@@ -287,3 +361,238 @@ ignores: []`)
 		assert.True(t, info.Mode()&os.ModeSymlink != 0)
 	})
 }
+
+func TestInstallNoReinstallCleanup(t *testing.T) {
+	// setup installs a module with two files, then drops one of them from the module
+	setup := func(t *testing.T) (dotfilesDir, targetDir string) {
+		tempDir := t.TempDir()
+		dotfilesDir = filepath.Join(tempDir, "dotfiles")
+		targetDir = filepath.Join(tempDir, "target")
+		moduleDir := filepath.Join(dotfilesDir, "module")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "kept.txt"), []byte("kept"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "dropped.txt"), []byte("dropped"), 0644))
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{}))
+		require.NoError(t, os.Remove(filepath.Join(moduleDir, "dropped.txt")))
+		return dotfilesDir, targetDir
+	}
+
+	t.Run("cleanup removes targets no longer configured", func(t *testing.T) {
+		dotfilesDir, targetDir := setup(t)
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{}))
+		_, err := os.Lstat(filepath.Join(targetDir, "dropped.txt"))
+		assert.True(t, os.IsNotExist(err))
+		assert.FileExists(t, filepath.Join(targetDir, "kept.txt"))
+	})
+
+	t.Run("no cleanup keeps them", func(t *testing.T) {
+		dotfilesDir, targetDir := setup(t)
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{NoReinstallCleanup: true}))
+		info, err := os.Lstat(filepath.Join(targetDir, "dropped.txt"))
+		require.NoError(t, err)
+		assert.True(t, info.Mode()&os.ModeSymlink != 0)
+		assert.FileExists(t, filepath.Join(targetDir, "kept.txt"))
+	})
+}
+
+func TestInstallSingleModule(t *testing.T) {
+	// setup installs the modules nvim and zsh, each linking one file into targetDir
+	setup := func(t *testing.T) (dotfilesDir, targetDir string) {
+		tempDir := t.TempDir()
+		dotfilesDir = filepath.Join(tempDir, "dotfiles")
+		targetDir = filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		for name, file := range map[string]string{"nvim": "init.lua", "zsh": "zshrc"} {
+			moduleDir := filepath.Join(dotfilesDir, name)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte(name), 0644))
+		}
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{}))
+		return dotfilesDir, targetDir
+	}
+
+	t.Run("target dir override applies to the named module only", func(t *testing.T) {
+		dotfilesDir, targetDir := setup(t)
+		testDir := filepath.Join(t.TempDir(), "test")
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: testDir, Mkdir: true}))
+		destination, err := os.Readlink(filepath.Join(testDir, "init.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dotfilesDir, "nvim", "init.lua"), destination)
+		_, err = os.Lstat(filepath.Join(testDir, "zshrc"))
+		assert.True(t, os.IsNotExist(err))
+
+		// The previous installation is kept
+		for _, file := range []string{"init.lua", "zshrc"} {
+			_, err := os.Readlink(filepath.Join(targetDir, file))
+			assert.NoError(t, err, file)
+		}
+	})
+
+	t.Run("dependencies on modules left out are resolved", func(t *testing.T) {
+		dotfilesDir, targetDir := setup(t)
+		testDir := filepath.Join(t.TempDir(), "test")
+		dotfile := "target_dir: \"" + targetDir + "\"\ndepends_on: [zsh]\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "nvim", "Dotfile"), []byte(dotfile), 0644))
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: testDir, Mkdir: true}))
+		_, err := os.Readlink(filepath.Join(testDir, "init.lua"))
+		assert.NoError(t, err)
+		_, err = os.Lstat(filepath.Join(testDir, "zshrc"))
+		assert.True(t, os.IsNotExist(err), "the dependency is not installed with the module")
+
+		unknown := strings.Replace(dotfile, "[zsh]", "[zsh, lua]", 1)
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "nvim", "Dotfile"), []byte(unknown), 0644))
+		err = install(context.Background(), dotfilesDir, installOptions{Module: "nvim"})
+		assert.ErrorContains(t, err, "module nvim depends on unknown module lua")
+	})
+
+	t.Run("templates and target dir checks see the other modules", func(t *testing.T) {
+		dotfilesDir, targetDir := setup(t)
+		testDir := filepath.Join(t.TempDir(), "test")
+		template := filepath.Join(dotfilesDir, "nvim", "zsh.lua.dot-tmpl")
+		require.NoError(t, os.WriteFile(template, []byte("-- {{.Modules.zsh.TargetDir}}"), 0644))
+		defer os.Remove(template)
+
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: testDir, Mkdir: true}))
+		content, err := os.ReadFile(filepath.Join(testDir, "zsh.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- "+targetDir, string(content))
+
+		// With strict_target_dirs, the new target dir is checked against the target dir of zsh
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("strict_target_dirs: true\n"), 0644))
+		defer os.Remove(filepath.Join(dotfilesDir, "DotRoot"))
+		nested := filepath.Join(targetDir, "nvim")
+		err = install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: nested, Mkdir: true, DryRun: true})
+		assert.ErrorContains(t, err, "validation failed")
+		err = install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: nested, Mkdir: true})
+		assert.ErrorContains(t, err, "installation failed")
+		assert.NoFileExists(t, filepath.Join(nested, "init.lua"))
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		dotfilesDir, _ := setup(t)
+
+		err := install(context.Background(), dotfilesDir, installOptions{Module: "emacs"})
+		assert.ErrorContains(t, err, "module emacs is not configured")
+	})
+
+	t.Run("relative target dir", func(t *testing.T) {
+		dotfilesDir, _ := setup(t)
+
+		err := install(context.Background(), dotfilesDir, installOptions{Module: "nvim", TargetDir: "test"})
+		assert.ErrorContains(t, err, "must be an absolute path")
+	})
+}
+
+func TestInstallSourceDir(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotman")
+	sourceDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "target")
+	moduleDir := filepath.Join(sourceDir, "vim")
+	require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("vars:\n  number: nu\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "vimrc.dot-tmpl"), []byte("set {{.number}} \" {{.Modules.vim.TargetDir}}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "gvimrc"), []byte("set guifont"), 0644))
+
+	require.NoError(t, validate(context.Background(), dotfilesDir, validateOptions{SourceDir: sourceDir}))
+	require.NoError(t, install(context.Background(), dotfilesDir, installOptions{SourceDir: sourceDir}))
+
+	// Templates are rendered with the vars of the DotRoot in the dotfiles directory
+	content, err := os.ReadFile(filepath.Join(targetDir, "vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "set nu \" "+targetDir, string(content))
+	destination, err := os.Readlink(filepath.Join(targetDir, "gvimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(moduleDir, "gvimrc"), destination)
+
+	// The state file is kept in the dotfiles directory, not with the sources
+	assert.FileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+	assert.NoFileExists(t, filepath.Join(sourceDir, "state.yaml"))
+
+	// Repair regenerates templates with the modules of the source dir
+	require.NoError(t, os.Remove(filepath.Join(targetDir, "vimrc")))
+	require.NoError(t, install(context.Background(), dotfilesDir, installOptions{Repair: true, SourceDir: sourceDir}))
+	content, err = os.ReadFile(filepath.Join(targetDir, "vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "set nu \" "+targetDir, string(content))
+
+	require.NoError(t, uninstall(context.Background(), dotfilesDir, uninstallOptions{ValidateAgainstConfig: true, SourceDir: sourceDir}))
+	assert.NoFileExists(t, filepath.Join(targetDir, "vimrc"))
+}
+
+func TestHashCache(t *testing.T) {
+	// setup installs a template, then modifies the generated file without changing its size and
+	// caches the hash it was installed with, as if an earlier run had hashed it before the change
+	setup := func(t *testing.T) (string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		targetDir := filepath.Join(tempDir, "target")
+		moduleDir := filepath.Join(dotfilesDir, "module")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "conf.dot-tmpl"), []byte("generated"), 0644))
+		require.NoError(t, install(context.Background(), dotfilesDir, installOptions{}))
+
+		target := filepath.Join(targetDir, "conf")
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, os.WriteFile(target, []byte("modified!"), 0644))
+		require.NoError(t, os.Chtimes(target, modTime, modTime))
+		cache := state.NewHashCache()
+		cache.Entries[target] = state.HashCacheEntry{Size: int64(len("modified!")), ModTime: modTime, SHA1: stateFile.Files[0].SHA1}
+		require.NoError(t, state.SaveHashCache(filepath.Join(dotfilesDir, state.HashCacheFileName), cache))
+		return dotfilesDir, target
+	}
+
+	// backups returns the backups made of target
+	backups := func(t *testing.T, target string) []string {
+		matches, err := filepath.Glob(target + "*.bak*")
+		require.NoError(t, err)
+		return matches
+	}
+
+	tests := []struct {
+		name      string
+		hashCache bool
+		backedUp  bool
+	}{
+		// The cached hash hides the modification, so the file is removed without a backup
+		{name: "cached hashes are reused", hashCache: true, backedUp: false},
+		{name: "files are rehashed without the flag", hashCache: false, backedUp: true},
+	}
+	for _, tt := range tests {
+		t.Run("uninstall: "+tt.name, func(t *testing.T) {
+			dotfilesDir, target := setup(t)
+
+			require.NoError(t, uninstall(context.Background(), dotfilesDir, uninstallOptions{HashCache: tt.hashCache}))
+			assert.NoFileExists(t, target)
+			assert.Equal(t, tt.backedUp, len(backups(t, target)) > 0)
+		})
+
+		t.Run("install cleanup: "+tt.name, func(t *testing.T) {
+			dotfilesDir, target := setup(t)
+
+			require.NoError(t, install(context.Background(), dotfilesDir, installOptions{HashCache: tt.hashCache}))
+			content, err := os.ReadFile(target)
+			require.NoError(t, err)
+			assert.Equal(t, "generated", string(content))
+			assert.Equal(t, tt.backedUp, len(backups(t, target)) > 0)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/spf13/cobra"
+)
+
+var (
+	relativizeStateFlag bool
+	dedupeStateFlag     bool
+	pruneStateFlag      bool
+	pruneSourceDirFlag  string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the state file to a new format",
+	Long: `Migrate the state file kept in the dotfiles directory.
+
+With --relativize-state, source paths are rewritten relative to the dotfiles
+directory and target paths relative to the home directory, so the state file
+can be synced between machines with different home directories.
+
+With --dedupe-state, duplicate entries for the same target (e.g. from a
+hand-edited or merged state file) are removed, keeping the most recent one.
+
+With --prune-state, files installed by modules that are now listed in the
+DotRoot's exclude_modules are uninstalled and their entries dropped.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !relativizeStateFlag && !dedupeStateFlag && !pruneStateFlag {
+			return fmt.Errorf("no migration selected, use --relativize-state, --dedupe-state or --prune-state")
+		}
+		if pruneSourceDirFlag != "" && !pruneStateFlag {
+			return fmt.Errorf("--source-dir requires --prune-state")
+		}
+
+		dotfilesDir, err := getDotfilesDir()
+		if err != nil {
+			return err
+		}
+		if dedupeStateFlag {
+			if err := dedupeState(dotfilesDir, profileFlag); err != nil {
+				return err
+			}
+		}
+		if pruneStateFlag {
+			if err := pruneState(cmd.Context(), dotfilesDir, pruneSourceDirFlag, profileFlag); err != nil {
+				return err
+			}
+		}
+		if relativizeStateFlag {
+			return migrateStateRelative(dotfilesDir, profileFlag)
+		}
+		return nil
+	},
+}
+
+// migrateStateRelative converts the state file to portable relative paths
+func migrateStateRelative(dotfilesDir, profile string) error {
+	log := logger.GetLogger()
+
+	if err := module.MigrateStateRelativeForProfile(dotfilesDir, profile); err != nil {
+		return fmt.Errorf("state migration failed: %w", err)
+	}
+
+	log.Info().Str("dotfiles_dir", dotfilesDir).Msg("State file now uses relative paths")
+	return nil
+}
+
+// dedupeState removes duplicate target entries from the state file
+func dedupeState(dotfilesDir, profile string) error {
+	log := logger.GetLogger()
+
+	removed, err := module.DedupeStateForProfile(dotfilesDir, profile)
+	if err != nil {
+		return fmt.Errorf("state deduplication failed: %w", err)
+	}
+
+	log.Info().Int("removed", removed).Msg("Removed duplicate state entries")
+	return nil
+}
+
+// pruneState uninstalls the tracked files of excluded modules and drops their state entries.
+// The modules are discovered in sourceDir when set, instead of the dotfiles directory.
+func pruneState(ctx context.Context, dotfilesDir, sourceDir, profile string) error {
+	log := logger.GetLogger()
+
+	rootConfig, err := config.LoadRootConfig(dotfilesDir)
+	if err != nil {
+		return err
+	}
+
+	result, err := module.PruneStateWithConfig(&module.UninstallConfig{
+		BackupModified:   true,
+		StatePath:        dotfilesDir,
+		MaxBackups:       rootConfig.MaxBackups,
+		CompressBackups:  rootConfig.CompressBackups,
+		TimestampBackups: rootConfig.TimestampBackups,
+		BackupSuffix:     rootConfig.BackupSuffix,
+		Profile:          profile,
+		SourceDir:        sourceDir,
+		Context:          ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("state pruning failed: %w", err)
+	}
+
+	log.Info().Msg(result.Summary)
+	if !result.IsSuccess {
+		return fmt.Errorf("state pruning failed with %d errors", len(result.Errors))
+	}
+	return nil
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&relativizeStateFlag, "relativize-state", false, "Store state paths relative to the dotfiles and home directories")
+	migrateCmd.Flags().BoolVar(&dedupeStateFlag, "dedupe-state", false, "Remove duplicate entries for the same target from the state file")
+	migrateCmd.Flags().BoolVar(&pruneStateFlag, "prune-state", false, "Uninstall the files of modules now in exclude_modules and drop their state entries")
+	migrateCmd.Flags().StringVar(&pruneSourceDirFlag, "source-dir", "", "Discover the modules to prune in this directory, as installed with install --source-dir")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/spf13/cobra"
+)
+
+var renderSourceDirFlag string
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render <template>",
+	Short: "Render a single template without installing it",
+	Long: `Render a template with the root and module vars it would be installed with
+and print the result, without writing anything. The template path may be
+relative to the dotfiles directory, e.g. nvim/init.lua.dot-tmpl, or to the
+--source-dir directory when set.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dotfilesDir, err := getDotfilesDir()
+		if err != nil {
+			return err
+		}
+
+		content, err := module.RenderPreviewWithSourceDir(dotfilesDir, renderSourceDirFlag, args[0])
+		if err != nil {
+			return fmt.Errorf("render failed: %w", err)
+		}
+
+		_, err = cmd.OutOrStdout().Write(content)
+		return err
+	},
+}
+
+func init() {
+	renderCmd.Flags().StringVar(&renderSourceDirFlag, "source-dir", "", "Load the modules from this directory instead of the dotfiles directory, as install --source-dir would")
+	rootCmd.AddCommand(renderCmd)
+}
@@ -1,19 +1,31 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
+	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/remote"
+	"github.com/elmhuangyu/dotman/pkg/state"
 	"github.com/spf13/cobra"
 )
 
 var (
-	debugFlag bool
-	dirFlag   string
+	debugFlag   bool
+	quietFlag   bool
+	dirFlag     string
+	profileFlag string
 )
 
+// newResolver creates the resolver for git URLs passed as --dir; tests replace it
+var newResolver = remote.NewResolver
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "dotman",
@@ -21,13 +33,36 @@ var rootCmd = &cobra.Command{
 	Long: `dotman is a CLI tool for managing and installing dotfiles.
 It provides commands to install and uninstall dotfile configurations.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if debugFlag && quietFlag {
+			return fmt.Errorf("only one of --debug or --quiet can be used at a time")
+		}
+
+		if err := state.ValidateProfile(profileFlag); err != nil {
+			return err
+		}
+
 		// Set debug mode if flag is provided
 		if debugFlag {
 			logger.SetDebugMode()
 		}
 
+		// Only report errors in quiet mode
+		if quietFlag {
+			logger.SetQuietMode()
+		}
+
+		// The root command itself only prints help or scaffolds a new repository with --init,
+		// so the dotfiles directory needn't exist yet
+		if !cmd.HasParent() {
+			return nil
+		}
+
 		// Log startup info
 		log := logger.GetLogger()
+		if err := resolveRemoteDir(cmd.Context()); err != nil {
+			log.Error().Err(err).Msg("Failed to fetch remote dotfiles repository")
+			return err
+		}
 		_, err := getDotfilesDir()
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to determine dotfiles directory")
@@ -35,37 +70,99 @@ It provides commands to install and uninstall dotfile configurations.`,
 		}
 		return nil
 	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !initFlag {
+			return cmd.Help()
+		}
+		dir, err := getInitDir()
+		if err != nil {
+			return err
+		}
+		return initDotfiles(dir, initForceFlag)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Interrupting stops the running command between operations
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		log := logger.GetLogger()
 		log.Error().Msg(err.Error())
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
 
+// exitCodeError is a command error that exits with a specific code instead of 1
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
 func init() {
 	// Global flags
 	rootCmd.PersistentFlags().BoolVar(&debugFlag, "debug", false, "Enable debug logging")
-	rootCmd.PersistentFlags().StringVar(&dirFlag, "dir", "", "Custom dotfiles directory (default: $HOME/.config/dotfiles)")
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "Only log errors")
+	rootCmd.PersistentFlags().StringVar(&dirFlag, "dir", "", "Custom dotfiles directory or git URL to clone (default: nearest DotRoot above the current directory, then ~/dotfiles or ~/.config/dotfiles)")
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "Track the installation in its own state file (state.<profile>.yaml), independent of other profiles")
 
 	// Add subcommands
 	rootCmd.AddCommand(installCmd)
 	rootCmd.AddCommand(uninstallCmd)
 }
 
-// getDotfilesDir returns the dotfiles directory based on flag or default
+// resolveRemoteDir replaces a git URL in --dir with its clone in the cache directory,
+// cloning it on first use and pulling it afterwards
+func resolveRemoteDir(ctx context.Context) error {
+	if !remote.IsGitURL(dirFlag) {
+		return nil
+	}
+	resolver, err := newResolver()
+	if err != nil {
+		return err
+	}
+	log := logger.GetLogger()
+	log.Info().Str("url", dirFlag).Msg("Fetching remote dotfiles repository")
+	dir, err := resolver.Resolve(ctx, dirFlag)
+	if err != nil {
+		return err
+	}
+	dirFlag = dir
+	return nil
+}
+
+// getDotfilesDir returns the dotfiles directory based on flag, the nearest DotRoot
+// above the current directory, or the default locations
 func getDotfilesDir() (string, error) {
 	if dirFlag != "" {
 		return dirFlag, nil
 	}
+	if cwd, err := os.Getwd(); err == nil {
+		if dir, err := config.FindRootUpwards(cwd); err == nil {
+			return dir, nil
+		}
+	}
 	dir := getDefaultDotfilesDir()
 	if dir == "" {
-		return "", fmt.Errorf("no dotfiles directory found: neither ~/dotfiles nor ~/.config/dotfiles exist")
+		return "", fmt.Errorf("no dotfiles directory found: no DotRoot in the current directory or its parents, and neither ~/dotfiles nor ~/.config/dotfiles exist")
 	}
 	return dir, nil
 }
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDotfilesDir(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "DotRoot"), []byte(""), 0644))
+	nested := filepath.Join(root, "zsh", "functions")
+	require.NoError(t, os.MkdirAll(nested, 0755))
+
+	t.Run("dir flag takes precedence", func(t *testing.T) {
+		oldDir := dirFlag
+		dirFlag = "/custom/dotfiles"
+		defer func() { dirFlag = oldDir }()
+		t.Chdir(nested)
+
+		dir, err := getDotfilesDir()
+		require.NoError(t, err)
+		assert.Equal(t, "/custom/dotfiles", dir)
+	})
+
+	t.Run("walks up from the current directory", func(t *testing.T) {
+		oldDir := dirFlag
+		dirFlag = ""
+		defer func() { dirFlag = oldDir }()
+		t.Chdir(nested)
+
+		dir, err := getDotfilesDir()
+		require.NoError(t, err)
+		assert.Equal(t, root, dir)
+	})
+
+	t.Run("falls back to defaults outside any repo", func(t *testing.T) {
+		oldDir := dirFlag
+		dirFlag = ""
+		defer func() { dirFlag = oldDir }()
+		outside := t.TempDir()
+		if _, err := config.FindRootUpwards(outside); err == nil {
+			t.Skip("a DotRoot exists above the temp directory")
+		}
+		t.Chdir(outside)
+		t.Setenv("HOME", t.TempDir())
+
+		_, err := getDotfilesDir()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no dotfiles directory found")
+	})
+}
+
+// fakeCloner populates clone directories with a DotRoot instead of running git
+type fakeCloner struct {
+	clones int
+	pulls  int
+}
+
+func (f *fakeCloner) Clone(ctx context.Context, url, dir string) error {
+	f.clones++
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(""), 0644)
+}
+
+func (f *fakeCloner) Pull(ctx context.Context, dir string) error {
+	f.pulls++
+	return nil
+}
+
+func TestResolveRemoteDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	cloner := &fakeCloner{}
+	oldResolver := newResolver
+	newResolver = func() (*remote.Resolver, error) {
+		return &remote.Resolver{Cloner: cloner, CacheDir: cacheDir}, nil
+	}
+	oldDir := dirFlag
+	defer func() {
+		newResolver = oldResolver
+		dirFlag = oldDir
+	}()
+
+	t.Run("local paths are kept", func(t *testing.T) {
+		dirFlag = "/custom/dotfiles"
+		require.NoError(t, resolveRemoteDir(context.Background()))
+		assert.Equal(t, "/custom/dotfiles", dirFlag)
+		assert.Zero(t, cloner.clones)
+	})
+
+	t.Run("git URLs are cloned then pulled", func(t *testing.T) {
+		dirFlag = "git@github.com:me/dotfiles.git"
+		require.NoError(t, resolveRemoteDir(context.Background()))
+		assert.True(t, strings.HasPrefix(dirFlag, cacheDir), dirFlag)
+		assert.FileExists(t, filepath.Join(dirFlag, "DotRoot"))
+		clone := dirFlag
+
+		dirFlag = "git@github.com:me/dotfiles.git"
+		require.NoError(t, resolveRemoteDir(context.Background()))
+		assert.Equal(t, clone, dirFlag)
+		assert.Equal(t, 1, cloner.clones)
+		assert.Equal(t, 1, cloner.pulls)
+	})
+}
@@ -1,13 +1,42 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 
+	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/logger"
 	"github.com/elmhuangyu/dotman/pkg/module"
 	"github.com/spf13/cobra"
 )
 
+var (
+	verifyOwnerFlag           bool
+	strictUninstallFlag       bool
+	validateAgainstConfigFlag bool
+	uninstallSourceDirFlag    string
+)
+
+// uninstallOptions contains the command line options of the uninstall command
+type uninstallOptions struct {
+	VerifyOwner bool
+	// Strict fails the uninstallation when any entry is skipped or fails to be removed
+	Strict bool
+	// ValidateAgainstConfig warns about state entries the current config no longer declares
+	ValidateAgainstConfig bool
+	// HashCache reuses the hashes of generated files cached by earlier uninstallations when
+	// their size and mtime are unchanged
+	HashCache bool
+	// SourceDir is the directory the config checked by ValidateAgainstConfig loads its modules
+	// from when set, instead of the dotfiles directory
+	SourceDir string
+	// Profile selects the state file, so only that profile's installation is removed
+	Profile string
+	// SummaryOut receives the one-line machine summary of the result when set
+	SummaryOut io.Writer
+}
+
 // uninstallCmd represents the uninstall command
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
@@ -16,25 +45,54 @@ var uninstallCmd = &cobra.Command{
 This command cleans up configuration files installed by the install command.`,
 	SilenceUsage:  true,
 	SilenceErrors: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if uninstallSourceDirFlag != "" && !validateAgainstConfigFlag {
+			return fmt.Errorf("--source-dir requires --validate-against-config")
+		}
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		dotfilesDir, err := getDotfilesDir()
 		if err != nil {
 			return err
 		}
-		return uninstall(dotfilesDir)
+		var summaryOut io.Writer
+		if summaryOnlyFlag {
+			logger.SetQuietMode()
+			summaryOut = cmd.OutOrStdout()
+		}
+		return uninstall(cmd.Context(), dotfilesDir, uninstallOptions{VerifyOwner: verifyOwnerFlag, Strict: strictUninstallFlag, ValidateAgainstConfig: validateAgainstConfigFlag, HashCache: hashCacheFlag, SourceDir: uninstallSourceDirFlag, Profile: profileFlag, SummaryOut: summaryOut})
 	},
 }
 
 // uninstall performs the dotfiles uninstallation
-func uninstall(dotfilesDir string) error {
+func uninstall(ctx context.Context, dotfilesDir string, opts uninstallOptions) error {
 	log := logger.GetLogger()
 
 	log.Info().Str("dotfiles_dir", dotfilesDir).Msg("Starting uninstallation")
 
+	// The DotRoot only configures backups here; a broken one must not keep the installation in place
+	rootConfig, err := config.LoadRootConfig(dotfilesDir)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load root config, using the default backup options")
+		rootConfig = config.RootConfig{}
+	}
+
 	// Create uninstall configuration
 	uninstallConfig := &module.UninstallConfig{
-		BackupModified: true, // Default to backing up modified files
-		StatePath:      dotfilesDir,
+		BackupModified:        true, // Default to backing up modified files
+		StatePath:             dotfilesDir,
+		VerifyOwner:           opts.VerifyOwner,
+		MaxBackups:            rootConfig.MaxBackups,
+		CompressBackups:       rootConfig.CompressBackups,
+		TimestampBackups:      rootConfig.TimestampBackups,
+		BackupSuffix:          rootConfig.BackupSuffix,
+		Profile:               opts.Profile,
+		Strict:                opts.Strict,
+		ValidateAgainstConfig: opts.ValidateAgainstConfig,
+		SourceDir:             opts.SourceDir,
+		HashCache:             opts.HashCache,
+		Context:               ctx,
 	}
 
 	// Perform uninstallation using the new configuration
@@ -45,6 +103,9 @@ func uninstall(dotfilesDir string) error {
 
 	// Log the results
 	log.Info().Str("summary", result.Summary).Msg("Uninstall completed")
+	if opts.SummaryOut != nil {
+		fmt.Fprintln(opts.SummaryOut, result.OneLine())
+	}
 
 	// Log any errors that occurred during the process
 	if len(result.Errors) > 0 {
@@ -69,6 +130,7 @@ func uninstall(dotfilesDir string) error {
 			log.Info().
 				Str("target", skipped.Target).
 				Str("reason", reason).
+				Str("reason_code", string(skipped.ReasonCode)).
 				Msg("Skipped symlink removal")
 		}
 	}
@@ -88,10 +150,24 @@ func uninstall(dotfilesDir string) error {
 			log.Info().
 				Str("target", skipped.Target).
 				Str("reason", reason).
+				Str("reason_code", string(skipped.ReasonCode)).
 				Msg("Skipped generated file removal")
 		}
 	}
 
+	// Log skipped blocks of shared files with reasons
+	for _, skipped := range result.SkippedBlocks {
+		reason := "unknown"
+		if skipped.Error != nil {
+			reason = skipped.Error.Error()
+		}
+		log.Info().
+			Str("target", skipped.Target).
+			Str("reason", reason).
+			Str("reason_code", string(skipped.ReasonCode)).
+			Msg("Skipped block removal")
+	}
+
 	// Log backed up generated files
 	if len(result.BackedUpGenerated) > 0 {
 		log.Warn().Int("backed_up_count", len(result.BackedUpGenerated)).Msg("Some generated files were backed up due to modifications")
@@ -138,5 +214,11 @@ func uninstall(dotfilesDir string) error {
 }
 
 func init() {
+	uninstallCmd.Flags().BoolVar(&verifyOwnerFlag, "verify-owner", false, "Skip symlinks not owned by the current user (for shared machines)")
+	uninstallCmd.Flags().BoolVar(&strictUninstallFlag, "strict", false, "Fail when any entry is skipped or can't be removed, after removing everything that is safe to remove")
+	uninstallCmd.Flags().BoolVar(&validateAgainstConfigFlag, "validate-against-config", false, "Warn about state entries the current config no longer declares, such as files of removed modules")
+	uninstallCmd.Flags().StringVar(&uninstallSourceDirFlag, "source-dir", "", "Check against the modules of this directory, as installed with install --source-dir")
+	uninstallCmd.Flags().BoolVar(&hashCacheFlag, "hash-cache", false, "Skip rehashing generated files unchanged in size and mtime, using .dotman-cache.yaml in the dotfiles directory")
+	uninstallCmd.Flags().BoolVar(&summaryOnlyFlag, "summary-only", false, "Only print errors and a single machine-readable summary line")
 	rootCmd.AddCommand(uninstallCmd)
 }
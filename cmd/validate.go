@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/goccy/go-yaml"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkFlag            bool
+	validateMkdirFlag    bool
+	printConfigFlag      bool
+	validateWritableFlag bool
+	validateSourceFlag   string
+)
+
+// validateOptions contains the command line options of the validate command
+type validateOptions struct {
+	// Check exits with module.ExitRequiresForce instead of 1 when only --force is missing
+	Check bool
+	Mkdir bool
+	// CheckWritable reports target directories that can't be written as errors
+	CheckWritable bool
+	// Profile is the profile being validated; targets of other profiles conflict
+	Profile string
+	// SourceDir is the directory modules are loaded from when set, instead of the dotfiles directory
+	SourceDir string
+}
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check what an install would do without making changes",
+	Long: `Validate the dotfiles configuration against the system, like install --dry-run.
+
+With --check the exit code is a contract for CI:
+  0  a plain install would only create files, or change nothing
+  1  the installation would fail
+  2  existing files conflict, so the installation needs --force
+
+With --print-config the resolved configuration is printed as YAML instead:
+target_dir expanded, excluded modules dropped and defaults filled in.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dotfilesDir, err := getDotfilesDir()
+		if err != nil {
+			return err
+		}
+
+		if printConfigFlag {
+			if validateSourceFlag != "" {
+				return fmt.Errorf("--print-config cannot be used with --source-dir")
+			}
+			return printConfig(cmd.OutOrStdout(), dotfilesDir)
+		}
+
+		return validate(cmd.Context(), dotfilesDir, validateOptions{
+			Check:         checkFlag,
+			Mkdir:         validateMkdirFlag,
+			CheckWritable: validateWritableFlag,
+			Profile:       profileFlag,
+			SourceDir:     validateSourceFlag,
+		})
+	},
+}
+
+// validate reports what an install would do, failing when a plain install would not succeed
+func validate(ctx context.Context, dotfilesDir string, opts validateOptions) error {
+	log := logger.GetLogger()
+
+	sourceDir := dotfilesDir
+	if opts.SourceDir != "" {
+		sourceDir = opts.SourceDir
+	}
+	cfg, err := config.LoadDirWithSourceDir(dotfilesDir, sourceDir)
+	if err != nil {
+		return err
+	}
+
+	result, err := module.ValidateWithConfig(cfg.Modules, &module.ValidateConfig{
+		Mkdir:             opts.Mkdir,
+		Vars:              cfg.RootConfig.Vars,
+		MkdirAllowedRoots: cfg.RootConfig.MkdirAllowedRoots,
+		StrictTargetDirs:  cfg.RootConfig.StrictTargetDirs,
+		KeepDangling:      !cfg.RootConfig.ReplacesDangling(),
+		StateDir:          dotfilesDir,
+		Profile:           opts.Profile,
+		CheckWritable:     opts.CheckWritable,
+		CompressBackups:   cfg.RootConfig.CompressBackups,
+		TimestampBackups:  cfg.RootConfig.TimestampBackups,
+		BackupSuffix:      cfg.RootConfig.BackupSuffix,
+		Context:           ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	module.LogValidateResult(result)
+
+	if result.IsValid {
+		log.Info().Msg("Validation passed - a plain install would succeed")
+		return nil
+	}
+
+	err = fmt.Errorf("validation failed with %d errors and %d conflicts", len(result.Errors), len(result.Conflicts()))
+	if opts.Check {
+		return &exitCodeError{code: result.ExitCode(), err: err}
+	}
+	return err
+}
+
+// printConfig writes the resolved configuration of dotfilesDir to w as YAML
+func printConfig(w io.Writer, dotfilesDir string) error {
+	cfg, err := config.ResolveConfig(dotfilesDir)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(config.NewResolvedConfig(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func init() {
+	validateCmd.Flags().BoolVar(&checkFlag, "check", false, "Exit with 2 when existing files would need --force, and 1 on errors")
+	validateCmd.Flags().BoolVar(&validateMkdirFlag, "mkdir", false, "Allow missing target directories, as install --mkdir would create them")
+	validateCmd.Flags().BoolVar(&validateWritableFlag, "check-writable", false, "Report directories that would receive files but aren't writable as errors")
+	validateCmd.Flags().StringVar(&validateSourceFlag, "source-dir", "", "Load the modules from this directory instead of the dotfiles directory, as install --source-dir would")
+	validateCmd.Flags().BoolVar(&printConfigFlag, "print-config", false, "Print the resolved configuration as YAML instead of validating")
+	rootCmd.AddCommand(validateCmd)
+}
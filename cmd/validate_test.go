@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCheckExitCodes(t *testing.T) {
+	// setup creates a dotfiles directory with one module linking config.txt into a target directory
+	setup := func(t *testing.T) (string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "module")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "config.txt"), []byte("content"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+		return dotfilesDir, targetDir
+	}
+
+	tests := []struct {
+		name     string
+		prepare  func(t *testing.T, dotfilesDir, targetDir string)
+		wantCode int
+	}{
+		{
+			name:     "clean create",
+			prepare:  func(t *testing.T, dotfilesDir, targetDir string) {},
+			wantCode: module.ExitClean,
+		},
+		{
+			name: "existing file requires force",
+			prepare: func(t *testing.T, dotfilesDir, targetDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(targetDir, "config.txt"), []byte("existing"), 0644))
+			},
+			wantCode: module.ExitRequiresForce,
+		},
+		{
+			name: "missing target directory is an error",
+			prepare: func(t *testing.T, dotfilesDir, targetDir string) {
+				require.NoError(t, os.RemoveAll(targetDir))
+			},
+			wantCode: module.ExitErrors,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dotfilesDir, targetDir := setup(t)
+			tt.prepare(t, dotfilesDir, targetDir)
+
+			err := validate(context.Background(), dotfilesDir, validateOptions{Check: true})
+			if tt.wantCode == module.ExitClean {
+				assert.NoError(t, err)
+				return
+			}
+			var exitErr *exitCodeError
+			require.True(t, errors.As(err, &exitErr), "expected an exit code error, got %v", err)
+			assert.Equal(t, tt.wantCode, exitErr.code)
+
+			// Without --check every failure exits with the default code
+			err = validate(context.Background(), dotfilesDir, validateOptions{})
+			require.Error(t, err)
+			assert.False(t, errors.As(err, &exitErr))
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+
+	dotfilesDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("exclude_modules:\n  - work\n"), 0644))
+	for name, dotfile := range map[string]string{
+		"nvim": "target_dir: $HOME/.config/nvim\ndir_mode: 0700\n",
+		"work": "target_dir: $HOME/work\n",
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(dotfilesDir, name), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, name, "Dotfile"), []byte(dotfile), 0644))
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, printConfig(&out, dotfilesDir))
+
+	assert.Contains(t, out.String(), "target_dir: "+filepath.Join(homeDir, ".config", "nvim"))
+	assert.Contains(t, out.String(), `dir_mode: "0700"`)
+	assert.NotContains(t, out.String(), filepath.Join(homeDir, "work"))
+}
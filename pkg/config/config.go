@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 type Config struct {
@@ -11,40 +14,230 @@ type Config struct {
 }
 
 func LoadDir(rootDir string) (*Config, error) {
+	return LoadDirWithSourceDir(rootDir, rootDir)
+}
+
+// LoadDirWithSourceDir loads the DotRoot of rootDir like LoadDir, but discovers the modules
+// in sourceDir, for module sources kept apart from the DotRoot and state files. The
+// module_roots globs are relative to sourceDir.
+func LoadDirWithSourceDir(rootDir, sourceDir string) (*Config, error) {
 	// Load root config
 	rootConfig, err := LoadRootConfig(rootDir)
 	if err != nil {
 		return nil, err
 	}
 
-	ls, err := os.ReadDir(rootDir)
+	discovered, err := discoverModuleDirs(sourceDir, rootConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	var modules []ModuleConfig
-	for _, entry := range ls {
-		if !entry.IsDir() {
-			continue
-		}
-
+	// skipped are the names of excluded and disabled modules
+	skipped := make(map[string]bool)
+	// dirs maps the name of every loaded module to its directory
+	dirs := make(map[string]string)
+	for _, candidate := range discovered {
 		// Skip excluded modules
-		if rootConfig.IsModuleExcluded(entry.Name()) {
+		if rootConfig.IsModuleExcluded(candidate.name) {
+			skipped[candidate.name] = true
 			continue
 		}
 
-		moduleDir := filepath.Join(rootDir, entry.Name())
-		moduleConfig, err := LoadConfig(moduleDir)
+		moduleConfig, err := LoadConfigWithVars(candidate.dir, rootConfig.Vars)
 		if err != nil {
 			return nil, err
 		}
+		if moduleConfig != nil && candidate.name != filepath.Base(candidate.dir) {
+			moduleConfig.DerivedName = candidate.name
+		}
+		// Skip modules disabled in their own Dotfile
+		if moduleConfig != nil && !moduleConfig.IsEnabled() {
+			skipped[moduleConfig.Name()] = true
+			continue
+		}
 		if moduleConfig != nil {
+			moduleConfig.VCSExcludes = rootConfig.VCSExcludes
+			moduleConfig.KeepFile = rootConfig.KeepFile
+			moduleConfig.RootVars = rootConfig.Vars
+			if moduleConfig.IncludeHidden == nil && rootConfig.IncludeHidden {
+				moduleConfig.IncludeHidden = &rootConfig.IncludeHidden
+			}
+			if moduleConfig.MaxFileSize == 0 {
+				moduleConfig.MaxFileSize = rootConfig.MaxFileSize
+			}
+			if moduleConfig.MaxDepth == 0 {
+				moduleConfig.MaxDepth = rootConfig.MaxDepth
+			}
+			// Modules are told apart by name everywhere, from depends_on to the state file
+			if other, ok := dirs[candidate.name]; ok {
+				return nil, fmt.Errorf("modules %s and %s are both named %s", other, candidate.dir, candidate.name)
+			}
+			dirs[candidate.name] = candidate.dir
 			modules = append(modules, *moduleConfig)
 		}
 	}
 
+	// A module excluded on this machine isn't installed, so depending on it doesn't order
+	// anything; only names that aren't configured at all are unknown dependencies
+	for i := range modules {
+		modules[i].DependsOn = slices.DeleteFunc(modules[i].DependsOn, func(dep string) bool {
+			return skipped[dep]
+		})
+	}
+
 	return &Config{
 		RootConfig: rootConfig,
 		Modules:    modules,
 	}, nil
 }
+
+// OverrideTargetDir sets the target_dir of the module named name to targetDir, which must be
+// an absolute path, leaving the other modules as they are. A single-file module keeps the
+// name of its target_file in the new directory.
+func OverrideTargetDir(modules []ModuleConfig, name, targetDir string) error {
+	if !filepath.IsAbs(targetDir) {
+		return fmt.Errorf("target dir %s of module %s must be an absolute path", targetDir, name)
+	}
+	targetDir = filepath.Clean(targetDir)
+
+	for i := range modules {
+		if modules[i].Name() != name {
+			continue
+		}
+		if modules[i].TargetFile != "" {
+			modules[i].TargetFile = filepath.Join(targetDir, filepath.Base(modules[i].TargetFile))
+		}
+		modules[i].TargetDir = targetDir
+		return nil
+	}
+	return fmt.Errorf("module %s is not configured", name)
+}
+
+// ListModuleNames returns the names of the modules LoadDir would load from rootDir, for shell
+// completion: the discovered, non-excluded directories with a Dotfile that doesn't disable the
+// module. Dotfiles are only read for enabled, not rendered or validated, so a broken one is
+// still listed.
+func ListModuleNames(rootDir string) ([]string, error) {
+	rootConfig, err := LoadRootConfig(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	discovered, err := discoverModuleDirs(rootDir, rootConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, candidate := range discovered {
+		if rootConfig.IsModuleExcluded(candidate.name) {
+			continue
+		}
+		configPath, err := findConfigFile(candidate.dir, ModuleConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if configPath == "" {
+			continue
+		}
+		if !isModuleDisabled(configPath) {
+			names = append(names, candidate.name)
+		}
+	}
+	return names, nil
+}
+
+// ModuleDirNames maps every candidate module directory under rootDir, excluded ones included,
+// to the name its module is loaded as
+func ModuleDirNames(rootDir string, rootConfig RootConfig) (map[string]string, error) {
+	discovered, err := discoverModuleDirs(rootDir, rootConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(discovered))
+	for _, candidate := range discovered {
+		names[filepath.Clean(candidate.dir)] = candidate.name
+	}
+	return names, nil
+}
+
+// discoveredModule is a candidate module directory and the name it would have
+type discoveredModule struct {
+	dir, name string
+}
+
+// discoverModuleDirs returns the candidate module directories under rootDir.
+// Without module_roots only the immediate subdirectories are candidates,
+// otherwise every directory matched by one of the module_roots globs is.
+func discoverModuleDirs(rootDir string, rootConfig RootConfig) ([]discoveredModule, error) {
+	if len(rootConfig.ModuleRoots) == 0 {
+		ls, err := os.ReadDir(rootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		var dirs []discoveredModule
+		for _, entry := range ls {
+			if !entry.IsDir() {
+				continue
+			}
+			dirs = append(dirs, discoveredModule{dir: filepath.Join(rootDir, entry.Name()), name: entry.Name()})
+		}
+		return dirs, nil
+	}
+
+	// Make sure the root itself exists so a typo in --dir is reported
+	if _, err := os.ReadDir(rootDir); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var dirs []discoveredModule
+	for _, pattern := range rootConfig.ModuleRoots {
+		matches, err := filepath.Glob(filepath.Join(rootDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid module_roots pattern '%s': %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			seen[match] = true
+			dirs = append(dirs, discoveredModule{dir: match, name: moduleRootName(pattern, match)})
+		}
+	}
+
+	return dirs, nil
+}
+
+// moduleRootName names the directory match of the module_roots pattern after the path
+// components its wildcards matched, joined with "-", so packages/editor/dotfiles matched by
+// packages/*/dotfiles is editor. A pattern without wildcards uses the base name of match.
+func moduleRootName(pattern, match string) string {
+	// Glob keeps one component per pattern component, so they line up from the end; a
+	// cleaned pattern only has .. components at its start
+	patternParts := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+	matchParts := strings.Split(match, string(filepath.Separator))
+
+	var parts []string
+	for i := 1; i <= len(patternParts) && i <= len(matchParts); i++ {
+		part := patternParts[len(patternParts)-i]
+		if part == ".." {
+			break
+		}
+		if strings.ContainsAny(part, "*?[") {
+			parts = append([]string{matchParts[len(matchParts)-i]}, parts...)
+		}
+	}
+	if len(parts) == 0 {
+		return filepath.Base(match)
+	}
+	return strings.Join(parts, "-")
+}
@@ -224,6 +224,327 @@ exclude_modules:
 				}
 			},
 		},
+		{
+			name: "NestedModuleRoots",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`module_roots:
+  - "packages/*/dotfiles"
+exclude_modules:
+  - "ignored"`), 0644)
+				require.NoError(t, err)
+
+				for _, pkg := range []string{"editor", "shell"} {
+					moduleDir := filepath.Join(rootDir, "packages", pkg, "dotfiles")
+					err = os.MkdirAll(moduleDir, 0755)
+					require.NoError(t, err)
+					err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user/`+pkg+`"`), 0644)
+					require.NoError(t, err)
+				}
+
+				// Matched directory without a Dotfile is not a module
+				err = os.MkdirAll(filepath.Join(rootDir, "packages", "docs", "dotfiles"), 0755)
+				require.NoError(t, err)
+
+				// Top-level module is not discovered when module_roots is set
+				topDir := filepath.Join(rootDir, "top")
+				err = os.Mkdir(topDir, 0755)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(topDir, "Dotfile"), []byte(`target_dir: "/home/user/top"`), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars: map[string]string{
+							"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!",
+						},
+						ExcludeModules: []string{"ignored"},
+						ModuleRoots:    []string{"packages/*/dotfiles"},
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:         filepath.Join(tmpDir, "NestedModuleRoots", "packages", "editor", "dotfiles"),
+							TargetDir:   "/home/user/editor",
+							DerivedName: "editor",
+						},
+						{
+							Dir:         filepath.Join(tmpDir, "NestedModuleRoots", "packages", "shell", "dotfiles"),
+							TargetDir:   "/home/user/shell",
+							DerivedName: "shell",
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "ModuleRootsExcludeByBaseName",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`module_roots:
+  - "modules/*"
+exclude_modules:
+  - "tmux"`), 0644)
+				require.NoError(t, err)
+
+				for _, name := range []string{"nvim", "tmux"} {
+					moduleDir := filepath.Join(rootDir, "modules", name)
+					err = os.MkdirAll(moduleDir, 0755)
+					require.NoError(t, err)
+					err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user/`+name+`"`), 0644)
+					require.NoError(t, err)
+				}
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars: map[string]string{
+							"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!",
+						},
+						ExcludeModules: []string{"tmux"},
+						ModuleRoots:    []string{"modules/*"},
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:       filepath.Join(tmpDir, "ModuleRootsExcludeByBaseName", "modules", "nvim"),
+							TargetDir: "/home/user/nvim",
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "TemplatedTargetDirAndIgnores",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`vars:
+  HOMEDIR: "/home/john"
+  PROFILE: "work"`), 0644)
+				require.NoError(t, err)
+
+				moduleDir := filepath.Join(rootDir, "nvim")
+				err = os.Mkdir(moduleDir, 0755)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "{{.HOMEDIR}}/.config/{{.PROFILE}}/nvim"
+ignores:
+  - "{{.PROFILE}}.local"
+  - "README.md"`), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars: map[string]string{
+							"HOMEDIR":   "/home/john",
+							"PROFILE":   "work",
+							"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!",
+						},
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:       filepath.Join(tmpDir, "TemplatedTargetDirAndIgnores", "nvim"),
+							TargetDir: "/home/john/.config/work/nvim",
+							Ignores:   []string{"work.local", "README.md"},
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "VCSExcludesCopiedToModules",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`vcs_excludes: [".git"]`), 0644)
+				require.NoError(t, err)
+
+				moduleDir := filepath.Join(rootDir, "git")
+				require.NoError(t, os.Mkdir(moduleDir, 0755))
+				err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user"`), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:        map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						VCSExcludes: []string{".git"},
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:         filepath.Join(tmpDir, "VCSExcludesCopiedToModules", "git"),
+							TargetDir:   "/home/user",
+							VCSExcludes: []string{".git"},
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "IncludeHiddenInheritedFromRoot",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`include_hidden: true`), 0644)
+				require.NoError(t, err)
+
+				moduleDir := filepath.Join(rootDir, "shell")
+				require.NoError(t, os.Mkdir(moduleDir, 0755))
+				err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user"`), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				includeHidden := true
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:          map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						IncludeHidden: true,
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:           filepath.Join(tmpDir, "IncludeHiddenInheritedFromRoot", "shell"),
+							TargetDir:     "/home/user",
+							IncludeHidden: &includeHidden,
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "IncludeHiddenModuleOverride",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`include_hidden: true`), 0644)
+				require.NoError(t, err)
+
+				moduleDir := filepath.Join(rootDir, "shell")
+				require.NoError(t, os.Mkdir(moduleDir, 0755))
+				err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte("target_dir: \"/home/user\"\ninclude_hidden: false"), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				includeHidden := false
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:          map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						IncludeHidden: true,
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:           filepath.Join(tmpDir, "IncludeHiddenModuleOverride", "shell"),
+							TargetDir:     "/home/user",
+							IncludeHidden: &includeHidden,
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "MaxFileSizeInheritedAndOverridden",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`max_file_size: 5MB`), 0644)
+				require.NoError(t, err)
+
+				for _, name := range []string{"assets", "shell"} {
+					require.NoError(t, os.Mkdir(filepath.Join(rootDir, name), 0755))
+				}
+				err = os.WriteFile(filepath.Join(rootDir, "assets", "Dotfile"), []byte("target_dir: \"/home/user\"\nmax_file_size: 50MB"), 0644)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(rootDir, "shell", "Dotfile"), []byte(`target_dir: "/home/user"`), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:        map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						MaxFileSize: 5 << 20,
+					},
+					Modules: []ModuleConfig{
+						{
+							Dir:         filepath.Join(tmpDir, "MaxFileSizeInheritedAndOverridden", "assets"),
+							TargetDir:   "/home/user",
+							MaxFileSize: 50 << 20,
+						},
+						{
+							Dir:         filepath.Join(tmpDir, "MaxFileSizeInheritedAndOverridden", "shell"),
+							TargetDir:   "/home/user",
+							MaxFileSize: 5 << 20,
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "DisabledModuleSkipped",
+			setupFunc: func(t *testing.T, rootDir string) {
+				for _, name := range []string{"wip", "shell", "git"} {
+					require.NoError(t, os.Mkdir(filepath.Join(rootDir, name), 0755))
+				}
+				err := os.WriteFile(filepath.Join(rootDir, "wip", "Dotfile"), []byte("target_dir: \"/home/user\"\nenabled: false"), 0644)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(rootDir, "shell", "Dotfile"), []byte(`target_dir: "/home/user"`), 0644)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(rootDir, "git", "Dotfile"), []byte("target_dir: \"/home/user\"\nenabled: true"), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				enabled := true
+				return &Config{
+					Modules: []ModuleConfig{
+						{
+							Dir:       filepath.Join(tmpDir, "DisabledModuleSkipped", "git"),
+							TargetDir: "/home/user",
+							Enabled:   &enabled,
+						},
+						{
+							Dir:       filepath.Join(tmpDir, "DisabledModuleSkipped", "shell"),
+							TargetDir: "/home/user",
+						},
+					},
+				}
+			},
+		},
+		{
+			name: "ExcludedModuleSkippedEvenIfEnabled",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`exclude_modules: ["work"]`), 0644)
+				require.NoError(t, err)
+				require.NoError(t, os.Mkdir(filepath.Join(rootDir, "work"), 0755))
+				err = os.WriteFile(filepath.Join(rootDir, "work", "Dotfile"), []byte("target_dir: \"/home/user\"\nenabled: true"), 0644)
+				require.NoError(t, err)
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:           map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						ExcludeModules: []string{"work"},
+					},
+				}
+			},
+		},
+		{
+			name: "DependenciesOnSkippedModulesDropped",
+			setupFunc: func(t *testing.T, rootDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`exclude_modules: ["work"]`), 0644))
+				dotfiles := map[string]string{
+					"work":  `target_dir: "/home/user"`,
+					"wip":   "target_dir: \"/home/user\"\nenabled: false",
+					"git":   `target_dir: "/home/user"`,
+					"shell": "target_dir: \"/home/user\"\ndepends_on: [work, wip, git]",
+				}
+				for name, dotfile := range dotfiles {
+					require.NoError(t, os.Mkdir(filepath.Join(rootDir, name), 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(rootDir, name, "Dotfile"), []byte(dotfile), 0644))
+				}
+			},
+			wantConfig: func(tmpDir string) *Config {
+				return &Config{
+					RootConfig: RootConfig{
+						Vars:           map[string]string{"DONT_EDIT": "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"},
+						ExcludeModules: []string{"work"},
+					},
+					Modules: []ModuleConfig{
+						{Dir: filepath.Join(tmpDir, "DependenciesOnSkippedModulesDropped", "git"), TargetDir: "/home/user"},
+						{
+							Dir:       filepath.Join(tmpDir, "DependenciesOnSkippedModulesDropped", "shell"),
+							TargetDir: "/home/user",
+							DependsOn: []string{"git"},
+						},
+					},
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -237,6 +558,10 @@ exclude_modules:
 			require.NoError(t, err)
 
 			expected := tt.wantConfig(tmpDir)
+			// Every module carries the root vars for its conditions
+			for i := range expected.Modules {
+				expected.Modules[i].RootVars = expected.RootConfig.Vars
+			}
 			assert.Equal(t, expected.RootConfig, config.RootConfig)
 			assert.ElementsMatch(t, expected.Modules, config.Modules)
 		})
@@ -315,6 +640,34 @@ exclude_modules:
 			},
 			errContains: "exclude_modules[0] 'module/invalid' contains invalid characters",
 		},
+		{
+			name: "UndefinedVarInTargetDir",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(`vars:
+  HOMEDIR: "/home/john"`), 0644)
+				require.NoError(t, err)
+
+				moduleDir := filepath.Join(rootDir, "nvim")
+				err = os.Mkdir(moduleDir, 0755)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "{{.HOMEDIR}}/{{.PROFILE}}/nvim"`), 0644)
+				require.NoError(t, err)
+			},
+			errContains: `map has no entry for key "PROFILE"`,
+		},
+		{
+			name: "ModuleRootsDuplicateNames",
+			setupFunc: func(t *testing.T, rootDir string) {
+				err := os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte("module_roots:\n  - \"home/*\"\n  - \"work/*\""), 0644)
+				require.NoError(t, err)
+				for _, parent := range []string{"home", "work"} {
+					moduleDir := filepath.Join(rootDir, parent, "nvim")
+					require.NoError(t, os.MkdirAll(moduleDir, 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user"`), 0644))
+				}
+			},
+			errContains: "home/nvim and " + filepath.Join(tmpDir, "ModuleRootsDuplicateNames", "work", "nvim") + " are both named nvim",
+		},
 	}
 
 	for _, tt := range tests {
@@ -338,3 +691,144 @@ exclude_modules:
 		})
 	}
 }
+
+func TestListModuleNames(t *testing.T) {
+	setup := func(t *testing.T, rootConfig string, modules ...string) string {
+		rootDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte(rootConfig), 0644))
+		for _, module := range modules {
+			moduleDir := filepath.Join(rootDir, module)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/user"`), 0644))
+		}
+		// Neither a directory without a Dotfile nor a file is a module
+		require.NoError(t, os.Mkdir(filepath.Join(rootDir, "docs"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "README.md"), []byte("# dotfiles"), 0644))
+		return rootDir
+	}
+
+	t.Run("matches the loaded modules", func(t *testing.T) {
+		rootDir := setup(t, "exclude_modules:\n  - work\n", "nvim", "bash", "work", "wip")
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "wip", "Dotfile"), []byte("target_dir: \"/home/user\"\nenabled: false\n"), 0644))
+
+		names, err := ListModuleNames(rootDir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bash", "nvim"}, names)
+
+		cfg, err := LoadDir(rootDir)
+		require.NoError(t, err)
+		var loaded []string
+		for _, module := range cfg.Modules {
+			loaded = append(loaded, module.Name())
+		}
+		assert.Equal(t, loaded, names)
+	})
+
+	t.Run("module roots", func(t *testing.T) {
+		rootDir := setup(t, "module_roots:\n  - \"*\"\n  - \"hosts/*\"\nexclude_modules:\n  - laptop\n", "nvim", "hosts/desktop", "hosts/laptop")
+
+		names, err := ListModuleNames(rootDir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"nvim", "desktop"}, names)
+	})
+
+	t.Run("module roots sharing a base name", func(t *testing.T) {
+		rootDir := setup(t, "module_roots:\n  - \"packages/*/dotfiles\"\n", "packages/editor/dotfiles", "packages/shell/dotfiles")
+
+		names, err := ListModuleNames(rootDir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"editor", "shell"}, names)
+
+		cfg, err := LoadDir(rootDir)
+		require.NoError(t, err)
+		require.Len(t, cfg.Modules, 2)
+		assert.Equal(t, "editor", cfg.Modules[0].Name())
+		assert.Equal(t, "shell", cfg.Modules[1].Name())
+	})
+
+	t.Run("broken Dotfiles are still listed", func(t *testing.T) {
+		rootDir := setup(t, "", "nvim")
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "nvim", "Dotfile"), []byte("target_dir: [broken"), 0644))
+
+		names, err := ListModuleNames(rootDir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"nvim"}, names)
+	})
+
+	t.Run("missing root directory", func(t *testing.T) {
+		_, err := ListModuleNames(filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadDirWithSourceDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	rootDir := filepath.Join(tmpDir, "dotman")
+	sourceDir := filepath.Join(tmpDir, "dotfiles")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, "stray"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "nvim"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte("vars:\n  editor: nvim\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "stray", "Dotfile"), []byte(`target_dir: "/home/user/stray"`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "nvim", "Dotfile"), []byte(`target_dir: "/home/user/.config/{{.editor}}"`), 0644))
+
+	cfg, err := LoadDirWithSourceDir(rootDir, sourceDir)
+	require.NoError(t, err)
+	assert.Equal(t, "nvim", cfg.RootConfig.Vars["editor"])
+	require.Len(t, cfg.Modules, 1, "only modules of the source directory are loaded")
+	assert.Equal(t, filepath.Join(sourceDir, "nvim"), cfg.Modules[0].Dir)
+	assert.Equal(t, "/home/user/.config/nvim", cfg.Modules[0].TargetDir)
+}
+
+func TestOverrideTargetDir(t *testing.T) {
+	modules := func() []ModuleConfig {
+		return []ModuleConfig{
+			{Dir: "/dots/nvim", TargetDir: "/home/user/.config/nvim"},
+			{Dir: "/dots/zsh", TargetDir: "/home/user"},
+			{Dir: "/dots/git", TargetDir: "/home/user", TargetFile: "/home/user/.gitconfig"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		module    string
+		targetDir string
+		want      func(modules []ModuleConfig)
+		wantErr   string
+	}{
+		{
+			name:      "only the named module is changed",
+			module:    "nvim",
+			targetDir: "/tmp/test/",
+			want: func(modules []ModuleConfig) {
+				modules[0].TargetDir = "/tmp/test"
+			},
+		},
+		{
+			name:      "a single-file module keeps its file name",
+			module:    "git",
+			targetDir: "/tmp/test",
+			want: func(modules []ModuleConfig) {
+				modules[2].TargetDir = "/tmp/test"
+				modules[2].TargetFile = "/tmp/test/.gitconfig"
+			},
+		},
+		{name: "relative target dir", module: "nvim", targetDir: "tmp/test", wantErr: "must be an absolute path"},
+		{name: "unknown module", module: "emacs", targetDir: "/tmp/test", wantErr: "module emacs is not configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := modules()
+			err := OverrideTargetDir(got, tt.module, tt.targetDir)
+
+			want := modules()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				tt.want(want)
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Names of the module and root configuration files. Either can be written as JSON instead,
+// in a file of the same name with the .json extension. JSON is also accepted under the
+// plain name, since YAML is a superset of JSON.
+const (
+	ModuleConfigFile = "Dotfile"
+	RootConfigFile   = "DotRoot"
+)
+
+// jsonExt is the extension of configuration files written as JSON
+const jsonExt = ".json"
+
+// findConfigFile returns the path of the configuration file name in dir, or of its JSON
+// variant. The path is empty when neither exists; both existing is an error.
+func findConfigFile(dir, name string) (string, error) {
+	var found []string
+	for _, candidate := range []string{name, name + jsonExt} {
+		path := filepath.Join(dir, candidate)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check config file %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			found = append(found, path)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("both %s and %s exist in %s, keep only one", name, name+jsonExt, dir)
+	}
+}
+
+// ReservedFileNames are the names of dotman's own control files: the module and root
+// configuration files, in either format, and .dotignore. They are never mapped from a module,
+// wherever they appear in it.
+var ReservedFileNames = []string{ModuleConfigFile, ModuleConfigFile + jsonExt, RootConfigFile, RootConfigFile + jsonExt, ".dotignore"}
+
+// IsReservedFileName reports whether fileName is one of ReservedFileNames
+func IsReservedFileName(fileName string) bool {
+	return slices.Contains(ReservedFileNames, fileName)
+}
+
+// IsModuleConfigFile reports whether fileName is the name of a module configuration file
+func IsModuleConfigFile(fileName string) bool {
+	return fileName == ModuleConfigFile || fileName == ModuleConfigFile+jsonExt
+}
+
+// parseConfig decodes the configuration read from path into out, rejecting unknown keys.
+// A .json file must be valid JSON; it is then decoded like YAML, so both formats go through
+// the same fields and validation.
+func parseConfig(path string, data []byte, out interface{}) error {
+	if filepath.Ext(path) == jsonExt && !json.Valid(data) {
+		var value interface{}
+		return fmt.Errorf("invalid JSON: %w", json.Unmarshal(data, &value))
+	}
+	return yaml.UnmarshalWithOptions(data, out, yaml.DisallowUnknownField())
+}
+
+// isModuleDisabled reports whether the Dotfile at configPath sets enabled to false, reading
+// only that key; a Dotfile that can't be read disables nothing
+func isModuleDisabled(configPath string) bool {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return false
+	}
+	var config struct {
+		Enabled *bool `yaml:"enabled"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return false
+	}
+	return config.Enabled != nil && !*config.Enabled
+}
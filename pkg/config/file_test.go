@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlModuleConfig = `target_dir: /home/user/.config/nvim
+ignores:
+  - README.md
+vars:
+  THEME: dark
+dir_mode: 0700
+max_file_size: 5MB
+`
+
+const jsonModuleConfig = `{
+	"target_dir": "/home/user/.config/nvim",
+	"ignores": ["README.md"],
+	"vars": {"THEME": "dark"},
+	"dir_mode": "0700",
+	"max_file_size": "5MB"
+}`
+
+const yamlRootConfig = `vars:
+  EDITOR: vim
+exclude_modules:
+  - work
+max_backups: 10
+`
+
+const jsonRootConfig = `{"vars": {"EDITOR": "vim"}, "exclude_modules": ["work"], "max_backups": 10}`
+
+func TestLoadJSONConfig(t *testing.T) {
+	// load writes content to name in a new directory and loads the module and root configs there
+	load := func(t *testing.T, name, content string) (*ModuleConfig, RootConfig) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		moduleConfig, err := LoadConfig(dir)
+		require.NoError(t, err)
+		if moduleConfig != nil {
+			moduleConfig.Dir = ""
+		}
+		rootConfig, err := LoadRootConfig(dir)
+		require.NoError(t, err)
+		return moduleConfig, rootConfig
+	}
+
+	t.Run("module config", func(t *testing.T) {
+		want, _ := load(t, "Dotfile", yamlModuleConfig)
+		require.NotNil(t, want)
+		assert.Equal(t, DirMode(0700), want.DirMode)
+
+		for _, name := range []string{"Dotfile.json", "Dotfile"} {
+			got, _ := load(t, name, jsonModuleConfig)
+			assert.Equal(t, want, got, name)
+		}
+	})
+
+	t.Run("root config", func(t *testing.T) {
+		_, want := load(t, "DotRoot", yamlRootConfig)
+		assert.Equal(t, "vim", want.Vars["EDITOR"])
+
+		for _, name := range []string{"DotRoot.json", "DotRoot"} {
+			_, got := load(t, name, jsonRootConfig)
+			assert.Equal(t, want, got, name)
+		}
+	})
+
+	t.Run("validation is identical", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Dotfile.json"), []byte(`{"target_dir": "relative/path"}`), 0644))
+		_, err := LoadConfig(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "target_dir must be an absolute path")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Dotfile.json"), []byte(`{"target_dir": "/home/user", "target": "typo"}`), 0644))
+		_, err = LoadConfig(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse config file")
+	})
+
+	t.Run("JSON files must be valid JSON", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Dotfile.json"), []byte("target_dir: /home/user\n"), 0644))
+		_, err := LoadConfig(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid JSON")
+	})
+
+	t.Run("both formats at once are an error", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(yamlRootConfig), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot.json"), []byte(jsonRootConfig), 0644))
+		_, err := LoadRootConfig(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "keep only one")
+	})
+
+	t.Run("DotRoot.json marks the root", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot.json"), []byte(jsonRootConfig), 0644))
+		nested := filepath.Join(dir, "nvim")
+		require.NoError(t, os.Mkdir(nested, 0755))
+
+		root, err := FindRootUpwards(nested)
+		require.NoError(t, err)
+		assert.Equal(t, dir, root)
+	})
+}
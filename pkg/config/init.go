@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExampleModule is the name of the sample module Init creates
+const ExampleModule = "example"
+
+// initRootConfig is the DotRoot Init writes, with the optional settings commented out
+const initRootConfig = `# DotRoot marks the root of a dotfiles repository managed by dotman.
+# Every subdirectory containing a Dotfile is a module.
+
+# Variables for templates (*.dot-tmpl) and the target_dir of every module
+vars:
+  EDITOR: vim
+
+# Modules to skip, by directory name
+# exclude_modules:
+#   - work
+
+# Backups kept per target when install --force replaces a file
+# max_backups: 100
+
+# Directories install --mkdir may create
+# mkdir_allowed_roots:
+#   - $HOME/.config
+`
+
+// initModuleConfig is the Dotfile of the sample module
+const initModuleConfig = `# The files of this module are linked into target_dir; $HOME is expanded
+target_dir: $HOME/.config/example
+
+# Paths never linked, matched as part of the path relative to this directory
+# ignores:
+#   - README.md
+
+# Module vars override the root vars of the same name
+# vars:
+#   EDITOR: nvim
+`
+
+// initFiles are the files Init creates, relative to the dotfiles directory
+var initFiles = []struct {
+	path    string
+	content string
+}{
+	{"DotRoot", initRootConfig},
+	{filepath.Join(ExampleModule, "Dotfile"), initModuleConfig},
+	{filepath.Join(ExampleModule, "config"), "# Linked to ~/.config/example/config by dotman install\n"},
+	{filepath.Join(ExampleModule, "editor.conf.dot-tmpl"), "# {{.DONT_EDIT}}\neditor={{.EDITOR}}\n"},
+}
+
+// Init scaffolds a new dotfiles repository in dir: a DotRoot with commented examples and
+// a sample module. It refuses to write into an existing directory that isn't empty.
+func Init(dir string) error {
+	return InitWithForce(dir, false)
+}
+
+// InitWithForce scaffolds a new dotfiles repository like Init; with force it also writes
+// into a directory that isn't empty, overwriting files of the same name
+func InitWithForce(dir string, force bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	if len(entries) > 0 && !force {
+		return fmt.Errorf("directory %s is not empty, use force to scaffold into it anyway", dir)
+	}
+
+	for _, file := range initFiles {
+		path := filepath.Join(dir, file.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(file.content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+
+	t.Run("scaffolded files load", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "dotfiles")
+		require.NoError(t, Init(dir))
+
+		cfg, err := LoadDir(dir)
+		require.NoError(t, err)
+		assert.Equal(t, "vim", cfg.RootConfig.Vars["EDITOR"])
+		require.Len(t, cfg.Modules, 1)
+		assert.Equal(t, ExampleModule, cfg.Modules[0].Name())
+		assert.Equal(t, filepath.Join(homeDir, ".config", "example"), cfg.Modules[0].TargetDir)
+		assert.FileExists(t, filepath.Join(dir, ExampleModule, "config"))
+		assert.FileExists(t, filepath.Join(dir, ExampleModule, "editor.conf.dot-tmpl"))
+	})
+
+	t.Run("non-empty directory is refused", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0644))
+
+		err := Init(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not empty")
+		assert.NoFileExists(t, filepath.Join(dir, "DotRoot"))
+	})
+
+	t.Run("force scaffolds into a non-empty directory", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0644))
+
+		require.NoError(t, InitWithForce(dir, true))
+		_, err := LoadDir(dir)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+	})
+}
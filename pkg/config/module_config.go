@@ -4,24 +4,282 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/elmhuangyu/dotman/pkg/module/template"
 	"github.com/goccy/go-yaml"
 )
 
 // ModuleConfig represents the structure of a Dotfile configuration
 type ModuleConfig struct {
-	Dir       string
+	Dir       string   `yaml:"-"`
 	TargetDir string   `yaml:"target_dir"`
 	Ignores   []string `yaml:"ignores"`
+	// TargetFile is the exact target of a single-file module, replacing target_dir, which
+	// becomes the directory of the file
+	TargetFile string `yaml:"target_file"`
+	// DependsOn lists module names that must be installed before this module
+	DependsOn []string `yaml:"depends_on"`
+	// Enabled set to false disables the module from its own Dotfile, like listing it in the
+	// root config's exclude_modules; nil means enabled
+	Enabled *bool `yaml:"enabled"`
+	// Generators produce target files from the stdout of a command
+	Generators []GeneratorConfig `yaml:"generators"`
+	// DirMode is the exact mode of directories dotman creates for the module's files
+	DirMode DirMode `yaml:"dir_mode"`
+	// Rename maps a source path relative to the module directory to the target
+	// path relative to target_dir, e.g. "git-sync.sh": "git-sync"
+	Rename map[string]string `yaml:"rename"`
+	// ExtraLinks map a source path relative to the module directory to more target paths
+	// relative to target_dir, each also linked to the source
+	ExtraLinks map[string][]string `yaml:"extra_links"`
+	// Layout is how source subdirectories map under target_dir: mirror (default) or flatten
+	Layout string `yaml:"layout"`
+	// Vars are template variables for the module's templates, overriding root vars of the same name
+	Vars map[string]string `yaml:"vars"`
+	// VCSExcludes are names never mapped from the module, copied from the root
+	// config's vcs_excludes; nil uses DefaultVCSExcludes
+	VCSExcludes []string `yaml:"-"`
+	// Formatters pipe rendered templates and generator output through a command before writing
+	Formatters []FormatterConfig `yaml:"formatters"`
+	// SkipLink are globs of files kept in the module on purpose but never linked, such as
+	// README.md or LICENSE, matched like formatter patterns against the path relative to the module
+	SkipLink []string `yaml:"skip_link"`
+	// IncludeHidden maps source files whose name starts with a dot; nil uses the root
+	// config's include_hidden, which defaults to false
+	IncludeHidden *bool `yaml:"include_hidden"`
+	// MaxFileSize overrides the root config's max_file_size for the module; zero uses the root's
+	MaxFileSize ByteSize `yaml:"max_file_size"`
+	// MaxDepth overrides the root config's max_depth for the module; zero uses the root's
+	MaxDepth int `yaml:"max_depth"`
+	// KeepFile is the placeholder name marking directories to create, copied from the
+	// root config's keep_file; empty uses DefaultKeepFile
+	KeepFile string `yaml:"-"`
+	// Conditions map a glob to a template expression; files matching the glob are only mapped
+	// when the expression, rendered with the module's vars, is not falsey
+	Conditions map[string]string `yaml:"conditions"`
+	// RootVars are the root config's vars, copied so conditions can reference them
+	RootVars map[string]string `yaml:"-"`
+	// DerivedName is the module name derived from the module_roots pattern that discovered
+	// it; empty uses the base name of Dir
+	DerivedName string `yaml:"-"`
+}
+
+// Module layouts, deciding whether source subdirectories are kept under target_dir
+const (
+	// LayoutMirror keeps the source subdirectory structure, e.g. app/config.toml -> target_dir/app/config.toml
+	LayoutMirror = "mirror"
+	// LayoutFlatten drops source subdirectories, e.g. app/config.toml -> target_dir/config.toml
+	LayoutFlatten = "flatten"
+)
+
+// DirMode is a directory permission written in octal, such as 0700.
+// Zero means unset, leaving directories at the default 0755 (subject to the umask).
+type DirMode os.FileMode
+
+// UnmarshalYAML parses the mode as octal, whether or not it is quoted
+func (mode *DirMode) UnmarshalYAML(data []byte) error {
+	text := strings.Trim(strings.TrimSpace(string(data)), `"'`)
+	text = strings.TrimPrefix(text, "0o")
+	value, err := strconv.ParseUint(text, 8, 32)
+	if err != nil {
+		return fmt.Errorf("dir_mode must be an octal permission such as 0700: %w", err)
+	}
+	*mode = DirMode(value)
+	return nil
+}
+
+// MarshalYAML writes the mode in octal, as it is read
+func (mode DirMode) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%04o", uint32(mode)), nil
+}
+
+// DefaultGeneratorTimeout is how long a generator command may run when no timeout is configured
+const DefaultGeneratorTimeout = 30 * time.Second
+
+// GeneratorConfig maps a target file to the command whose output becomes its content
+type GeneratorConfig struct {
+	// Target is the file path relative to the module's target_dir
+	Target string `yaml:"target"`
+	// Command is the program and its arguments; it is run directly, not through a shell
+	Command []string `yaml:"command"`
+	// Timeout is a Go duration string such as "10s"; defaults to DefaultGeneratorTimeout
+	Timeout string `yaml:"timeout"`
+	// Merge writes the output into a block between markers named after the module instead of
+	// replacing the whole file, so several modules and the user can share the target
+	Merge bool `yaml:"merge"`
+}
+
+// TimeoutDuration returns the configured timeout, or DefaultGeneratorTimeout when unset
+func (generator GeneratorConfig) TimeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(generator.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultGeneratorTimeout
+	}
+	return timeout
+}
+
+// FormatterConfig runs a command over the content of generated files matching a glob
+type FormatterConfig struct {
+	// Pattern is a glob matched against the target path relative to target_dir,
+	// or against the file name when it contains no separator
+	Pattern string `yaml:"pattern"`
+	// FormatCmd receives the content on stdin; its stdout replaces the content
+	FormatCmd []string `yaml:"format_cmd"`
+}
+
+// Matches reports whether the formatter applies to target, a path relative to target_dir
+func (formatter FormatterConfig) Matches(target string) bool {
+	return matchGlob(formatter.Pattern, target)
+}
+
+// IsEnabled reports whether the module is enabled, which it is unless enabled is false
+func (config *ModuleConfig) IsEnabled() bool {
+	return config.Enabled == nil || *config.Enabled
+}
+
+// MapsHidden reports whether source files whose name starts with a dot are mapped
+func (config *ModuleConfig) MapsHidden() bool {
+	return config.IncludeHidden != nil && *config.IncludeHidden
+}
+
+// SkipsLink reports whether a file, a path relative to the module directory, matches a skip_link glob
+func (config *ModuleConfig) SkipsLink(relPath string) bool {
+	for _, pattern := range config.SkipLink {
+		if matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether a file, a path relative to the module directory, meets the
+// condition of every conditions glob it matches. Conditions are rendered as templates with
+// the module's vars merged over the root vars; "", "0", "false", "no" and "off" are falsey.
+func (config *ModuleConfig) Includes(relPath string) (bool, error) {
+	if len(config.Conditions) == 0 {
+		return true, nil
+	}
+
+	patterns := make([]string, 0, len(config.Conditions))
+	for pattern := range config.Conditions {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	vars := config.TemplateVars(config.RootVars)
+	for _, pattern := range patterns {
+		if !matchGlob(pattern, relPath) {
+			continue
+		}
+		value, err := template.RenderString(fmt.Sprintf("conditions[%s]", pattern), config.Conditions[pattern], vars)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "", "0", "false", "no", "off":
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchGlob matches a glob against a relative path, or against its base name when the
+// pattern contains no separator
+func matchGlob(pattern, path string) bool {
+	if !strings.ContainsRune(pattern, filepath.Separator) {
+		path = filepath.Base(path)
+	}
+	matched, err := filepath.Match(pattern, path)
+	return err == nil && matched
+}
+
+// FormatCmd returns the format command of the first formatter matching target, or nil
+func (config *ModuleConfig) FormatCmd(target string) []string {
+	for _, formatter := range config.Formatters {
+		if formatter.Matches(target) {
+			return formatter.FormatCmd
+		}
+	}
+	return nil
+}
+
+// LoadVarsFile loads a per-file vars file colocated with a template; nil when it does not exist
+func LoadVarsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vars file %s: %w", path, err)
+	}
+
+	var vars map[string]string
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse vars file %s: %w", path, err)
+	}
+	for key := range vars {
+		if !varKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("vars key '%s' in %s contains invalid characters, only a-zA-Z0-9_ are allowed", key, path)
+		}
+	}
+	return vars, nil
+}
+
+// TemplateVars returns the variables the module's templates are rendered with:
+// rootVars merged with the module's vars, which take precedence
+func (config *ModuleConfig) TemplateVars(rootVars map[string]string) map[string]string {
+	vars := make(map[string]string, len(rootVars)+len(config.Vars))
+	for key, value := range rootVars {
+		vars[key] = value
+	}
+	for key, value := range config.Vars {
+		vars[key] = value
+	}
+	return vars
+}
+
+// ExcludedNames returns the file and directory names never mapped from the module
+func (config *ModuleConfig) ExcludedNames() []string {
+	if config.VCSExcludes == nil {
+		return DefaultVCSExcludes
+	}
+	return config.VCSExcludes
+}
+
+// KeepFileName returns the name of placeholder files marking directories to create
+func (config *ModuleConfig) KeepFileName() string {
+	if config.KeepFile == "" {
+		return DefaultKeepFile
+	}
+	return config.KeepFile
+}
+
+// Name returns the module name: the base name of the module directory, or for a module
+// discovered through module_roots, the path components its wildcards matched
+func (config *ModuleConfig) Name() string {
+	if config.DerivedName != "" {
+		return config.DerivedName
+	}
+	return filepath.Base(config.Dir)
 }
 
 // LoadConfig loads and parses a Dotfile configuration from the specified directory
 func LoadConfig(moduleDir string) (*ModuleConfig, error) {
-	configPath := filepath.Join(moduleDir, "Dotfile")
+	return LoadConfigWithVars(moduleDir, nil)
+}
 
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+// LoadConfigWithVars loads a Dotfile configuration, rendering its target_dir and
+// ignores entries as Go templates with the given (root) variables
+func LoadConfigWithVars(moduleDir string, vars map[string]string) (*ModuleConfig, error) {
+	configPath, err := findConfigFile(moduleDir, ModuleConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if configPath == "" {
 		return nil, nil // No config file is not an error
 	}
 
@@ -31,12 +289,17 @@ func LoadConfig(moduleDir string) (*ModuleConfig, error) {
 		return nil, fmt.Errorf("failed to read config file %s: %w", configPath, err)
 	}
 
-	// Parse YAML
+	// Parse YAML or JSON, rejecting unknown keys so a misspelled one isn't silently ignored
 	var config ModuleConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := parseConfig(configPath, data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
 
+	// Render templated values before validating them
+	if err := config.render(vars); err != nil {
+		return nil, fmt.Errorf("failed to render config %s: %w", configPath, err)
+	}
+
 	// Validate config
 	if err := config.validate(); err != nil {
 		return nil, fmt.Errorf("invalid config in %s: %w", configPath, err)
@@ -47,8 +310,51 @@ func LoadConfig(moduleDir string) (*ModuleConfig, error) {
 	return &config, nil
 }
 
+// render renders the templated string fields of the configuration with vars
+func (config *ModuleConfig) render(vars map[string]string) error {
+	targetDir, err := template.RenderString("target_dir", config.TargetDir, vars)
+	if err != nil {
+		return err
+	}
+	config.TargetDir = targetDir
+
+	targetFile, err := template.RenderString("target_file", config.TargetFile, vars)
+	if err != nil {
+		return err
+	}
+	config.TargetFile = targetFile
+
+	for i, ignore := range config.Ignores {
+		rendered, err := template.RenderString(fmt.Sprintf("ignores[%d]", i), ignore, vars)
+		if err != nil {
+			return err
+		}
+		config.Ignores[i] = rendered
+	}
+
+	return nil
+}
+
 // validate validates the configuration structure and values
 func (config *ModuleConfig) validate() error {
+	// A single-file module installs into the directory of its target_file
+	if config.TargetFile != "" {
+		if config.TargetDir != "" {
+			return fmt.Errorf("target_dir and target_file cannot both be set")
+		}
+		if strings.HasPrefix(config.TargetFile, "$HOME") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to get home directory: %w", err)
+			}
+			config.TargetFile = strings.Replace(config.TargetFile, "$HOME", home, 1)
+		}
+		if !filepath.IsAbs(config.TargetFile) || filepath.Clean(config.TargetFile) != config.TargetFile {
+			return fmt.Errorf("target_file must be a clean absolute path")
+		}
+		config.TargetDir = filepath.Dir(config.TargetFile)
+	}
+
 	if config.TargetDir == "" {
 		return fmt.Errorf("target_dir field is required")
 	}
@@ -79,5 +385,120 @@ func (config *ModuleConfig) validate() error {
 		}
 	}
 
+	// Validate vars keys - alphanumeric and underscore characters allowed
+	for key := range config.Vars {
+		if !varKeyPattern.MatchString(key) {
+			return fmt.Errorf("vars key '%s' contains invalid characters, only a-zA-Z0-9_ are allowed", key)
+		}
+	}
+
+	if config.MaxDepth < 0 {
+		return fmt.Errorf("max_depth cannot be negative")
+	}
+
+	// Validate depends_on list - ensure no empty strings
+	for i, dep := range config.DependsOn {
+		if dep == "" {
+			return fmt.Errorf("depends_on[%d] cannot be empty", i)
+		}
+	}
+
+	// Validate dir_mode - only permission bits, and the owner must be able to populate the directory
+	if config.DirMode != 0 {
+		if config.DirMode&^0777 != 0 {
+			return fmt.Errorf("dir_mode %o must only contain permission bits", config.DirMode)
+		}
+		if config.DirMode&0700 != 0700 {
+			return fmt.Errorf("dir_mode %o must grant the owner read, write and execute (0700)", config.DirMode)
+		}
+	}
+
+	// Validate rename entries - both sides must be relative paths inside their directories
+	for source, target := range config.Rename {
+		if source == "" || !filepath.IsLocal(source) {
+			return fmt.Errorf("rename source %q must be a relative path inside the module", source)
+		}
+		if target == "" || !filepath.IsLocal(target) {
+			return fmt.Errorf("rename target %q for %s must be a relative path inside target_dir", target, source)
+		}
+	}
+
+	// Validate extra_links entries - like rename, both sides must stay inside their directories
+	for source, targets := range config.ExtraLinks {
+		if source == "" || !filepath.IsLocal(source) {
+			return fmt.Errorf("extra_links source %q must be a relative path inside the module", source)
+		}
+		for _, target := range targets {
+			if target == "" || !filepath.IsLocal(target) {
+				return fmt.Errorf("extra_links target %q for %s must be a relative path inside target_dir", target, source)
+			}
+		}
+	}
+
+	// Validate layout - empty means mirror
+	switch config.Layout {
+	case "", LayoutMirror, LayoutFlatten:
+	default:
+		return fmt.Errorf("layout %q must be %s or %s", config.Layout, LayoutMirror, LayoutFlatten)
+	}
+
+	// Validate formatters - patterns must be valid globs and commands must be set
+	for i, formatter := range config.Formatters {
+		if formatter.Pattern == "" {
+			return fmt.Errorf("formatters[%d].pattern cannot be empty", i)
+		}
+		if _, err := filepath.Match(formatter.Pattern, ""); err != nil {
+			return fmt.Errorf("formatters[%d].pattern is invalid: %w", i, err)
+		}
+		if len(formatter.FormatCmd) == 0 || formatter.FormatCmd[0] == "" {
+			return fmt.Errorf("formatters[%d].format_cmd cannot be empty", i)
+		}
+	}
+
+	// Validate skip_link globs
+	for i, pattern := range config.SkipLink {
+		if pattern == "" {
+			return fmt.Errorf("skip_link[%d] cannot be empty", i)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("skip_link[%d] is invalid: %w", i, err)
+		}
+	}
+
+	// Validate conditions - globs must be valid and expressions set
+	for pattern, condition := range config.Conditions {
+		if pattern == "" {
+			return fmt.Errorf("conditions cannot have an empty glob")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("conditions glob %q is invalid: %w", pattern, err)
+		}
+		if strings.TrimSpace(condition) == "" {
+			return fmt.Errorf("conditions[%s] cannot be empty", pattern)
+		}
+	}
+
+	// Validate generators - targets must stay inside target_dir and commands must be set
+	for i, generator := range config.Generators {
+		if generator.Target == "" {
+			return fmt.Errorf("generators[%d].target cannot be empty", i)
+		}
+		if !filepath.IsLocal(generator.Target) {
+			return fmt.Errorf("generators[%d].target must be a relative path inside target_dir", i)
+		}
+		if len(generator.Command) == 0 || generator.Command[0] == "" {
+			return fmt.Errorf("generators[%d].command cannot be empty", i)
+		}
+		if generator.Timeout != "" {
+			timeout, err := time.ParseDuration(generator.Timeout)
+			if err != nil {
+				return fmt.Errorf("generators[%d].timeout is invalid: %w", i, err)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("generators[%d].timeout must be positive", i)
+			}
+		}
+	}
+
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -61,12 +62,51 @@ func TestLoadConfig(t *testing.T) {
 			wantErr:     true,
 			errContains: "failed to parse config file",
 		},
+		{
+			name:          "MisspelledKey",
+			configContent: "targetdir: /home/user/.config/nvim\n",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte("targetdir: /home/user/.config/nvim\n"), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: `Dotfile: [1:1] unknown field "targetdir"`,
+		},
+		{
+			name:          "DirIsNotAKey",
+			configContent: "target_dir: /tmp\ndir: /elsewhere\n",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte("target_dir: /tmp\ndir: /elsewhere\n"), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: `Dotfile: [2:1] unknown field "dir"`,
+		},
+		{
+			name:          "UnknownGeneratorKey",
+			configContent: "target_dir: /tmp\ngenerators:\n  - target: out\n    command: [echo]\n    merged: true\n",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte("target_dir: /tmp\ngenerators:\n  - target: out\n    command: [echo]\n    merged: true\n"), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: `Dotfile: [5:5] unknown field "merged"`,
+		},
 		{
 			name:          "MissingTargetDir",
-			configContent: `other_field: "value"`,
+			configContent: `ignores: ["*.bak"]`,
 			setupFunc: func(t *testing.T, dir string) string {
 				configPath := filepath.Join(dir, "Dotfile")
-				err := os.WriteFile(configPath, []byte(`other_field: "value"`), 0644)
+				err := os.WriteFile(configPath, []byte(`ignores: ["*.bak"]`), 0644)
 				require.NoError(t, err)
 				return dir
 			},
@@ -176,6 +216,433 @@ ignores:
 			}(),
 			wantErr: false,
 		},
+		{
+			name: "ValidConfigWithGenerators",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: .ssh/id_ed25519.pub
+    command: ["ssh-keygen", "-y", "-f", "/home/user/.ssh/id_ed25519"]
+    timeout: 5s`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithGenerators"),
+				TargetDir: "/home/user",
+				Generators: []GeneratorConfig{
+					{
+						Target:  ".ssh/id_ed25519.pub",
+						Command: []string{"ssh-keygen", "-y", "-f", "/home/user/.ssh/id_ed25519"},
+						Timeout: "5s",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidGeneratorEmptyTarget",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - command: ["echo"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].target cannot be empty",
+		},
+		{
+			name: "InvalidGeneratorEscapingTarget",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: ../outside
+    command: ["echo"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].target must be a relative path inside target_dir",
+		},
+		{
+			name: "InvalidGeneratorAbsoluteTarget",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: /etc/hosts
+    command: ["echo"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].target must be a relative path inside target_dir",
+		},
+		{
+			name: "InvalidGeneratorEmptyCommand",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: out`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].command cannot be empty",
+		},
+		{
+			name: "InvalidGeneratorTimeout",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: out
+    command: ["echo"]
+    timeout: soon`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].timeout is invalid",
+		},
+		{
+			name: "InvalidGeneratorNegativeTimeout",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+generators:
+  - target: out
+    command: ["echo"]
+    timeout: -1s`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "generators[0].timeout must be positive",
+		},
+		{
+			name: "ValidConfigWithVars",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+vars:
+  THEME: dark`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithVars"),
+				TargetDir: "/home/user",
+				Vars:      map[string]string{"THEME": "dark"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidVarsKey",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+vars:
+  color-scheme: dark`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "vars key 'color-scheme' contains invalid characters",
+		},
+		{
+			name: "ValidConfigWithFormatters",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+formatters:
+  - pattern: "*.json"
+    format_cmd: ["jq", "."]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithFormatters"),
+				TargetDir: "/home/user",
+				Formatters: []FormatterConfig{
+					{Pattern: "*.json", FormatCmd: []string{"jq", "."}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidFormatterPattern",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+formatters:
+  - pattern: "[.json"
+    format_cmd: ["jq", "."]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "formatters[0].pattern is invalid",
+		},
+		{
+			name: "InvalidFormatterEmptyCommand",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+formatters:
+  - pattern: "*.sh"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "formatters[0].format_cmd cannot be empty",
+		},
+		{
+			name: "ValidConfigWithDirMode",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/.gnupg"
+dir_mode: 0700`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithDirMode"),
+				TargetDir: "/home/user/.gnupg",
+				DirMode:   0700,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ValidConfigWithQuotedDirMode",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/.ssh"
+dir_mode: "0750"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithQuotedDirMode"),
+				TargetDir: "/home/user/.ssh",
+				DirMode:   0750,
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidDirModeNotOctal",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/.gnupg"
+dir_mode: rwx`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "dir_mode must be an octal permission",
+		},
+		{
+			name: "InvalidDirModeOwnerCannotWrite",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/.gnupg"
+dir_mode: 0500`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "dir_mode 500 must grant the owner read, write and execute",
+		},
+		{
+			name: "ValidConfigWithRename",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/bin"
+rename:
+  git-sync.sh: git-sync`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithRename"),
+				TargetDir: "/home/user/bin",
+				Rename:    map[string]string{"git-sync.sh": "git-sync"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidRenameEscapingTarget",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user/bin"
+rename:
+  git-sync.sh: ../git-sync`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: `rename target "../git-sync" for git-sync.sh must be a relative path inside target_dir`,
+		},
+		{
+			name: "ValidConfigWithFlattenLayout",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+layout: flatten`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithFlattenLayout"),
+				TargetDir: "/home/user",
+				Layout:    LayoutFlatten,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ValidConfigWithSkipLink",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+skip_link: ["README.md", "docs/*"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:       filepath.Join(tmpDir, "ValidConfigWithSkipLink"),
+				TargetDir: "/home/user",
+				SkipLink:  []string{"README.md", "docs/*"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidSkipLinkPattern",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+skip_link: ["[README"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "skip_link[0] is invalid",
+		},
+		{
+			name: "InvalidExtraLinksTarget",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+extra_links:
+  profile: ["../profile"]`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "extra_links target \"../profile\" for profile must be a relative path inside target_dir",
+		},
+		{
+			name: "ValidConfigWithConditions",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+conditions:
+  gpg-agent.conf: "{{.USE_GPG}}"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:        filepath.Join(tmpDir, "ValidConfigWithConditions"),
+				TargetDir:  "/home/user",
+				Conditions: map[string]string{"gpg-agent.conf": "{{.USE_GPG}}"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidConditionsGlob",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+conditions:
+  "[gpg": "{{.USE_GPG}}"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "conditions glob \"[gpg\" is invalid",
+		},
+		{
+			name: "InvalidLayout",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+layout: nested`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: `layout "nested" must be mirror or flatten`,
+		},
+		{
+			name: "ValidConfigWithTargetFile",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_file: "/home/user/.gitconfig"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig: &ModuleConfig{
+				Dir:        filepath.Join(tmpDir, "ValidConfigWithTargetFile"),
+				TargetDir:  "/home/user",
+				TargetFile: "/home/user/.gitconfig",
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidTargetFileWithTargetDir",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_dir: "/home/user"
+target_file: "/home/user/.gitconfig"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "target_dir and target_file cannot both be set",
+		},
+		{
+			name: "InvalidRelativeTargetFile",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "Dotfile")
+				err := os.WriteFile(configPath, []byte(`target_file: ".gitconfig"`), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  nil,
+			wantErr:     true,
+			errContains: "target_file must be a clean absolute path",
+		},
 	}
 
 	for _, tt := range tests {
@@ -201,3 +668,39 @@ ignores:
 		})
 	}
 }
+
+func TestGeneratorConfig_TimeoutDuration(t *testing.T) {
+	assert.Equal(t, DefaultGeneratorTimeout, GeneratorConfig{}.TimeoutDuration())
+	assert.Equal(t, 5*time.Second, GeneratorConfig{Timeout: "5s"}.TimeoutDuration())
+}
+
+func TestFormatterConfig_Matches(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		target  string
+		want    bool
+	}{
+		{name: "file name glob", pattern: "*.json", target: "settings.json", want: true},
+		{name: "file name glob in subdirectory", pattern: "*.json", target: "app/settings.json", want: true},
+		{name: "different extension", pattern: "*.json", target: "settings.yaml", want: false},
+		{name: "path glob", pattern: "bin/*.sh", target: "bin/sync.sh", want: true},
+		{name: "path glob outside directory", pattern: "bin/*.sh", target: "sync.sh", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatter := FormatterConfig{Pattern: tt.pattern, FormatCmd: []string{"cat"}}
+			assert.Equal(t, tt.want, formatter.Matches(tt.target))
+		})
+	}
+}
+
+func TestModuleConfig_TemplateVars(t *testing.T) {
+	rootVars := map[string]string{"USER": "alice", "THEME": "light"}
+	module := ModuleConfig{Vars: map[string]string{"THEME": "dark", "LEADER": ","}}
+
+	assert.Equal(t, map[string]string{"USER": "alice", "THEME": "dark", "LEADER": ","}, module.TemplateVars(rootVars))
+	// The root vars are left untouched
+	assert.Equal(t, "light", rootVars["THEME"])
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SortModules orders modules so that every module comes after the modules it
+// depends on. Modules without dependencies keep their relative input order.
+// An error is returned if a dependency is unknown or the dependencies form a cycle.
+func SortModules(modules []ModuleConfig) ([]ModuleConfig, error) {
+	byName := make(map[string]int, len(modules))
+	for i := range modules {
+		byName[modules[i].Name()] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(modules))
+	sorted := make([]ModuleConfig, 0, len(modules))
+	var path []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		name := modules[i].Name()
+		switch marks[i] {
+		case visited:
+			return nil
+		case visiting:
+			// Report the cycle starting from the first occurrence of this module
+			start := 0
+			for j, p := range path {
+				if p == name {
+					start = j
+					break
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("circular module dependency: %s", strings.Join(cycle, " -> "))
+		}
+
+		marks[i] = visiting
+		path = append(path, name)
+		for _, dep := range modules[i].DependsOn {
+			j, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("module %s depends on unknown module %s", name, dep)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		sorted = append(sorted, modules[i])
+		return nil
+	}
+
+	for i := range modules {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// SelectModule returns the module named name alone, for installing it without the others.
+// Its dependencies are resolved against all modules, so an unknown dependency or a cycle is
+// still an error, but are left out of the selection: they are configured and installed on
+// their own.
+func SelectModule(modules []ModuleConfig, name string) ([]ModuleConfig, error) {
+	if _, err := SortModules(modules); err != nil {
+		return nil, err
+	}
+
+	for _, module := range modules {
+		if module.Name() == name {
+			module.DependsOn = nil
+			return []ModuleConfig{module}, nil
+		}
+	}
+	return nil, fmt.Errorf("module %s is not configured", name)
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortModules(t *testing.T) {
+	tests := []struct {
+		name      string
+		modules   []ModuleConfig
+		wantOrder []string
+	}{
+		{
+			name: "NoDependenciesKeepsOrder",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/zsh"},
+				{Dir: "/dotfiles/git"},
+				{Dir: "/dotfiles/nvim"},
+			},
+			wantOrder: []string{"zsh", "git", "nvim"},
+		},
+		{
+			name: "DependencyChain",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/nvim", DependsOn: []string{"lsp"}},
+				{Dir: "/dotfiles/lsp", DependsOn: []string{"base"}},
+				{Dir: "/dotfiles/base"},
+				{Dir: "/dotfiles/git"},
+			},
+			wantOrder: []string{"base", "lsp", "nvim", "git"},
+		},
+		{
+			name: "SharedDependency",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/a", DependsOn: []string{"c"}},
+				{Dir: "/dotfiles/b", DependsOn: []string{"c"}},
+				{Dir: "/dotfiles/c"},
+			},
+			wantOrder: []string{"c", "a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := SortModules(tt.modules)
+			require.NoError(t, err)
+
+			var names []string
+			for _, m := range sorted {
+				names = append(names, m.Name())
+			}
+			assert.Equal(t, tt.wantOrder, names)
+		})
+	}
+}
+
+func TestSortModules_Error(t *testing.T) {
+	tests := []struct {
+		name        string
+		modules     []ModuleConfig
+		errContains string
+	}{
+		{
+			name: "TwoModuleCycle",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/a", DependsOn: []string{"b"}},
+				{Dir: "/dotfiles/b", DependsOn: []string{"a"}},
+			},
+			errContains: "circular module dependency: a -> b -> a",
+		},
+		{
+			name: "SelfDependency",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/a", DependsOn: []string{"a"}},
+			},
+			errContains: "circular module dependency: a -> a",
+		},
+		{
+			name: "UnknownDependency",
+			modules: []ModuleConfig{
+				{Dir: "/dotfiles/a", DependsOn: []string{"missing"}},
+			},
+			errContains: "module a depends on unknown module missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := SortModules(tt.modules)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errContains)
+			assert.Nil(t, sorted)
+		})
+	}
+}
+
+func TestSelectModule(t *testing.T) {
+	modules := []ModuleConfig{
+		{Dir: "/dotfiles/shell"},
+		{Dir: "/dotfiles/zsh", DependsOn: []string{"shell"}},
+		{Dir: "/dotfiles/vim"},
+	}
+
+	t.Run("dependencies are resolved but not selected", func(t *testing.T) {
+		selected, err := SelectModule(modules, "zsh")
+		require.NoError(t, err)
+		require.Len(t, selected, 1)
+		assert.Equal(t, "zsh", selected[0].Name())
+		assert.Empty(t, selected[0].DependsOn)
+		assert.Equal(t, []string{"shell"}, modules[1].DependsOn, "the input is left as it is")
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		_, err := SelectModule(modules, "emacs")
+		assert.EqualError(t, err, "module emacs is not configured")
+	})
+
+	t.Run("unknown dependency of another module", func(t *testing.T) {
+		broken := append(slices.Clone(modules), ModuleConfig{Dir: "/dotfiles/nvim", DependsOn: []string{"lua"}})
+		_, err := SelectModule(broken, "vim")
+		assert.ErrorContains(t, err, "module nvim depends on unknown module lua")
+	})
+}
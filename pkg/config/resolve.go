@@ -0,0 +1,70 @@
+package config
+
+import (
+	"slices"
+
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+)
+
+// ResolveConfig loads the configuration of dotfilesDir like LoadDir and fills in every default,
+// giving the effective configuration an installation uses: target_dir expanded, excluded
+// modules dropped, and module vars merged with the root vars
+func ResolveConfig(dotfilesDir string) (*Config, error) {
+	cfg, err := LoadDir(dotfilesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &cfg.RootConfig
+	if root.VCSExcludes == nil {
+		root.VCSExcludes = slices.Clone(DefaultVCSExcludes)
+	}
+	if root.KeepFile == "" {
+		root.KeepFile = DefaultKeepFile
+	}
+	if root.MaxBackups <= 0 {
+		root.MaxBackups = filesystem.DefaultMaxBackups
+	}
+	replaceDangling := root.ReplacesDangling()
+	root.ReplaceDangling = &replaceDangling
+
+	for i := range cfg.Modules {
+		module := &cfg.Modules[i]
+		module.Vars = module.TemplateVars(root.Vars)
+		module.VCSExcludes = slices.Clone(module.ExcludedNames())
+		module.KeepFile = module.KeepFileName()
+		if module.Layout == "" {
+			module.Layout = LayoutMirror
+		}
+		includeHidden := module.MapsHidden()
+		module.IncludeHidden = &includeHidden
+		for j := range module.Generators {
+			module.Generators[j].Timeout = module.Generators[j].TimeoutDuration().String()
+		}
+	}
+
+	return cfg, nil
+}
+
+// ResolvedConfig is a resolved configuration as it is printed
+type ResolvedConfig struct {
+	RootConfig RootConfig       `yaml:"root"`
+	Modules    []ResolvedModule `yaml:"modules"`
+}
+
+// ResolvedModule is a resolved module along with its name and directory, which come from where
+// the module was found rather than from its Dotfile
+type ResolvedModule struct {
+	Name         string `yaml:"name"`
+	Dir          string `yaml:"dir"`
+	ModuleConfig `yaml:",inline"`
+}
+
+// NewResolvedConfig returns cfg, as returned by ResolveConfig, in the form it is printed in
+func NewResolvedConfig(cfg *Config) *ResolvedConfig {
+	resolved := &ResolvedConfig{RootConfig: cfg.RootConfig, Modules: make([]ResolvedModule, 0, len(cfg.Modules))}
+	for _, module := range cfg.Modules {
+		resolved.Modules = append(resolved.Modules, ResolvedModule{Name: module.Name(), Dir: module.Dir, ModuleConfig: module})
+	}
+	return resolved
+}
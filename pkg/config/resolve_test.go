@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveConfig(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+
+	rootDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "DotRoot"), []byte("vars:\n  EDITOR: nvim\nexclude_modules:\n  - work\n"), 0644))
+	for name, dotfile := range map[string]string{
+		"nvim": "target_dir: $HOME/.config/nvim\nvars:\n  THEME: dark\ngenerators:\n  - target: colors.lua\n    command: [\"gen\"]\n",
+		"work": "target_dir: $HOME/work\n",
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(rootDir, name), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, name, "Dotfile"), []byte(dotfile), 0644))
+	}
+
+	cfg, err := ResolveConfig(rootDir)
+	require.NoError(t, err)
+
+	// The excluded module is absent
+	require.Len(t, cfg.Modules, 1)
+	module := cfg.Modules[0]
+	assert.Equal(t, "nvim", module.Name())
+
+	// target_dir reflects the expanded $HOME
+	assert.Equal(t, filepath.Join(homeDir, ".config", "nvim"), module.TargetDir)
+
+	// Defaults are filled in
+	assert.Equal(t, "nvim", module.Vars["EDITOR"])
+	assert.Equal(t, "dark", module.Vars["THEME"])
+	assert.Equal(t, LayoutMirror, module.Layout)
+	require.NotNil(t, module.IncludeHidden)
+	assert.False(t, *module.IncludeHidden)
+	assert.Equal(t, DefaultGeneratorTimeout.String(), module.Generators[0].Timeout)
+	assert.Equal(t, DefaultVCSExcludes, cfg.RootConfig.VCSExcludes)
+	assert.Equal(t, DefaultKeepFile, cfg.RootConfig.KeepFile)
+	assert.Equal(t, filesystem.DefaultMaxBackups, cfg.RootConfig.MaxBackups)
+	require.NotNil(t, cfg.RootConfig.ReplaceDangling)
+	assert.True(t, *cfg.RootConfig.ReplaceDangling)
+}
+
+func TestNewResolvedConfig(t *testing.T) {
+	rootDir := t.TempDir()
+	moduleDir := filepath.Join(rootDir, "nvim")
+	require.NoError(t, os.Mkdir(moduleDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte("target_dir: /home/user/.config/nvim\n"), 0644))
+
+	cfg, err := ResolveConfig(rootDir)
+	require.NoError(t, err)
+	data, err := yaml.Marshal(NewResolvedConfig(cfg))
+	require.NoError(t, err)
+
+	var printed struct {
+		Modules []map[string]interface{} `yaml:"modules"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &printed))
+	require.Len(t, printed.Modules, 1)
+	assert.Equal(t, "nvim", printed.Modules[0]["name"])
+	assert.Equal(t, moduleDir, printed.Modules[0]["dir"])
+	assert.Equal(t, "/home/user/.config/nvim", printed.Modules[0]["target_dir"])
+}
@@ -1,26 +1,114 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
-
-	"github.com/goccy/go-yaml"
+	"strings"
 )
 
 // RootConfig represents the root configuration structure
 type RootConfig struct {
 	Vars           map[string]string `yaml:"vars"`
 	ExcludeModules []string          `yaml:"exclude_modules"`
+	// ModuleRoots are glob patterns, relative to the dotfiles root, used to
+	// discover module directories. Defaults to the immediate subdirectories.
+	ModuleRoots []string `yaml:"module_roots"`
+	// MaxBackups is how many backups are kept per target before the oldest is rotated
+	// out. Zero uses the default of 100.
+	MaxBackups int `yaml:"max_backups"`
+	// CompressBackups writes backups of replaced regular files gzip-compressed, as
+	// .bak.gz instead of .bak
+	CompressBackups bool `yaml:"compress_backups"`
+	// TimestampBackups names backups after the time they were made, as
+	// target.YYYYMMDD-HHMMSS.bak instead of .bak, .bak.1, ...
+	TimestampBackups bool `yaml:"timestamp_backups"`
+	// BackupSuffix replaces .bak in the names of backups, e.g. .dotman.bak to avoid the
+	// backups of editors and other tools. Defaults to .bak.
+	BackupSuffix string `yaml:"backup_suffix"`
+	// MkdirAllowedRoots restricts the directories --mkdir may create to these absolute
+	// roots. Environment variables are expanded; empty means anywhere is allowed.
+	MkdirAllowedRoots []string `yaml:"mkdir_allowed_roots"`
+	// StrictTargetDirs fails validation when the target_dir of a module is inside the
+	// target_dir of another module, which is otherwise only a warning
+	StrictTargetDirs bool `yaml:"strict_target_dirs"`
+	// ReplaceDangling replaces targets that are symlinks to a missing file, such as stale
+	// links of a moved dotfiles directory, without --force or a backup. Defaults to true;
+	// false treats them as conflicts like any other existing target.
+	ReplaceDangling *bool `yaml:"replace_dangling"`
+	// VCSExcludes are file and directory names never mapped from modules. Unset
+	// uses DefaultVCSExcludes; an empty list maps version control metadata too.
+	VCSExcludes []string `yaml:"vcs_excludes"`
+	// IncludeHidden maps source files whose name starts with a dot, such as .DS_Store;
+	// modules can override it. Dotted target names, e.g. from rename, are unaffected.
+	IncludeHidden bool `yaml:"include_hidden"`
+	// MaxFileSize skips source files larger than this, such as 5MB, and rejects templates
+	// larger than it; modules can override it. Zero means no limit.
+	MaxFileSize ByteSize `yaml:"max_file_size"`
+	// MaxDepth bounds how many directory levels below a module directory are walked for
+	// source files; a deeper directory fails the installation. Modules can override it.
+	// Zero means no limit.
+	MaxDepth int `yaml:"max_depth"`
+	// KeepFile is the name of placeholder files that keep otherwise empty directories in git.
+	// Instead of being linked, they make dotman create the directory in the target.
+	// Defaults to DefaultKeepFile.
+	KeepFile string `yaml:"keep_file"`
+	// PrivilegedCmd is a command template, such as "sudo ln -sfn %s %s", that creates a
+	// symlink the user may not create, e.g. in /etc. Its two %s arguments are the source
+	// and the target. It is only used when install runs with --allow-privileged.
+	PrivilegedCmd string `yaml:"privileged_cmd"`
+	// DontEditMessage is the banner templates get as the DONT_EDIT variable. Defaults to
+	// DefaultDontEditMessage; an explicit DONT_EDIT in Vars takes precedence.
+	DontEditMessage string `yaml:"dont_edit_message"`
+}
+
+// PrivilegedCmdPlaceholder is the privileged_cmd argument replaced by the source, then the target
+const PrivilegedCmdPlaceholder = "%s"
+
+// DefaultDontEditMessage is the DONT_EDIT template variable when dont_edit_message is unset
+const DefaultDontEditMessage = "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"
+
+// DefaultKeepFile is the placeholder file name that marks a directory to create
+const DefaultKeepFile = ".keep"
+
+// DefaultVCSExcludes are the version control metadata names skipped in modules by default
+var DefaultVCSExcludes = []string{".git", ".gitignore", ".gitmodules", ".svn", ".hg"}
+
+// ErrRootNotFound is returned by FindRootUpwards when no ancestor contains a DotRoot
+var ErrRootNotFound = errors.New("no DotRoot found")
+
+// FindRootUpwards returns the nearest directory at or above startDir that contains a
+// DotRoot or DotRoot.json file, ascending until the filesystem root like git does for .git
+func FindRootUpwards(startDir string) (string, error) {
+	dir, err := filepath.Abs(startDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for %s: %w", startDir, err)
+	}
+
+	for {
+		for _, name := range []string{RootConfigFile, RootConfigFile + jsonExt} {
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+				return dir, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%w in %s or any parent directory", ErrRootNotFound, startDir)
+		}
+		dir = parent
+	}
 }
 
 // LoadRootConfig loads and parses a root configuration from the specified directory
 func LoadRootConfig(dir string) (RootConfig, error) {
-	configPath := filepath.Join(dir, "DotRoot")
-
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	configPath, err := findConfigFile(dir, RootConfigFile)
+	if err != nil {
+		return RootConfig{}, err
+	}
+	if configPath == "" {
 		return RootConfig{}, nil // No config file is not an error
 	}
 
@@ -30,9 +118,9 @@ func LoadRootConfig(dir string) (RootConfig, error) {
 		return RootConfig{}, fmt.Errorf("failed to read root config file %s: %w", configPath, err)
 	}
 
-	// Parse YAML
+	// Parse YAML or JSON, rejecting unknown keys so a misspelled one isn't silently ignored
 	var config RootConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := parseConfig(configPath, data, &config); err != nil {
 		return RootConfig{}, fmt.Errorf("failed to parse root config file %s: %w", configPath, err)
 	}
 
@@ -41,7 +129,10 @@ func LoadRootConfig(dir string) (RootConfig, error) {
 	}
 
 	if _, ok := config.Vars["DONT_EDIT"]; !ok {
-		config.Vars["DONT_EDIT"] = "!!! THIS FILE IS GENERATED. DON'T EDIT THIS FILE !!!"
+		config.Vars["DONT_EDIT"] = DefaultDontEditMessage
+		if config.DontEditMessage != "" {
+			config.Vars["DONT_EDIT"] = config.DontEditMessage
+		}
 	}
 
 	// Validate config
@@ -52,10 +143,12 @@ func LoadRootConfig(dir string) (RootConfig, error) {
 	return config, nil
 }
 
+// varKeyPattern matches valid root and module vars keys
+var varKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
 // validate validates the root configuration structure and values
 func (config *RootConfig) validate() error {
 	// Validate vars keys - alphanumeric and underscore characters allowed
-	varKeyPattern := regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 	for key := range config.Vars {
 		if !varKeyPattern.MatchString(key) {
 			return fmt.Errorf("vars key '%s' contains invalid characters, only a-zA-Z0-9 are allowed", key)
@@ -73,6 +166,89 @@ func (config *RootConfig) validate() error {
 		}
 	}
 
+	if config.MaxBackups < 0 {
+		return fmt.Errorf("max_backups cannot be negative")
+	}
+
+	// Validate backup_suffix - appended to target names, so it can't be a path
+	if config.BackupSuffix != "" {
+		if !strings.HasPrefix(config.BackupSuffix, ".") || len(config.BackupSuffix) < 2 {
+			return fmt.Errorf("backup_suffix '%s' must start with a dot followed by a name, e.g. .dotman.bak", config.BackupSuffix)
+		}
+		if strings.ContainsAny(config.BackupSuffix, `/\`) {
+			return fmt.Errorf("backup_suffix '%s' must not contain a path separator", config.BackupSuffix)
+		}
+	}
+
+	if config.MaxDepth < 0 {
+		return fmt.Errorf("max_depth cannot be negative")
+	}
+
+	// Validate module_roots patterns - must be relative globs inside the dotfiles root
+	for i, pattern := range config.ModuleRoots {
+		if pattern == "" {
+			return fmt.Errorf("module_roots[%d] cannot be empty", i)
+		}
+		if filepath.IsAbs(pattern) {
+			return fmt.Errorf("module_roots[%d] '%s' must be a relative path", i, pattern)
+		}
+		for _, part := range strings.Split(filepath.ToSlash(pattern), "/") {
+			if part == ".." {
+				return fmt.Errorf("module_roots[%d] '%s' must not leave the dotfiles directory", i, pattern)
+			}
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("module_roots[%d] '%s' is not a valid glob pattern: %w", i, pattern, err)
+		}
+	}
+
+	// Validate vcs_excludes - entries are single file or directory names
+	for i, name := range config.VCSExcludes {
+		if name == "" {
+			return fmt.Errorf("vcs_excludes[%d] cannot be empty", i)
+		}
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("vcs_excludes[%d] '%s' must be a name, not a path", i, name)
+		}
+	}
+
+	// Validate privileged_cmd - a command followed by arguments with exactly two placeholders
+	if config.PrivilegedCmd != "" {
+		fields := strings.Fields(config.PrivilegedCmd)
+		placeholders := 0
+		for _, field := range fields {
+			if field == PrivilegedCmdPlaceholder {
+				placeholders++
+			}
+		}
+		if placeholders != 2 || fields[0] == PrivilegedCmdPlaceholder {
+			return fmt.Errorf("privileged_cmd '%s' must be a command with two %s arguments for the source and the target", config.PrivilegedCmd, PrivilegedCmdPlaceholder)
+		}
+	}
+
+	// Validate keep_file - a file name
+	if strings.ContainsAny(config.KeepFile, `/\`) {
+		return fmt.Errorf("keep_file '%s' must be a name, not a path", config.KeepFile)
+	}
+
+	// Validate mkdir_allowed_roots - expanded roots must be absolute; roots naming an
+	// unset variable don't exist on this machine and are dropped
+	var roots []string
+	for i, root := range config.MkdirAllowedRoots {
+		expanded := os.ExpandEnv(root)
+		if expanded == "" {
+			continue
+		}
+		if !filepath.IsAbs(expanded) {
+			return fmt.Errorf("mkdir_allowed_roots[%d] '%s' must be an absolute path", i, root)
+		}
+		roots = append(roots, filepath.Clean(expanded))
+	}
+	if len(config.MkdirAllowedRoots) > 0 && len(roots) == 0 {
+		return fmt.Errorf("mkdir_allowed_roots only names unset environment variables")
+	}
+	config.MkdirAllowedRoots = roots
+
 	return nil
 }
 
@@ -85,3 +261,9 @@ func (config *RootConfig) IsModuleExcluded(moduleName string) bool {
 	}
 	return false
 }
+
+// ReplacesDangling reports whether dangling target symlinks are replaced, which they are
+// unless replace_dangling is false
+func (config *RootConfig) ReplacesDangling() bool {
+	return config.ReplaceDangling == nil || *config.ReplaceDangling
+}
@@ -124,6 +124,19 @@ exclude_modules:
 			wantErr:     true,
 			errContains: "failed to parse root config file",
 		},
+		{
+			name:          "UnknownKey",
+			configContent: "vars: {}\nexclude_module: [nvim]\n",
+			setupFunc: func(t *testing.T, dir string) string {
+				configPath := filepath.Join(dir, "DotRoot")
+				err := os.WriteFile(configPath, []byte("vars: {}\nexclude_module: [nvim]\n"), 0644)
+				require.NoError(t, err)
+				return dir
+			},
+			wantConfig:  RootConfig{},
+			wantErr:     true,
+			errContains: `DotRoot: [2:1] unknown field "exclude_module"`,
+		},
 		{
 			name: "InvalidVarKeyWithSpecialChars",
 			configContent: `vars:
@@ -279,6 +292,81 @@ func TestValidateRootConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:        "InvalidNegativeMaxBackups",
+			config:      RootConfig{MaxBackups: -1},
+			wantErr:     true,
+			errContains: "max_backups cannot be negative",
+		},
+		{
+			name:    "ValidBackupSuffix",
+			config:  RootConfig{BackupSuffix: ".dotman.bak"},
+			wantErr: false,
+		},
+		{
+			name:        "InvalidBackupSuffixWithoutDot",
+			config:      RootConfig{BackupSuffix: "bak"},
+			wantErr:     true,
+			errContains: "backup_suffix 'bak' must start with a dot",
+		},
+		{
+			name:        "InvalidBackupSuffixPath",
+			config:      RootConfig{BackupSuffix: ".bak/old"},
+			wantErr:     true,
+			errContains: "must not contain a path separator",
+		},
+		{
+			name:        "InvalidNegativeMaxDepth",
+			config:      RootConfig{MaxDepth: -1},
+			wantErr:     true,
+			errContains: "max_depth cannot be negative",
+		},
+		{
+			name:   "ValidPrivilegedCmd",
+			config: RootConfig{PrivilegedCmd: "sudo ln -sfn %s %s"},
+		},
+		{
+			name:        "InvalidPrivilegedCmdPlaceholders",
+			config:      RootConfig{PrivilegedCmd: "sudo ln -sfn %s"},
+			wantErr:     true,
+			errContains: "privileged_cmd 'sudo ln -sfn %s' must be a command with two %s arguments",
+		},
+		{
+			name:        "InvalidPrivilegedCmdWithoutCommand",
+			config:      RootConfig{PrivilegedCmd: "%s %s"},
+			wantErr:     true,
+			errContains: "privileged_cmd",
+		},
+		{
+			name:        "InvalidKeepFilePath",
+			config:      RootConfig{KeepFile: "dirs/.keep"},
+			wantErr:     true,
+			errContains: "keep_file 'dirs/.keep' must be a name, not a path",
+		},
+		{
+			name:        "InvalidEmptyVCSExclude",
+			config:      RootConfig{VCSExcludes: []string{".git", ""}},
+			wantErr:     true,
+			errContains: "vcs_excludes[1] cannot be empty",
+		},
+		{
+			name:        "InvalidVCSExcludePath",
+			config:      RootConfig{VCSExcludes: []string{"vendor/.git"}},
+			wantErr:     true,
+			errContains: "vcs_excludes[0] 'vendor/.git' must be a name, not a path",
+		},
+		{
+			name:        "InvalidRelativeMkdirAllowedRoot",
+			config:      RootConfig{MkdirAllowedRoots: []string{"config"}},
+			wantErr:     true,
+			errContains: "mkdir_allowed_roots[0] 'config' must be an absolute path",
+		},
+		{
+			name:        "InvalidOnlyUnsetMkdirAllowedRoots",
+			config:      RootConfig{MkdirAllowedRoots: []string{"$DOTMAN_TEST_UNSET_ROOT"}},
+			wantErr:     true,
+			errContains: "mkdir_allowed_roots only names unset environment variables",
+		},
 		{
 			name: "InvalidVarKeyWithHyphen",
 			config: RootConfig{
@@ -347,6 +435,41 @@ func TestValidateRootConfig(t *testing.T) {
 			wantErr:     true,
 			errContains: "exclude_modules[0] cannot be empty",
 		},
+		{
+			name: "ValidModuleRoots",
+			config: RootConfig{
+				Vars:        map[string]string{},
+				ModuleRoots: []string{"packages/*/dotfiles", "*"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "InvalidModuleRootsAbsolute",
+			config: RootConfig{
+				Vars:        map[string]string{},
+				ModuleRoots: []string{"/packages/*"},
+			},
+			wantErr:     true,
+			errContains: "module_roots[0] '/packages/*' must be a relative path",
+		},
+		{
+			name: "InvalidModuleRootsParent",
+			config: RootConfig{
+				Vars:        map[string]string{},
+				ModuleRoots: []string{"../other/*"},
+			},
+			wantErr:     true,
+			errContains: "must not leave the dotfiles directory",
+		},
+		{
+			name: "InvalidModuleRootsPattern",
+			config: RootConfig{
+				Vars:        map[string]string{},
+				ModuleRoots: []string{"packages/[*"},
+			},
+			wantErr:     true,
+			errContains: "is not a valid glob pattern",
+		},
 	}
 
 	for _, tt := range tests {
@@ -421,3 +544,123 @@ func TestRootConfig_IsModuleExcluded(t *testing.T) {
 		})
 	}
 }
+
+func TestFindRootUpwards(t *testing.T) {
+	t.Run("from nested subdirectory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "DotRoot"), []byte(""), 0644))
+		nested := filepath.Join(root, "nvim", "lua", "plugins")
+		require.NoError(t, os.MkdirAll(nested, 0755))
+
+		found, err := FindRootUpwards(nested)
+		require.NoError(t, err)
+		assert.Equal(t, root, found)
+	})
+
+	t.Run("from the root itself", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "DotRoot"), []byte(""), 0644))
+
+		found, err := FindRootUpwards(root)
+		require.NoError(t, err)
+		assert.Equal(t, root, found)
+	})
+
+	t.Run("nearest root wins", func(t *testing.T) {
+		outer := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(outer, "DotRoot"), []byte(""), 0644))
+		inner := filepath.Join(outer, "inner")
+		require.NoError(t, os.MkdirAll(filepath.Join(inner, "sub"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(inner, "DotRoot"), []byte(""), 0644))
+
+		found, err := FindRootUpwards(filepath.Join(inner, "sub"))
+		require.NoError(t, err)
+		assert.Equal(t, inner, found)
+	})
+
+	t.Run("outside any dotfiles repo", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "a", "b")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		if _, err := FindRootUpwards(filepath.Dir(t.TempDir())); err == nil {
+			t.Skip("a DotRoot exists above the temp directory")
+		}
+
+		_, err := FindRootUpwards(dir)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrRootNotFound)
+	})
+}
+
+func TestLoadRootConfig_MkdirAllowedRoots(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", "/home/alice")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(`mkdir_allowed_roots:
+  - $HOME
+  - $XDG_CONFIG_HOME
+  - /opt/dotfiles/
+`), 0644))
+
+	config, err := LoadRootConfig(dir)
+	require.NoError(t, err)
+	// Variables are expanded and roots naming an unset variable are dropped
+	assert.Equal(t, []string{"/home/alice", "/opt/dotfiles"}, config.MkdirAllowedRoots)
+}
+
+func TestLoadRootConfig_DontEditMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "default",
+			content: "vars: {}\n",
+			want:    DefaultDontEditMessage,
+		},
+		{
+			name:    "custom message",
+			content: "dont_edit_message: Managed by dotman\n",
+			want:    "Managed by dotman",
+		},
+		{
+			name:    "vars take precedence",
+			content: "dont_edit_message: Managed by dotman\nvars:\n  DONT_EDIT: from vars\n",
+			want:    "from vars",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(tt.content), 0644))
+
+			config, err := LoadRootConfig(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.Vars["DONT_EDIT"])
+		})
+	}
+}
+
+func TestLoadRootConfig_ReplaceDangling(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "default", content: "vars: {}\n", want: true},
+		{name: "enabled", content: "replace_dangling: true\n", want: true},
+		{name: "disabled", content: "replace_dangling: false\n", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(tt.content), 0644))
+
+			config, err := LoadRootConfig(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.ReplacesDangling())
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a file size written as a number of bytes or with a unit, such as 512KB or 5MB.
+// Units are binary: 1KB is 1024 bytes. Zero means unset.
+type ByteSize int64
+
+// sizeUnits maps upper-case unit suffixes to their multiple of a byte
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+}
+
+// ParseByteSize parses a size such as 1048576, 100KB or 5MB
+func ParseByteSize(text string) (ByteSize, error) {
+	text = strings.TrimSpace(text)
+	number := strings.TrimRight(text, "ABGIKMabgikm ")
+	unit := strings.ToUpper(strings.TrimSpace(text[len(number):]))
+
+	multiple, ok := sizeUnits[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("size %q must be a number of bytes, optionally followed by B, KB, MB or GB", text)
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("size %q must be a non-negative whole number of bytes, KB, MB or GB", text)
+	}
+	if value > (1<<63-1)/multiple {
+		return 0, fmt.Errorf("size %q is too large", text)
+	}
+	return ByteSize(value * multiple), nil
+}
+
+// UnmarshalYAML parses the size, whether or not it is quoted
+func (size *ByteSize) UnmarshalYAML(data []byte) error {
+	parsed, err := ParseByteSize(strings.Trim(strings.TrimSpace(string(data)), `"'`))
+	if err != nil {
+		return err
+	}
+	*size = parsed
+	return nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected ByteSize
+		wantErr  bool
+	}{
+		{text: "0", expected: 0},
+		{text: "1048576", expected: 1 << 20},
+		{text: "512B", expected: 512},
+		{text: "100KB", expected: 100 << 10},
+		{text: "5MB", expected: 5 << 20},
+		{text: "5mb", expected: 5 << 20},
+		{text: "5 MiB", expected: 5 << 20},
+		{text: "2G", expected: 2 << 30},
+		{text: "", wantErr: true},
+		{text: "MB", wantErr: true},
+		{text: "1.5MB", wantErr: true},
+		{text: "-1KB", wantErr: true},
+		{text: "5TB", wantErr: true},
+		{text: "99999999999GB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			size, err := ParseByteSize(tt.text)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+}
+
+func TestByteSizeUnmarshalYAML(t *testing.T) {
+	var config struct {
+		Quoted   ByteSize `yaml:"quoted"`
+		Unquoted ByteSize `yaml:"unquoted"`
+		Bytes    ByteSize `yaml:"bytes"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte("quoted: \"5MB\"\nunquoted: 64KB\nbytes: 1024\n"), &config))
+	assert.Equal(t, ByteSize(5<<20), config.Quoted)
+	assert.Equal(t, ByteSize(64<<10), config.Unquoted)
+	assert.Equal(t, ByteSize(1024), config.Bytes)
+
+	err := yaml.Unmarshal([]byte("quoted: lots\n"), &config)
+	assert.Error(t, err)
+}
@@ -37,3 +37,16 @@ func SetDebugMode() {
 func GetLogger() zerolog.Logger {
 	return Logger
 }
+
+// SetQuietMode suppresses everything below error level
+func SetQuietMode() {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+}
+
+// OrDefault returns the given logger, or the global logger when it is nil
+func OrDefault(l *zerolog.Logger) zerolog.Logger {
+	if l != nil {
+		return *l
+	}
+	return Logger
+}
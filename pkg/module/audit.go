@@ -0,0 +1,117 @@
+package module
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// StateIssue is a state entry whose target exists but doesn't match the entry
+type StateIssue struct {
+	Entry  dotmanState.FileMapping
+	Code   ReasonCode
+	Reason string
+}
+
+// StateAudit is the outcome of a read-only scan of a state file
+type StateAudit struct {
+	// Entries is the number of entries scanned
+	Entries int
+	// MissingSources are entries whose source no longer exists in the dotfiles directory
+	MissingSources []dotmanState.FileMapping
+	// MissingTargets are entries whose target no longer exists
+	MissingTargets []dotmanState.FileMapping
+	// Inconsistent are entries whose target is not what their type records, such as a link
+	// entry whose target is a regular file or a symlink to another source
+	Inconsistent []StateIssue
+}
+
+// Clean reports whether the audit found nothing wrong
+func (a *StateAudit) Clean() bool {
+	return len(a.MissingSources) == 0 && len(a.MissingTargets) == 0 && len(a.Inconsistent) == 0
+}
+
+// AuditState checks every entry of the state file in dotfilesDir against the file system
+// without changing anything
+func AuditState(dotfilesDir string) (*StateAudit, error) {
+	return AuditStateWithConfig(&AuditConfig{StatePath: dotfilesDir})
+}
+
+// AuditStateWithConfig audits a state file using the provided configuration. A missing state
+// file tracks nothing and gives a clean audit.
+func AuditStateWithConfig(cfg *AuditConfig) (*StateAudit, error) {
+	log := logger.OrDefault(cfg.Logger)
+
+	statePath := dotmanState.Path(cfg.StatePath, cfg.Profile)
+	stateFile, err := dotmanState.LoadStateFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+
+	audit := &StateAudit{}
+	if stateFile == nil {
+		return audit, nil
+	}
+
+	symlinkMgr := filesystem.NewSymlinkManager(filesystem.NewOperator())
+	for _, entry := range stateFile.Files {
+		audit.Entries++
+
+		if _, err := os.Lstat(entry.Source); err != nil {
+			if !os.IsNotExist(err) {
+				audit.Inconsistent = append(audit.Inconsistent, StateIssue{Entry: entry, Code: ReasonCheckFailed, Reason: fmt.Sprintf("failed to check source: %v", err)})
+				continue
+			}
+			audit.MissingSources = append(audit.MissingSources, entry)
+		}
+
+		info, err := os.Lstat(entry.Target)
+		if err != nil {
+			if os.IsNotExist(err) {
+				audit.MissingTargets = append(audit.MissingTargets, entry)
+			} else {
+				audit.Inconsistent = append(audit.Inconsistent, StateIssue{Entry: entry, Code: ReasonCheckFailed, Reason: fmt.Sprintf("failed to check target: %v", err)})
+			}
+			continue
+		}
+
+		if code, reason := auditTarget(entry, info, symlinkMgr); code != "" {
+			audit.Inconsistent = append(audit.Inconsistent, StateIssue{Entry: entry, Code: code, Reason: reason})
+		}
+	}
+
+	log.Debug().Int("entries", audit.Entries).Int("missing_sources", len(audit.MissingSources)).Int("missing_targets", len(audit.MissingTargets)).Int("inconsistent", len(audit.Inconsistent)).Msg("Audited state file")
+	return audit, nil
+}
+
+// auditTarget checks an existing target against the type of its entry; the code is empty when
+// they match
+func auditTarget(entry dotmanState.FileMapping, info os.FileInfo, symlinkMgr *filesystem.SymlinkManager) (ReasonCode, string) {
+	switch entry.Type {
+	case dotmanState.TypeLink:
+		linkState, reason, err := symlinkMgr.CheckSymlink(entry.Target, entry.Source)
+		if err != nil {
+			return ReasonCheckFailed, err.Error()
+		}
+		switch linkState {
+		case filesystem.SymlinkNotALink:
+			return ReasonNotASymlink, reason
+		case filesystem.SymlinkWrongTarget:
+			return ReasonWrongTarget, reason
+		}
+	case dotmanState.TypeGenerated, dotmanState.TypeCopy, dotmanState.TypeBlock:
+		if !info.Mode().IsRegular() {
+			return ReasonNotARegularFile, fmt.Sprintf("%s entry target is not a regular file", entry.Type)
+		}
+	case dotmanState.TypeDir:
+		if !info.IsDir() {
+			return ReasonNotADirectory, "dir entry target is not a directory"
+		}
+	default:
+		return ReasonUnknownType, fmt.Sprintf("unknown entry type %q", entry.Type)
+	}
+	return "", ""
+}
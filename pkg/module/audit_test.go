@@ -0,0 +1,120 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditState(t *testing.T) {
+	t.Run("reports missing sources, missing targets and inconsistent entries", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(filepath.Join(dotfilesDir, "vim"), 0755))
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+
+		vimrc := filepath.Join(dotfilesDir, "vim", "vimrc")
+		gvimrc := filepath.Join(dotfilesDir, "vim", "gvimrc")
+		zshrc := filepath.Join(dotfilesDir, "zsh", "zshrc")
+		require.NoError(t, os.WriteFile(vimrc, []byte("set nu"), 0644))
+		require.NoError(t, os.WriteFile(gvimrc, []byte("set gui"), 0644))
+
+		// A correct link, a link whose source is gone, a missing target and a link replaced by a file
+		require.NoError(t, os.Symlink(vimrc, filepath.Join(homeDir, ".vimrc")))
+		require.NoError(t, os.Symlink(zshrc, filepath.Join(homeDir, ".zshrc")))
+		require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".gvimrc"), []byte("local"), 0644))
+
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".vimrc"), state.TypeLink)
+		stateFile.AddFileMapping(zshrc, filepath.Join(homeDir, ".zshrc"), state.TypeLink)
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".vim", "vimrc"), state.TypeLink)
+		stateFile.AddFileMapping(gvimrc, filepath.Join(homeDir, ".gvimrc"), state.TypeLink)
+		require.NoError(t, state.SaveStateFile(state.Path(dotfilesDir, ""), stateFile))
+
+		audit, err := AuditState(dotfilesDir)
+		require.NoError(t, err)
+		assert.False(t, audit.Clean())
+		assert.Equal(t, 4, audit.Entries)
+
+		require.Len(t, audit.MissingSources, 1)
+		assert.Equal(t, zshrc, audit.MissingSources[0].Source)
+		require.Len(t, audit.MissingTargets, 1)
+		assert.Equal(t, filepath.Join(homeDir, ".vim", "vimrc"), audit.MissingTargets[0].Target)
+		require.Len(t, audit.Inconsistent, 1)
+		assert.Equal(t, filepath.Join(homeDir, ".gvimrc"), audit.Inconsistent[0].Entry.Target)
+		assert.Equal(t, ReasonNotASymlink, audit.Inconsistent[0].Code)
+
+		// Nothing was changed
+		assert.FileExists(t, filepath.Join(homeDir, ".gvimrc"))
+		loaded, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		assert.Len(t, loaded.Files, 4)
+	})
+
+	t.Run("relative links to the source are consistent", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(filepath.Join(dotfilesDir, "vim"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(homeDir, ".config"), 0755))
+
+		vimrc := filepath.Join(dotfilesDir, "vim", "vimrc")
+		require.NoError(t, os.WriteFile(vimrc, []byte("set nu"), 0644))
+		require.NoError(t, os.Symlink(filepath.Join("..", "dotfiles", "vim", "vimrc"), filepath.Join(homeDir, ".vimrc")))
+		require.NoError(t, os.Symlink(filepath.Join("..", "..", "dotfiles", "vim", "vimrc"), filepath.Join(homeDir, ".config", "vimrc")))
+		// Relative to the working directory this would resolve to the source, but not to the link's directory
+		require.NoError(t, os.Symlink(filepath.Join("dotfiles", "vim", "vimrc"), filepath.Join(homeDir, ".gvimrc")))
+
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".vimrc"), state.TypeLink)
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".config", "vimrc"), state.TypeLink)
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".gvimrc"), state.TypeLink)
+		require.NoError(t, state.SaveStateFile(state.Path(dotfilesDir, ""), stateFile))
+
+		t.Chdir(tempDir)
+		audit, err := AuditState(dotfilesDir)
+		require.NoError(t, err)
+		require.Len(t, audit.Inconsistent, 1)
+		assert.Equal(t, filepath.Join(homeDir, ".gvimrc"), audit.Inconsistent[0].Entry.Target)
+		assert.Equal(t, ReasonWrongTarget, audit.Inconsistent[0].Code)
+	})
+
+	t.Run("checks targets against the entry type", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		source := filepath.Join(dotfilesDir, "app", "Dotfile")
+		require.NoError(t, os.MkdirAll(filepath.Dir(source), 0755))
+		require.NoError(t, os.WriteFile(source, []byte("{}"), 0644))
+		require.NoError(t, os.MkdirAll(filepath.Join(homeDir, "generated"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(homeDir, "dir"), []byte("file"), 0644))
+
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(source, filepath.Join(homeDir, "generated"), state.TypeGenerated)
+		stateFile.AddFileMapping(source, filepath.Join(homeDir, "dir"), state.TypeDir)
+		stateFile.AddFileMapping(source, homeDir, "future")
+		require.NoError(t, state.SaveStateFile(state.Path(dotfilesDir, "work"), stateFile))
+
+		audit, err := AuditStateWithConfig(&AuditConfig{StatePath: dotfilesDir, Profile: "work"})
+		require.NoError(t, err)
+		var codes []ReasonCode
+		for _, issue := range audit.Inconsistent {
+			codes = append(codes, issue.Code)
+		}
+		assert.ElementsMatch(t, []ReasonCode{ReasonNotARegularFile, ReasonNotADirectory, ReasonUnknownType}, codes)
+		assert.Empty(t, audit.MissingSources)
+		assert.Empty(t, audit.MissingTargets)
+	})
+
+	t.Run("missing state file is clean", func(t *testing.T) {
+		audit, err := AuditState(t.TempDir())
+		require.NoError(t, err)
+		assert.True(t, audit.Clean())
+		assert.Zero(t, audit.Entries)
+	})
+}
@@ -0,0 +1,115 @@
+package module
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+)
+
+// blockMarkers returns the lines that open and close the block of module name in a shared file
+func blockMarkers(name string) (begin, end []byte) {
+	return []byte("# >>> dotman:" + name + " >>>"), []byte("# <<< dotman:" + name + " <<<")
+}
+
+// findBlock locates the block of name in content. It returns the byte range of the block
+// including its marker lines and the range of its body; ok is false when there is no block.
+// A marker without its counterpart, or a block appearing twice, is an error.
+func findBlock(content []byte, name string) (start, end, bodyStart, bodyEnd int, ok bool, err error) {
+	begin, finish := blockMarkers(name)
+	start, end, bodyStart, bodyEnd = -1, -1, -1, -1
+
+	offset := 0
+	for offset < len(content) {
+		lineEnd := bytes.IndexByte(content[offset:], '\n')
+		next := len(content)
+		if lineEnd >= 0 {
+			next = offset + lineEnd + 1
+		}
+		line := bytes.TrimRight(content[offset:next], "\r\n")
+
+		switch {
+		case bytes.Equal(line, begin):
+			if start >= 0 {
+				return 0, 0, 0, 0, false, fmt.Errorf("block %q appears more than once", name)
+			}
+			start, bodyStart = offset, next
+		case bytes.Equal(line, finish):
+			if start < 0 || end >= 0 {
+				return 0, 0, 0, 0, false, fmt.Errorf("block %q has an end marker without a begin marker", name)
+			}
+			bodyEnd, end = offset, next
+		}
+		offset = next
+	}
+
+	if start < 0 {
+		return 0, 0, 0, 0, false, nil
+	}
+	if end < 0 {
+		return 0, 0, 0, 0, false, fmt.Errorf("block %q has no end marker", name)
+	}
+	return start, end, bodyStart, bodyEnd, true, nil
+}
+
+// blockBody returns the content of the block of name in content, without its markers
+func blockBody(content []byte, name string) ([]byte, bool, error) {
+	_, _, bodyStart, bodyEnd, ok, err := findBlock(content, name)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return content[bodyStart:bodyEnd], true, nil
+}
+
+// normalizeBlock terminates body with a newline, so the end marker starts on its own line
+func normalizeBlock(body []byte) []byte {
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body[:len(body):len(body)], '\n')
+	}
+	return body
+}
+
+// mergeBlock replaces the block of name in content with body, or appends the block when
+// content has none yet. Everything outside the block is left as it is.
+func mergeBlock(content []byte, name string, body []byte) ([]byte, error) {
+	start, end, _, _, ok, err := findBlock(content, name)
+	if err != nil {
+		return nil, err
+	}
+
+	begin, finish := blockMarkers(name)
+	block := make([]byte, 0, len(begin)+len(body)+len(finish)+2)
+	block = append(block, begin...)
+	block = append(block, '\n')
+	block = append(block, normalizeBlock(body)...)
+	block = append(block, finish...)
+	block = append(block, '\n')
+
+	merged := make([]byte, 0, len(content)+len(block)+1)
+	if ok {
+		merged = append(merged, content[:start]...)
+		merged = append(merged, block...)
+		return append(merged, content[end:]...), nil
+	}
+
+	merged = append(merged, content...)
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	return append(merged, block...), nil
+}
+
+// removeBlock returns content without the block of name; ok is false when there is no block
+func removeBlock(content []byte, name string) ([]byte, bool, error) {
+	start, end, _, _, ok, err := findBlock(content, name)
+	if err != nil || !ok {
+		return content, ok, err
+	}
+	remaining := make([]byte, 0, len(content)-(end-start))
+	remaining = append(remaining, content[:start]...)
+	return append(remaining, content[end:]...), true, nil
+}
+
+// blockSHA1 returns the SHA1 of a block body as recorded in the state file
+func blockSHA1(body []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(normalizeBlock(body)))
+}
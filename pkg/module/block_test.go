@@ -0,0 +1,89 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		body        string
+		expected    string
+		errContains string
+	}{
+		{
+			name:     "insert into new file",
+			body:     "Host a",
+			expected: "# >>> dotman:ssh >>>\nHost a\n# <<< dotman:ssh <<<\n",
+		},
+		{
+			name:     "append after user content",
+			content:  "Host mine",
+			body:     "Host a\n",
+			expected: "Host mine\n# >>> dotman:ssh >>>\nHost a\n# <<< dotman:ssh <<<\n",
+		},
+		{
+			name:     "update in place",
+			content:  "Host first\n# >>> dotman:ssh >>>\nHost old\n# <<< dotman:ssh <<<\nHost last\n",
+			body:     "Host a\nHost b\n",
+			expected: "Host first\n# >>> dotman:ssh >>>\nHost a\nHost b\n# <<< dotman:ssh <<<\nHost last\n",
+		},
+		{
+			name:     "other blocks are left alone",
+			content:  "# >>> dotman:git >>>\nHost git\n# <<< dotman:git <<<\n",
+			body:     "Host a\n",
+			expected: "# >>> dotman:git >>>\nHost git\n# <<< dotman:git <<<\n# >>> dotman:ssh >>>\nHost a\n# <<< dotman:ssh <<<\n",
+		},
+		{
+			name:        "missing end marker",
+			content:     "# >>> dotman:ssh >>>\nHost old\n",
+			errContains: `block "ssh" has no end marker`,
+		},
+		{
+			name:        "end marker without begin",
+			content:     "Host old\n# <<< dotman:ssh <<<\n",
+			errContains: `block "ssh" has an end marker without a begin marker`,
+		},
+		{
+			name:        "block twice",
+			content:     "# >>> dotman:ssh >>>\n# <<< dotman:ssh <<<\n# >>> dotman:ssh >>>\n# <<< dotman:ssh <<<\n",
+			errContains: `block "ssh" appears more than once`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeBlock([]byte(tt.content), "ssh", []byte(tt.body))
+			if tt.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(merged))
+
+			body, ok, err := blockBody(merged, "ssh")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, blockSHA1([]byte(tt.body)), blockSHA1(body))
+		})
+	}
+}
+
+func TestRemoveBlock(t *testing.T) {
+	content := "Host first\n# >>> dotman:ssh >>>\nHost a\n# <<< dotman:ssh <<<\nHost last\n"
+
+	remaining, ok, err := removeBlock([]byte(content), "ssh")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Host first\nHost last\n", string(remaining))
+
+	remaining, ok, err = removeBlock([]byte(content), "git")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, content, string(remaining))
+}
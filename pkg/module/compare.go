@@ -0,0 +1,220 @@
+package module
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// OperationChange is an operation whose module and target are in both compared sets, but
+// that differs in another field
+type OperationChange struct {
+	Before FileOperation `json:"before" yaml:"before"`
+	After  FileOperation `json:"after" yaml:"after"`
+}
+
+// OperationDiff is the difference between two sets of operations, ignoring their order
+type OperationDiff struct {
+	// Added are operations only in the second set, Removed only in the first
+	Added   []FileOperation   `json:"added,omitempty" yaml:"added,omitempty"`
+	Removed []FileOperation   `json:"removed,omitempty" yaml:"removed,omitempty"`
+	Changed []OperationChange `json:"changed,omitempty" yaml:"changed,omitempty"`
+}
+
+// IsEmpty reports whether both sets have the same operations
+func (diff OperationDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// DiffOperations compares the operations before with after, ignoring their order. Operations
+// are matched by module and target; matched operations that differ in another field are
+// changed. The prepared Rendered content and the Generator are not compared.
+func DiffOperations(before, after []FileOperation) OperationDiff {
+	type key struct{ module, target string }
+	remaining := make(map[key][]FileOperation)
+	for _, op := range sortedOperations(before) {
+		k := key{op.Module, op.Target}
+		remaining[k] = append(remaining[k], op)
+	}
+
+	// Equal operations are matched first, so duplicated targets pair up with their equal
+	var unmatched []FileOperation
+	for _, op := range sortedOperations(after) {
+		k := key{op.Module, op.Target}
+		index := slices.IndexFunc(remaining[k], func(candidate FileOperation) bool { return operationsEqual(candidate, op) })
+		if index < 0 {
+			unmatched = append(unmatched, op)
+			continue
+		}
+		remaining[k] = slices.Delete(remaining[k], index, index+1)
+	}
+
+	var diff OperationDiff
+	for _, op := range unmatched {
+		k := key{op.Module, op.Target}
+		if len(remaining[k]) == 0 {
+			diff.Added = append(diff.Added, op)
+			continue
+		}
+		diff.Changed = append(diff.Changed, OperationChange{Before: remaining[k][0], After: op})
+		remaining[k] = remaining[k][1:]
+	}
+	for _, ops := range remaining {
+		diff.Removed = append(diff.Removed, ops...)
+	}
+	sortFileOperations(diff.Removed)
+	return diff
+}
+
+// EqualOperations reports whether before and after have the same operations, in any order
+func EqualOperations(before, after []FileOperation) bool {
+	return DiffOperations(before, after).IsEmpty()
+}
+
+// ResultDiff is the difference between two results of the same kind
+type ResultDiff struct {
+	// Operations maps the name of every group of operations that differs, such as
+	// create_operations, to its diff
+	Operations map[string]OperationDiff `json:"operations,omitempty" yaml:"operations,omitempty"`
+	// Added errors and warnings are only in the second result, removed ones only in the first
+	AddedErrors     []string `json:"added_errors,omitempty" yaml:"added_errors,omitempty"`
+	RemovedErrors   []string `json:"removed_errors,omitempty" yaml:"removed_errors,omitempty"`
+	AddedWarnings   []string `json:"added_warnings,omitempty" yaml:"added_warnings,omitempty"`
+	RemovedWarnings []string `json:"removed_warnings,omitempty" yaml:"removed_warnings,omitempty"`
+}
+
+// IsEmpty reports whether both results have the same operations, errors and warnings
+func (diff ResultDiff) IsEmpty() bool {
+	return len(diff.Operations) == 0 && len(diff.AddedErrors) == 0 && len(diff.RemovedErrors) == 0 &&
+		len(diff.AddedWarnings) == 0 && len(diff.RemovedWarnings) == 0
+}
+
+// Diff compares result with other, ignoring the order of operations, errors and warnings.
+// The summary and flags derived from them are not compared.
+func (result *ValidateResult) Diff(other *ValidateResult) ResultDiff {
+	return diffResults(result.comparable(), other.comparable())
+}
+
+// Equal reports whether result and other have the same operations, errors and warnings
+func (result *ValidateResult) Equal(other *ValidateResult) bool {
+	return result.Diff(other).IsEmpty()
+}
+
+// comparable returns the operation groups, named as in reports, errors and warnings of result
+func (result *ValidateResult) comparable() comparableResult {
+	return comparableResult{
+		groups: map[string][]FileOperation{
+			"create_operations":     result.CreateOperations,
+			"create_template_ops":   result.CreateTemplateOps,
+			"force_link_operations": result.ForceLinkOperations,
+			"force_template_ops":    result.ForceTemplateOps,
+			"skip_operations":       result.SkipOperations,
+			"create_generated_ops":  result.CreateGeneratedOps,
+			"force_generated_ops":   result.ForceGeneratedOps,
+			"create_dir_ops":        result.CreateDirOps,
+			"merge_generated_ops":   result.MergeGeneratedOps,
+			"excluded_ops":          result.ExcludedOps,
+			"skipped_templates":     result.SkippedTemplates,
+		},
+		errors:   result.Errors,
+		warnings: result.Warnings,
+	}
+}
+
+// Diff compares r with other, ignoring the order of operations, errors and warnings. The
+// summary, backup paths and per-module breakdown are not compared.
+func (r *InstallResult) Diff(other *InstallResult) ResultDiff {
+	return diffResults(r.comparable(), other.comparable())
+}
+
+// Equal reports whether r and other have the same operations, errors and warnings
+func (r *InstallResult) Equal(other *InstallResult) bool {
+	return r.Diff(other).IsEmpty()
+}
+
+// comparable returns the operation groups, errors and warnings of r
+func (r *InstallResult) comparable() comparableResult {
+	return comparableResult{
+		groups: map[string][]FileOperation{
+			"created_links":       r.CreatedLinks,
+			"created_templates":   r.CreatedTemplates,
+			"created_generated":   r.CreatedGenerated,
+			"copied_files":        r.CopiedFiles,
+			"skipped_links":       r.SkippedLinks,
+			"resumed_operations":  r.ResumedOperations,
+			"skipped_templates":   r.SkippedTemplates,
+			"created_dirs":        r.CreatedDirs,
+			"merged_blocks":       r.MergedBlocks,
+			"excluded_operations": r.ExcludedOperations,
+			"failed_operations":   r.FailedOperations,
+		},
+		errors:   r.Errors,
+		warnings: r.Warnings,
+	}
+}
+
+// comparableResult is the part of a result that Diff compares
+type comparableResult struct {
+	groups           map[string][]FileOperation
+	errors, warnings []string
+}
+
+// diffResults compares the groups, errors and warnings of before with after
+func diffResults(before, after comparableResult) ResultDiff {
+	var diff ResultDiff
+	for name, ops := range before.groups {
+		if groupDiff := DiffOperations(ops, after.groups[name]); !groupDiff.IsEmpty() {
+			if diff.Operations == nil {
+				diff.Operations = make(map[string]OperationDiff)
+			}
+			diff.Operations[name] = groupDiff
+		}
+	}
+	diff.AddedErrors, diff.RemovedErrors = diffStrings(before.errors, after.errors)
+	diff.AddedWarnings, diff.RemovedWarnings = diffStrings(before.warnings, after.warnings)
+	return diff
+}
+
+// diffStrings returns the strings only in after and only in before, counting duplicates
+func diffStrings(before, after []string) (added, removed []string) {
+	counts := make(map[string]int)
+	for _, s := range before {
+		counts[s]++
+	}
+	for _, s := range after {
+		if counts[s] > 0 {
+			counts[s]--
+			continue
+		}
+		added = append(added, s)
+	}
+	for _, s := range before {
+		if counts[s] > 0 {
+			counts[s]--
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// operationsEqual reports whether a and b are the same operation, leaving out the prepared
+// Rendered content and the Generator, and not telling nil from empty Vars
+func operationsEqual(a, b FileOperation) bool {
+	for _, op := range []*FileOperation{&a, &b} {
+		op.Rendered = nil
+		op.Generator = nil
+		if len(op.Vars) == 0 {
+			op.Vars = nil
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sortedOperations returns a copy of ops sorted by target and source
+func sortedOperations(ops []FileOperation) []FileOperation {
+	sorted := append([]FileOperation(nil), ops...)
+	sortFileOperations(sorted)
+	return sorted
+}
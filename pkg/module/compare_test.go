@@ -0,0 +1,100 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffOperations(t *testing.T) {
+	vimrc := FileOperation{Type: OperationCreateLink, Source: "/dots/vim/vimrc", Target: "/home/.vimrc", Module: "vim"}
+	zshrc := FileOperation{Type: OperationCreateLink, Source: "/dots/zsh/zshrc", Target: "/home/.zshrc", Module: "zsh"}
+	gitconfig := FileOperation{Type: OperationCreateTemplate, Source: "/dots/git/gitconfig.dot-tmpl", Target: "/home/.gitconfig", Module: "git"}
+	forcedVimrc := vimrc
+	forcedVimrc.Type = OperationForceLink
+
+	tests := []struct {
+		name   string
+		before []FileOperation
+		after  []FileOperation
+		want   OperationDiff
+	}{
+		{
+			name:   "same operations in another order",
+			before: []FileOperation{vimrc, zshrc, gitconfig},
+			after:  []FileOperation{gitconfig, vimrc, zshrc},
+		},
+		{
+			name:   "added operation",
+			before: []FileOperation{vimrc, zshrc},
+			after:  []FileOperation{zshrc, gitconfig, vimrc},
+			want:   OperationDiff{Added: []FileOperation{gitconfig}},
+		},
+		{
+			name:   "removed operation",
+			before: []FileOperation{vimrc, zshrc, gitconfig},
+			after:  []FileOperation{vimrc, gitconfig},
+			want:   OperationDiff{Removed: []FileOperation{zshrc}},
+		},
+		{
+			name:   "changed operation",
+			before: []FileOperation{vimrc, zshrc},
+			after:  []FileOperation{zshrc, forcedVimrc},
+			want:   OperationDiff{Changed: []OperationChange{{Before: vimrc, After: forcedVimrc}}},
+		},
+		{
+			name:   "prepared content is not compared",
+			before: []FileOperation{gitconfig},
+			after:  []FileOperation{{Type: gitconfig.Type, Source: gitconfig.Source, Target: gitconfig.Target, Module: gitconfig.Module, Rendered: []byte("[user]"), Vars: map[string]string{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffOperations(tt.before, tt.after)
+			assert.Equal(t, tt.want, diff)
+			assert.Equal(t, diff.IsEmpty(), EqualOperations(tt.before, tt.after))
+		})
+	}
+}
+
+func TestResultDiff(t *testing.T) {
+	vimrc := FileOperation{Type: OperationCreateLink, Source: "/dots/vim/vimrc", Target: "/home/.vimrc", Module: "vim"}
+	zshrc := FileOperation{Type: OperationCreateLink, Source: "/dots/zsh/zshrc", Target: "/home/.zshrc", Module: "zsh"}
+
+	t.Run("validate results", func(t *testing.T) {
+		before := &ValidateResult{IsValid: true, CreateOperations: []FileOperation{vimrc, zshrc}}
+		after := &ValidateResult{IsValid: true, CreateOperations: []FileOperation{zshrc, vimrc}, Summary: "reworded"}
+		assert.True(t, before.Equal(after))
+
+		after.CreateOperations = []FileOperation{zshrc}
+		after.SkipOperations = []FileOperation{{Type: OperationSkip, Source: vimrc.Source, Target: vimrc.Target, Module: "vim"}}
+		diff := before.Diff(after)
+		assert.False(t, before.Equal(after))
+		assert.Equal(t, map[string]OperationDiff{
+			"create_operations": {Removed: []FileOperation{vimrc}},
+			"skip_operations":   {Added: after.SkipOperations},
+		}, diff.Operations)
+	})
+
+	t.Run("install results", func(t *testing.T) {
+		failed := vimrc
+		failed.Description = "permission denied"
+		before := &InstallResult{IsSuccess: true, CreatedLinks: []FileOperation{vimrc, zshrc}, Warnings: []string{"state not saved"}}
+		after := &InstallResult{
+			CreatedLinks:     []FileOperation{zshrc},
+			FailedOperations: []FileOperation{failed},
+			Errors:           []string{"failed to link /home/.vimrc"},
+			Warnings:         []string{"state not saved"},
+		}
+
+		assert.Equal(t, ResultDiff{
+			Operations: map[string]OperationDiff{
+				"created_links":     {Removed: []FileOperation{vimrc}},
+				"failed_operations": {Added: []FileOperation{failed}},
+			},
+			AddedErrors: []string{"failed to link /home/.vimrc"},
+		}, before.Diff(after))
+		assert.True(t, after.Equal(after))
+	})
+}
@@ -0,0 +1,65 @@
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// declaredEntry is the source and target of a state entry the config would install
+type declaredEntry struct {
+	source, target string
+}
+
+// declaredEntries returns the source and target pairs the modules install: linked files,
+// templates, extra links, keep dirs and generator output, which is recorded with the module's
+// Dotfile as its source
+func declaredEntries(modules []config.ModuleConfig) (map[declaredEntry]bool, error) {
+	mapping, err := BuildFileMapping(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	declared := make(map[declaredEntry]bool)
+	for source, target := range mapping.GetAllMappings() {
+		declared[declaredEntry{source, target}] = true
+	}
+	for source, targets := range mapping.GetExtraLinks() {
+		for _, target := range targets {
+			declared[declaredEntry{source, target}] = true
+		}
+	}
+	for source, targetDir := range mapping.GetKeepDirs() {
+		declared[declaredEntry{source, targetDir}] = true
+	}
+	for _, module := range modules {
+		dotfile := filepath.Join(module.Dir, config.ModuleConfigFile)
+		for _, generator := range module.Generators {
+			declared[declaredEntry{dotfile, filepath.Join(module.TargetDir, generator.Target)}] = true
+		}
+	}
+	return declared, nil
+}
+
+// undeclaredEntries returns the entries of stateFile that the config in dotfilesDir, with its
+// modules in sourceDir when set, no longer declares, most likely left by a removed module or file
+func undeclaredEntries(dotfilesDir, sourceDir string, stateFile *dotmanState.StateFile) ([]dotmanState.FileMapping, error) {
+	cfg, err := config.LoadDirWithSourceDir(dotfilesDir, moduleSourceDir(dotfilesDir, sourceDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	declared, err := declaredEntries(cfg.Modules)
+	if err != nil {
+		return nil, err
+	}
+
+	var undeclared []dotmanState.FileMapping
+	for _, entry := range stateFile.Files {
+		if !declared[declaredEntry{entry.Source, entry.Target}] {
+			undeclared = append(undeclared, entry)
+		}
+	}
+	return undeclared, nil
+}
@@ -0,0 +1,94 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUninstallValidateAgainstConfig(t *testing.T) {
+	// setup installs the modules vim and work, configured with Dotfiles, from dotfilesDir into homeDir
+	setup := func(t *testing.T) (string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+		require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("vars: {}\n"), 0644))
+
+		for name, file := range map[string]string{"vim": "vimrc", "work": "work.conf"} {
+			moduleDir := filepath.Join(dotfilesDir, name)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte("target_dir: "+homeDir+"\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte(name), 0644))
+		}
+		cfg, err := config.LoadDir(dotfilesDir)
+		require.NoError(t, err)
+
+		result, err := InstallWithConfig(cfg.Modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		return dotfilesDir, homeDir
+	}
+
+	t.Run("entries of a removed module are flagged", func(t *testing.T) {
+		dotfilesDir, homeDir := setup(t)
+		require.NoError(t, os.RemoveAll(filepath.Join(dotfilesDir, "work")))
+
+		result, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir, ValidateAgainstConfig: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.UndeclaredEntries, 1)
+		assert.Equal(t, filepath.Join(homeDir, "work.conf"), result.UndeclaredEntries[0].Target)
+		require.Len(t, result.Warnings, 1)
+		assert.Contains(t, result.Warnings[0], "not declared by the config")
+
+		// Flagged entries are still uninstalled
+		assert.Len(t, result.RemovedLinks, 2)
+		assert.NoFileExists(t, filepath.Join(homeDir, "work.conf"))
+	})
+
+	t.Run("nothing is flagged when the config declares every entry", func(t *testing.T) {
+		dotfilesDir, _ := setup(t)
+
+		result, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir, ValidateAgainstConfig: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Empty(t, result.UndeclaredEntries)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("modules are loaded from the source dir", func(t *testing.T) {
+		sourceDir, _ := setup(t)
+		stateDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(stateDir, "DotRoot"), []byte("vars: {}\n"), 0644))
+		require.NoError(t, os.Rename(filepath.Join(sourceDir, "state.yaml"), filepath.Join(stateDir, "state.yaml")))
+
+		result, err := UninstallWithConfig(&UninstallConfig{StatePath: stateDir, SourceDir: sourceDir, ValidateAgainstConfig: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Empty(t, result.UndeclaredEntries)
+	})
+
+	t.Run("entries are not checked without the option", func(t *testing.T) {
+		dotfilesDir, _ := setup(t)
+		require.NoError(t, os.RemoveAll(filepath.Join(dotfilesDir, "work")))
+
+		result, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.Empty(t, result.UndeclaredEntries)
+	})
+
+	t.Run("an unloadable config fails before removing anything", func(t *testing.T) {
+		dotfilesDir, homeDir := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "vim", "Dotfile"), []byte("target_dir: [\n"), 0644))
+
+		_, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir, ValidateAgainstConfig: true})
+		assert.ErrorContains(t, err, "failed to validate state against config")
+		assert.FileExists(t, filepath.Join(homeDir, "vimrc"))
+	})
+}
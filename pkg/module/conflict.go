@@ -0,0 +1,60 @@
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+)
+
+// WouldConflict reports whether adding a source -> target mapping to the modules would
+// conflict with an existing mapping or with a file already on disk, along with the reason
+func WouldConflict(modules []config.ModuleConfig, source, target string) (bool, string, error) {
+	return WouldConflictWithVars(modules, source, target, nil)
+}
+
+// WouldConflictWithVars is like WouldConflict, validating template sources with vars
+func WouldConflictWithVars(modules []config.ModuleConfig, source, target string, vars map[string]string) (bool, string, error) {
+	source, target = filepath.Clean(source), filepath.Clean(target)
+
+	mapping, err := BuildFileMapping(modules)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to build file mapping: %w", err)
+	}
+
+	// Inject the hypothetical pair and look for other sources mapping to the same target
+	isTemplate := isTemplateFile(filepath.Base(source))
+	if isTemplate {
+		mapping.AddTemplateMapping(source, target)
+	} else {
+		mapping.AddMapping(source, target)
+	}
+	if sources, exists := mapping.GetTargetConflicts()[target]; exists {
+		for _, other := range sources {
+			if other != source {
+				return true, fmt.Sprintf("target %s is already mapped from %s", target, other), nil
+			}
+		}
+	}
+
+	// Generators own their targets just like source files do
+	for _, module := range modules {
+		for _, generator := range module.Generators {
+			if filepath.Join(module.TargetDir, generator.Target) == target {
+				return true, fmt.Sprintf("target %s is already generated by module %s", target, module.Name()), nil
+			}
+		}
+	}
+
+	// Check the target on disk the same way a dry run would
+	operation, err := validateFileMapping(source, target, isTemplate, vars, ModulePaths(modules), nil)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to validate %s -> %s: %w", source, target, err)
+	}
+	switch operation.Type {
+	case OperationForceLink, OperationForceTemplate:
+		return true, operation.Description, nil
+	}
+
+	return false, "", nil
+}
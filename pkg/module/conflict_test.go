@@ -0,0 +1,85 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWouldConflict(t *testing.T) {
+	tempDir := t.TempDir()
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	// Two modules installing into the same target directory
+	shellDir := filepath.Join(tempDir, "dotfiles", "shell")
+	zshDir := filepath.Join(tempDir, "dotfiles", "zsh")
+	require.NoError(t, os.MkdirAll(shellDir, 0755))
+	require.NoError(t, os.MkdirAll(zshDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(shellDir, ".profile"), []byte("existing"), 0644))
+
+	includeHidden := true
+	modules := []config.ModuleConfig{
+		{Dir: shellDir, TargetDir: targetDir, IncludeHidden: &includeHidden},
+		{Dir: zshDir, TargetDir: targetDir, IncludeHidden: &includeHidden},
+	}
+
+	// New, not yet committed files in the zsh module
+	for _, name := range []string{".profile", ".zshrc", ".zshenv"} {
+		require.NoError(t, os.WriteFile(filepath.Join(zshDir, name), []byte("new"), 0644))
+	}
+	// A stray file already occupies the .zshenv target
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, ".zshenv"), []byte("stray"), 0644))
+
+	tests := []struct {
+		name         string
+		source       string
+		target       string
+		wantConflict bool
+		wantReason   string
+	}{
+		{
+			name:         "cross-module collision",
+			source:       filepath.Join(zshDir, ".profile"),
+			target:       filepath.Join(targetDir, ".profile"),
+			wantConflict: true,
+			wantReason:   "already mapped from " + filepath.Join(shellDir, ".profile"),
+		},
+		{
+			name:         "clean addition",
+			source:       filepath.Join(zshDir, ".zshrc"),
+			target:       filepath.Join(targetDir, ".zshrc"),
+			wantConflict: false,
+		},
+		{
+			name:         "existing file on disk",
+			source:       filepath.Join(zshDir, ".zshenv"),
+			target:       filepath.Join(targetDir, ".zshenv"),
+			wantConflict: true,
+			wantReason:   "target exists as regular file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflict, reason, err := WouldConflict(modules, tt.source, tt.target)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantConflict, conflict)
+			if tt.wantConflict {
+				assert.Contains(t, reason, tt.wantReason)
+			} else {
+				assert.Empty(t, reason)
+			}
+		})
+	}
+
+	t.Run("missing source", func(t *testing.T) {
+		_, _, err := WouldConflict(modules, filepath.Join(zshDir, "missing"), filepath.Join(targetDir, "missing"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "source file does not exist")
+	})
+}
@@ -0,0 +1,63 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	// maxDiffInput is the largest file, in bytes, a conflict diff is computed for
+	maxDiffInput = 1 << 20
+	// maxConflictDiff is the size, in bytes, conflict diffs are truncated to
+	maxConflictDiff = 16 << 10
+)
+
+// unifiedDiff returns the unified diff from the existing content of target to the content
+// installed over it; empty when they are the same. Files larger than maxDiffInput and binary
+// files are only reported as different, and long diffs are truncated to maxConflictDiff.
+func unifiedDiff(target string, existing, installed []byte) string {
+	if bytes.Equal(existing, installed) {
+		return ""
+	}
+	if len(existing) > maxDiffInput || len(installed) > maxDiffInput {
+		return fmt.Sprintf("Files %s and the installed content differ (too large to diff)\n", target)
+	}
+	if bytes.IndexByte(existing, 0) >= 0 || bytes.IndexByte(installed, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and the installed content differ\n", target)
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(existing),
+		B:        diffLines(installed),
+		FromFile: target,
+		ToFile:   target + " (installed)",
+		Context:  3,
+	})
+	if err != nil {
+		return ""
+	}
+	if len(diff) > maxConflictDiff {
+		// Cut at the last complete line
+		cut := strings.LastIndexByte(diff[:maxConflictDiff], '\n') + 1
+		diff = diff[:cut] + fmt.Sprintf("... diff truncated, %d more bytes\n", len(diff)-cut)
+	}
+	return diff
+}
+
+// diffLines splits content into lines that each end with a newline, also the last one, unlike
+// difflib.SplitLines, which adds an empty line to content that ends with a newline
+func diffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
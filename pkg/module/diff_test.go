@@ -0,0 +1,45 @@
+package module
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	longLines := strings.Repeat("existing line\n", 2000)
+
+	tests := []struct {
+		name      string
+		existing  string
+		installed string
+		want      []string
+		wantEmpty bool
+	}{
+		{name: "same content", existing: "a\n", installed: "a\n", wantEmpty: true},
+		{
+			name:      "changed line",
+			existing:  "keep\nold\n",
+			installed: "keep\nnew\n",
+			want:      []string{"--- /home/user/.bashrc\n", "+++ /home/user/.bashrc (installed)\n", " keep\n", "-old\n", "+new\n"},
+		},
+		{name: "binary", existing: "a\x00b", installed: "c", want: []string{"Binary files /home/user/.bashrc and the installed content differ\n"}},
+		{name: "too large", existing: strings.Repeat("a", maxDiffInput+1), installed: "a", want: []string{"too large to diff"}},
+		{name: "truncated", existing: longLines, installed: "new\n", want: []string{"... diff truncated, "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := unifiedDiff("/home/user/.bashrc", []byte(tt.existing), []byte(tt.installed))
+			if tt.wantEmpty {
+				assert.Empty(t, diff)
+				return
+			}
+			for _, want := range tt.want {
+				assert.Contains(t, diff, want)
+			}
+			assert.LessOrEqual(t, len(diff), maxConflictDiff+64)
+		})
+	}
+}
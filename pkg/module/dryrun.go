@@ -1,28 +1,98 @@
 package module
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"github.com/elmhuangyu/dotman/pkg/module/template"
 )
 
 // ValidateResult contains the complete results of a dry run
 type ValidateResult struct {
-	IsValid bool
-	Summary string
-	Errors  []string
+	IsValid bool `json:"is_valid" yaml:"is_valid"`
+	// RequiresForce is set when an existing target would be overwritten, even in force mode
+	RequiresForce bool     `json:"requires_force" yaml:"requires_force"`
+	Summary       string   `json:"summary" yaml:"summary"`
+	Errors        []string `json:"errors" yaml:"errors"`
+	// Warnings do not affect IsValid
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	// UnlinkedFiles are module files deliberately not linked because they match skip_link
+	UnlinkedFiles []string `json:"unlinked_files,omitempty" yaml:"unlinked_files,omitempty"`
+	// SourceFanout maps sources linked to more than one target to their targets; it is
+	// informational and never affects IsValid
+	SourceFanout map[string][]string `json:"source_fanout,omitempty" yaml:"source_fanout,omitempty"`
 	// Grouped operations by type
-	CreateOperations    []FileOperation
-	CreateTemplateOps   []FileOperation
-	ForceLinkOperations []FileOperation
-	ForceTemplateOps    []FileOperation
-	SkipOperations      []FileOperation
+	CreateOperations    []FileOperation `json:"create_operations" yaml:"create_operations"`
+	CreateTemplateOps   []FileOperation `json:"create_template_ops" yaml:"create_template_ops"`
+	ForceLinkOperations []FileOperation `json:"force_link_operations" yaml:"force_link_operations"`
+	ForceTemplateOps    []FileOperation `json:"force_template_ops" yaml:"force_template_ops"`
+	SkipOperations      []FileOperation `json:"skip_operations" yaml:"skip_operations"`
+	CreateGeneratedOps  []FileOperation `json:"create_generated_ops,omitempty" yaml:"create_generated_ops,omitempty"`
+	ForceGeneratedOps   []FileOperation `json:"force_generated_ops,omitempty" yaml:"force_generated_ops,omitempty"`
+	CreateDirOps        []FileOperation `json:"create_dir_ops,omitempty" yaml:"create_dir_ops,omitempty"`
+	MergeGeneratedOps   []FileOperation `json:"merge_generated_ops,omitempty" yaml:"merge_generated_ops,omitempty"`
+	// ExcludedOps are operations dropped because their target matches an exclude target
+	ExcludedOps []FileOperation `json:"excluded_ops,omitempty" yaml:"excluded_ops,omitempty"`
+	// SkippedTemplates are templates left out because they failed validation, with the error
+	// as their Description; only set with SkipFailedTemplates, otherwise they are errors
+	SkippedTemplates []FileOperation `json:"skipped_templates,omitempty" yaml:"skipped_templates,omitempty"`
+}
+
+// ForceOperations returns all operations that would overwrite an existing target
+func (result *ValidateResult) ForceOperations() []FileOperation {
+	var ops []FileOperation
+	ops = append(ops, result.ForceLinkOperations...)
+	ops = append(ops, result.ForceTemplateOps...)
+	ops = append(ops, result.ForceGeneratedOps...)
+	return ops
+}
+
+// Conflicts returns the operations of ForceOperations sorted by target, so conflicts of
+// links, templates and generated files are listed together
+func (result *ValidateResult) Conflicts() []FileOperation {
+	ops := result.ForceOperations()
+	sortFileOperations(ops)
+	return ops
+}
+
+// HasConflicts reports whether any operation would overwrite an existing target
+func (result *ValidateResult) HasConflicts() bool {
+	return len(result.ForceLinkOperations) > 0 || len(result.ForceTemplateOps) > 0 || len(result.ForceGeneratedOps) > 0
+}
+
+// Exit codes of a validation result, for CI checks
+const (
+	// ExitClean means a plain install would only create targets, or change nothing
+	ExitClean = 0
+	// ExitErrors means the installation would fail
+	ExitErrors = 1
+	// ExitRequiresForce means the installation would only succeed with --force
+	ExitRequiresForce = 2
+)
+
+// ExitCode maps the result to ExitClean, ExitErrors or ExitRequiresForce; errors take
+// precedence over conflicts with existing targets
+func (result *ValidateResult) ExitCode() int {
+	switch {
+	case len(result.Errors) > 0:
+		return ExitErrors
+	case result.RequiresForce:
+		return ExitRequiresForce
+	case !result.IsValid:
+		return ExitErrors
+	default:
+		return ExitClean
+	}
 }
 
 // validateTargetDirectories ensures all target directories and their parents are valid
@@ -42,9 +112,10 @@ func validateTargetDirectories(modules []config.ModuleConfig, mkdir bool) []stri
 // validateDirectoryStructure validates that a directory and all its parents are directories, not symlinks
 func validateDirectoryStructure(dir string, mkdir bool) error {
 	// Start from the target directory and go up to root
+	dir = filepath.Clean(dir)
 	current := dir
 	for {
-		if current == "" || current == "/" || current == "." {
+		if current == "" || current == "." || filesystem.IsRoot(current) {
 			break
 		}
 
@@ -70,8 +141,8 @@ func validateDirectoryStructure(dir string, mkdir bool) error {
 			return fmt.Errorf("failed to stat %s: %w", current, err)
 		}
 
-		// Check if it's a symlink first
-		if info.Mode()&os.ModeSymlink != 0 {
+		// Check if it's a symlink (or Windows junction) first
+		if filesystem.IsLinkLike(info) {
 			return fmt.Errorf("path %s is a symlink, must be a regular directory", current)
 		}
 
@@ -91,31 +162,59 @@ func validateDirectoryStructure(dir string, mkdir bool) error {
 	return nil
 }
 
-// validateFileMapping validates a single source->target mapping
-func validateFileMapping(source, target string, isTemplate bool, vars map[string]string) (FileOperation, error) {
+// validateFileMapping validates a single source->target mapping; templates may read the paths of
+// modules. Conflicts are compared with the backups of backupMgr, unless it is nil.
+func validateFileMapping(source, target string, isTemplate bool, vars map[string]string, modules map[string]template.ModulePaths, backupMgr *filesystem.BackupManager) (FileOperation, error) {
+	if err := validateSourceFile(source); err != nil {
+		return FileOperation{}, err
+	}
+
+	// For templates, validate template syntax and variables
+	if isTemplate {
+		if err := validateTemplate(source, vars, modules); err != nil {
+			return FileOperation{}, err
+		}
+	}
+
+	return classifyFileMapping(source, target, isTemplate, backupMgr)
+}
+
+// validateSourceFile checks that source exists and is a file
+func validateSourceFile(source string) error {
+	// Follow in-repo symlinks first, so a cycle of links is reported as such
+	if _, err := filesystem.ResolveSource(source); err != nil {
+		return err
+	}
+
 	// Check if source file exists
 	if _, err := os.Stat(source); os.IsNotExist(err) {
-		return FileOperation{}, fmt.Errorf("source file does not exist: %s", source)
+		return fmt.Errorf("source file does not exist: %s", source)
 	}
 
-	// Check source file info
-	sourceInfo, err := os.Lstat(source)
+	// Check source file info, following in-repo symlinks to what would be linked
+	sourceInfo, err := os.Stat(source)
 	if err != nil {
-		return FileOperation{}, fmt.Errorf("failed to stat source file %s: %w", source, err)
+		return fmt.Errorf("failed to stat source file %s: %w", source, err)
 	}
 
 	if sourceInfo.IsDir() {
-		return FileOperation{}, fmt.Errorf("source is a directory, not a file: %s", source)
+		return fmt.Errorf("source is a directory, not a file: %s", source)
 	}
+	return nil
+}
 
-	// For templates, validate template syntax and variables
-	if isTemplate {
-		renderer := template.NewRenderer()
-		if err := renderer.Validate(source, vars); err != nil {
-			return FileOperation{}, fmt.Errorf("template validation failed: %w", err)
-		}
+// validateTemplate checks the syntax of a template and that vars, or the paths of modules,
+// define every variable it uses
+func validateTemplate(source string, vars map[string]string, modules map[string]template.ModulePaths) error {
+	renderer := template.NewRendererWithModules(modules)
+	if err := renderer.Validate(source, vars); err != nil {
+		return fmt.Errorf("template validation failed: %w", err)
 	}
+	return nil
+}
 
+// classifyFileMapping returns the operation that installs an existing source file to target
+func classifyFileMapping(source, target string, isTemplate bool, backupMgr *filesystem.BackupManager) (FileOperation, error) {
 	// Check if target exists
 	targetInfo, err := os.Lstat(target)
 	if os.IsNotExist(err) {
@@ -139,15 +238,31 @@ func validateFileMapping(source, target string, isTemplate bool, vars map[string
 		return FileOperation{}, fmt.Errorf("failed to stat target %s: %w", target, err)
 	}
 
+	if destination, ok := danglingSymlink(target, targetInfo); ok {
+		operation := FileOperation{
+			Type:        OperationForceLink,
+			Source:      source,
+			Target:      target,
+			Description: fmt.Sprintf("target exists as dangling symlink to %s", destination),
+			Dangling:    true,
+		}
+		if isTemplate {
+			operation.Type = OperationForceTemplate
+		}
+		return operation, nil
+	}
+
 	// For templates, we need to check if the target file exists and has correct content
 	// For now, treat existing files as conflicts (will be handled by force mode)
 	if isTemplate {
-		return FileOperation{
+		operation := FileOperation{
 			Type:        OperationForceTemplate,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as file (template would overwrite)",
-		}, nil
+			Description: fmt.Sprintf("target exists as %s (template would overwrite)", filesystem.DescribeFileType(targetInfo)),
+		}
+		compareConflict(&operation, targetInfo, backupMgr)
+		return operation, nil
 	}
 
 	// Target exists, check if it's a symlink to the correct source
@@ -164,12 +279,17 @@ func validateFileMapping(source, target string, isTemplate bool, vars map[string
 			return FileOperation{}, fmt.Errorf("failed to resolve absolute path for source %s: %w", source, err)
 		}
 
-		absCurrentTarget, err := filepath.Abs(currentTarget)
+		resolvedSource, err := filesystem.ResolveSource(source)
+		if err != nil {
+			return FileOperation{}, fmt.Errorf("failed to resolve source %s: %w", source, err)
+		}
+
+		absCurrentTarget, err := filesystem.LinkDestination(target, currentTarget)
 		if err != nil {
 			return FileOperation{}, fmt.Errorf("failed to resolve absolute path for current target %s: %w", currentTarget, err)
 		}
 
-		if absSource == absCurrentTarget {
+		if absCurrentTarget == resolvedSource || absCurrentTarget == absSource {
 			// Correct symlink already exists
 			return FileOperation{
 				Type:        OperationSkip,
@@ -177,6 +297,14 @@ func validateFileMapping(source, target string, isTemplate bool, vars map[string
 				Target:      target,
 				Description: "correct symlink already exists",
 			}, nil
+		} else if filesystem.SameRealPath(absCurrentTarget, source) {
+			// A relative link, or one through a symlinked directory, to the same file is kept
+			return FileOperation{
+				Type:        OperationSkip,
+				Source:      source,
+				Target:      target,
+				Description: fmt.Sprintf("symlink to %s already resolves to the source", currentTarget),
+			}, nil
 		} else {
 			// Symlink exists but points to wrong file, treat as conflict
 			return FileOperation{
@@ -188,21 +316,114 @@ func validateFileMapping(source, target string, isTemplate bool, vars map[string
 		}
 	} else {
 		// Target exists but is not a symlink
-		return FileOperation{
+		operation := FileOperation{
 			Type:        OperationForceLink,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as regular file",
-		}, nil
+			Description: fmt.Sprintf("target exists as %s", filesystem.DescribeFileType(targetInfo)),
+		}
+		compareConflict(&operation, targetInfo, backupMgr)
+		return operation, nil
+	}
+}
+
+// compareConflict sets MatchesSource and MatchesBackup of a conflict whose target is a regular
+// file, noting the matches in its description. A template's source is not the content it
+// installs, so only link conflicts are compared with the source, and backups are only compared
+// with a backupMgr. Unreadable files match nothing.
+func compareConflict(operation *FileOperation, targetInfo os.FileInfo, backupMgr *filesystem.BackupManager) {
+	if !targetInfo.Mode().IsRegular() {
+		return
+	}
+	if operation.Type == OperationForceLink {
+		operation.MatchesSource, _ = filesystem.SameContent(operation.Source, operation.Target)
+	}
+	if backupMgr != nil {
+		operation.MatchesBackup, _ = backupMgr.MatchesBackup(operation.Target)
+	}
+
+	switch {
+	case operation.MatchesSource && operation.MatchesBackup:
+		operation.Description += " with the same content as the source and a backup"
+	case operation.MatchesSource:
+		operation.Description += " with the same content as the source"
+	case operation.MatchesBackup:
+		operation.Description += " with the same content as a backup"
+	}
+}
+
+// classifyGeneratedMapping returns the operation that writes the output of the Generator
+// registered for source to target
+func classifyGeneratedMapping(source, target string) (FileOperation, error) {
+	generator, ok := sourceGenerator(source)
+	if !ok {
+		return FileOperation{}, fmt.Errorf("no generator is registered for %s files", filepath.Ext(source))
+	}
+
+	operation := FileOperation{
+		Type:        OperationCreateGenerated,
+		Source:      source,
+		Target:      target,
+		Description: fmt.Sprintf("generate with the %s generator", filepath.Ext(source)),
+		Generator:   generator,
+	}
+	targetInfo, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return operation, nil
+	} else if err != nil {
+		return FileOperation{}, fmt.Errorf("failed to stat target %s: %w", target, err)
+	}
+
+	operation.Type = OperationForceGenerated
+	operation.Description = fmt.Sprintf("target exists as %s (output of the %s generator would overwrite)", filesystem.DescribeFileType(targetInfo), filepath.Ext(source))
+	if destination, ok := danglingSymlink(target, targetInfo); ok {
+		operation.Description = fmt.Sprintf("target exists as dangling symlink to %s", destination)
+		operation.Dangling = true
+	}
+	return operation, nil
+}
+
+// danglingSymlink returns the destination of target when it is a symlink to a missing file
+func danglingSymlink(target string, targetInfo os.FileInfo) (string, bool) {
+	if targetInfo.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		return "", false
+	}
+	destination, err := os.Readlink(target)
+	if err != nil {
+		return "", false
 	}
+	return destination, true
 }
 
-// validateInstallation performs dry-run validation of the installation
-func validateInstallation(modules []config.ModuleConfig, vars map[string]string) (*struct {
-	IsValid    bool
-	Mappings   *FileMapping
-	Errors     []string
-	Operations []FileOperation
+// replaceDangling turns the conflict of a dangling target symlink into the operation creating
+// the target, which removes the symlink first
+func replaceDangling(operation FileOperation) FileOperation {
+	switch operation.Type {
+	case OperationForceLink:
+		operation.Type = OperationCreateLink
+	case OperationForceTemplate:
+		operation.Type = OperationCreateTemplate
+	case OperationForceGenerated:
+		operation.Type = OperationCreateGenerated
+	}
+	destination, _ := os.Readlink(operation.Target)
+	operation.Description = fmt.Sprintf("replace dangling symlink to %s", destination)
+	return operation
+}
+
+// validateInstallation performs dry-run validation of the installation, stopping when ctx is
+// cancelled; templates may read the module paths in paths. With skipFailedTemplates invalid
+// templates are returned as SkippedTemplates instead of errors. Conflicts are compared with
+// the backups of backupMgr.
+func validateInstallation(ctx context.Context, modules []config.ModuleConfig, vars map[string]string, paths map[string]template.ModulePaths, skipFailedTemplates bool, backupMgr *filesystem.BackupManager) (*struct {
+	IsValid          bool
+	Mappings         *FileMapping
+	Errors           []string
+	Operations       []FileOperation
+	SkippedTemplates []FileOperation
 }, error) {
 	// Build file mappings
 	mapping, err := BuildFileMapping(modules)
@@ -211,10 +432,11 @@ func validateInstallation(modules []config.ModuleConfig, vars map[string]string)
 	}
 
 	result := &struct {
-		IsValid    bool
-		Mappings   *FileMapping
-		Errors     []string
-		Operations []FileOperation
+		IsValid          bool
+		Mappings         *FileMapping
+		Errors           []string
+		Operations       []FileOperation
+		SkippedTemplates []FileOperation
 	}{
 		IsValid:  true,
 		Mappings: mapping,
@@ -228,31 +450,363 @@ func validateInstallation(modules []config.ModuleConfig, vars map[string]string)
 		result.Errors = append(result.Errors, fmt.Sprintf("target conflict: %d source files map to the same target %s: %v", len(sources), target, sources))
 	}
 
+	// Validate every template before any link, so all template errors are reported together
+	templateVars, templateFailures, err := validateTemplates(ctx, mapping, modules, vars, paths)
+	if err != nil {
+		return nil, err
+	}
+	if skipFailedTemplates {
+		result.SkippedTemplates = templateFailures
+	} else {
+		for _, failure := range templateFailures {
+			result.IsValid = false
+			result.Errors = append(result.Errors, failure.Description)
+		}
+	}
+
 	// Validate each mapping
 	for source, target := range mapping.GetAllMappings() {
-		operation, err := validateFileMapping(source, target, mapping.IsTemplate(source), vars)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		module, hasModule := sourceModule(source, modules)
+		isTemplate := mapping.IsTemplate(source)
+		isGenerated := mapping.IsGenerated(source)
+		sourceVars, validTemplate := templateVars[source]
+		if isTemplate && !validTemplate {
+			// Already reported by the template validation
+			continue
+		}
+
+		var operation FileOperation
+		var err error
+		if !isTemplate {
+			err = validateSourceFile(source)
+		}
+		if err == nil && isGenerated {
+			operation, err = classifyGeneratedMapping(source, target)
+		} else if err == nil {
+			operation, err = classifyFileMapping(source, target, isTemplate, backupMgr)
+		}
 		if err != nil {
 			result.IsValid = false
 			result.Errors = append(result.Errors, fmt.Sprintf("validation error for %s -> %s: %v", source, target, err))
 			continue
 		}
+		if hasModule {
+			operation.DirMode = os.FileMode(module.DirMode)
+			operation.Module = module.Name()
+		}
+		if isTemplate {
+			operation.Vars = sourceVars
+		}
+		if isGenerated {
+			operation.Vars = vars
+			if hasModule {
+				operation.Vars = module.TemplateVars(vars)
+			}
+		}
+		if (isTemplate || isGenerated) && hasModule {
+			operation.FormatCmd = moduleFormatCmd(module, target)
+		}
+		if err := validateFormatCmd(operation.FormatCmd); err != nil {
+			result.IsValid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("validation error for %s -> %s: %v", source, target, err))
+			continue
+		}
+
+		result.Operations = append(result.Operations, operation)
+	}
+
+	// Extra links are plain links of an already mapped source, sorted for stable output
+	extraLinks := mapping.GetExtraLinks()
+	extraSources := make([]string, 0, len(extraLinks))
+	for source := range extraLinks {
+		extraSources = append(extraSources, source)
+	}
+	sort.Strings(extraSources)
+	for _, source := range extraSources {
+		for _, target := range extraLinks[source] {
+			var operation FileOperation
+			err := validateSourceFile(source)
+			if err == nil {
+				operation, err = classifyFileMapping(source, target, false, backupMgr)
+			}
+			if err != nil {
+				result.IsValid = false
+				result.Errors = append(result.Errors, fmt.Sprintf("validation error for %s -> %s: %v", source, target, err))
+				continue
+			}
+			if module, hasModule := sourceModule(source, modules); hasModule {
+				operation.DirMode = os.FileMode(module.DirMode)
+				operation.Module = module.Name()
+			}
+			result.Operations = append(result.Operations, operation)
+		}
+	}
 
+	// Keep files create their directory when it doesn't exist yet
+	keepDirs := mapping.GetKeepDirs()
+	keepFiles := make([]string, 0, len(keepDirs))
+	for source := range keepDirs {
+		keepFiles = append(keepFiles, source)
+	}
+	sort.Strings(keepFiles)
+	for _, source := range keepFiles {
+		operation, ok, err := validateKeepDir(source, keepDirs[source])
+		if err != nil {
+			result.IsValid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("validation error for %s -> %s: %v", source, keepDirs[source], err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if module, hasModule := sourceModule(source, modules); hasModule {
+			operation.DirMode = os.FileMode(module.DirMode)
+			operation.Module = module.Name()
+		}
 		result.Operations = append(result.Operations, operation)
 	}
 
+	// Validate generator commands. Generators of different modules may share a target when
+	// all of them merge their output into blocks.
+	generatorTargets := make(map[string]string)
+	mergedTargets := make(map[string]bool)
+	for _, module := range modules {
+		for _, generator := range module.Generators {
+			target := filepath.Join(module.TargetDir, generator.Target)
+			if source, exists := mapping.GetSource(target); exists {
+				result.IsValid = false
+				result.Errors = append(result.Errors, fmt.Sprintf("target conflict: generator in module %s and source file %s map to the same target %s", module.Name(), source, target))
+				continue
+			}
+			if other, exists := generatorTargets[target]; exists && !(generator.Merge && mergedTargets[target] && other != module.Name()) {
+				result.IsValid = false
+				message := fmt.Sprintf("target conflict: generators in modules %s and %s map to the same target %s", other, module.Name(), target)
+				if (generator.Merge || mergedTargets[target]) && other != module.Name() {
+					message += " (generators sharing a target must all set merge)"
+				}
+				result.Errors = append(result.Errors, message)
+				continue
+			}
+			generatorTargets[target] = module.Name()
+			mergedTargets[target] = generator.Merge
+
+			operation, err := validateGenerator(module, generator)
+			if err != nil {
+				result.IsValid = false
+				result.Errors = append(result.Errors, fmt.Sprintf("validation error for generator %s: %v", target, err))
+				continue
+			}
+
+			result.Operations = append(result.Operations, operation)
+		}
+	}
+
 	return result, nil
 }
 
+// validateTemplates checks the sources and variables of every template mapping, sorted by source.
+// It returns the variables of each valid template, keyed by source, and the operation of each
+// invalid one with its error as the Description.
+func validateTemplates(ctx context.Context, mapping *FileMapping, modules []config.ModuleConfig, vars map[string]string, paths map[string]template.ModulePaths) (map[string]map[string]string, []FileOperation, error) {
+	templates := mapping.GetTemplateMappings()
+	sources := make([]string, 0, len(templates))
+	for source := range templates {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	templateVars := make(map[string]map[string]string, len(templates))
+	var failures []FileOperation
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		target := templates[source]
+		sourceVars := vars
+		module, hasModule := sourceModule(source, modules)
+		if hasModule {
+			sourceVars = module.TemplateVars(vars)
+		}
+		sourceVars, err := fileTemplateVars(source, sourceVars)
+		if err == nil {
+			err = validateSourceFile(source)
+		}
+		if err == nil {
+			err = validateTemplateSize(source, modules)
+		}
+		if err == nil {
+			err = validateTemplate(source, sourceVars, paths)
+		}
+		if err != nil {
+			failure := FileOperation{
+				Type:        OperationCreateTemplate,
+				Source:      source,
+				Target:      target,
+				Description: fmt.Sprintf("template error for %s -> %s: %v", source, target, err),
+			}
+			if hasModule {
+				failure.Module = module.Name()
+			}
+			failures = append(failures, failure)
+			continue
+		}
+		templateVars[source] = sourceVars
+	}
+	return templateVars, failures, nil
+}
+
+// validateKeepDir returns the operation creating the directory a keep file stands for;
+// ok is false when the directory already exists
+func validateKeepDir(source, targetDir string) (FileOperation, bool, error) {
+	info, err := os.Lstat(targetDir)
+	if err == nil {
+		if info.IsDir() {
+			return FileOperation{}, false, nil
+		}
+		return FileOperation{}, false, fmt.Errorf("target exists as %s, not a directory", filesystem.DescribeFileType(info))
+	}
+	if !os.IsNotExist(err) {
+		return FileOperation{}, false, fmt.Errorf("failed to stat target %s: %w", targetDir, err)
+	}
+	return FileOperation{
+		Type:        OperationCreateDir,
+		Source:      source,
+		Target:      targetDir,
+		Description: "create empty directory for keep file",
+	}, true, nil
+}
+
+// validateTemplateSize rejects a template larger than its module's max_file_size before it is rendered
+func validateTemplateSize(source string, modules []config.ModuleConfig) error {
+	module, ok := sourceModule(source, modules)
+	if !ok || module.MaxFileSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to stat source file %s: %w", source, err)
+	}
+	if info.Size() > int64(module.MaxFileSize) {
+		return fmt.Errorf("template is %d bytes, larger than max_file_size of %d bytes", info.Size(), module.MaxFileSize)
+	}
+	return nil
+}
+
+// oversizedWarnings reports source files skipped because they exceed their module's max_file_size
+func oversizedWarnings(modules []config.ModuleConfig, mapping *FileMapping) []string {
+	var warnings []string
+	for source, size := range mapping.GetOversized() {
+		var limit config.ByteSize
+		if module, ok := sourceModule(source, modules); ok {
+			limit = module.MaxFileSize
+		}
+		warnings = append(warnings, fmt.Sprintf("skipped %s: %d bytes is larger than max_file_size of %d bytes", source, size, limit))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// validateGenerator validates a generator command and its target
+func validateGenerator(module config.ModuleConfig, generator config.GeneratorConfig) (FileOperation, error) {
+	if _, err := exec.LookPath(generator.Command[0]); err != nil {
+		return FileOperation{}, fmt.Errorf("generator command not found: %w", err)
+	}
+
+	operation := FileOperation{
+		Type:    OperationCreateGenerated,
+		Source:  filepath.Join(module.Dir, "Dotfile"),
+		Target:  filepath.Join(module.TargetDir, generator.Target),
+		Command: generator.Command,
+		Timeout: generator.TimeoutDuration(),
+		DirMode: os.FileMode(module.DirMode),
+		Module:  module.Name(),
+	}
+	command := strings.Join(generator.Command, " ")
+	if generator.Merge {
+		return validateMergedGenerator(operation, command)
+	}
+
+	operation.FormatCmd = moduleFormatCmd(module, operation.Target)
+	if err := validateFormatCmd(operation.FormatCmd); err != nil {
+		return FileOperation{}, err
+	}
+
+	targetInfo, err := os.Lstat(operation.Target)
+	if os.IsNotExist(err) {
+		operation.Description = fmt.Sprintf("generate from output of %q", command)
+		return operation, nil
+	} else if err != nil {
+		return FileOperation{}, fmt.Errorf("failed to stat target %s: %w", operation.Target, err)
+	}
+
+	operation.Type = OperationForceGenerated
+	operation.Description = fmt.Sprintf("target exists as %s (output of %q would overwrite)", filesystem.DescribeFileType(targetInfo), command)
+	return operation, nil
+}
+
+// validateMergedGenerator checks that the output of a merge generator can be merged into its
+// target. Formatters don't apply, since they would only see the block.
+func validateMergedGenerator(operation FileOperation, command string) (FileOperation, error) {
+	operation.Type = OperationMergeGenerated
+
+	targetInfo, err := os.Lstat(operation.Target)
+	if os.IsNotExist(err) {
+		operation.Description = fmt.Sprintf("create with block %s from output of %q", operation.Module, command)
+		return operation, nil
+	} else if err != nil {
+		return FileOperation{}, fmt.Errorf("failed to stat target %s: %w", operation.Target, err)
+	}
+	if !targetInfo.Mode().IsRegular() {
+		return FileOperation{}, fmt.Errorf("target exists as %s, blocks can only be merged into regular files", filesystem.DescribeFileType(targetInfo))
+	}
+
+	content, err := os.ReadFile(operation.Target)
+	if err != nil {
+		return FileOperation{}, fmt.Errorf("failed to read target %s: %w", operation.Target, err)
+	}
+	_, ok, err := blockBody(content, operation.Module)
+	if err != nil {
+		return FileOperation{}, err
+	}
+	if ok {
+		operation.Description = fmt.Sprintf("update block %s with output of %q", operation.Module, command)
+	} else {
+		operation.Description = fmt.Sprintf("append block %s with output of %q", operation.Module, command)
+	}
+	return operation, nil
+}
+
 // Validate performs a complete dry-run validation and returns structured results
 func Validate(modules []config.ModuleConfig, vars map[string]string, mkdir bool, force bool) (*ValidateResult, error) {
-	log := logger.GetLogger()
+	return ValidateWithConfig(modules, &ValidateConfig{
+		Mkdir: mkdir,
+		Force: force,
+		Vars:  vars,
+	})
+}
+
+// ValidateWithConfig performs a complete dry-run validation using the provided configuration
+func ValidateWithConfig(modules []config.ModuleConfig, cfg *ValidateConfig) (*ValidateResult, error) {
+	log := logger.OrDefault(cfg.Logger)
+	vars, mkdir, force := cfg.Vars, cfg.Mkdir, cfg.Force
 
 	log.Info().Int("modules", len(modules)).Msg("Starting validation")
 
+	// Order modules by their dependencies
+	modules, err := config.SortModules(modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to order modules: %w", err)
+	}
+
 	// Debug log all module names
 	moduleNames := make([]string, len(modules))
 	for i, module := range modules {
-		_, moduleNames[i] = filepath.Split(module.Dir)
+		moduleNames[i] = module.Name()
 	}
 	log.Debug().Str("modules", strings.Join(moduleNames, ", ")).Msg("Processing modules")
 
@@ -266,18 +820,40 @@ func Validate(modules []config.ModuleConfig, vars map[string]string, mkdir bool,
 	}
 
 	// Validate file mappings
-	validation, err := validateInstallation(modules, vars)
+	paths := cfg.ModulePaths
+	if paths == nil {
+		paths = ModulePaths(modules)
+	}
+	// Conflicts are compared with backups named the way an installation would name them
+	backupMgr := filesystem.NewBackupManagerWithOptions(filesystem.NewOperator(), filesystem.BackupOptions{Compress: cfg.CompressBackups, Timestamped: cfg.TimestampBackups, Suffix: cfg.BackupSuffix})
+	validation, err := validateInstallation(contextOrBackground(cfg.Context), modules, vars, paths, cfg.SkipFailedTemplates, backupMgr)
 	if err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
+	// Operations on excluded targets are dropped before any other check
+	operations, excluded := excludeOperations(validation.Operations, cfg.ExcludeTargets)
+	for _, op := range excluded {
+		log.Debug().Str("source", op.Source).Str("target", op.Target).Msg("Skipping operation matched by exclude target")
+	}
+
 	// Group operations by type
 	result := &ValidateResult{
-		IsValid: validation.IsValid,
-		Errors:  validation.Errors,
+		IsValid:  validation.IsValid,
+		Errors:   validation.Errors,
+		Warnings: append(homeDotfileWarnings(modules, validation.Mappings), oversizedWarnings(modules, validation.Mappings)...),
+		// Unlinked files are intentional, so they are reported but never fail the validation
+		UnlinkedFiles: validation.Mappings.GetUnlinked(),
+		SourceFanout:  validation.Mappings.GetSourceFanout(),
+		ExcludedOps:   excluded,
+		// Skipped templates are only reported, the rest of their module is still installed
+		SkippedTemplates: validation.SkippedTemplates,
 	}
 
-	for _, op := range validation.Operations {
+	for _, op := range operations {
+		if op.Dangling && !cfg.KeepDangling {
+			op = replaceDangling(op)
+		}
 		switch op.Type {
 		case OperationCreateLink:
 			result.CreateOperations = append(result.CreateOperations, op)
@@ -289,6 +865,14 @@ func Validate(modules []config.ModuleConfig, vars map[string]string, mkdir bool,
 			result.ForceTemplateOps = append(result.ForceTemplateOps, op)
 		case OperationSkip:
 			result.SkipOperations = append(result.SkipOperations, op)
+		case OperationCreateGenerated:
+			result.CreateGeneratedOps = append(result.CreateGeneratedOps, op)
+		case OperationForceGenerated:
+			result.ForceGeneratedOps = append(result.ForceGeneratedOps, op)
+		case OperationCreateDir:
+			result.CreateDirOps = append(result.CreateDirOps, op)
+		case OperationMergeGenerated:
+			result.MergeGeneratedOps = append(result.MergeGeneratedOps, op)
 		}
 	}
 
@@ -298,11 +882,71 @@ func Validate(modules []config.ModuleConfig, vars map[string]string, mkdir bool,
 	sortFileOperations(result.ForceLinkOperations)
 	sortFileOperations(result.ForceTemplateOps)
 	sortFileOperations(result.SkipOperations)
+	sortFileOperations(result.CreateGeneratedOps)
+	sortFileOperations(result.ForceGeneratedOps)
+	sortFileOperations(result.CreateDirOps)
+	sortFileOperations(result.MergeGeneratedOps)
+	sortFileOperations(result.ExcludedOps)
+
+	// Keep directories are created like targets: their parent must exist unless mkdir is set
+	if !mkdir {
+		for _, op := range result.CreateDirOps {
+			parent := filepath.Dir(op.Target)
+			if _, err := os.Stat(parent); os.IsNotExist(err) {
+				result.IsValid = false
+				result.Errors = append(result.Errors, fmt.Sprintf("target directory does not exist: %s (for keep file %s)", parent, op.Source))
+			}
+		}
+	}
+
+	// Directories may only be created under the allowed roots, when configured. Keep
+	// directories are created even without mkdir, so they are always checked.
+	if len(cfg.MkdirAllowedRoots) > 0 {
+		checked := operations
+		if !mkdir {
+			checked = result.CreateDirOps
+		}
+		for _, dir := range disallowedMkdirs(checked, cfg.MkdirAllowedRoots) {
+			result.IsValid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("mkdir would create %s outside mkdir_allowed_roots", dir))
+		}
+	}
+
+	// Directories that can't be written would only fail the installation once it is under way
+	if cfg.CheckWritable {
+		for _, unwritable := range unwritableDirs(operations) {
+			result.IsValid = false
+			result.Errors = append(result.Errors, unwritable)
+		}
+	}
+
+	// Nested target directories are legal but make it hard to tell which module owns a target
+	for _, nested := range nestedTargetDirs(modules, paths) {
+		if cfg.StrictTargetDirs {
+			result.IsValid = false
+			result.Errors = append(result.Errors, nested)
+		} else {
+			result.Warnings = append(result.Warnings, nested)
+		}
+	}
+
+	// Targets managed by another profile would be broken by installing or uninstalling either profile
+	if cfg.StateDir != "" {
+		conflicts, err := crossProfileConflicts(cfg.StateDir, cfg.Profile, operations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check other profiles: %w", err)
+		}
+		if len(conflicts) > 0 {
+			result.IsValid = false
+			result.Errors = append(result.Errors, conflicts...)
+		}
+	}
 
 	// Force operations make the dry run invalid, unless in force mode
 	// In force mode, only module config conflicts (multiple sources to same target) should fail
 	// Target file conflicts (existing files) are allowed in force mode
-	if (len(result.ForceLinkOperations) > 0 || len(result.ForceTemplateOps) > 0) && !force {
+	result.RequiresForce = result.HasConflicts()
+	if result.RequiresForce && !force {
 		result.IsValid = false
 	}
 
@@ -314,16 +958,216 @@ func Validate(modules []config.ModuleConfig, vars map[string]string, mkdir bool,
 	return result, nil
 }
 
-// sortFileOperations sorts operations by target path for consistent output
+// nestedTargetDirs reports each of modules whose target_dir lies inside the target_dir of
+// another module; paths are the paths of every module, so modules validated one at a time are
+// still checked against the others
+func nestedTargetDirs(modules []config.ModuleConfig, paths map[string]template.ModulePaths) []string {
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var nested []string
+	for _, module := range modules {
+		inner := filepath.Clean(module.TargetDir)
+		for _, name := range names {
+			if name == module.Name() {
+				continue
+			}
+			outer := filepath.Clean(paths[name].TargetDir)
+			if rel, err := filepath.Rel(outer, inner); err == nil && rel != "." && filepath.IsLocal(rel) {
+				nested = append(nested, fmt.Sprintf("target_dir %s of module %s is inside target_dir %s of module %s", inner, module.Name(), outer, name))
+			}
+		}
+	}
+	return nested
+}
+
+// homeDotfileWarnings reports targets placed directly in the home directory without a leading dot
+func homeDotfileWarnings(modules []config.ModuleConfig, mapping *FileMapping) []string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	homeDir = filepath.Clean(homeDir)
+
+	homeModules := make(map[string]string)
+	for _, module := range modules {
+		if filepath.Clean(module.TargetDir) == homeDir {
+			homeModules[filepath.Clean(module.Dir)] = module.Name()
+		}
+	}
+	if len(homeModules) == 0 {
+		return nil
+	}
+
+	var warnings []string
+	for source, target := range mapping.GetAllMappings() {
+		name, ok := owningModule(source, homeModules)
+		if !ok {
+			continue
+		}
+
+		relTarget, err := filepath.Rel(homeDir, target)
+		if err != nil {
+			continue
+		}
+		topLevel := strings.SplitN(filepath.ToSlash(relTarget), "/", 2)[0]
+		if strings.HasPrefix(topLevel, ".") {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("module %s targets the home directory but %s is not a dotfile; name the source with a leading dot and set include_hidden, or add a rename entry", name, filepath.Join(homeDir, topLevel)))
+	}
+
+	sort.Strings(warnings)
+	return slices.Compact(warnings)
+}
+
+// owningModule finds the module directory containing source
+func owningModule(source string, modules map[string]string) (string, bool) {
+	for dir := filepath.Dir(source); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if name, ok := modules[dir]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sourceModule returns the module whose directory contains source
+func sourceModule(source string, modules []config.ModuleConfig) (config.ModuleConfig, bool) {
+	for _, module := range modules {
+		if rel, err := filepath.Rel(module.Dir, source); err == nil && filepath.IsLocal(rel) {
+			return module, true
+		}
+	}
+	return config.ModuleConfig{}, false
+}
+
+// moduleFormatCmd returns the format command the module configures for target, or nil
+func moduleFormatCmd(module config.ModuleConfig, target string) []string {
+	rel, err := filepath.Rel(module.TargetDir, target)
+	if err != nil {
+		return nil
+	}
+	return module.FormatCmd(rel)
+}
+
+// validateFormatCmd checks that a configured format command can be run
+func validateFormatCmd(command []string) error {
+	if len(command) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return fmt.Errorf("format command not found: %w", err)
+	}
+	return nil
+}
+
+// disallowedMkdirs returns the missing target parent directories of ops, and the keep
+// directories ops create, sorted, that lie outside every allowed root
+func disallowedMkdirs(ops []FileOperation, allowedRoots []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, op := range ops {
+		if op.Type == OperationSkip {
+			continue
+		}
+		dir := filepath.Dir(op.Target)
+		if op.Type == OperationCreateDir {
+			dir = op.Target
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if _, err := os.Lstat(dir); err == nil || isUnderAnyRoot(dir, allowedRoots) {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// unwritableDirs describes the directories that would receive a file of ops but can't be
+// written by this process. A missing directory is checked at its nearest existing ancestor,
+// where mkdir would create it.
+func unwritableDirs(ops []FileOperation) []string {
+	checked := make(map[string]bool)
+	var unwritable []string
+	for _, op := range ops {
+		if op.Type == OperationSkip {
+			continue
+		}
+		dir := existingAncestor(filepath.Dir(op.Target))
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		if err := checkWritable(dir); err != nil {
+			unwritable = append(unwritable, fmt.Sprintf("target directory %s is not writable: %v", dir, err))
+		}
+	}
+	sort.Strings(unwritable)
+	return unwritable
+}
+
+// existingAncestor returns dir, or its nearest ancestor that exists
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// checkWritable creates and removes a temporary file in dir, which catches read-only mounts
+// and ACLs that permission bits don't show
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".dotman-write-check-*")
+	if err != nil {
+		// The name of the temporary file means nothing to the user
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return pathErr.Err
+		}
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// isUnderAnyRoot reports whether path is one of roots or inside one of them
+func isUnderAnyRoot(path string, roots []string) bool {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortFileOperations sorts operations by target path for consistent output; the blocks merged
+// into a shared target are ordered by source
 func sortFileOperations(ops []FileOperation) {
 	sort.Slice(ops, func(i, j int) bool {
-		return ops[i].Target < ops[j].Target
+		if ops[i].Target != ops[j].Target {
+			return ops[i].Target < ops[j].Target
+		}
+		return ops[i].Source < ops[j].Source
 	})
 }
 
 // generateValidationSummary creates a human-readable summary of the validation results
 func generateValidationSummary(result *ValidateResult, force bool) string {
-	totalOps := len(result.CreateOperations) + len(result.CreateTemplateOps) + len(result.ForceLinkOperations) + len(result.ForceTemplateOps) + len(result.SkipOperations)
+	forceOps := len(result.ForceOperations())
+	totalOps := len(result.CreateOperations) + len(result.CreateTemplateOps) + len(result.CreateGeneratedOps) + len(result.CreateDirOps) + len(result.MergeGeneratedOps) + forceOps + len(result.SkipOperations)
 
 	summary := fmt.Sprintf("Validation Summary: %d total file operations\n", totalOps)
 
@@ -335,7 +1179,18 @@ func generateValidationSummary(result *ValidateResult, force bool) string {
 		summary += fmt.Sprintf("  • %d template files would be generated\n", len(result.CreateTemplateOps))
 	}
 
-	forceOps := len(result.ForceLinkOperations) + len(result.ForceTemplateOps)
+	if len(result.CreateGeneratedOps) > 0 {
+		summary += fmt.Sprintf("  • %d files would be generated from command output\n", len(result.CreateGeneratedOps))
+	}
+
+	if len(result.CreateDirOps) > 0 {
+		summary += fmt.Sprintf("  • %d empty directories would be created for keep files\n", len(result.CreateDirOps))
+	}
+
+	if len(result.MergeGeneratedOps) > 0 {
+		summary += fmt.Sprintf("  • %d blocks would be merged into shared files\n", len(result.MergeGeneratedOps))
+	}
+
 	if forceOps > 0 {
 		if force {
 			summary += fmt.Sprintf("  • %d conflicts found (will be backed up in force mode)\n", forceOps)
@@ -348,29 +1203,86 @@ func generateValidationSummary(result *ValidateResult, force bool) string {
 		summary += fmt.Sprintf("  • %d files skipped (correct symlinks already exist)\n", len(result.SkipOperations))
 	}
 
+	if len(result.ExcludedOps) > 0 {
+		summary += fmt.Sprintf("  • %d targets excluded by filter\n", len(result.ExcludedOps))
+	}
+
+	if len(result.SkippedTemplates) > 0 {
+		summary += fmt.Sprintf("  • %d failed templates skipped\n", len(result.SkippedTemplates))
+	}
+
 	if len(result.Errors) > 0 {
 		summary += fmt.Sprintf("  • %d errors\n", len(result.Errors))
 	}
 
+	if len(result.Warnings) > 0 {
+		summary += fmt.Sprintf("  • %d warnings\n", len(result.Warnings))
+	}
+
 	return summary
 }
 
 // LogValidateResult logs the validation results in a structured format
 func LogValidateResult(result *ValidateResult) {
-	log := logger.GetLogger()
+	LogValidateResultWithConfig(result, &LogValidateConfig{})
+}
+
+// LogValidateResultWithConfig logs the validation results using the provided configuration
+func LogValidateResultWithConfig(result *ValidateResult, cfg *LogValidateConfig) {
+	log := logger.OrDefault(cfg.Logger)
 
 	// Log summary
 	log.Info().Msg(result.Summary)
 
-	// Log conflicts (these are the most important details)
-	forceOps := append(result.ForceLinkOperations, result.ForceTemplateOps...)
-	if len(forceOps) > 0 {
+	forceOps := result.Conflicts()
+	if cfg.Explain {
+		// Log every operation with its reason
+		log.Info().Msg("Operations:")
+		for _, group := range [][]FileOperation{result.CreateOperations, result.CreateTemplateOps, result.CreateGeneratedOps, result.CreateDirOps, result.MergeGeneratedOps, forceOps, result.SkipOperations} {
+			for _, op := range group {
+				log.Info().Msgf("  [%s] %s -> %s: %s", op.Type, op.Source, op.Target, op.Description)
+			}
+		}
+		for _, source := range result.UnlinkedFiles {
+			log.Info().Msgf("  [unlinked] %s: matches skip_link", source)
+		}
+		for _, op := range result.ExcludedOps {
+			log.Info().Msgf("  [excluded] %s -> %s: matches an exclude target", op.Source, op.Target)
+		}
+	} else if len(forceOps) > 0 {
+		// Log conflicts (these are the most important details)
 		log.Warn().Msg("Conflicts found:")
 		for _, op := range forceOps {
 			log.Warn().Msgf("  %s -> %s (%s)", op.Source, op.Target, op.Description)
 		}
 	}
 
+	// Skipped templates are never explained away, since each hides an error
+	for _, op := range result.SkippedTemplates {
+		log.Warn().Msgf("Skipped template: %s", op.Description)
+	}
+
+	// Log sources linked to several targets; this may be intended, so it is only informational
+	if len(result.SourceFanout) > 0 {
+		sources := make([]string, 0, len(result.SourceFanout))
+		for source := range result.SourceFanout {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		log.Info().Msg("Sources linked to multiple targets:")
+		for _, source := range sources {
+			log.Info().Msgf("  %s -> %s", source, strings.Join(result.SourceFanout[source], ", "))
+		}
+	}
+
+	// Log warnings
+	if len(result.Warnings) > 0 {
+		log.Warn().Msg("Warnings:")
+		for _, warning := range result.Warnings {
+			log.Warn().Msgf("  %s", warning)
+		}
+	}
+
 	// Log errors
 	if len(result.Errors) > 0 {
 		log.Error().Msg("Errors:")
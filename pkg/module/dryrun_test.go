@@ -1,11 +1,15 @@
 package module
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -230,6 +234,23 @@ func TestDryRun(t *testing.T) {
 		assert.Contains(t, result.Summary, "2 total file operations")
 		assert.Contains(t, result.Summary, "2 files would be linked")
 	})
+
+	t.Run("dry run fails on circular module dependencies", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetDir := filepath.Join(tempDir, "target")
+		err := os.MkdirAll(targetDir, 0755)
+		require.NoError(t, err)
+
+		modules := []config.ModuleConfig{
+			{Dir: filepath.Join(tempDir, "a"), TargetDir: targetDir, DependsOn: []string{"b"}},
+			{Dir: filepath.Join(tempDir, "b"), TargetDir: targetDir, DependsOn: []string{"a"}},
+		}
+
+		result, err := Validate(modules, map[string]string{}, false, false)
+		require.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "circular module dependency: a -> b -> a")
+	})
 }
 
 func TestGenerateDryRunSummary(t *testing.T) {
@@ -365,3 +386,726 @@ func TestDryRunLogOutput(t *testing.T) {
 		LogValidateResult(result)
 	})
 }
+
+func TestHomeDotfileWarnings(t *testing.T) {
+	setup := func(t *testing.T, files ...string) (config.ModuleConfig, string) {
+		tempDir := t.TempDir()
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+		t.Setenv("HOME", homeDir)
+
+		moduleDir := filepath.Join(tempDir, "dotfiles", "bash")
+		for _, file := range files {
+			path := filepath.Join(moduleDir, file)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+		}
+
+		return config.ModuleConfig{Dir: moduleDir, TargetDir: homeDir}, homeDir
+	}
+
+	t.Run("dotted names targeting home", func(t *testing.T) {
+		module, _ := setup(t, ".bashrc", ".config/bash/aliases")
+
+		result, err := Validate([]config.ModuleConfig{module}, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid)
+		assert.Empty(t, result.Warnings)
+		assert.NotContains(t, result.Summary, "warnings")
+	})
+
+	t.Run("non-dotted names targeting home", func(t *testing.T) {
+		module, homeDir := setup(t, "bashrc", "config/bash/aliases", "config/bash/env", ".profile")
+
+		result, err := Validate([]config.ModuleConfig{module}, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, "warnings must not fail validation")
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, []string{
+			"module bash targets the home directory but " + filepath.Join(homeDir, "bashrc") + " is not a dotfile; name the source with a leading dot and set include_hidden, or add a rename entry",
+			"module bash targets the home directory but " + filepath.Join(homeDir, "config") + " is not a dotfile; name the source with a leading dot and set include_hidden, or add a rename entry",
+		}, result.Warnings)
+		assert.Contains(t, result.Summary, "2 warnings")
+	})
+
+	t.Run("non-dotted names outside home", func(t *testing.T) {
+		module, homeDir := setup(t, "bashrc")
+		module.TargetDir = filepath.Join(homeDir, ".config", "bash")
+		require.NoError(t, os.MkdirAll(module.TargetDir, 0755))
+
+		result, err := Validate([]config.ModuleConfig{module}, nil, false, false)
+		require.NoError(t, err)
+		assert.Empty(t, result.Warnings)
+	})
+}
+
+func TestOperationDescriptions(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source.txt")
+	other := filepath.Join(tempDir, "other.txt")
+	tmpl := filepath.Join(tempDir, "config.dot-tmpl")
+	require.NoError(t, os.WriteFile(source, []byte("content"), 0644))
+	require.NoError(t, os.WriteFile(other, []byte("other"), 0644))
+	require.NoError(t, os.WriteFile(tmpl, []byte("user={{.USER}}"), 0644))
+
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	correctLink := filepath.Join(targetDir, "correct")
+	require.NoError(t, os.Symlink(source, correctLink))
+	wrongLink := filepath.Join(targetDir, "wrong")
+	require.NoError(t, os.Symlink(other, wrongLink))
+	regularFile := filepath.Join(targetDir, "regular")
+	require.NoError(t, os.WriteFile(regularFile, []byte("existing"), 0644))
+	directory := filepath.Join(targetDir, "directory")
+	require.NoError(t, os.MkdirAll(directory, 0755))
+	missing := filepath.Join(tempDir, "missing.txt")
+	danglingLink := filepath.Join(targetDir, "dangling")
+	require.NoError(t, os.Symlink(missing, danglingLink))
+
+	tests := []struct {
+		name        string
+		source      string
+		target      string
+		isTemplate  bool
+		opType      OperationType
+		description string
+	}{
+		{"create", source, filepath.Join(targetDir, "new"), false, OperationCreateLink, "create new symlink"},
+		{"skip", source, correctLink, false, OperationSkip, "correct symlink already exists"},
+		{"wrong symlink", source, wrongLink, false, OperationForceLink, "target exists as symlink pointing to wrong file: " + other},
+		{"regular file conflict", source, regularFile, false, OperationForceLink, "target exists as regular file"},
+		{"directory conflict", source, directory, false, OperationForceLink, "target exists as directory"},
+		{"dangling symlink", source, danglingLink, false, OperationForceLink, "target exists as dangling symlink to " + missing},
+		{"dangling template target", tmpl, danglingLink, true, OperationForceTemplate, "target exists as dangling symlink to " + missing},
+		{"create template", tmpl, filepath.Join(targetDir, "config"), true, OperationCreateTemplate, "create new template file"},
+		{"template conflict", tmpl, regularFile, true, OperationForceTemplate, "target exists as regular file (template would overwrite)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := validateFileMapping(tt.source, tt.target, tt.isTemplate, map[string]string{"USER": "test"}, nil, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.opType, op.Type)
+			assert.NotEmpty(t, op.Description)
+			assert.Equal(t, tt.description, op.Description)
+		})
+	}
+}
+
+func TestLogValidateResultExplain(t *testing.T) {
+	result := &ValidateResult{
+		IsValid: false,
+		Summary: "summary",
+		CreateOperations: []FileOperation{
+			{Type: OperationCreateLink, Source: "/src/a", Target: "/dst/a", Description: "create new symlink"},
+		},
+		ForceLinkOperations: []FileOperation{
+			{Type: OperationForceLink, Source: "/src/b", Target: "/dst/b", Description: "target exists as regular file"},
+		},
+		SkipOperations: []FileOperation{
+			{Type: OperationSkip, Source: "/src/c", Target: "/dst/c", Description: "correct symlink already exists"},
+		},
+	}
+
+	t.Run("explain lists every operation", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := zerolog.New(&buf)
+		LogValidateResultWithConfig(result, &LogValidateConfig{Explain: true, Logger: &log})
+
+		output := buf.String()
+		assert.Contains(t, output, "[create_link] /src/a -> /dst/a: create new symlink")
+		assert.Contains(t, output, "[force_link] /src/b -> /dst/b: target exists as regular file")
+		assert.Contains(t, output, "[skip] /src/c -> /dst/c: correct symlink already exists")
+	})
+
+	t.Run("default lists only conflicts", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := zerolog.New(&buf)
+		LogValidateResultWithConfig(result, &LogValidateConfig{Logger: &log})
+
+		output := buf.String()
+		assert.Contains(t, output, "/src/b -> /dst/b (target exists as regular file)")
+		assert.NotContains(t, output, "/src/a")
+		assert.NotContains(t, output, "/src/c")
+	})
+}
+
+func TestValidateResultExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		result ValidateResult
+		want   int
+	}{
+		{
+			name:   "nothing to do",
+			result: ValidateResult{IsValid: true},
+			want:   ExitClean,
+		},
+		{
+			name:   "creates only",
+			result: ValidateResult{IsValid: true, CreateOperations: []FileOperation{{Type: OperationCreateLink}}},
+			want:   ExitClean,
+		},
+		{
+			name:   "conflicts without force",
+			result: ValidateResult{IsValid: false, RequiresForce: true},
+			want:   ExitRequiresForce,
+		},
+		{
+			name:   "conflicts validated in force mode",
+			result: ValidateResult{IsValid: true, RequiresForce: true},
+			want:   ExitRequiresForce,
+		},
+		{
+			name:   "errors",
+			result: ValidateResult{IsValid: false, Errors: []string{"target conflict"}},
+			want:   ExitErrors,
+		},
+		{
+			name:   "errors take precedence over conflicts",
+			result: ValidateResult{IsValid: false, RequiresForce: true, Errors: []string{"template error"}},
+			want:   ExitErrors,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.result.ExitCode())
+		})
+	}
+}
+
+func TestValidateResultConflicts(t *testing.T) {
+	result := ValidateResult{
+		CreateOperations:    []FileOperation{{Target: "/home/a", Type: OperationCreateLink}},
+		ForceLinkOperations: []FileOperation{{Target: "/home/d", Type: OperationForceLink}, {Target: "/home/b", Type: OperationForceLink}},
+		ForceTemplateOps:    []FileOperation{{Target: "/home/c", Type: OperationForceTemplate}},
+		ForceGeneratedOps:   []FileOperation{{Target: "/home/a", Type: OperationForceGenerated}},
+	}
+
+	assert.True(t, result.HasConflicts())
+	var targets []string
+	for _, op := range result.Conflicts() {
+		targets = append(targets, op.Target)
+	}
+	assert.Equal(t, []string{"/home/a", "/home/b", "/home/c", "/home/d"}, targets)
+
+	// The grouped operations keep their own order
+	assert.Equal(t, "/home/d", result.ForceLinkOperations[0].Target)
+
+	empty := ValidateResult{CreateOperations: result.CreateOperations}
+	assert.False(t, empty.HasConflicts())
+	assert.Empty(t, empty.Conflicts())
+}
+
+func TestValidateRequiresForce(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "config.txt"), []byte("content"), 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+	result, err := Validate(modules, map[string]string{}, false, false)
+	require.NoError(t, err)
+	assert.False(t, result.RequiresForce)
+	assert.Equal(t, ExitClean, result.ExitCode())
+
+	// An existing file at the target can only be replaced with --force
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "config.txt"), []byte("existing"), 0644))
+	for _, force := range []bool{false, true} {
+		result, err := Validate(modules, map[string]string{}, false, force)
+		require.NoError(t, err)
+		assert.True(t, result.RequiresForce)
+		assert.Equal(t, ExitRequiresForce, result.ExitCode())
+	}
+}
+
+func TestValidateReportsUndefinedTemplateVars(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "gitconfig.dot-tmpl"), []byte("name = {{.NAME}}\nemail = {{.EMAIL}}\neditor = {{.EDITOR}}\n"), 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+	result, err := Validate(modules, map[string]string{"NAME": "alice"}, false, false)
+	require.NoError(t, err)
+	assert.False(t, result.IsValid)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0], "references undefined variables: EDITOR, EMAIL")
+}
+
+func TestValidateCollectsTemplateErrorsFirst(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.conf.dot-tmpl"), []byte("{{.MISSING}}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "b.conf.dot-tmpl"), []byte("{{if}}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "c.conf.dot-tmpl"), []byte("user = {{.USER}}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "zshrc"), []byte("# zsh"), 0644))
+	// A broken symlink source fails link validation
+	require.NoError(t, os.Symlink(filepath.Join(tempDir, "missing"), filepath.Join(sourceDir, "bashrc")))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+	result, err := Validate(modules, map[string]string{"USER": "alice"}, false, false)
+	require.NoError(t, err)
+	assert.False(t, result.IsValid)
+	require.Len(t, result.Errors, 3)
+
+	// Every template error is collected, in source order, before link errors
+	assert.Contains(t, result.Errors[0], "template error for "+filepath.Join(sourceDir, "a.conf.dot-tmpl"))
+	assert.Contains(t, result.Errors[0], "references undefined variables: MISSING")
+	assert.Contains(t, result.Errors[1], "template error for "+filepath.Join(sourceDir, "b.conf.dot-tmpl"))
+	assert.Contains(t, result.Errors[2], "validation error for "+filepath.Join(sourceDir, "bashrc"))
+
+	// Valid templates and links still get their operations
+	require.Len(t, result.CreateTemplateOps, 1)
+	assert.Equal(t, filepath.Join(targetDir, "c.conf"), result.CreateTemplateOps[0].Target)
+	assert.Equal(t, "alice", result.CreateTemplateOps[0].Vars["USER"])
+	require.Len(t, result.CreateOperations, 1)
+	assert.Equal(t, filepath.Join(targetDir, "zshrc"), result.CreateOperations[0].Target)
+}
+
+func TestValidateMaxFileSize(t *testing.T) {
+	const limit = 1 << 10
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	write := func(name string, size int) string {
+		path := filepath.Join(sourceDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644))
+		return path
+	}
+	write("under.bin", limit)
+	over := write("over.bin", limit+1)
+	write("under.conf.dot-tmpl", limit)
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir, MaxFileSize: limit}}
+
+	t.Run("large files are skipped with a warning", func(t *testing.T) {
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		require.Len(t, result.CreateOperations, 1)
+		assert.Equal(t, filepath.Join(targetDir, "under.bin"), result.CreateOperations[0].Target)
+		assert.Len(t, result.CreateTemplateOps, 1)
+		assert.Contains(t, result.Warnings, fmt.Sprintf("skipped %s: 1025 bytes is larger than max_file_size of 1024 bytes", over))
+	})
+
+	t.Run("large templates are errors", func(t *testing.T) {
+		template := write("over.conf.dot-tmpl", limit+1)
+		defer os.Remove(template)
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "template error for "+template)
+		assert.Contains(t, result.Errors[0], "template is 1025 bytes, larger than max_file_size of 1024 bytes")
+	})
+
+	t.Run("no limit maps everything", func(t *testing.T) {
+		result, err := Validate([]config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Len(t, result.CreateOperations, 2)
+		assert.Empty(t, result.Warnings)
+	})
+}
+
+func TestValidateKeepFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "logs"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "spool"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(targetDir, "spool"), 0755))
+	logsKeep := filepath.Join(sourceDir, "logs", ".gitkeep")
+	require.NoError(t, os.WriteFile(logsKeep, nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "spool", ".gitkeep"), nil, 0644))
+	// A keep file at the module root has no directory of its own to create
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, ".gitkeep"), nil, 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir, KeepFile: ".gitkeep"}}
+
+	t.Run("missing directories are created, existing ones left alone", func(t *testing.T) {
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Empty(t, result.CreateOperations)
+		require.Len(t, result.CreateDirOps, 1)
+		assert.Equal(t, logsKeep, result.CreateDirOps[0].Source)
+		assert.Equal(t, filepath.Join(targetDir, "logs"), result.CreateDirOps[0].Target)
+	})
+
+	t.Run("a file in place of the directory is an error", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "logs"), nil, 0644))
+		defer os.Remove(filepath.Join(targetDir, "logs"))
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "not a directory")
+	})
+
+	t.Run("a missing parent needs mkdir", func(t *testing.T) {
+		nestedKeep := filepath.Join(sourceDir, "cache", "empty", ".gitkeep")
+		require.NoError(t, os.MkdirAll(filepath.Dir(nestedKeep), 0755))
+		require.NoError(t, os.WriteFile(nestedKeep, nil, 0644))
+		defer os.RemoveAll(filepath.Join(sourceDir, "cache"))
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "target directory does not exist: "+filepath.Join(targetDir, "cache"))
+
+		result, err = Validate(modules, nil, true, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Len(t, result.CreateDirOps, 2)
+	})
+
+	t.Run("keep directories must be under mkdir_allowed_roots", func(t *testing.T) {
+		result, err := ValidateWithConfig(modules, &ValidateConfig{MkdirAllowedRoots: []string{filepath.Join(tempDir, "elsewhere")}})
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		assert.Equal(t, []string{"mkdir would create " + filepath.Join(targetDir, "logs") + " outside mkdir_allowed_roots"}, result.Errors)
+
+		result, err = ValidateWithConfig(modules, &ValidateConfig{MkdirAllowedRoots: []string{targetDir}})
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+	})
+}
+
+func TestValidateSymlinkLoop(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.Symlink("b", filepath.Join(sourceDir, "a")))
+	require.NoError(t, os.Symlink("a", filepath.Join(sourceDir, "b")))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "vimrc"), []byte("set nu"), 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+	result, err := Validate(modules, nil, false, false)
+	require.NoError(t, err)
+	assert.False(t, result.IsValid)
+	require.Len(t, result.Errors, 2)
+	for _, message := range result.Errors {
+		assert.Contains(t, message, "symlink loop detected")
+	}
+	assert.Len(t, result.CreateOperations, 1)
+}
+
+func TestValidateNestedTargetDirs(t *testing.T) {
+	tempDir := t.TempDir()
+	homeDir := filepath.Join(tempDir, "home")
+	// setup returns modules named after their target directories below homeDir
+	setup := func(t *testing.T, targets map[string]string) []config.ModuleConfig {
+		var modules []config.ModuleConfig
+		for name, target := range targets {
+			moduleDir := filepath.Join(t.TempDir(), name)
+			targetDir := filepath.Join(homeDir, target)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.MkdirAll(targetDir, 0755))
+			modules = append(modules, config.ModuleConfig{Dir: moduleDir, TargetDir: targetDir})
+		}
+		return modules
+	}
+
+	t.Run("nested target dirs are a warning", func(t *testing.T) {
+		modules := setup(t, map[string]string{"config": ".config", "nvim": ".config/nvim"})
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{})
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Equal(t, []string{fmt.Sprintf("target_dir %s of module nvim is inside target_dir %s of module config",
+			filepath.Join(homeDir, ".config", "nvim"), filepath.Join(homeDir, ".config"))}, result.Warnings)
+	})
+
+	t.Run("strict target dirs make them an error", func(t *testing.T) {
+		modules := setup(t, map[string]string{"config": ".config", "nvim": ".config/nvim"})
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{StrictTargetDirs: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "of module nvim is inside target_dir")
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("disjoint and identical target dirs are fine", func(t *testing.T) {
+		modules := setup(t, map[string]string{"nvim": ".config/nvim", "nvim-lua": ".config/nvim-lua", "fish": ".config/fish", "fish-extra": ".config/fish"})
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{StrictTargetDirs: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("modules validated alone are checked against all modules", func(t *testing.T) {
+		modules := setup(t, map[string]string{"config": ".config", "nvim": ".config/nvim"})
+		var nvim []config.ModuleConfig
+		for _, module := range modules {
+			if module.Name() == "nvim" {
+				nvim = append(nvim, module)
+			}
+		}
+
+		result, err := ValidateWithConfig(nvim, &ValidateConfig{ModulePaths: ModulePaths(modules)})
+		require.NoError(t, err)
+		require.Len(t, result.Warnings, 1)
+		assert.Contains(t, result.Warnings[0], "of module nvim is inside target_dir")
+	})
+}
+
+func TestValidateSourceFanout(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "profile"), []byte("export EDITOR=vim"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "zshrc"), []byte("source ~/.profile"), 0644))
+	source := filepath.Join(sourceDir, "profile")
+
+	t.Run("a source linked to two targets is reported without failing", func(t *testing.T) {
+		modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir, ExtraLinks: map[string][]string{"profile": {"bash_profile"}}}}
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Len(t, result.CreateOperations, 3)
+		assert.Equal(t, map[string][]string{
+			source: {filepath.Join(targetDir, "bash_profile"), filepath.Join(targetDir, "profile")},
+		}, result.SourceFanout)
+
+		var buf bytes.Buffer
+		log := zerolog.New(&buf)
+		LogValidateResultWithConfig(result, &LogValidateConfig{Logger: &log})
+		assert.Contains(t, buf.String(), "Sources linked to multiple targets:")
+		assert.Contains(t, buf.String(), source+" -> "+filepath.Join(targetDir, "bash_profile"))
+	})
+
+	t.Run("an extra link onto another source's target is a conflict", func(t *testing.T) {
+		modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir, ExtraLinks: map[string][]string{"profile": {"zshrc"}}}}
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		assert.Contains(t, strings.Join(result.Errors, "\n"), "target conflict")
+	})
+}
+
+func TestValidateLinkStyle(t *testing.T) {
+	// setup creates a module with vimrc and zshrc, both already linked into the target directory
+	// with link, which returns the destination to link to
+	setup := func(t *testing.T, link func(tempDir, source string) string) (string, string) {
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "dotfiles", "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(sourceDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		for _, name := range []string{"vimrc", "zshrc"} {
+			source := filepath.Join(sourceDir, name)
+			require.NoError(t, os.WriteFile(source, []byte(name), 0644))
+			require.NoError(t, os.Symlink(link(tempDir, source), filepath.Join(targetDir, name)))
+		}
+		return sourceDir, targetDir
+	}
+
+	tests := []struct {
+		name string
+		link func(tempDir, source string) string
+	}{
+		{
+			name: "absolute links",
+			link: func(tempDir, source string) string { return source },
+		},
+		{
+			name: "relative links",
+			link: func(tempDir, source string) string {
+				return filepath.Join("..", "dotfiles", "shell", filepath.Base(source))
+			},
+		},
+		{
+			name: "links through a symlinked directory",
+			link: func(tempDir, source string) string {
+				alias := filepath.Join(tempDir, "alias")
+				if _, err := os.Lstat(alias); os.IsNotExist(err) {
+					require.NoError(t, os.Symlink(filepath.Dir(source), alias))
+				}
+				return filepath.Join(alias, filepath.Base(source))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir, targetDir := setup(t, tt.link)
+			modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+			result, err := Validate(modules, nil, false, false)
+			require.NoError(t, err)
+			assert.True(t, result.IsValid, result.Errors)
+			assert.Empty(t, result.ForceLinkOperations)
+			assert.Len(t, result.SkipOperations, 2)
+		})
+	}
+
+	t.Run("relative link to another file still conflicts", func(t *testing.T) {
+		sourceDir, targetDir := setup(t, func(tempDir, source string) string {
+			return filepath.Join("..", "dotfiles", "shell", "zshrc")
+		})
+		modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		require.Len(t, result.ForceLinkOperations, 1)
+		assert.Equal(t, filepath.Join(targetDir, "vimrc"), result.ForceLinkOperations[0].Target)
+	})
+}
+
+func TestValidateConflictMatches(t *testing.T) {
+	tests := []struct {
+		name          string
+		existing      string
+		backups       map[string]string
+		matchesSource bool
+		matchesBackup bool
+	}{
+		{name: "differs from source and backups", existing: "local", backups: map[string]string{".bak": "older"}},
+		{name: "same as source", existing: "content", matchesSource: true},
+		{name: "same as a backup", existing: "local", backups: map[string]string{".bak": "older", ".bak.1": "local"}, matchesBackup: true},
+		{name: "same as source and backup", existing: "content", backups: map[string]string{".bak": "content"}, matchesSource: true, matchesBackup: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "source")
+			targetDir := filepath.Join(tempDir, "target")
+			require.NoError(t, os.MkdirAll(sourceDir, 0755))
+			require.NoError(t, os.MkdirAll(targetDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "config.txt"), []byte("content"), 0644))
+			target := filepath.Join(targetDir, "config.txt")
+			require.NoError(t, os.WriteFile(target, []byte(tt.existing), 0644))
+			for suffix, content := range tt.backups {
+				require.NoError(t, os.WriteFile(target+suffix, []byte(content), 0644))
+			}
+
+			result, err := Validate([]config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}, nil, false, false)
+			require.NoError(t, err)
+			require.Len(t, result.ForceLinkOperations, 1)
+			assert.Equal(t, tt.matchesSource, result.ForceLinkOperations[0].MatchesSource)
+			assert.Equal(t, tt.matchesBackup, result.ForceLinkOperations[0].MatchesBackup)
+		})
+	}
+
+	t.Run("template conflicts are only compared with backups", func(t *testing.T) {
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "source")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(sourceDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "config.dot-tmpl"), []byte("content"), 0644))
+		target := filepath.Join(targetDir, "config")
+		require.NoError(t, os.WriteFile(target, []byte("content"), 0644))
+		require.NoError(t, os.WriteFile(target+".bak", []byte("content"), 0644))
+
+		result, err := Validate([]config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}, nil, false, false)
+		require.NoError(t, err)
+		require.Len(t, result.ForceTemplateOps, 1)
+		assert.False(t, result.ForceTemplateOps[0].MatchesSource)
+		assert.True(t, result.ForceTemplateOps[0].MatchesBackup)
+		assert.Contains(t, result.ForceTemplateOps[0].Description, "same content as a backup")
+	})
+
+	t.Run("backups are looked up with the configured suffix", func(t *testing.T) {
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "source")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(sourceDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "config.txt"), []byte("content"), 0644))
+		target := filepath.Join(targetDir, "config.txt")
+		require.NoError(t, os.WriteFile(target, []byte("local"), 0644))
+		require.NoError(t, os.WriteFile(target+".dotman.bak", []byte("local"), 0644))
+		modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{BackupSuffix: ".dotman.bak"})
+		require.NoError(t, err)
+		require.Len(t, result.ForceLinkOperations, 1)
+		assert.True(t, result.ForceLinkOperations[0].MatchesBackup)
+
+		result, err = ValidateWithConfig(modules, &ValidateConfig{})
+		require.NoError(t, err)
+		require.Len(t, result.ForceLinkOperations, 1)
+		assert.False(t, result.ForceLinkOperations[0].MatchesBackup)
+	})
+}
+
+func TestValidateCheckWritable(t *testing.T) {
+	// setup creates a module linking vimrc into the target dir and nvim/init.vim into a
+	// read-only subdirectory of it
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "dotfiles", "vim")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "nvim"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(targetDir, "nvim"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "vimrc"), []byte("set nu"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "nvim", "init.vim"), []byte("set nu"), 0644))
+		require.NoError(t, os.Chmod(filepath.Join(targetDir, "nvim"), 0555))
+		t.Cleanup(func() { os.Chmod(filepath.Join(targetDir, "nvim"), 0755) })
+		return filepath.Dir(sourceDir), targetDir, []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+	}
+
+	t.Run("writable directories pass", func(t *testing.T) {
+		_, targetDir, modules := setup(t)
+		require.NoError(t, os.Chmod(filepath.Join(targetDir, "nvim"), 0755))
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{CheckWritable: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		entries, err := os.ReadDir(targetDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "the write check leaves no file behind")
+	})
+
+	t.Run("a missing directory is checked at its existing parent", func(t *testing.T) {
+		_, targetDir, modules := setup(t)
+		require.NoError(t, os.Chmod(filepath.Join(targetDir, "nvim"), 0755))
+		require.NoError(t, os.Remove(filepath.Join(targetDir, "nvim")))
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{Mkdir: true, CheckWritable: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+	})
+
+	t.Run("a read-only directory fails validation and the installation up front", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to read-only directories")
+		}
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := ValidateWithConfig(modules, &ValidateConfig{CheckWritable: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Equal(t, fmt.Sprintf("target directory %s is not writable: permission denied", filepath.Join(targetDir, "nvim")), result.Errors[0])
+
+		installResult, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, CheckWritable: true})
+		require.NoError(t, err)
+		assert.False(t, installResult.IsSuccess)
+		assert.NoFileExists(t, filepath.Join(targetDir, "vimrc"))
+	})
+}
@@ -2,11 +2,16 @@ package module
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
 )
 
 // FileMapping represents a two-way mapping between source and target files
@@ -17,14 +22,52 @@ type FileMapping struct {
 	targetToSource map[string]string
 	// templates maps source template file paths to their target paths
 	templates map[string]string
+	// unlinked are source files intentionally left out of the mapping by skip_link
+	unlinked []string
+	// oversized maps source files left out of the mapping by max_file_size to their size
+	oversized map[string]int64
+	// keepDirs maps keep files to the target directory they stand for
+	keepDirs map[string]string
+	// generated maps source files with a registered Generator to their target paths
+	generated map[string]string
+	// extraLinks maps source files to the targets linked by extra_links, besides their own
+	extraLinks map[string][]string
 }
 
 // FileOperation represents a file operation that would be performed
 type FileOperation struct {
-	Type        OperationType
-	Source      string
-	Target      string
-	Description string
+	Type        OperationType `json:"type" yaml:"type"`
+	Source      string        `json:"source" yaml:"source"`
+	Target      string        `json:"target" yaml:"target"`
+	Description string        `json:"description" yaml:"description"`
+	// Command and Timeout are only set for generated operations
+	Command []string      `json:"command,omitempty" yaml:"command,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// DirMode is the module's dir_mode for directories created for the target, zero when unset
+	DirMode os.FileMode `json:"dir_mode,omitempty" yaml:"dir_mode,omitempty"`
+	// Module is the name of the module the operation originates from
+	Module string `json:"module,omitempty" yaml:"module,omitempty"`
+	// FormatCmd formats the content of template and generated operations before it is written
+	FormatCmd []string `json:"format_cmd,omitempty" yaml:"format_cmd,omitempty"`
+	// Vars are the root and module variables a template operation is rendered with
+	Vars map[string]string `json:"-" yaml:"-"`
+	// Rendered is the template output prepared before any file is written, when preflighted
+	Rendered []byte `json:"-" yaml:"-"`
+	// Generator produces the content of generated operations whose source has a registered
+	// Generator, instead of Command
+	Generator Generator `json:"-" yaml:"-"`
+	// MatchesSource is set on a link conflict whose target is a regular file with the same
+	// content as the source, so replacing it with the link loses nothing
+	MatchesSource bool `json:"matches_source,omitempty" yaml:"matches_source,omitempty"`
+	// MatchesBackup is set on a conflict whose target is a regular file with the same content
+	// as one of its backups, so it was already backed up once
+	MatchesBackup bool `json:"matches_backup,omitempty" yaml:"matches_backup,omitempty"`
+	// Dangling is set when the target is a symlink to a missing file, which is replaced
+	// without a backup unless dangling symlinks are kept as conflicts
+	Dangling bool `json:"dangling,omitempty" yaml:"dangling,omitempty"`
+	// ConflictDiff is the unified diff from a replaced regular file to the linked source or
+	// rendered template, set when installing with ShowDiff
+	ConflictDiff string `json:"conflict_diff,omitempty" yaml:"conflict_diff,omitempty"`
 }
 
 // NewFileMapping creates a new empty FileMapping
@@ -33,6 +76,10 @@ func NewFileMapping() *FileMapping {
 		sourceToTarget: make(map[string]string),
 		targetToSource: make(map[string]string),
 		templates:      make(map[string]string),
+		oversized:      make(map[string]int64),
+		keepDirs:       make(map[string]string),
+		generated:      make(map[string]string),
+		extraLinks:     make(map[string][]string),
 	}
 }
 
@@ -48,6 +95,18 @@ func (fm *FileMapping) AddTemplateMapping(source, target string) {
 	fm.templates[source] = target
 }
 
+// AddGeneratedMapping adds the mapping of a source whose target a registered Generator produces
+func (fm *FileMapping) AddGeneratedMapping(source, target string) {
+	fm.AddMapping(source, target)
+	fm.generated[source] = target
+}
+
+// IsGenerated checks if a source file is turned into its target by a registered Generator
+func (fm *FileMapping) IsGenerated(source string) bool {
+	_, exists := fm.generated[source]
+	return exists
+}
+
 // GetTarget returns the target path for a given source path
 func (fm *FileMapping) GetTarget(source string) (string, bool) {
 	target, exists := fm.sourceToTarget[source]
@@ -69,6 +128,39 @@ func (fm *FileMapping) GetAllMappings() map[string]string {
 	return result
 }
 
+// AddExtraLink links one more target to a source that is already mapped
+func (fm *FileMapping) AddExtraLink(source, target string) {
+	fm.extraLinks[source] = append(fm.extraLinks[source], target)
+	fm.targetToSource[target] = source
+}
+
+// GetExtraLinks returns the extra targets linked to each source, besides its own target
+func (fm *FileMapping) GetExtraLinks() map[string][]string {
+	result := make(map[string][]string, len(fm.extraLinks))
+	for source, targets := range fm.extraLinks {
+		result[source] = slices.Clone(targets)
+	}
+	return result
+}
+
+// GetSourceFanout returns the sources mapped to more than one target, with all their
+// targets sorted. Unlike target conflicts this is allowed, but may be a mistake.
+func (fm *FileMapping) GetSourceFanout() map[string][]string {
+	fanout := make(map[string][]string)
+	for source, extra := range fm.extraLinks {
+		targets := append([]string{}, extra...)
+		if target, exists := fm.sourceToTarget[source]; exists {
+			targets = append(targets, target)
+		}
+		slices.Sort(targets)
+		targets = slices.Compact(targets)
+		if len(targets) > 1 {
+			fanout[source] = targets
+		}
+	}
+	return fanout
+}
+
 // GetTargetConflicts returns any duplicate target mappings
 func (fm *FileMapping) GetTargetConflicts() map[string][]string {
 	conflicts := make(map[string][]string)
@@ -78,6 +170,11 @@ func (fm *FileMapping) GetTargetConflicts() map[string][]string {
 	for source, target := range fm.sourceToTarget {
 		targetToSources[target] = append(targetToSources[target], source)
 	}
+	for source, targets := range fm.extraLinks {
+		for _, target := range targets {
+			targetToSources[target] = append(targetToSources[target], source)
+		}
+	}
 
 	// Find targets with multiple sources
 	for target, sources := range targetToSources {
@@ -104,6 +201,38 @@ func (fm *FileMapping) GetTemplateMappings() map[string]string {
 	return result
 }
 
+// AddUnlinked records a source file that is part of a module but intentionally not linked
+func (fm *FileMapping) AddUnlinked(source string) {
+	fm.unlinked = append(fm.unlinked, source)
+}
+
+// GetUnlinked returns the source files excluded by skip_link, sorted
+func (fm *FileMapping) GetUnlinked() []string {
+	unlinked := slices.Clone(fm.unlinked)
+	slices.Sort(unlinked)
+	return unlinked
+}
+
+// AddOversized records a source file of size bytes that is not linked because it exceeds max_file_size
+func (fm *FileMapping) AddOversized(source string, size int64) {
+	fm.oversized[source] = size
+}
+
+// GetOversized returns the source files excluded by max_file_size, mapped to their size
+func (fm *FileMapping) GetOversized() map[string]int64 {
+	return fm.oversized
+}
+
+// AddKeepDir records a keep file that makes dotman create targetDir instead of linking it
+func (fm *FileMapping) AddKeepDir(source, targetDir string) {
+	fm.keepDirs[source] = targetDir
+}
+
+// GetKeepDirs returns the keep files mapped to the target directories they stand for
+func (fm *FileMapping) GetKeepDirs() map[string]string {
+	return fm.keepDirs
+}
+
 // BuildFileMapping creates a FileMapping from all modules in the config
 func BuildFileMapping(modules []config.ModuleConfig) (*FileMapping, error) {
 	mapping := NewFileMapping()
@@ -118,10 +247,26 @@ func BuildFileMapping(modules []config.ModuleConfig) (*FileMapping, error) {
 		for source, target := range moduleMapping.GetAllMappings() {
 			if moduleMapping.IsTemplate(source) {
 				mapping.AddTemplateMapping(source, target)
+			} else if moduleMapping.IsGenerated(source) {
+				mapping.AddGeneratedMapping(source, target)
 			} else {
 				mapping.AddMapping(source, target)
 			}
 		}
+		for _, source := range moduleMapping.GetUnlinked() {
+			mapping.AddUnlinked(source)
+		}
+		for source, size := range moduleMapping.GetOversized() {
+			mapping.AddOversized(source, size)
+		}
+		for source, targetDir := range moduleMapping.GetKeepDirs() {
+			mapping.AddKeepDir(source, targetDir)
+		}
+		for source, targets := range moduleMapping.GetExtraLinks() {
+			for _, target := range targets {
+				mapping.AddExtraLink(source, target)
+			}
+		}
 	}
 
 	return mapping, nil
@@ -130,6 +275,10 @@ func BuildFileMapping(modules []config.ModuleConfig) (*FileMapping, error) {
 // buildModuleMapping creates a FileMapping for a single module
 func buildModuleMapping(module config.ModuleConfig) (*FileMapping, error) {
 	mapping := NewFileMapping()
+	// flattened tracks the source of each target when the layout drops subdirectories
+	flattened := make(map[string]string)
+	// vars render directory names with template syntax
+	vars := module.TemplateVars(module.RootVars)
 
 	// Walk through all files in module directory recursively
 	err := filepath.WalkDir(module.Dir, func(path string, entry os.DirEntry, err error) error {
@@ -142,8 +291,50 @@ func buildModuleMapping(module config.ModuleConfig) (*FileMapping, error) {
 			return nil
 		}
 
-		// Skip directories (but continue walking into them)
+		// Never map version control metadata, such as the .git file of a submodule
+		if slices.Contains(module.ExcludedNames(), entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Skip directories (but continue walking into them), unless they are deeper than
+		// max_depth or ignored; every file below an ignored directory is ignored too
 		if entry.IsDir() {
+			relDir, err := filepath.Rel(module.Dir, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+			}
+			if isIgnored(relDir, module.Ignores) {
+				return filepath.SkipDir
+			}
+			if depth := len(strings.Split(filepath.ToSlash(relDir), "/")); module.MaxDepth > 0 && depth > module.MaxDepth {
+				return fmt.Errorf("%s is %d directories deep, more than max_depth %d", relDir, depth, module.MaxDepth)
+			}
+			return nil
+		}
+
+		// Keep files stand for their directory, which is created instead; the module root
+		// and flattened layouts have no directory of their own to create
+		if entry.Name() == module.KeepFileName() {
+			relPath, err := filepath.Rel(module.Dir, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+			}
+			relDir := filepath.Dir(relPath)
+			if relDir != "." && module.Layout != config.LayoutFlatten && !isIgnored(relPath, module.Ignores) {
+				targetPath, err := renderDirNames(relPath, vars)
+				if err != nil {
+					return err
+				}
+				mapping.AddKeepDir(path, filepath.Join(module.TargetDir, filepath.Dir(targetPath)))
+			}
+			return nil
+		}
+
+		// Hidden source files, such as .DS_Store or editor swap files, are only mapped on request
+		if !module.MapsHidden() && strings.HasPrefix(entry.Name(), ".") {
 			return nil
 		}
 
@@ -157,25 +348,90 @@ func buildModuleMapping(module config.ModuleConfig) (*FileMapping, error) {
 			return nil
 		}
 
-		// Skip Dotfile config file
-		if entry.Name() == "Dotfile" {
+		// Skip dotman's own control files, such as the Dotfile or a state file
+		if isReservedFile(entry.Name()) {
+			return nil
+		}
+
+		// Skip per-file vars, which are read when rendering their template
+		if isVarsFile(path) {
+			return nil
+		}
+
+		// Files whose condition doesn't hold are left out, like ignored files
+		included, err := module.Includes(relPath)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate condition for %s: %w", relPath, err)
+		}
+		if !included {
+			return nil
+		}
+
+		// Files matched by skip_link belong to the module but are never linked
+		if module.SkipsLink(relPath) {
+			mapping.AddUnlinked(path)
 			return nil
 		}
 
-		// Calculate target path, preserving subdirectory structure
+		// Files too large to link are skipped; templates are rejected by validation instead
+		if module.MaxFileSize > 0 && !isTemplateFile(entry.Name()) {
+			if info, err := os.Stat(path); err == nil && info.Size() > int64(module.MaxFileSize) {
+				mapping.AddOversized(path, info.Size())
+				return nil
+			}
+		}
+
+		// Calculate target path, preserving subdirectory structure unless the layout flattens it
+		_, hasGenerator := sourceGenerator(entry.Name())
+		hasGenerator = hasGenerator && !isTemplateFile(entry.Name())
 		targetName := relPath
-		if isTemplateFile(entry.Name()) {
-			// Remove .dot-tmpl extension for target filename
-			targetName = strings.TrimSuffix(relPath, ".dot-tmpl")
+		if renamed, ok := renamedTarget(relPath, module.Rename); ok {
+			// An explicit rename replaces the whole target name, including any template suffix
+			targetName = renamed
+		} else {
+			if module.Layout == config.LayoutFlatten {
+				targetName = entry.Name()
+			} else if targetName, err = renderDirNames(relPath, vars); err != nil {
+				return err
+			}
+			if isTemplateFile(entry.Name()) {
+				// Remove .dot-tmpl extension for target filename
+				targetName = strings.TrimSuffix(targetName, ".dot-tmpl")
+			} else if hasGenerator {
+				// Remove the generator's extension for target filename
+				targetName = strings.TrimSuffix(targetName, filepath.Ext(targetName))
+			}
 		}
 		targetFile := filepath.Join(module.TargetDir, targetName)
+		if module.TargetFile != "" {
+			targetFile = module.TargetFile
+		}
+
+		if module.Layout == config.LayoutFlatten {
+			if other, exists := flattened[targetFile]; exists {
+				return fmt.Errorf("flatten layout maps both %s and %s to %s", other, relPath, targetFile)
+			}
+			flattened[targetFile] = relPath
+		}
 
 		if isTemplateFile(entry.Name()) {
 			mapping.AddTemplateMapping(path, targetFile)
+		} else if hasGenerator {
+			mapping.AddGeneratedMapping(path, targetFile)
 		} else {
 			mapping.AddMapping(path, targetFile)
 		}
 
+		if extraTargets := extraLinkTargets(relPath, module.ExtraLinks); len(extraTargets) > 0 {
+			// Templates and generated files are written per target, so only links can fan out
+			if isTemplateFile(entry.Name()) || hasGenerator {
+				return fmt.Errorf("extra_links only apply to linked files, not %s", relPath)
+			}
+			for _, extraTarget := range extraTargets {
+				mapping.AddExtraLink(path, filepath.Join(module.TargetDir, extraTarget))
+			}
+		}
+
 		return nil
 	})
 
@@ -183,19 +439,100 @@ func buildModuleMapping(module config.ModuleConfig) (*FileMapping, error) {
 		return nil, fmt.Errorf("failed to walk module directory %s: %w", module.Dir, err)
 	}
 
+	// Every file of a single-file module maps to target_file, so there must be exactly one
+	if module.TargetFile != "" && len(mapping.sourceToTarget) != 1 {
+		sources := slices.Sorted(maps.Keys(mapping.sourceToTarget))
+		return nil, fmt.Errorf("target_file requires exactly one file in the module, found %d: %v", len(sources), sources)
+	}
+
 	return mapping, nil
 }
 
+// renderDirNames renders the directory components of a module-relative path that contain
+// template syntax, such as {{.PROFILE}}/config, with vars; the file name is kept as it is.
+// Each component must render to a single directory name, so the target stays in target_dir.
+func renderDirNames(relPath string, vars map[string]string) (string, error) {
+	dir, name := filepath.Split(relPath)
+	if !strings.Contains(dir, "{{") {
+		return relPath, nil
+	}
+
+	components := strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/")
+	for i, component := range components {
+		if !strings.Contains(component, "{{") {
+			continue
+		}
+		rendered, err := template.RenderString(component, component, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to render directory name of %s: %w", relPath, err)
+		}
+		if rendered == "" || rendered == "." || rendered == ".." || strings.ContainsAny(rendered, `/\`) {
+			return "", fmt.Errorf("directory name %s of %s renders to %q, which is not a single directory name", component, relPath, rendered)
+		}
+		components[i] = rendered
+	}
+	return filepath.Join(append(components, name)...), nil
+}
+
+// renamedTarget looks up the renamed target for a module-relative source path
+func renamedTarget(relPath string, rename map[string]string) (string, bool) {
+	for source, target := range rename {
+		// Compare with forward slashes so rename keys are portable across platforms
+		if filepath.ToSlash(filepath.Clean(source)) == filepath.ToSlash(relPath) {
+			return filepath.FromSlash(target), true
+		}
+	}
+	return "", false
+}
+
+// extraLinkTargets looks up the extra_links targets for a module-relative source path
+func extraLinkTargets(relPath string, extraLinks map[string][]string) []string {
+	var targets []string
+	for source, extra := range extraLinks {
+		if filepath.ToSlash(filepath.Clean(source)) == filepath.ToSlash(relPath) {
+			for _, target := range extra {
+				targets = append(targets, filepath.FromSlash(target))
+			}
+		}
+	}
+	return targets
+}
+
+// isReservedFile reports whether name is a dotman control file, either a configuration
+// file or one dotman writes itself, which is never mapped
+func isReservedFile(name string) bool {
+	return config.IsReservedFileName(name) || dotmanState.IsReservedFileName(name)
+}
+
 // isIgnored checks if a file should be ignored based on the ignore patterns
 func isIgnored(filename string, ignores []string) bool {
+	// Compare with forward slashes so patterns like "a/b" also match on Windows
+	filename = filepath.ToSlash(filename)
 	for _, pattern := range ignores {
-		if strings.Contains(filename, pattern) {
+		if strings.Contains(filename, filepath.ToSlash(pattern)) {
 			return true
 		}
 	}
 	return false
 }
 
+// varsFileSuffix marks a per-file vars file, e.g. init.lua.vars.yaml for init.lua.dot-tmpl
+const varsFileSuffix = ".vars.yaml"
+
+// varsFilePath returns the path of the per-file vars file of a template
+func varsFilePath(templatePath string) string {
+	return strings.TrimSuffix(templatePath, ".dot-tmpl") + varsFileSuffix
+}
+
+// isVarsFile checks if a file is the per-file vars file of a template next to it
+func isVarsFile(path string) bool {
+	if !strings.HasSuffix(path, varsFileSuffix) {
+		return false
+	}
+	_, err := os.Stat(strings.TrimSuffix(path, varsFileSuffix) + ".dot-tmpl")
+	return err == nil
+}
+
 // isTemplateFile checks if a file is a template file (.dot-tmpl extension)
 func isTemplateFile(filename string) bool {
 	return strings.HasSuffix(filename, ".dot-tmpl")
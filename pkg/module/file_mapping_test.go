@@ -3,6 +3,7 @@ package module
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
@@ -141,6 +142,23 @@ func TestFileMappingGetTargetConflicts(t *testing.T) {
 	assert.Contains(t, sources2, "/source4.txt")
 }
 
+func TestFileMappingGetSourceFanout(t *testing.T) {
+	fm := NewFileMapping()
+	fm.AddMapping("/source1.txt", "/target1.txt")
+	fm.AddMapping("/source2.txt", "/target2.txt")
+	assert.Empty(t, fm.GetSourceFanout())
+
+	fm.AddExtraLink("/source1.txt", "/target3.txt")
+	assert.Equal(t, map[string][]string{
+		"/source1.txt": {"/target1.txt", "/target3.txt"},
+	}, fm.GetSourceFanout())
+	assert.Empty(t, fm.GetTargetConflicts())
+
+	// An extra link onto another source's target conflicts
+	fm.AddExtraLink("/source1.txt", "/target2.txt")
+	assert.ElementsMatch(t, []string{"/source1.txt", "/source2.txt"}, fm.GetTargetConflicts()["/target2.txt"])
+}
+
 func TestBuildFileMapping(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -516,3 +534,580 @@ ignores:
 	_, exists = mapping.GetTarget(ignoreFileSource)
 	assert.False(t, exists, "ignore_dir/file.txt should not be mapped")
 }
+
+func TestBuildModuleMappingWithRename(t *testing.T) {
+	tempDir := t.TempDir()
+	moduleDir := filepath.Join(tempDir, "scripts")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "lib"), 0755))
+
+	for _, file := range []string{"git-sync.sh", "backup.sh", "greet.sh.dot-tmpl", "lib/util.sh"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte("#!/bin/sh\n"), 0755))
+	}
+
+	module := config.ModuleConfig{
+		Dir:       moduleDir,
+		TargetDir: "/home/user/bin",
+		Rename: map[string]string{
+			"git-sync.sh":       "git-sync",
+			"greet.sh.dot-tmpl": "greet",
+			"lib/util.sh":       "util",
+		},
+	}
+
+	mapping, err := buildModuleMapping(module)
+	require.NoError(t, err)
+
+	expectedTargets := map[string]string{
+		filepath.Join(moduleDir, "git-sync.sh"):       "/home/user/bin/git-sync",
+		filepath.Join(moduleDir, "backup.sh"):         "/home/user/bin/backup.sh",
+		filepath.Join(moduleDir, "greet.sh.dot-tmpl"): "/home/user/bin/greet",
+		filepath.Join(moduleDir, "lib", "util.sh"):    "/home/user/bin/util",
+	}
+	assert.Equal(t, expectedTargets, mapping.GetAllMappings())
+
+	// Renaming a template keeps it a template
+	assert.True(t, mapping.IsTemplate(filepath.Join(moduleDir, "greet.sh.dot-tmpl")))
+	assert.False(t, mapping.IsTemplate(filepath.Join(moduleDir, "git-sync.sh")))
+}
+
+func TestBuildModuleMappingTargetFile(t *testing.T) {
+	tempDir := t.TempDir()
+	moduleDir := filepath.Join(tempDir, "git")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "gitconfig"), []byte("[user]\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte("target_file: /home/user/.gitconfig\n"), 0644))
+
+	module := config.ModuleConfig{
+		Dir:        moduleDir,
+		TargetDir:  "/home/user",
+		TargetFile: "/home/user/.gitconfig",
+	}
+
+	// The one file maps to the exact target, whatever its name
+	mapping, err := buildModuleMapping(module)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{filepath.Join(moduleDir, "gitconfig"): "/home/user/.gitconfig"}, mapping.GetAllMappings())
+
+	// A second mappable file makes the target ambiguous
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "gitignore"), []byte("*.swp\n"), 0644))
+	_, err = buildModuleMapping(module)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "target_file requires exactly one file in the module, found 2")
+}
+
+func TestRenameTargetConflict(t *testing.T) {
+	tempDir := t.TempDir()
+	moduleDir := filepath.Join(tempDir, "scripts")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+
+	// git-sync.sh renamed to git-sync collides with the existing git-sync file
+	for _, file := range []string{"git-sync.sh", "git-sync"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte("#!/bin/sh\n"), 0755))
+	}
+
+	modules := []config.ModuleConfig{{
+		Dir:       moduleDir,
+		TargetDir: filepath.Join(tempDir, "bin"),
+		Rename:    map[string]string{"git-sync.sh": "git-sync"},
+	}}
+
+	mapping, err := BuildFileMapping(modules)
+	require.NoError(t, err)
+
+	conflicts := mapping.GetTargetConflicts()
+	require.Len(t, conflicts, 1)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(moduleDir, "git-sync.sh"),
+		filepath.Join(moduleDir, "git-sync"),
+	}, conflicts[filepath.Join(tempDir, "bin", "git-sync")])
+
+	result, err := Validate(modules, nil, true, false)
+	require.NoError(t, err)
+	assert.False(t, result.IsValid)
+	require.NotEmpty(t, result.Errors)
+	assert.Contains(t, result.Errors[0], "target conflict")
+}
+
+func TestBuildModuleMappingExcludesVCSMetadata(t *testing.T) {
+	// setup creates a submodule-style module with a .git file, a nested .git directory and a .gitignore
+	setup := func(t *testing.T) string {
+		moduleDir := filepath.Join(t.TempDir(), "nvim")
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "plugins", "vendored", ".git", "objects"), 0755))
+		files := map[string]string{
+			".git":                                "gitdir: ../.git/modules/nvim",
+			".gitignore":                          "*.log",
+			"init.lua":                            "-- nvim",
+			"plugins/vendored/plugin.lua":         "-- plugin",
+			"plugins/vendored/.git/HEAD":          "ref: refs/heads/main",
+			"plugins/vendored/.git/objects/pack":  "pack",
+			"plugins/vendored/.gitignore.example": "not metadata",
+		}
+		for file, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, filepath.FromSlash(file)), []byte(content), 0644))
+		}
+		return moduleDir
+	}
+
+	tests := []struct {
+		name        string
+		vcsExcludes []string
+		wantTargets []string
+	}{
+		{
+			name:        "default excludes skip .git files, directories and .gitignore",
+			vcsExcludes: nil,
+			wantTargets: []string{"init.lua", "plugins/vendored/.gitignore.example", "plugins/vendored/plugin.lua"},
+		},
+		{
+			name:        "empty excludes map everything",
+			vcsExcludes: []string{},
+			wantTargets: []string{
+				".git", ".gitignore", "init.lua",
+				"plugins/vendored/.git/HEAD", "plugins/vendored/.git/objects/pack",
+				"plugins/vendored/.gitignore.example", "plugins/vendored/plugin.lua",
+			},
+		},
+		{
+			name:        "custom excludes keep .gitignore",
+			vcsExcludes: []string{".git"},
+			wantTargets: []string{".gitignore", "init.lua", "plugins/vendored/.gitignore.example", "plugins/vendored/plugin.lua"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moduleDir := setup(t)
+			includeHidden := true
+			module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config/nvim", VCSExcludes: tt.vcsExcludes, IncludeHidden: &includeHidden}
+
+			mapping, err := buildModuleMapping(module)
+			require.NoError(t, err)
+
+			var targets []string
+			for _, target := range mapping.GetAllMappings() {
+				rel, err := filepath.Rel(module.TargetDir, target)
+				require.NoError(t, err)
+				targets = append(targets, filepath.ToSlash(rel))
+			}
+			assert.ElementsMatch(t, tt.wantTargets, targets)
+		})
+	}
+}
+
+func TestBuildModuleMappingIncludeHidden(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "shell")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "conf"), 0755))
+	for _, file := range []string{"bashrc", ".DS_Store", "conf/.hidden", "conf/aliases"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, filepath.FromSlash(file)), []byte(file), 0644))
+	}
+
+	enabled, disabled := true, false
+	tests := []struct {
+		name          string
+		includeHidden *bool
+		wantTargets   []string
+	}{
+		{
+			name:          "unset skips hidden source files",
+			includeHidden: nil,
+			wantTargets:   []string{".bashrc", "conf/aliases"},
+		},
+		{
+			name:          "false skips hidden source files",
+			includeHidden: &disabled,
+			wantTargets:   []string{".bashrc", "conf/aliases"},
+		},
+		{
+			name:          "true maps hidden source files",
+			includeHidden: &enabled,
+			wantTargets:   []string{".bashrc", ".DS_Store", "conf/.hidden", "conf/aliases"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := config.ModuleConfig{
+				Dir:           moduleDir,
+				TargetDir:     "/home/user",
+				Rename:        map[string]string{"bashrc": ".bashrc"},
+				IncludeHidden: tt.includeHidden,
+			}
+
+			mapping, err := buildModuleMapping(module)
+			require.NoError(t, err)
+
+			var targets []string
+			for _, target := range mapping.GetAllMappings() {
+				rel, err := filepath.Rel(module.TargetDir, target)
+				require.NoError(t, err)
+				targets = append(targets, filepath.ToSlash(rel))
+			}
+			assert.ElementsMatch(t, tt.wantTargets, targets)
+		})
+	}
+}
+
+func TestBuildModuleMappingLayout(t *testing.T) {
+	// setup creates a module with files in nested subdirectories, optionally colliding by name
+	setup := func(t *testing.T, files []string) string {
+		moduleDir := filepath.Join(t.TempDir(), "app")
+		for _, file := range files {
+			path := filepath.Join(moduleDir, filepath.FromSlash(file))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+		}
+		return moduleDir
+	}
+
+	tests := []struct {
+		name        string
+		layout      string
+		files       []string
+		rename      map[string]string
+		wantTargets map[string]string
+		errContains string
+	}{
+		{
+			name:   "default layout mirrors subdirectories",
+			layout: "",
+			files:  []string{"app/config.toml", "app/themes/dark.toml.dot-tmpl", "apprc"},
+			wantTargets: map[string]string{
+				"app/config.toml":               "app/config.toml",
+				"app/themes/dark.toml.dot-tmpl": "app/themes/dark.toml",
+				"apprc":                         "apprc",
+			},
+		},
+		{
+			name:   "mirror layout mirrors subdirectories",
+			layout: config.LayoutMirror,
+			files:  []string{"app/config.toml", "apprc"},
+			wantTargets: map[string]string{
+				"app/config.toml": "app/config.toml",
+				"apprc":           "apprc",
+			},
+		},
+		{
+			name:   "flatten layout drops subdirectories",
+			layout: config.LayoutFlatten,
+			files:  []string{"app/config.toml", "app/themes/dark.toml.dot-tmpl", "apprc"},
+			wantTargets: map[string]string{
+				"app/config.toml":               "config.toml",
+				"app/themes/dark.toml.dot-tmpl": "dark.toml",
+				"apprc":                         "apprc",
+			},
+		},
+		{
+			name:   "flatten layout keeps explicit renames",
+			layout: config.LayoutFlatten,
+			files:  []string{"app/config.toml", "other/config.toml"},
+			rename: map[string]string{"other/config.toml": "other/config.toml"},
+			wantTargets: map[string]string{
+				"app/config.toml":   "config.toml",
+				"other/config.toml": "other/config.toml",
+			},
+		},
+		{
+			name:        "flatten layout rejects basename collisions",
+			layout:      config.LayoutFlatten,
+			files:       []string{"app/config.toml", "other/config.toml"},
+			errContains: "flatten layout maps both",
+		},
+		{
+			name:        "flatten layout rejects collisions after removing the template suffix",
+			layout:      config.LayoutFlatten,
+			files:       []string{"a/config.toml", "b/config.toml.dot-tmpl"},
+			errContains: "config.toml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moduleDir := setup(t, tt.files)
+			module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config", Layout: tt.layout, Rename: tt.rename}
+
+			mapping, err := buildModuleMapping(module)
+			if tt.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+
+			expected := make(map[string]string)
+			for source, target := range tt.wantTargets {
+				expected[filepath.Join(moduleDir, filepath.FromSlash(source))] = filepath.Join("/home/user/.config", filepath.FromSlash(target))
+			}
+			assert.Equal(t, expected, mapping.GetAllMappings())
+		})
+	}
+}
+
+func TestBuildModuleMappingSkipLink(t *testing.T) {
+	// setup creates two modules sharing a target directory, each with its own README.md and LICENSE
+	setup := func(t *testing.T) (string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+		var modules []config.ModuleConfig
+		for _, name := range []string{"zsh", "git"} {
+			moduleDir := filepath.Join(tempDir, "dotfiles", name)
+			require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "docs"), 0755))
+			for _, file := range []string{"README.md", "LICENSE", "docs/usage.txt", name + "rc"} {
+				require.NoError(t, os.WriteFile(filepath.Join(moduleDir, filepath.FromSlash(file)), []byte(file), 0644))
+			}
+			modules = append(modules, config.ModuleConfig{
+				Dir:       moduleDir,
+				TargetDir: targetDir,
+				SkipLink:  []string{"README.md", "LICENSE", "docs/*"},
+			})
+		}
+		return targetDir, modules
+	}
+
+	t.Run("matching files are recorded as unlinked instead of mapped", func(t *testing.T) {
+		targetDir, modules := setup(t)
+
+		mapping, err := buildModuleMapping(modules[0])
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			filepath.Join(modules[0].Dir, "zshrc"): filepath.Join(targetDir, "zshrc"),
+		}, mapping.GetAllMappings())
+		assert.Equal(t, []string{
+			filepath.Join(modules[0].Dir, "LICENSE"),
+			filepath.Join(modules[0].Dir, "README.md"),
+			filepath.Join(modules[0].Dir, "docs", "usage.txt"),
+		}, mapping.GetUnlinked())
+	})
+
+	t.Run("unlinked files are not flagged as conflicts", func(t *testing.T) {
+		targetDir, modules := setup(t)
+
+		result, err := Validate(modules, map[string]string{}, false, false)
+		require.NoError(t, err)
+		assert.True(t, result.IsValid, result.Errors)
+		assert.Empty(t, result.Errors)
+		assert.Empty(t, result.Warnings)
+		assert.Len(t, result.CreateOperations, 2)
+		assert.Len(t, result.UnlinkedFiles, 6)
+
+		installResult, err := Install(modules, map[string]string{}, false, false, filepath.Dir(modules[0].Dir))
+		require.NoError(t, err)
+		require.True(t, installResult.IsSuccess, installResult.Errors)
+		assert.NoFileExists(t, filepath.Join(targetDir, "README.md"))
+		assert.NoFileExists(t, filepath.Join(targetDir, "LICENSE"))
+		assert.NoDirExists(t, filepath.Join(targetDir, "docs"))
+	})
+
+	t.Run("without skip_link the shared files conflict", func(t *testing.T) {
+		_, modules := setup(t)
+		for i := range modules {
+			modules[i].SkipLink = nil
+		}
+
+		result, err := Validate(modules, map[string]string{}, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		assert.Len(t, result.Errors, 3)
+	})
+}
+
+func TestBuildModuleMappingConditions(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "gnupg")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	for _, file := range []string{"gpg-agent.conf", "gpg.conf", "profile"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte(file), 0644))
+	}
+	conditions := map[string]string{
+		"gpg*.conf": "{{.USE_GPG}}",
+		"profile":   `{{eq .SHELL_NAME "zsh"}}`,
+	}
+
+	tests := []struct {
+		name        string
+		rootVars    map[string]string
+		moduleVars  map[string]string
+		wantTargets []string
+	}{
+		{
+			name:        "true conditions include files",
+			rootVars:    map[string]string{"USE_GPG": "true", "SHELL_NAME": "zsh"},
+			wantTargets: []string{"gpg-agent.conf", "gpg.conf", "profile"},
+		},
+		{
+			name:        "falsey conditions skip files",
+			rootVars:    map[string]string{"USE_GPG": "false", "SHELL_NAME": "bash"},
+			wantTargets: nil,
+		},
+		{
+			name:        "module vars override root vars",
+			rootVars:    map[string]string{"USE_GPG": "true", "SHELL_NAME": "zsh"},
+			moduleVars:  map[string]string{"USE_GPG": "0"},
+			wantTargets: []string{"profile"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.gnupg", Conditions: conditions, Vars: tt.moduleVars, RootVars: tt.rootVars}
+
+			mapping, err := buildModuleMapping(module)
+			require.NoError(t, err)
+
+			var targets []string
+			for _, target := range mapping.GetAllMappings() {
+				targets = append(targets, filepath.Base(target))
+			}
+			assert.ElementsMatch(t, tt.wantTargets, targets)
+		})
+	}
+
+	t.Run("undefined variable is an error", func(t *testing.T) {
+		module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.gnupg", Conditions: conditions}
+
+		_, err := buildModuleMapping(module)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to evaluate condition")
+	})
+}
+
+func TestBuildModuleMappingSkipsJSONDotfile(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "nvim")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile.json"), []byte(`{"target_dir": "/home/user/.config/nvim"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "init.lua"), []byte("-- init"), 0644))
+
+	moduleConfig, err := config.LoadConfig(moduleDir)
+	require.NoError(t, err)
+	mapping, err := buildModuleMapping(*moduleConfig)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		filepath.Join(moduleDir, "init.lua"): "/home/user/.config/nvim/init.lua",
+	}, mapping.GetAllMappings())
+}
+
+func TestBuildModuleMappingSkipsReservedFiles(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "conf"), 0755))
+	reserved := append(slices.Clone(config.ReservedFileNames),
+		"state.yaml", "state.work.yaml", "state.log", "state.work.log", ".dotman-cache.yaml")
+	for _, name := range reserved {
+		for _, dir := range []string{moduleDir, filepath.Join(moduleDir, "conf")} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+		}
+	}
+	for _, file := range []string{"app.conf", "conf/state.yaml.example", "conf/DotRoot.md"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, filepath.FromSlash(file)), []byte(file), 0644))
+	}
+
+	includeHidden := true
+	module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config/app", IncludeHidden: &includeHidden}
+	mapping, err := buildModuleMapping(module)
+	require.NoError(t, err)
+
+	var targets []string
+	for _, target := range mapping.GetAllMappings() {
+		rel, err := filepath.Rel(module.TargetDir, target)
+		require.NoError(t, err)
+		targets = append(targets, filepath.ToSlash(rel))
+	}
+	assert.ElementsMatch(t, []string{"app.conf", "conf/state.yaml.example", "conf/DotRoot.md"}, targets)
+}
+
+func TestBuildModuleMappingMaxDepth(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "nvim")
+	deep := filepath.Join(moduleDir, "a", "b", "c")
+	require.NoError(t, os.MkdirAll(deep, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "init.lua"), []byte("-- init"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(deep, "deep.lua"), []byte("-- deep"), 0644))
+
+	tests := []struct {
+		name        string
+		maxDepth    int
+		ignores     []string
+		wantErr     string
+		wantTargets int
+	}{
+		{name: "no limit", maxDepth: 0, wantTargets: 2},
+		{name: "limit at the deepest directory", maxDepth: 3, wantTargets: 2},
+		{name: "deeper tree than the limit", maxDepth: 2, wantErr: "a/b/c is 3 directories deep, more than max_depth 2"},
+		{name: "ignored directories are not walked", maxDepth: 1, ignores: []string{"a/b"}, wantTargets: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config/nvim", MaxDepth: tt.maxDepth, Ignores: tt.ignores}
+
+			mapping, err := buildModuleMapping(module)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), filepath.FromSlash(tt.wantErr))
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, mapping.GetAllMappings(), tt.wantTargets)
+		})
+	}
+}
+
+func TestBuildModuleMappingTemplatedDirNames(t *testing.T) {
+	moduleDir := filepath.Join(t.TempDir(), "shell")
+	for _, file := range []string{
+		"{{.PROFILE}}/config",
+		"{{.PROFILE}}/{{.HOST}}-conf.d/aliases",
+		"{{.PROFILE}}/env.dot-tmpl",
+		"{{.PROFILE}}/cache/.keep",
+		"plain/{{.NOT_A_DIR}}",
+	} {
+		path := filepath.Join(moduleDir, filepath.FromSlash(file))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(file), 0644))
+	}
+
+	module := config.ModuleConfig{
+		Dir:       moduleDir,
+		TargetDir: "/home/user/.config/shell",
+		Vars:      map[string]string{"PROFILE": "work"},
+		RootVars:  map[string]string{"PROFILE": "home", "HOST": "laptop"},
+	}
+	mapping, err := buildModuleMapping(module)
+	require.NoError(t, err)
+
+	source := func(rel string) string { return filepath.Join(moduleDir, filepath.FromSlash(rel)) }
+	assert.Equal(t, map[string]string{
+		source("{{.PROFILE}}/config"):                   "/home/user/.config/shell/work/config",
+		source("{{.PROFILE}}/{{.HOST}}-conf.d/aliases"): "/home/user/.config/shell/work/laptop-conf.d/aliases",
+		source("{{.PROFILE}}/env.dot-tmpl"):             "/home/user/.config/shell/work/env",
+		source("plain/{{.NOT_A_DIR}}"):                  "/home/user/.config/shell/plain/{{.NOT_A_DIR}}",
+	}, mapping.GetAllMappings())
+	assert.Equal(t, map[string]string{
+		source("{{.PROFILE}}/cache/.keep"): "/home/user/.config/shell/work/cache",
+	}, mapping.GetKeepDirs())
+
+	tests := []struct {
+		name    string
+		profile string
+		wantErr string
+	}{
+		{name: "parent directory", profile: "..", wantErr: `renders to ".."`},
+		{name: "nested path", profile: "a/b", wantErr: `renders to "a/b"`},
+		{name: "empty name", profile: "", wantErr: `renders to ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config/shell", RootVars: map[string]string{"PROFILE": tt.profile, "HOST": "laptop"}}
+
+			_, err := buildModuleMapping(module)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	t.Run("undefined variable is an error", func(t *testing.T) {
+		module := config.ModuleConfig{Dir: moduleDir, TargetDir: "/home/user/.config/shell"}
+
+		_, err := buildModuleMapping(module)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to render directory name")
+	})
+}
@@ -1,36 +1,94 @@
 package filesystem
 
 import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultMaxBackups is how many backups are kept per target before the oldest is rotated out
+const DefaultMaxBackups = 100
+
+// DefaultBackupSuffix is appended to a target to name its backups
+const DefaultBackupSuffix = ".bak"
+
+// compressedSuffix is appended to the backup name of gzip-compressed backups
+const compressedSuffix = ".gz"
+
 // BackupManager handles backup operations
 type BackupManager struct {
 	fileOp FileOperator
+	// maxBackups is the number of backup names (.bak, .bak.1, ...) used per target
+	maxBackups int
+	// suffix names the backups of a target, e.g. .bak for target.bak, target.bak.1, ...
+	suffix string
+	// compress writes backups of regular files gzip-compressed
+	compress bool
+	// timestamped names backups after the time they were made instead of numbering them
+	timestamped bool
+	// now returns the time a timestamped backup is named after; replaceable in tests
+	now func() time.Time
 }
 
-// NewBackupManager creates a new BackupManager
+// BackupOptions configures a BackupManager
+type BackupOptions struct {
+	// MaxBackups is the number of backups kept per target; non-positive uses DefaultMaxBackups
+	MaxBackups int
+	// Compress writes backups of regular files gzip-compressed as .bak.gz (.bak.N.gz);
+	// symlinks and directories are always backed up as is
+	Compress bool
+	// Timestamped names backups target.YYYYMMDD-HHMMSS.bak (UTC), so they sort by the time
+	// they were made; once MaxBackups are kept, the oldest is removed instead of rotating
+	// every name. Numbered backups of earlier runs are still listed and restored.
+	Timestamped bool
+	// Suffix replaces .bak in every backup name, e.g. .dotman.bak to keep clear of the
+	// backups of other tools; empty uses DefaultBackupSuffix
+	Suffix string
+}
+
+// NewBackupManager creates a new BackupManager keeping up to DefaultMaxBackups backups per target
 func NewBackupManager(fileOp FileOperator) *BackupManager {
-	return &BackupManager{fileOp: fileOp}
+	return NewBackupManagerWithLimit(fileOp, DefaultMaxBackups)
+}
+
+// NewBackupManagerWithLimit creates a new BackupManager keeping up to maxBackups backups per
+// target; a non-positive limit uses DefaultMaxBackups
+func NewBackupManagerWithLimit(fileOp FileOperator, maxBackups int) *BackupManager {
+	return NewBackupManagerWithOptions(fileOp, BackupOptions{MaxBackups: maxBackups})
 }
 
-// CreateBackup creates a backup of a file with .bak extension
+// NewBackupManagerWithOptions creates a new BackupManager configured by options
+func NewBackupManagerWithOptions(fileOp FileOperator, options BackupOptions) *BackupManager {
+	maxBackups := options.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	suffix := options.Suffix
+	if suffix == "" {
+		suffix = DefaultBackupSuffix
+	}
+	return &BackupManager{fileOp: fileOp, maxBackups: maxBackups, suffix: suffix, compress: options.Compress, timestamped: options.Timestamped, now: time.Now}
+}
+
+// CreateBackup creates a backup of a file with the backup suffix, followed by .gz when compressing
 func (bm *BackupManager) CreateBackup(target string) (string, error) {
-	backupPath := target + ".bak"
+	backupPath, err := bm.nextBackupPath(target)
+	if err != nil {
+		return "", err
+	}
 
-	// Check if backup already exists and find a unique name if needed
-	counter := 1
-	for {
-		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-			break // File doesn't exist, we can use this name
-		}
-		backupPath = fmt.Sprintf("%s.bak.%d", target, counter)
-		counter++
-		if counter > 100 { // Prevent infinite loop
-			return "", fmt.Errorf("too many backup files exist")
-		}
+	if bm.compressible(target) {
+		return bm.compressBackup(target, backupPath)
 	}
 
 	// Copy the file
@@ -60,7 +118,7 @@ func (bm *BackupManager) BackupAndReplace(target string, replaceFunc func() erro
 	// Perform the replacement
 	if err := replaceFunc(); err != nil {
 		// If replacement fails, try to restore from backup
-		if restoreErr := os.Rename(backupPath, target); restoreErr != nil {
+		if restoreErr := bm.RestoreBackup(backupPath, target); restoreErr != nil {
 			return "", fmt.Errorf("replacement failed (%v) and restore from backup failed (%v)", err, restoreErr)
 		}
 		return "", fmt.Errorf("replacement failed, restored from backup: %w", err)
@@ -71,19 +129,22 @@ func (bm *BackupManager) BackupAndReplace(target string, replaceFunc func() erro
 
 // createBackupByMove creates a backup by moving the existing file (original behavior)
 func (bm *BackupManager) createBackupByMoving(target string) (string, error) {
-	backupPath := target + ".bak"
+	backupPath, err := bm.nextBackupPath(target)
+	if err != nil {
+		return "", err
+	}
 
-	// Check if backup already exists and find a unique name if needed
-	counter := 1
-	for {
-		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-			break // File doesn't exist, we can use this name
+	// A compressed backup replaces the move, so remove the original afterwards
+	if bm.compressible(target) {
+		compressedPath, err := bm.compressBackup(target, backupPath)
+		if err != nil {
+			return "", err
 		}
-		backupPath = fmt.Sprintf("%s.bak.%d", target, counter)
-		counter++
-		if counter > 100 { // Prevent infinite loop
-			return "", fmt.Errorf("too many backup files exist")
+		if err := bm.fileOp.RemoveFile(target); err != nil {
+			bm.fileOp.RemoveFile(compressedPath)
+			return "", fmt.Errorf("failed to remove file after backup: %w", err)
 		}
+		return compressedPath, nil
 	}
 
 	// Move the file to backup location
@@ -94,7 +155,414 @@ func (bm *BackupManager) createBackupByMoving(target string) (string, error) {
 	return backupPath, nil
 }
 
-// ListBackups finds all backup files for a given target
+// BackupAndReplaceAtomic backs up an existing target and replaces it without a window where
+// the target is missing: createFunc builds the replacement at a temporary path in the same
+// directory, which is then renamed over the target. Directories cannot be replaced by a rename,
+// so they fall back to BackupAndReplace.
+func (bm *BackupManager) BackupAndReplaceAtomic(target string, createFunc func(path string) error) (string, error) {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		// No existing file, create the replacement in place
+		if err := createFunc(target); err != nil {
+			return "", fmt.Errorf("failed to replace file: %w", err)
+		}
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to stat target: %w", err)
+	}
+
+	if info.IsDir() {
+		return bm.BackupAndReplace(target, func() error {
+			return createFunc(target)
+		})
+	}
+
+	// Back up by copying so the target stays in place until the rename
+	backupPath, err := bm.createBackupByCopying(target, info)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	tempPath := tempPathFor(target)
+	if err := createFunc(tempPath); err != nil {
+		bm.fileOp.RemoveFile(tempPath)
+		bm.fileOp.RemoveFile(backupPath)
+		return "", fmt.Errorf("replacement failed: %w", err)
+	}
+
+	if err := bm.fileOp.Rename(tempPath, target); err != nil {
+		bm.fileOp.RemoveFile(tempPath)
+		bm.fileOp.RemoveFile(backupPath)
+		return "", fmt.Errorf("failed to move replacement into place: %w", err)
+	}
+
+	return backupPath, nil
+}
+
+// createBackupByCopying creates a backup while leaving the target in place; symlinks are
+// backed up as symlinks with the same destination
+func (bm *BackupManager) createBackupByCopying(target string, info os.FileInfo) (string, error) {
+	backupPath, err := bm.nextBackupPath(target)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		dest, err := bm.fileOp.Readlink(target)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink: %w", err)
+		}
+		if err := bm.fileOp.CreateSymlink(dest, backupPath); err != nil {
+			return "", fmt.Errorf("failed to copy symlink to backup: %w", err)
+		}
+		return backupPath, nil
+	}
+
+	if bm.compress && info.Mode().IsRegular() {
+		return bm.compressBackup(target, backupPath)
+	}
+
+	if err := bm.fileOp.CopyFile(target, backupPath); err != nil {
+		return "", fmt.Errorf("failed to copy file to backup: %w", err)
+	}
+
+	return backupPath, nil
+}
+
+// nextBackupPath returns the first unused uncompressed backup name for target, rotating out
+// the oldest backup when all maxBackups names are taken; a name is taken by either its
+// uncompressed or its compressed backup
+func (bm *BackupManager) nextBackupPath(target string) (string, error) {
+	if bm.timestamped {
+		return bm.nextTimestampedPath(target)
+	}
+
+	for index := 0; index < bm.maxBackups; index++ {
+		if _, ok := bm.existingBackup(target, index); !ok {
+			return bm.backupName(target, index), nil
+		}
+	}
+
+	return bm.rotateBackups(target)
+}
+
+// rotateBackups removes the oldest backup (.bak) and shifts every other backup down one
+// name, so .bak.1 becomes .bak (and .bak.1.gz becomes .bak.gz), and returns the freed newest name
+func (bm *BackupManager) rotateBackups(target string) (string, error) {
+	// Backups of directories are directories, so remove recursively
+	for _, oldest := range []string{bm.backupName(target, 0), bm.backupName(target, 0) + compressedSuffix} {
+		if err := os.RemoveAll(oldest); err != nil {
+			return "", fmt.Errorf("failed to remove oldest backup: %w", err)
+		}
+	}
+
+	for index := 1; index < bm.maxBackups; index++ {
+		backupPath, ok := bm.existingBackup(target, index)
+		if !ok {
+			continue
+		}
+		newPath := bm.backupName(target, index-1)
+		if isCompressedBackup(backupPath) {
+			newPath += compressedSuffix
+		}
+		if err := bm.fileOp.Rename(backupPath, newPath); err != nil {
+			return "", fmt.Errorf("failed to rotate backups: %w", err)
+		}
+	}
+
+	return bm.backupName(target, bm.maxBackups-1), nil
+}
+
+// nextTimestampedPath returns an unused backup name for target made now, first removing the
+// oldest timestamped backups so no more than maxBackups remain with the new one. Backups made
+// within the same second get a sequence number, target.YYYYMMDD-HHMMSS.bak.N.
+func (bm *BackupManager) nextTimestampedPath(target string) (string, error) {
+	backups, err := bm.ListBackups(target)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(target)
+	var timestamped []string
+	for _, backupPath := range backups {
+		if _, _, ok := bm.backupTime(base, filepath.Base(backupPath)); ok {
+			timestamped = append(timestamped, backupPath)
+		}
+	}
+	// ListBackups orders timestamped backups oldest first; backups of directories are directories
+	for len(timestamped) >= bm.maxBackups {
+		if err := os.RemoveAll(timestamped[0]); err != nil {
+			return "", fmt.Errorf("failed to remove oldest backup: %w", err)
+		}
+		timestamped = timestamped[1:]
+	}
+
+	made := bm.now()
+	for seq := 0; ; seq++ {
+		backupPath := bm.timestampedBackupName(target, made, seq)
+		if !backupExists(backupPath) {
+			return backupPath, nil
+		}
+	}
+}
+
+// backupExists reports whether the uncompressed backupPath or its compressed form exists
+func backupExists(backupPath string) bool {
+	for _, path := range []string{backupPath, backupPath + compressedSuffix} {
+		if _, err := os.Lstat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// existingBackup returns the backup of target at index, uncompressed or compressed, if it exists
+func (bm *BackupManager) existingBackup(target string, index int) (string, bool) {
+	backupPath := bm.backupName(target, index)
+	for _, path := range []string{backupPath, backupPath + compressedSuffix} {
+		if _, err := os.Lstat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// compressible reports whether a backup of target is written compressed
+func (bm *BackupManager) compressible(target string) bool {
+	if !bm.compress {
+		return false
+	}
+	info, err := os.Lstat(target)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// compressBackup writes a gzip-compressed copy of target next to the uncompressed backupPath
+// and returns the compressed backup path
+func (bm *BackupManager) compressBackup(target, backupPath string) (string, error) {
+	compressedPath := backupPath + compressedSuffix
+	if err := gzipFile(target, compressedPath); err != nil {
+		os.Remove(compressedPath)
+		return "", fmt.Errorf("failed to compress file to backup: %w", err)
+	}
+	return compressedPath, nil
+}
+
+// RestoreBackup moves backupPath back to target, decompressing compressed backups, and
+// replaces target if it exists as a file or symlink
+func (bm *BackupManager) RestoreBackup(backupPath, target string) error {
+	if !isCompressedBackup(backupPath) {
+		return bm.fileOp.Rename(backupPath, target)
+	}
+
+	tempPath := tempPathFor(target)
+	if err := gunzipFile(backupPath, tempPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to decompress backup %s: %w", backupPath, err)
+	}
+	if err := bm.fileOp.Rename(tempPath, target); err != nil {
+		bm.fileOp.RemoveFile(tempPath)
+		return fmt.Errorf("failed to move restored backup into place: %w", err)
+	}
+	return bm.fileOp.RemoveFile(backupPath)
+}
+
+// PlannedRestore is a target and the backup RestoreBackups restores it from
+type PlannedRestore struct {
+	Target string
+	Backup string
+}
+
+// RestoreBackups restores every target from its newest backup, leaving targets without a
+// backup alone, and returns the restores in the order of targets. With dryRun nothing is
+// moved and the returned restores are the ones that would be performed.
+func (bm *BackupManager) RestoreBackups(targets []string, dryRun bool) ([]PlannedRestore, error) {
+	var plan []PlannedRestore
+	for _, target := range targets {
+		backupPath, ok, err := bm.NewestBackup(target)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			plan = append(plan, PlannedRestore{Target: target, Backup: backupPath})
+		}
+	}
+	if dryRun {
+		return plan, nil
+	}
+
+	for i, restore := range plan {
+		if err := bm.RestoreBackup(restore.Backup, restore.Target); err != nil {
+			return plan[:i], fmt.Errorf("failed to restore %s from %s: %w", restore.Target, restore.Backup, err)
+		}
+	}
+	return plan, nil
+}
+
+// NewestBackup returns the most recent backup of target; ok is false when it has none.
+// Rotation keeps the oldest numbered backup at .bak, so the newest has the highest index, and
+// timestamped backups are named after the time they were made. When target has both, the
+// modification time of the newest numbered backup tells which is more recent.
+func (bm *BackupManager) NewestBackup(target string) (string, bool, error) {
+	backups, err := bm.ListBackups(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	base := filepath.Base(target)
+	newest, newestIndex := "", -1
+	newestStamped, newestTime := "", time.Time{}
+	for _, backupPath := range backups {
+		if index, ok := bm.backupIndex(base, filepath.Base(backupPath)); ok && index > newestIndex {
+			newest, newestIndex = backupPath, index
+		}
+		// Timestamped backups are listed oldest first
+		if made, _, ok := bm.backupTime(base, filepath.Base(backupPath)); ok {
+			newestStamped, newestTime = backupPath, made
+		}
+	}
+
+	switch {
+	case newestStamped == "":
+		return newest, newestIndex >= 0, nil
+	case newestIndex < 0:
+		return newestStamped, true, nil
+	}
+	if info, err := os.Lstat(newest); err == nil && info.ModTime().After(newestTime) {
+		return newest, true, nil
+	}
+	return newestStamped, true, nil
+}
+
+// MatchesBackup reports whether the content of the file at target equals that of one of its
+// regular file backups, compressed or not
+func (bm *BackupManager) MatchesBackup(target string) (bool, error) {
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return false, err
+	}
+	backups, err := bm.ListBackups(target)
+	if err != nil {
+		return false, err
+	}
+
+	for _, backupPath := range backups {
+		// Backups of symlinks are links themselves, holding no content of their own
+		if info, err := os.Lstat(backupPath); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		backupContent, err := readBackup(backupPath)
+		if err != nil {
+			return false, fmt.Errorf("failed to read backup %s: %w", backupPath, err)
+		}
+		if bytes.Equal(content, backupContent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readBackup returns the content of a backup, decompressing compressed backups
+func readBackup(backupPath string) ([]byte, error) {
+	if !isCompressedBackup(backupPath) {
+		return os.ReadFile(backupPath)
+	}
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// isCompressedBackup reports whether backupPath names a gzip-compressed backup; uncompressed
+// backups always end in the backup suffix or the suffix and .N
+func isCompressedBackup(backupPath string) bool {
+	return strings.HasSuffix(backupPath, compressedSuffix)
+}
+
+// gzipFile writes a gzip-compressed copy of src to dst with the permissions of src
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gunzipFile writes the decompressed content of src to dst with the permissions of src
+func gunzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// backupName returns the backup path for target at index: .bak for 0, .bak.N otherwise, with
+// .bak standing for the backup suffix
+func (bm *BackupManager) backupName(target string, index int) string {
+	if index == 0 {
+		return target + bm.suffix
+	}
+	return fmt.Sprintf("%s%s.%d", target, bm.suffix, index)
+}
+
+// tempPathFor returns a temporary sibling path of target, so a rename onto target stays on one filesystem
+func tempPathFor(target string) string {
+	return filepath.Join(filepath.Dir(target), fmt.Sprintf(".%s.dotman-%d-%d", filepath.Base(target), os.Getpid(), time.Now().UnixNano()))
+}
+
+// ListBackups finds all backup files for a given target: numbered backups first, in name
+// order, then timestamped backups from oldest to newest
 func (bm *BackupManager) ListBackups(target string) ([]string, error) {
 	dir := filepath.Dir(target)
 	base := filepath.Base(target)
@@ -113,10 +581,93 @@ func (bm *BackupManager) ListBackups(target string) ([]string, error) {
 
 		name := entry.Name()
 		// Check if it's a backup of the target file
-		if name == base+".bak" || (len(name) > len(base)+5 && name[:len(base)+5] == base+".bak.") {
+		if bm.isBackupName(base, name) {
 			backups = append(backups, filepath.Join(dir, name))
 		}
 	}
 
+	slices.SortStableFunc(backups, func(a, b string) int {
+		timeA, seqA, stampedA := bm.backupTime(base, filepath.Base(a))
+		timeB, seqB, stampedB := bm.backupTime(base, filepath.Base(b))
+		switch {
+		case !stampedA && !stampedB:
+			return 0
+		case !stampedA:
+			return -1
+		case !stampedB:
+			return 1
+		}
+		if order := timeA.Compare(timeB); order != 0 {
+			return order
+		}
+		return cmp.Compare(seqA, seqB)
+	})
+
 	return backups, nil
 }
+
+// isBackupName reports whether name is a backup of base: base.bak, base.bak.N or a timestamped
+// backup, optionally followed by the compressed suffix
+func (bm *BackupManager) isBackupName(base, name string) bool {
+	if _, ok := bm.backupIndex(base, name); ok {
+		return true
+	}
+	_, _, ok := bm.backupTime(base, name)
+	return ok
+}
+
+// backupTimeLayout formats the time in timestamped backup names
+const backupTimeLayout = "20060102-150405"
+
+// timestampedBackupName returns the backup path of target made at made, in UTC so names sort
+// chronologically; seq tells apart backups made within the same second
+func (bm *BackupManager) timestampedBackupName(target string, made time.Time, seq int) string {
+	backupPath := fmt.Sprintf("%s.%s%s", target, made.UTC().Format(backupTimeLayout), bm.suffix)
+	if seq > 0 {
+		backupPath += fmt.Sprintf(".%d", seq)
+	}
+	return backupPath
+}
+
+// backupTime returns the time and sequence number of the timestamped backup of base named
+// name, compressed or not; ok is false when name is no timestamped backup of base
+func (bm *BackupManager) backupTime(base, name string) (made time.Time, seq int, ok bool) {
+	name = strings.TrimSuffix(name, compressedSuffix)
+	rest, ok := strings.CutPrefix(name, base+".")
+	if !ok || len(rest) < len(backupTimeLayout) {
+		return time.Time{}, 0, false
+	}
+	made, err := time.Parse(backupTimeLayout, rest[:len(backupTimeLayout)])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	suffix := rest[len(backupTimeLayout):]
+	if suffix == bm.suffix {
+		return made, 0, true
+	}
+	seqText, ok := strings.CutPrefix(suffix, bm.suffix+".")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	seq, err = strconv.Atoi(seqText)
+	if err != nil || seq < 1 {
+		return time.Time{}, 0, false
+	}
+	return made, seq, true
+}
+
+// backupIndex returns the index of the backup of base named name: 0 for base.bak and N for
+// base.bak.N, compressed or not; ok is false when name is no backup of base
+func (bm *BackupManager) backupIndex(base, name string) (int, bool) {
+	name = strings.TrimSuffix(name, compressedSuffix)
+	if name == base+bm.suffix {
+		return 0, true
+	}
+	suffix, ok := strings.CutPrefix(name, base+bm.suffix+".")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	return index, err == nil
+}
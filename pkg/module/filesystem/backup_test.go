@@ -1,9 +1,12 @@
 package filesystem
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -168,6 +171,75 @@ func TestBackupManager_BackupAndReplace(t *testing.T) {
 	})
 }
 
+func TestBackupManager_Rotation(t *testing.T) {
+	readBackup := func(t *testing.T, path string) string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	t.Run("rotates past a configured limit", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "test.txt")
+		backupMgr := NewBackupManagerWithLimit(NewOperator(), 3)
+
+		for version := 1; version <= 5; version++ {
+			require.NoError(t, os.WriteFile(targetFile, []byte(fmt.Sprintf("v%d", version)), 0644))
+			_, err := backupMgr.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Len(t, backups, 3)
+
+		// The two oldest backups were rotated out, newest is in the highest slot
+		assert.Equal(t, "v3", readBackup(t, targetFile+".bak"))
+		assert.Equal(t, "v4", readBackup(t, targetFile+".bak.1"))
+		assert.Equal(t, "v5", readBackup(t, targetFile+".bak.2"))
+	})
+
+	t.Run("default limit rotates instead of failing", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "test.txt")
+		require.NoError(t, os.WriteFile(targetFile, []byte("latest"), 0644))
+
+		// Fill every backup slot
+		require.NoError(t, os.WriteFile(targetFile+".bak", []byte("oldest"), 0644))
+		for index := 1; index < DefaultMaxBackups; index++ {
+			require.NoError(t, os.WriteFile(fmt.Sprintf("%s.bak.%d", targetFile, index), []byte(fmt.Sprintf("backup %d", index)), 0644))
+		}
+
+		backupMgr := NewBackupManager(NewOperator())
+		backupPath, err := backupMgr.CreateBackup(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%s.bak.%d", targetFile, DefaultMaxBackups-1), backupPath)
+		assert.Equal(t, "latest", readBackup(t, backupPath))
+		assert.Equal(t, "backup 1", readBackup(t, targetFile+".bak"))
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Len(t, backups, DefaultMaxBackups)
+	})
+
+	t.Run("atomic replace rotates too", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "test.txt")
+		backupMgr := NewBackupManagerWithLimit(NewOperator(), 1)
+
+		for version := 1; version <= 3; version++ {
+			require.NoError(t, os.WriteFile(targetFile, []byte(fmt.Sprintf("v%d", version)), 0644))
+			_, err := backupMgr.BackupAndReplaceAtomic(targetFile, func(path string) error {
+				return os.WriteFile(path, []byte("replacement"), 0644)
+			})
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{targetFile + ".bak"}, backups)
+		assert.Equal(t, "v3", readBackup(t, targetFile+".bak"))
+	})
+}
+
 func TestBackupManager_ListBackups(t *testing.T) {
 	tempDir := t.TempDir()
 	fileOp := NewOperator()
@@ -232,3 +304,454 @@ func TestBackupManager_ListBackups(t *testing.T) {
 		assert.NotContains(t, backups, unrelatedFile)
 	})
 }
+
+// recordingOperator records file operations and checks after each one that target still exists
+type recordingOperator struct {
+	FileOperator
+	t      *testing.T
+	target string
+	calls  []string
+}
+
+func (r *recordingOperator) record(call string) {
+	r.calls = append(r.calls, call)
+	_, err := os.Lstat(r.target)
+	assert.NoError(r.t, err, "target missing after %s", call)
+}
+
+func (r *recordingOperator) CreateSymlink(source, target string) error {
+	err := r.FileOperator.CreateSymlink(source, target)
+	r.record("CreateSymlink " + filepath.Base(target))
+	return err
+}
+
+func (r *recordingOperator) CopyFile(src, dst string) error {
+	err := r.FileOperator.CopyFile(src, dst)
+	r.record("CopyFile " + filepath.Base(dst))
+	return err
+}
+
+func (r *recordingOperator) RemoveFile(path string) error {
+	err := r.FileOperator.RemoveFile(path)
+	r.record("RemoveFile " + filepath.Base(path))
+	return err
+}
+
+func (r *recordingOperator) Rename(oldPath, newPath string) error {
+	err := r.FileOperator.Rename(oldPath, newPath)
+	r.record("Rename " + filepath.Base(newPath))
+	return err
+}
+
+func TestBackupManager_BackupAndReplaceAtomic(t *testing.T) {
+	setup := func(t *testing.T) (string, string, *recordingOperator) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "config")
+		sourceFile := filepath.Join(tempDir, "source")
+		require.NoError(t, os.WriteFile(targetFile, []byte("original content"), 0644))
+		require.NoError(t, os.WriteFile(sourceFile, []byte("new content"), 0644))
+		return targetFile, sourceFile, &recordingOperator{FileOperator: NewOperator(), t: t, target: targetFile}
+	}
+
+	t.Run("target is never absent while replacing a file", func(t *testing.T) {
+		targetFile, sourceFile, fileOp := setup(t)
+		backupMgr := NewBackupManager(fileOp)
+
+		var tempPath string
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(targetFile, func(path string) error {
+			tempPath = path
+			return fileOp.CreateSymlink(sourceFile, path)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, targetFile+".bak", backupPath)
+
+		// The replacement is built beside the target, then renamed over it
+		assert.Equal(t, filepath.Dir(targetFile), filepath.Dir(tempPath))
+		assert.NotEqual(t, targetFile, tempPath)
+		assert.Equal(t, []string{
+			"CopyFile config.bak",
+			"CreateSymlink " + filepath.Base(tempPath),
+			"Rename config",
+		}, fileOp.calls)
+
+		backupContent, err := os.ReadFile(backupPath)
+		require.NoError(t, err)
+		assert.Equal(t, "original content", string(backupContent))
+
+		dest, err := os.Readlink(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, sourceFile, dest)
+		assert.NoFileExists(t, tempPath)
+	})
+
+	t.Run("symlink target is backed up as a symlink", func(t *testing.T) {
+		targetFile, sourceFile, _ := setup(t)
+		oldSource := targetFile + ".old"
+		require.NoError(t, os.Rename(targetFile, oldSource))
+		require.NoError(t, os.Symlink(oldSource, targetFile))
+
+		fileOp := &recordingOperator{FileOperator: NewOperator(), t: t, target: targetFile}
+		backupMgr := NewBackupManager(fileOp)
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(targetFile, func(path string) error {
+			return fileOp.CreateSymlink(sourceFile, path)
+		})
+		require.NoError(t, err)
+
+		backupDest, err := os.Readlink(backupPath)
+		require.NoError(t, err)
+		assert.Equal(t, oldSource, backupDest)
+		targetDest, err := os.Readlink(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, sourceFile, targetDest)
+	})
+
+	t.Run("failed replacement leaves target untouched", func(t *testing.T) {
+		targetFile, _, fileOp := setup(t)
+		backupMgr := NewBackupManager(fileOp)
+
+		var tempPath string
+		_, err := backupMgr.BackupAndReplaceAtomic(targetFile, func(path string) error {
+			tempPath = path
+			require.NoError(t, os.WriteFile(path, []byte("partial"), 0644))
+			return assert.AnError
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "replacement failed")
+
+		content, err := os.ReadFile(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, "original content", string(content))
+		assert.NoFileExists(t, tempPath)
+		assert.NoFileExists(t, targetFile+".bak")
+	})
+
+	t.Run("missing target is created in place", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "config")
+		backupMgr := NewBackupManager(NewOperator())
+
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(targetFile, func(path string) error {
+			assert.Equal(t, targetFile, path)
+			return os.WriteFile(path, []byte("content"), 0644)
+		})
+		require.NoError(t, err)
+		assert.Empty(t, backupPath)
+		assert.FileExists(t, targetFile)
+	})
+
+	t.Run("directory target falls back to move and replace", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetDir := filepath.Join(tempDir, "config")
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		backupMgr := NewBackupManager(NewOperator())
+
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(targetDir, func(path string) error {
+			return os.WriteFile(path, []byte("content"), 0644)
+		})
+		require.NoError(t, err)
+		assert.DirExists(t, backupPath)
+		assert.FileExists(t, targetDir)
+	})
+}
+
+func TestBackupManager_Compression(t *testing.T) {
+	content := bytes.Repeat([]byte("export PATH=$HOME/bin:$PATH\n"), 100)
+	backupMgr := NewBackupManagerWithOptions(NewOperator(), BackupOptions{Compress: true})
+
+	t.Run("backs up, lists and restores a compressed backup", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), ".profile")
+		require.NoError(t, os.WriteFile(targetFile, content, 0600))
+
+		backupPath, err := backupMgr.CreateBackup(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, targetFile+".bak.gz", backupPath)
+
+		compressed, err := os.ReadFile(backupPath)
+		require.NoError(t, err)
+		assert.Less(t, len(compressed), len(content))
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{backupPath}, backups)
+
+		require.NoError(t, os.WriteFile(targetFile, []byte("replaced"), 0644))
+		require.NoError(t, backupMgr.RestoreBackup(backupPath, targetFile))
+
+		restored, err := os.ReadFile(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, content, restored)
+		info, err := os.Stat(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assert.NoFileExists(t, backupPath)
+	})
+
+	t.Run("compressed and uncompressed backups share the rotation", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "test.txt")
+		require.NoError(t, os.WriteFile(targetFile+".bak", []byte("v1"), 0644))
+		limited := NewBackupManagerWithOptions(NewOperator(), BackupOptions{MaxBackups: 2, Compress: true})
+
+		for version := 2; version <= 3; version++ {
+			require.NoError(t, os.WriteFile(targetFile, []byte(fmt.Sprintf("v%d", version)), 0644))
+			_, err := limited.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := limited.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{targetFile + ".bak.1.gz", targetFile + ".bak.gz"}, backups)
+
+		// v1 was rotated out, v2 moved down to the oldest slot
+		require.NoError(t, limited.RestoreBackup(targetFile+".bak.gz", targetFile))
+		restored, err := os.ReadFile(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(restored))
+	})
+
+	t.Run("atomic replace compresses regular files only", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "config")
+		require.NoError(t, os.WriteFile(targetFile, content, 0644))
+		targetLink := filepath.Join(tempDir, "link")
+		require.NoError(t, os.Symlink(targetFile, targetLink))
+
+		replace := func(path string) error {
+			return os.WriteFile(path, []byte("replacement"), 0644)
+		}
+		fileBackup, err := backupMgr.BackupAndReplaceAtomic(targetFile, replace)
+		require.NoError(t, err)
+		assert.Equal(t, targetFile+".bak.gz", fileBackup)
+
+		linkBackup, err := backupMgr.BackupAndReplaceAtomic(targetLink, replace)
+		require.NoError(t, err)
+		assert.Equal(t, targetLink+".bak", linkBackup)
+	})
+
+	t.Run("content matches compressed backups", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), ".profile")
+		require.NoError(t, os.WriteFile(targetFile, content, 0644))
+		_, err := backupMgr.CreateBackup(targetFile)
+		require.NoError(t, err)
+
+		require.NoError(t, os.WriteFile(targetFile, content, 0644))
+		matches, err := backupMgr.MatchesBackup(targetFile)
+		require.NoError(t, err)
+		assert.True(t, matches)
+
+		require.NoError(t, os.WriteFile(targetFile, []byte("edited"), 0644))
+		matches, err = backupMgr.MatchesBackup(targetFile)
+		require.NoError(t, err)
+		assert.False(t, matches)
+	})
+}
+
+func TestBackupManager_RestoreBackups(t *testing.T) {
+	setup := func(t *testing.T) (string, []string) {
+		tempDir := t.TempDir()
+		write := func(name, content string) {
+			require.NoError(t, os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644))
+		}
+		write("vimrc", "current")
+		write("vimrc.bak", "oldest")
+		write("vimrc.bak.1", "older")
+		write("vimrc.bak.2", "newest")
+		write("zshrc.bak", "only")
+		write("gitconfig", "no backups")
+
+		targets := []string{
+			filepath.Join(tempDir, "vimrc"),
+			filepath.Join(tempDir, "gitconfig"),
+			filepath.Join(tempDir, "zshrc"),
+			filepath.Join(tempDir, "missing", "rc"),
+		}
+		return tempDir, targets
+	}
+
+	snapshot := func(t *testing.T, dir string) map[string]string {
+		files := make(map[string]string)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			require.NoError(t, err)
+			files[entry.Name()] = string(content)
+		}
+		return files
+	}
+
+	t.Run("dry run plans the newest backup per target", func(t *testing.T) {
+		tempDir, targets := setup(t)
+		before := snapshot(t, tempDir)
+
+		plan, err := NewBackupManager(NewOperator()).RestoreBackups(targets, true)
+		require.NoError(t, err)
+		assert.Equal(t, []PlannedRestore{
+			{Target: targets[0], Backup: targets[0] + ".bak.2"},
+			{Target: targets[2], Backup: targets[2] + ".bak"},
+		}, plan)
+
+		assert.Equal(t, before, snapshot(t, tempDir))
+	})
+
+	t.Run("restores the planned backups", func(t *testing.T) {
+		tempDir, targets := setup(t)
+
+		plan, err := NewBackupManager(NewOperator()).RestoreBackups(targets, false)
+		require.NoError(t, err)
+		assert.Len(t, plan, 2)
+
+		assert.Equal(t, map[string]string{
+			"vimrc":       "newest",
+			"vimrc.bak":   "oldest",
+			"vimrc.bak.1": "older",
+			"zshrc":       "only",
+			"gitconfig":   "no backups",
+		}, snapshot(t, tempDir))
+	})
+}
+
+func TestBackupManager_Timestamped(t *testing.T) {
+	// newManager returns a manager naming backups after the times, one per backup made
+	newManager := func(options BackupOptions, times ...time.Time) *BackupManager {
+		options.Timestamped = true
+		backupMgr := NewBackupManagerWithOptions(NewOperator(), options)
+		backupMgr.now = func() time.Time {
+			made := times[0]
+			times = times[1:]
+			return made
+		}
+		return backupMgr
+	}
+	noon := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	t.Run("lists and restores by time", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "vimrc")
+		// The second backup is made earlier in the day, but on a later date
+		times := []time.Time{noon, noon.AddDate(0, 0, 1).Add(-11 * time.Hour), noon.AddDate(0, 0, 1).Add(-11 * time.Hour)}
+		backupMgr := newManager(BackupOptions{}, times...)
+
+		for _, version := range []string{"v1", "v2", "v3"} {
+			require.NoError(t, os.WriteFile(targetFile, []byte(version), 0644))
+			_, err := backupMgr.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			targetFile + ".20260314-120000.bak",
+			targetFile + ".20260315-010000.bak",
+			targetFile + ".20260315-010000.bak.1",
+		}, backups)
+
+		newest, ok, err := backupMgr.NewestBackup(targetFile)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, targetFile+".20260315-010000.bak.1", newest)
+
+		require.NoError(t, os.WriteFile(targetFile, []byte("current"), 0644))
+		_, err = backupMgr.RestoreBackups([]string{targetFile}, false)
+		require.NoError(t, err)
+		restored, err := os.ReadFile(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, "v3", string(restored))
+	})
+
+	t.Run("removes the oldest beyond the limit", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "vimrc")
+		backupMgr := newManager(BackupOptions{MaxBackups: 2, Compress: true}, noon, noon.Add(time.Minute), noon.Add(2*time.Minute))
+
+		for _, version := range []string{"v1", "v2", "v3"} {
+			require.NoError(t, os.WriteFile(targetFile, []byte(version), 0644))
+			_, err := backupMgr.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			targetFile + ".20260314-120100.bak.gz",
+			targetFile + ".20260314-120200.bak.gz",
+		}, backups)
+	})
+
+	t.Run("numbered backups of earlier runs are kept", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "vimrc")
+		require.NoError(t, os.WriteFile(targetFile+".bak", []byte("numbered"), 0644))
+		require.NoError(t, os.Chtimes(targetFile+".bak", noon.Add(-time.Hour), noon.Add(-time.Hour)))
+		require.NoError(t, os.WriteFile(targetFile, []byte("stamped"), 0644))
+		backupMgr := newManager(BackupOptions{}, noon)
+
+		_, err := backupMgr.CreateBackup(targetFile)
+		require.NoError(t, err)
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{targetFile + ".bak", targetFile + ".20260314-120000.bak"}, backups)
+
+		// The numbered backup is older than the timestamped one
+		newest, ok, err := backupMgr.NewestBackup(targetFile)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, targetFile+".20260314-120000.bak", newest)
+	})
+}
+
+func TestBackupManager_Suffix(t *testing.T) {
+	t.Run("creates, lists and rotates with the suffix", func(t *testing.T) {
+		tempDir := t.TempDir()
+		targetFile := filepath.Join(tempDir, "vimrc")
+		// An editor backup of the target is not one of dotman's
+		require.NoError(t, os.WriteFile(targetFile+".bak", []byte("editor"), 0644))
+		backupMgr := NewBackupManagerWithOptions(NewOperator(), BackupOptions{MaxBackups: 2, Suffix: ".dotman.bak"})
+
+		for _, version := range []string{"v1", "v2", "v3"} {
+			require.NoError(t, os.WriteFile(targetFile, []byte(version), 0644))
+			_, err := backupMgr.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{targetFile + ".dotman.bak", targetFile + ".dotman.bak.1"}, backups)
+
+		// The oldest backup was rotated out
+		content, err := os.ReadFile(targetFile + ".dotman.bak")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(content))
+		content, err = os.ReadFile(targetFile + ".bak")
+		require.NoError(t, err)
+		assert.Equal(t, "editor", string(content))
+
+		newest, ok, err := backupMgr.NewestBackup(targetFile)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, targetFile+".dotman.bak.1", newest)
+	})
+
+	t.Run("timestamped backups prune with the suffix", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "vimrc")
+		noon := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+		times := []time.Time{noon, noon.Add(time.Minute), noon.Add(2 * time.Minute)}
+		backupMgr := NewBackupManagerWithOptions(NewOperator(), BackupOptions{MaxBackups: 2, Timestamped: true, Compress: true, Suffix: ".orig"})
+		backupMgr.now = func() time.Time {
+			made := times[0]
+			times = times[1:]
+			return made
+		}
+
+		for _, version := range []string{"v1", "v2", "v3"} {
+			require.NoError(t, os.WriteFile(targetFile, []byte(version), 0644))
+			_, err := backupMgr.CreateBackup(targetFile)
+			require.NoError(t, err)
+		}
+
+		backups, err := backupMgr.ListBackups(targetFile)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			targetFile + ".20260314-120100.orig.gz",
+			targetFile + ".20260314-120200.orig.gz",
+		}, backups)
+	})
+}
@@ -0,0 +1,35 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SameDevice reports whether source and target are on the same filesystem. A target that
+// does not exist yet is checked through its nearest existing parent directory. Platforms
+// without device ids always report the same device.
+func SameDevice(source, target string) (bool, error) {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	targetInfo, err := os.Stat(target)
+	for dir := filepath.Dir(target); os.IsNotExist(err) && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		targetInfo, err = os.Stat(dir)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat target: %w", err)
+	}
+
+	sourceDevice, ok := FileDevice(sourceInfo)
+	if !ok {
+		return true, nil
+	}
+	targetDevice, ok := FileDevice(targetInfo)
+	if !ok {
+		return true, nil
+	}
+	return sourceDevice == targetDevice, nil
+}
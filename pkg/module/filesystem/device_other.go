@@ -0,0 +1,10 @@
+//go:build !unix
+
+package filesystem
+
+import "os"
+
+// FileDevice reports that device ids are unavailable on this platform
+func FileDevice(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameDevice(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	require.NoError(t, os.WriteFile(source, []byte("content"), 0644))
+
+	t.Run("existing target in the same directory", func(t *testing.T) {
+		target := filepath.Join(tempDir, "target")
+		require.NoError(t, os.WriteFile(target, []byte("content"), 0644))
+
+		same, err := SameDevice(source, target)
+		require.NoError(t, err)
+		assert.True(t, same)
+	})
+
+	t.Run("missing target is checked through its nearest existing parent", func(t *testing.T) {
+		same, err := SameDevice(source, filepath.Join(tempDir, "missing", "nested", "target"))
+		require.NoError(t, err)
+		assert.True(t, same)
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		_, err := SameDevice(filepath.Join(tempDir, "missing"), source)
+		assert.ErrorContains(t, err, "failed to stat source")
+	})
+}
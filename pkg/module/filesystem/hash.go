@@ -0,0 +1,33 @@
+package filesystem
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// SHA1 returns the hex SHA1 of everything read from r, streamed through the hasher
+func SHA1(r io.Reader) (string, error) {
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// FileSHA1 returns the hex SHA1 of the content of the file at path, read through fileOp
+// without loading the whole file into memory
+func FileSHA1(fileOp FileOperator, path string) (string, error) {
+	file, err := fileOp.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for SHA1 calculation: %w", err)
+	}
+	defer file.Close()
+
+	sum, err := SHA1(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file for SHA1 calculation: %w", err)
+	}
+	return sum, nil
+}
@@ -0,0 +1,52 @@
+package filesystem
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSHA1(t *testing.T) {
+	tempDir := t.TempDir()
+	op := NewOperator()
+
+	t.Run("large file matches the reference hash", func(t *testing.T) {
+		// Larger than any copy buffer and not a multiple of its size
+		content := make([]byte, 5<<20+12345)
+		for i := range content {
+			content[i] = byte(i * 31)
+		}
+		path := filepath.Join(tempDir, "large.bin")
+		require.NoError(t, os.WriteFile(path, content, 0644))
+
+		sum, err := FileSHA1(op, path)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(content)), sum)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		path := filepath.Join(tempDir, "empty")
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+
+		sum, err := FileSHA1(op, path)
+		require.NoError(t, err)
+		assert.Equal(t, "da39a3ee5e6b4b0d3255bfef95601890afd80709", sum)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := FileSHA1(op, filepath.Join(tempDir, "missing"))
+		assert.ErrorContains(t, err, "failed to open file for SHA1 calculation")
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		sum, err := SHA1(strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", sum)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 // FileOperator interface for file system operations
@@ -12,10 +13,15 @@ type FileOperator interface {
 	RemoveFile(path string) error
 	CreateBackup(path string) (string, error)
 	EnsureDirectory(path string) error
+	EnsureDirectoryMode(path string, perm os.FileMode) error
 	CopyFile(src, dst string) error
 	FileExists(path string) bool
 	IsSymlink(path string) bool
 	Readlink(path string) (string, error)
+	ReadFile(path string) ([]byte, error)
+	Open(path string) (io.ReadCloser, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	Rename(oldPath, newPath string) error
 }
 
 // Operator implements the FileOperator interface
@@ -41,6 +47,46 @@ func (op *Operator) EnsureDirectory(path string) error {
 	return os.MkdirAll(path, 0755)
 }
 
+// EnsureDirectoryMode ensures that a directory exists, creating missing directories with exactly perm.
+// Directories that already exist keep their mode.
+func (op *Operator) EnsureDirectoryMode(path string, perm os.FileMode) error {
+	// Collect the directories that do not exist yet
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+
+	// MkdirAll applies the umask, so set the requested mode explicitly
+	for _, dir := range missing {
+		if err := os.Chmod(dir, perm); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// EnsureDirectoryWithMode creates path through fileOp with dirMode, or with the default
+// EnsureDirectory mode when dirMode is zero
+func EnsureDirectoryWithMode(fileOp FileOperator, path string, dirMode os.FileMode) error {
+	if dirMode == 0 {
+		return fileOp.EnsureDirectory(path)
+	}
+	return fileOp.EnsureDirectoryMode(path, dirMode)
+}
+
 // CopyFile copies a file from src to dst
 func (op *Operator) CopyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -89,27 +135,27 @@ func (op *Operator) Readlink(path string) (string, error) {
 	return os.Readlink(path)
 }
 
-// CreateBackup creates a backup of a file with .bak extension
-func (op *Operator) CreateBackup(target string) (string, error) {
-	backupPath := target + ".bak"
+// ReadFile reads the content of a file
+func (op *Operator) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
 
-	// Check if backup already exists and find a unique name if needed
-	counter := 1
-	for {
-		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-			break // File doesn't exist, we can use this name
-		}
-		backupPath = fmt.Sprintf("%s.bak.%d", target, counter)
-		counter++
-		if counter > 100 { // Prevent infinite loop
-			return "", fmt.Errorf("too many backup files exist")
-		}
-	}
+// Open opens a file for streaming reads
+func (op *Operator) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
 
-	// Copy the file
-	if err := op.CopyFile(target, backupPath); err != nil {
-		return "", fmt.Errorf("failed to create backup: %w", err)
-	}
+// WriteFile writes data to a file, creating or truncating it
+func (op *Operator) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
 
-	return backupPath, nil
+// Rename atomically moves oldPath to newPath, replacing newPath if it exists
+func (op *Operator) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// CreateBackup creates a backup of a file with .bak extension
+func (op *Operator) CreateBackup(target string) (string, error) {
+	return NewBackupManager(op).CreateBackup(target)
 }
@@ -90,6 +90,36 @@ func TestOperator_EnsureDirectory(t *testing.T) {
 	})
 }
 
+func TestOperator_EnsureDirectoryMode(t *testing.T) {
+	tempDir := t.TempDir()
+	op := NewOperator()
+
+	existing := filepath.Join(tempDir, "existing")
+	require.NoError(t, os.Mkdir(existing, 0755))
+	require.NoError(t, os.Chmod(existing, 0755))
+
+	newDir := filepath.Join(existing, ".gnupg", "private-keys")
+	require.NoError(t, op.EnsureDirectoryMode(newDir, 0700))
+
+	// Created directories get exactly the requested mode, regardless of umask
+	for _, dir := range []string{filepath.Join(existing, ".gnupg"), newDir} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), dir)
+	}
+
+	// Existing directories keep their mode
+	info, err := os.Stat(existing)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// Calling it again on an existing directory is a no-op
+	require.NoError(t, op.EnsureDirectoryMode(newDir, 0750))
+	info, err = os.Stat(newDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
+
 func TestOperator_CreateSymlink(t *testing.T) {
 	tempDir := t.TempDir()
 	op := NewOperator()
@@ -212,3 +242,33 @@ func TestOperator_Readlink(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestOperator_ReadWriteFile(t *testing.T) {
+	tempDir := t.TempDir()
+	op := NewOperator()
+
+	t.Run("writes and reads file content", func(t *testing.T) {
+		path := filepath.Join(tempDir, "file.txt")
+
+		err := op.WriteFile(path, []byte("first"), 0644)
+		require.NoError(t, err)
+
+		// Overwrites existing content
+		err = op.WriteFile(path, []byte("second"), 0644)
+		require.NoError(t, err)
+
+		content, err := op.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(content))
+	})
+
+	t.Run("handles non-existing file", func(t *testing.T) {
+		_, err := op.ReadFile(filepath.Join(tempDir, "nonexistent.txt"))
+		assert.Error(t, err)
+	})
+
+	t.Run("handles missing parent directory", func(t *testing.T) {
+		err := op.WriteFile(filepath.Join(tempDir, "missing", "file.txt"), []byte("content"), 0644)
+		assert.Error(t, err)
+	})
+}
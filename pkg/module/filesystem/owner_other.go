@@ -0,0 +1,10 @@
+//go:build !unix
+
+package filesystem
+
+import "os"
+
+// FileOwner reports that file ownership is unavailable on this platform
+func FileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
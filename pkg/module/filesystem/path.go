@@ -1,7 +1,13 @@
 package filesystem
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 // PathResolver handles path resolution utilities
@@ -34,3 +40,144 @@ func (pr *PathResolver) FileExists(path string) bool {
 	}
 	return !info.IsDir()
 }
+
+// maxSymlinkHops bounds how many symlinks ResolveSource follows before giving up
+const maxSymlinkHops = 40
+
+// ErrSymlinkLoop is returned when following a symlink leads back to a link already followed
+var ErrSymlinkLoop = errors.New("symlink loop detected")
+
+// ResolveSource returns the absolute path a source file should be linked to.
+// If the source is itself a symlink (e.g. a relative link shared between modules),
+// the link chain is followed to its final destination so the installed symlink
+// does not depend on the in-repo link. Parent directories are left untouched.
+// Sources that no longer exist resolve to their absolute path. A chain leading back to one
+// of its own links fails with ErrSymlinkLoop.
+func ResolveSource(source string) (string, error) {
+	current, err := filepath.Abs(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for source %s: %w", source, err)
+	}
+
+	visited := make(map[string]bool)
+	chain := []string{current}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		info, err := os.Lstat(current)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return current, nil
+		}
+		if visited[current] {
+			return "", fmt.Errorf("%w resolving %s: %s", ErrSymlinkLoop, source, strings.Join(chain, " -> "))
+		}
+		visited[current] = true
+
+		dest, err := os.Readlink(current)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %s: %w", current, err)
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(filepath.Dir(current), dest)
+		}
+		current = filepath.Clean(dest)
+		chain = append(chain, current)
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic links resolving %s", source)
+}
+
+// LinkDestination returns the absolute path a symlink destination read from link refers to;
+// a relative destination is relative to the directory containing link
+func LinkDestination(link, destination string) (string, error) {
+	if !filepath.IsAbs(destination) {
+		destination = filepath.Join(filepath.Dir(link), destination)
+	}
+	return filepath.Abs(destination)
+}
+
+// SameRealPath reports whether a and b are the same existing file once every symlink in both
+// paths is followed, such as an absolute and a relative link destination for one source
+func SameRealPath(a, b string) bool {
+	realA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	realB, err := filepath.EvalSymlinks(b)
+	if err != nil {
+		return false
+	}
+	return realA == realB
+}
+
+// SameContent reports whether the files a and b, following symlinks, have identical content
+func SameContent(a, b string) (bool, error) {
+	contentA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	contentB, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(contentA, contentB), nil
+}
+
+// IsRoot reports whether path is a filesystem root, such as "/" on POSIX systems
+// or a drive root like `C:\` (or a UNC share root) on Windows
+func IsRoot(path string) bool {
+	if path == "" {
+		return false
+	}
+	cleaned := filepath.Clean(path)
+	volume := filepath.VolumeName(cleaned)
+	rest := cleaned[len(volume):]
+	return rest == string(filepath.Separator) || (volume != "" && rest == "")
+}
+
+// IsLinkLike reports whether info describes a symlink or, on Windows, a directory
+// junction (reported as an irregular file), both of which redirect path resolution
+func IsLinkLike(info os.FileInfo) bool {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return true
+	}
+	return runtime.GOOS == "windows" && info.Mode()&os.ModeIrregular != 0
+}
+
+// DescribeFileType returns a human-readable name for the kind of file info describes
+func DescribeFileType(info os.FileInfo) string {
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		return "regular file"
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	default:
+		return "special file"
+	}
+}
+
+// LinkOwner returns the uid owning path itself, without following a final symlink.
+// ok is false when the platform does not expose file owners.
+func LinkOwner(path string) (uid int, ok bool, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, false, err
+	}
+	uid, ok = FileOwner(info)
+	return uid, ok, nil
+}
+
+// LinkPerm returns the permission bits of path itself, without following a final symlink
+func LinkPerm(path string) (os.FileMode, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Mode().Perm(), nil
+}
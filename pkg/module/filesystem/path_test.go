@@ -1,8 +1,11 @@
 package filesystem
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -85,3 +88,160 @@ func TestPathResolver_FileExists(t *testing.T) {
 		assert.False(t, pr.FileExists(nonExistentFile))
 	})
 }
+
+func TestResolveSource(t *testing.T) {
+	tempDir := t.TempDir()
+
+	shared := filepath.Join(tempDir, "shared", "config.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(shared), 0755))
+	require.NoError(t, os.WriteFile(shared, []byte("shared"), 0644))
+
+	moduleDir := filepath.Join(tempDir, "module")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+
+	regular := filepath.Join(moduleDir, "regular.txt")
+	require.NoError(t, os.WriteFile(regular, []byte("regular"), 0644))
+
+	relativeLink := filepath.Join(moduleDir, "relative.txt")
+	require.NoError(t, os.Symlink("../shared/config.txt", relativeLink))
+
+	chainedLink := filepath.Join(moduleDir, "chained.txt")
+	require.NoError(t, os.Symlink("relative.txt", chainedLink))
+
+	loopA := filepath.Join(moduleDir, "loop-a")
+	loopB := filepath.Join(moduleDir, "loop-b")
+	require.NoError(t, os.Symlink("loop-b", loopA))
+	require.NoError(t, os.Symlink("loop-a", loopB))
+
+	// A chain of more links than ResolveSource follows, ending in a regular file
+	longChain := regular
+	for i := 0; i <= maxSymlinkHops; i++ {
+		link := filepath.Join(moduleDir, fmt.Sprintf("hop-%d", i))
+		require.NoError(t, os.Symlink(longChain, link))
+		longChain = link
+	}
+
+	tests := []struct {
+		name        string
+		source      string
+		want        string
+		errContains string
+	}{
+		{
+			name:   "regular file resolves to itself",
+			source: regular,
+			want:   regular,
+		},
+		{
+			name:   "relative in-repo symlink resolves to destination",
+			source: relativeLink,
+			want:   shared,
+		},
+		{
+			name:   "chained symlinks are followed",
+			source: chainedLink,
+			want:   shared,
+		},
+		{
+			name:   "missing source resolves to absolute path",
+			source: filepath.Join(moduleDir, "missing.txt"),
+			want:   filepath.Join(moduleDir, "missing.txt"),
+		},
+		{
+			name:        "symlink loop",
+			source:      loopA,
+			errContains: fmt.Sprintf("symlink loop detected resolving %s: %s -> %s -> %s", loopA, loopA, loopB, loopA),
+		},
+		{
+			name:        "long chain without a loop",
+			source:      longChain,
+			errContains: "too many levels of symbolic links",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSource(tt.source)
+			if tt.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				assert.Equal(t, tt.source == loopA, errors.Is(err, ErrSymlinkLoop))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIsRoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		goos     string
+		path     string
+		expected bool
+	}{
+		{name: "posix root", goos: "linux", path: "/", expected: true},
+		{name: "posix root uncleaned", goos: "linux", path: "//", expected: true},
+		{name: "posix directory", goos: "linux", path: "/home/user", expected: false},
+		{name: "empty path", goos: "", path: "", expected: false},
+		{name: "relative path", goos: "", path: "config", expected: false},
+		{name: "windows drive root", goos: "windows", path: `C:\`, expected: true},
+		{name: "windows drive without separator", goos: "windows", path: `C:`, expected: true},
+		{name: "windows directory", goos: "windows", path: `C:\Users\me`, expected: false},
+		{name: "windows unc share root", goos: "windows", path: `\\server\share\`, expected: true},
+		{name: "windows forward slash root", goos: "windows", path: `D:/`, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.goos == "windows" && runtime.GOOS != "windows" {
+				t.Skip("windows path semantics only apply on windows")
+			}
+			if tt.goos == "linux" && runtime.GOOS == "windows" {
+				t.Skip("posix path semantics only apply on posix systems")
+			}
+			assert.Equal(t, tt.expected, IsRoot(tt.path))
+		})
+	}
+}
+
+func TestIsLinkLike(t *testing.T) {
+	tempDir := t.TempDir()
+
+	dir := filepath.Join(tempDir, "dir")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	link := filepath.Join(tempDir, "link")
+	require.NoError(t, os.Symlink(dir, link))
+
+	dirInfo, err := os.Lstat(dir)
+	require.NoError(t, err)
+	assert.False(t, IsLinkLike(dirInfo))
+
+	linkInfo, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.True(t, IsLinkLike(linkInfo))
+}
+
+func TestLinkOwner(t *testing.T) {
+	tempDir := t.TempDir()
+
+	t.Run("reports the owner of the link itself", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file owners are not exposed on windows")
+		}
+
+		link := filepath.Join(tempDir, "link")
+		require.NoError(t, os.Symlink(filepath.Join(tempDir, "missing"), link))
+
+		uid, ok, err := LinkOwner(link)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, os.Getuid(), uid)
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		_, _, err := LinkOwner(filepath.Join(tempDir, "nonexistent"))
+		assert.Error(t, err)
+	})
+}
@@ -18,11 +18,17 @@ func NewSymlinkManager(fileOp FileOperator) *SymlinkManager {
 
 // CreateSymlinkWithMkdir creates a symlink, ensuring the target directory exists
 func (sm *SymlinkManager) CreateSymlinkWithMkdir(source, target string, mkdir bool) error {
+	return sm.CreateSymlinkWithDirMode(source, target, mkdir, 0)
+}
+
+// CreateSymlinkWithDirMode creates a symlink like CreateSymlinkWithMkdir, creating missing
+// directories with dirMode; a zero dirMode uses the default directory mode
+func (sm *SymlinkManager) CreateSymlinkWithDirMode(source, target string, mkdir bool, dirMode os.FileMode) error {
 	// Ensure target directory exists
 	targetDir := filepath.Dir(target)
 	if !sm.fileOp.FileExists(targetDir) {
 		if mkdir {
-			if err := sm.fileOp.EnsureDirectory(targetDir); err != nil {
+			if err := EnsureDirectoryWithMode(sm.fileOp, targetDir, dirMode); err != nil {
 				return fmt.Errorf("failed to create target directory %s: %w", targetDir, err)
 			}
 		} else {
@@ -30,10 +36,16 @@ func (sm *SymlinkManager) CreateSymlinkWithMkdir(source, target string, mkdir bo
 		}
 	}
 
-	// Get absolute path for source
-	absSource, err := filepath.Abs(source)
+	// Get absolute path for source, following in-repo symlinks
+	absSource, err := ResolveSource(source)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for source %s: %w", source, err)
+		return err
+	}
+
+	// Check the parent again right before linking: validation only checked it earlier, and a
+	// directory swapped for a symlink since then would redirect the link outside the target tree
+	if err := checkRealDirectory(targetDir); err != nil {
+		return err
 	}
 
 	// Create the symlink using absolute path
@@ -44,49 +56,90 @@ func (sm *SymlinkManager) CreateSymlinkWithMkdir(source, target string, mkdir bo
 	return nil
 }
 
+// checkRealDirectory fails when dir is a symlink (or Windows junction) or not a directory. A
+// missing dir is left for the caller's write to report.
+func checkRealDirectory(dir string) error {
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check target directory %s: %w", dir, err)
+	}
+	if IsLinkLike(info) {
+		return fmt.Errorf("target directory %s is a symlink, must be a regular directory", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("target directory %s is not a directory", dir)
+	}
+	return nil
+}
+
+// SymlinkState is the outcome of checking a symlink against its expected source
+type SymlinkState int
+
+const (
+	// SymlinkValid is a symlink pointing to the expected source
+	SymlinkValid SymlinkState = iota
+	// SymlinkMissing is a target that does not exist
+	SymlinkMissing
+	// SymlinkNotALink is a target that exists but is not a symlink
+	SymlinkNotALink
+	// SymlinkWrongTarget is a symlink pointing somewhere else
+	SymlinkWrongTarget
+)
+
 // ValidateSymlink validates that a symlink points to the expected source
 func (sm *SymlinkManager) ValidateSymlink(target, expectedSource string) (bool, string, error) {
+	state, reason, err := sm.CheckSymlink(target, expectedSource)
+	return state == SymlinkValid, reason, err
+}
+
+// CheckSymlink checks a symlink against the expected source like ValidateSymlink, returning
+// the state of the target and, unless it is valid, a description of the problem
+func (sm *SymlinkManager) CheckSymlink(target, expectedSource string) (SymlinkState, string, error) {
 	// Check if target exists
 	targetInfo, err := os.Lstat(target)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, "target file does not exist", nil
+			return SymlinkMissing, "target file does not exist", nil
 		}
-		return false, "", fmt.Errorf("failed to stat target: %w", err)
+		return SymlinkMissing, "", fmt.Errorf("failed to stat target: %w", err)
 	}
 
 	// Check if target is a symlink
 	if targetInfo.Mode()&os.ModeSymlink == 0 {
-		return false, "target exists but is not a symlink", nil
+		return SymlinkNotALink, "target exists but is not a symlink", nil
 	}
 
 	// Read the symlink target
 	actualSource, err := sm.fileOp.Readlink(target)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to read symlink: %w", err)
+		return SymlinkWrongTarget, "", fmt.Errorf("failed to read symlink: %w", err)
 	}
 
-	// Convert to absolute path for comparison
-	if !filepath.IsAbs(actualSource) {
-		// If relative, resolve relative to the symlink's directory
-		actualSource = filepath.Join(filepath.Dir(target), actualSource)
-	}
-	absActualSource, err := filepath.Abs(actualSource)
+	// Convert to absolute path for comparison, resolving a relative one against the symlink's directory
+	absActualSource, err := LinkDestination(target, actualSource)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to resolve absolute path for actual source: %w", err)
+		return SymlinkWrongTarget, "", fmt.Errorf("failed to resolve absolute path for actual source: %w", err)
 	}
 
 	absExpectedSource, err := filepath.Abs(expectedSource)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to resolve absolute path for expected source: %w", err)
+		return SymlinkWrongTarget, "", fmt.Errorf("failed to resolve absolute path for expected source: %w", err)
+	}
+
+	resolvedExpectedSource, err := ResolveSource(expectedSource)
+	if err != nil {
+		return SymlinkWrongTarget, "", fmt.Errorf("failed to resolve expected source: %w", err)
 	}
 
-	// Compare the paths
-	if absActualSource != absExpectedSource {
-		return false, fmt.Sprintf("symlink points to %s, expected %s", absActualSource, absExpectedSource), nil
+	// Compare the paths, accepting links to either the source or its resolved destination, or
+	// any other path to the same file, such as one through a symlinked directory
+	if absActualSource != absExpectedSource && absActualSource != resolvedExpectedSource && !SameRealPath(absActualSource, expectedSource) {
+		return SymlinkWrongTarget, fmt.Sprintf("symlink points to %s, expected %s", absActualSource, resolvedExpectedSource), nil
 	}
 
-	return true, "", nil
+	return SymlinkValid, "", nil
 }
 
 // RemoveSymlink safely removes a symlink
@@ -0,0 +1,10 @@
+//go:build linux
+
+package filesystem
+
+import "os"
+
+// SymlinkPerm returns the permission bits every symlink has on this platform
+func SymlinkPerm() (os.FileMode, bool) {
+	return 0777, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package filesystem
+
+import "os"
+
+// SymlinkPerm reports that symlink permissions follow the umask or can be changed on this
+// platform, so there is no single expected mode
+func SymlinkPerm() (os.FileMode, bool) {
+	return 0, false
+}
@@ -61,6 +61,42 @@ func TestSymlinkManager_CreateSymlinkWithMkdir(t *testing.T) {
 	})
 }
 
+// swappingOperator replaces a directory with a symlink to another one as soon as its existence
+// is checked, like an attacker racing the installation between validation and linking
+type swappingOperator struct {
+	FileOperator
+	dir, redirect string
+}
+
+func (op *swappingOperator) FileExists(path string) bool {
+	exists := op.FileOperator.FileExists(path)
+	if path == op.dir {
+		if err := os.Rename(op.dir, op.dir+".orig"); err == nil {
+			os.Symlink(op.redirect, op.dir)
+		}
+	}
+	return exists
+}
+
+func TestSymlinkManager_CreateSymlinkParentSwapped(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceFile := filepath.Join(tempDir, "dotfiles", "init.vim")
+	targetDir := filepath.Join(tempDir, "home", ".config", "nvim")
+	outsideDir := filepath.Join(tempDir, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sourceFile), 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.MkdirAll(outsideDir, 0755))
+	require.NoError(t, os.WriteFile(sourceFile, []byte("set nu"), 0644))
+
+	symlinkMgr := NewSymlinkManager(&swappingOperator{FileOperator: NewOperator(), dir: targetDir, redirect: outsideDir})
+	err := symlinkMgr.CreateSymlinkWithMkdir(sourceFile, filepath.Join(targetDir, "init.vim"), false)
+	assert.ErrorContains(t, err, "is a symlink, must be a regular directory")
+
+	entries, err := os.ReadDir(outsideDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "nothing is linked through the swapped directory")
+}
+
 func TestSymlinkManager_ValidateSymlink(t *testing.T) {
 	fileOp := NewOperator()
 	symlinkMgr := NewSymlinkManager(fileOp)
@@ -148,6 +184,23 @@ func TestSymlinkManager_ValidateSymlink(t *testing.T) {
 		assert.True(t, isValid)
 		assert.Empty(t, reason)
 	})
+
+	t.Run("symlink through a symlinked directory is valid", func(t *testing.T) {
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "dotfiles")
+		require.NoError(t, os.Mkdir(sourceDir, 0755))
+		sourceFile := filepath.Join(sourceDir, "source.txt")
+		require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0644))
+		require.NoError(t, os.Symlink(sourceDir, filepath.Join(tempDir, "alias")))
+
+		targetFile := filepath.Join(tempDir, "target.txt")
+		require.NoError(t, os.Symlink(filepath.Join("alias", "source.txt"), targetFile))
+
+		isValid, reason, err := symlinkMgr.ValidateSymlink(targetFile, sourceFile)
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		assert.Empty(t, reason)
+	})
 }
 
 func TestSymlinkManager_RemoveSymlink(t *testing.T) {
@@ -0,0 +1,40 @@
+package module
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// excludedTarget reports whether target is matched by one of patterns. A pattern with glob
+// characters matches the whole target path; any other pattern is a path prefix matching
+// itself and everything below it. Patterns should be absolute, as targets are; malformed
+// globs match nothing.
+func excludedTarget(target string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := filepath.Match(pattern, target); ok {
+				return true
+			}
+			continue
+		}
+		if isUnderAnyRoot(target, []string{pattern}) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeOperations splits ops into the ones to apply and the ones excluded by patterns
+func excludeOperations(ops []FileOperation, patterns []string) (kept, excluded []FileOperation) {
+	if len(patterns) == 0 {
+		return ops, nil
+	}
+	for _, op := range ops {
+		if excludedTarget(op.Target, patterns) {
+			excluded = append(excluded, op)
+		} else {
+			kept = append(kept, op)
+		}
+	}
+	return kept, excluded
+}
@@ -0,0 +1,31 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludedTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		patterns []string
+		want     bool
+	}{
+		{name: "no patterns", target: "/home/u/.vimrc", want: false},
+		{name: "exact path", target: "/home/u/.vimrc", patterns: []string{"/home/u/.vimrc"}, want: true},
+		{name: "below a prefix", target: "/home/u/.config/private/key", patterns: []string{"/home/u/.config/private"}, want: true},
+		{name: "sibling with a common prefix", target: "/home/u/.config/private2/key", patterns: []string{"/home/u/.config/private"}, want: false},
+		{name: "glob", target: "/home/u/.config/app/config.toml", patterns: []string{"/home/u/.config/*/*.toml"}, want: true},
+		{name: "glob does not cross directories", target: "/home/u/.config/app/sub/config.toml", patterns: []string{"/home/u/.config/*/*.toml"}, want: false},
+		{name: "malformed glob", target: "/home/u/[", patterns: []string{"/home/u/["}, want: false},
+		{name: "second pattern", target: "/etc/hosts", patterns: []string{"/home", "/etc"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, excludedTarget(tt.target, tt.patterns))
+		})
+	}
+}
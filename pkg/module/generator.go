@@ -0,0 +1,74 @@
+package module
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+)
+
+// runGenerator runs a generator command in dir and returns its stdout. The command is
+// killed once timeout elapses; a non-zero exit is reported with the command's stderr.
+func runGenerator(ctx context.Context, command []string, dir string, timeout time.Duration) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("generator command is empty")
+	}
+	return runCommand(ctx, command, dir, timeout, nil)
+}
+
+// runFormatter pipes content through a format command run in dir and returns its stdout.
+// A non-zero exit means the content is invalid and is reported with the command's stderr.
+func runFormatter(ctx context.Context, command []string, dir string, content []byte) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("format command is empty")
+	}
+	output, err := runCommand(ctx, command, dir, config.DefaultGeneratorTimeout, content)
+	if err != nil {
+		return nil, fmt.Errorf("formatter rejected content: %w", err)
+	}
+	return output, nil
+}
+
+// runCommand runs command in dir with stdin as its input and returns its stdout.
+// The command is killed when ctx is cancelled.
+func runCommand(ctx context.Context, command []string, dir string, timeout time.Duration, stdin []byte) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = config.DefaultGeneratorTimeout
+	}
+
+	commandCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(commandCtx, command[0], command[1:]...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	// Don't wait on pipes held open by orphaned grandchildren after a kill
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	commandLine := strings.Join(command, " ")
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command %q was cancelled: %w", commandLine, ctx.Err())
+		}
+		if errors.Is(commandCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("command %q timed out after %s", commandLine, timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("command %q failed: %w: %s", commandLine, err, message)
+		}
+		return nil, fmt.Errorf("command %q failed: %w", commandLine, err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,454 @@
+package module
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunGenerator(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("generator tests use POSIX commands")
+	}
+
+	tests := []struct {
+		name        string
+		command     []string
+		timeout     time.Duration
+		want        string
+		errContains []string
+	}{
+		{
+			name:    "captures stdout",
+			command: []string{"echo", "hello"},
+			want:    "hello\n",
+		},
+		{
+			name:        "non-zero exit",
+			command:     []string{"sh", "-c", "echo partial; echo boom >&2; exit 3"},
+			errContains: []string{"failed", "exit status 3", "boom"},
+		},
+		{
+			name:        "timeout",
+			command:     []string{"sleep", "5"},
+			timeout:     100 * time.Millisecond,
+			errContains: []string{"timed out after 100ms"},
+		},
+		{
+			name:        "empty command",
+			command:     nil,
+			errContains: []string{"generator command is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := runGenerator(context.Background(), tt.command, t.TempDir(), tt.timeout)
+			if len(tt.errContains) > 0 {
+				require.Error(t, err)
+				for _, want := range tt.errContains {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(output))
+		})
+	}
+}
+
+func TestRunGeneratorCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("generator tests use POSIX commands")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := runGenerator(ctx, []string{"sleep", "5"}, t.TempDir(), 0)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "was cancelled")
+	// The command is killed instead of running to completion
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestInstallGenerated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("generator tests use POSIX commands")
+	}
+
+	setup := func(t *testing.T, generators ...config.GeneratorConfig) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "ssh")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+		return dotfilesDir, targetDir, []config.ModuleConfig{
+			{Dir: moduleDir, TargetDir: targetDir, Generators: generators},
+		}
+	}
+
+	t.Run("writes command output and records sha1", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, config.GeneratorConfig{Target: "keys/id.pub", Command: []string{"echo", "ssh-ed25519 AAAA"}})
+
+		result, err := Install(modules, nil, true, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.CreatedGenerated, 1)
+		assert.Contains(t, result.Summary, "1 command outputs generated")
+
+		target := filepath.Join(targetDir, "keys", "id.pub")
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "ssh-ed25519 AAAA\n", string(content))
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, state.TypeGenerated, stateFile.Files[0].Type)
+		assert.Equal(t, target, stateFile.Files[0].Target)
+		assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(content)), stateFile.Files[0].SHA1)
+
+		// Uninstall removes it like a template-generated file
+		uninstallResult, err := Uninstall(dotfilesDir)
+		require.NoError(t, err)
+		assert.Len(t, uninstallResult.RemovedGenerated, 1)
+		assert.NoFileExists(t, target)
+	})
+
+	t.Run("non-zero exit fails installation", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, config.GeneratorConfig{Target: "out", Command: []string{"false"}})
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "exit status 1")
+		assert.NoFileExists(t, filepath.Join(targetDir, "out"))
+	})
+
+	t.Run("existing target is a conflict unless forced", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, config.GeneratorConfig{Target: "out", Command: []string{"echo", "new"}})
+		target := filepath.Join(targetDir, "out")
+		require.NoError(t, os.WriteFile(target, []byte("old\n"), 0644))
+
+		validation, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, validation.IsValid)
+		require.Len(t, validation.ForceGeneratedOps, 1)
+		assert.Contains(t, validation.ForceGeneratedOps[0].Description, "target exists as regular file")
+
+		result, err := Install(modules, nil, false, true, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "new\n", string(content))
+		backup, err := os.ReadFile(target + ".bak")
+		require.NoError(t, err)
+		assert.Equal(t, "old\n", string(backup))
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			generators  []config.GeneratorConfig
+			files       []string
+			errContains string
+		}{
+			{
+				name:        "unknown command",
+				generators:  []config.GeneratorConfig{{Target: "out", Command: []string{"dotman-no-such-command"}}},
+				errContains: "generator command not found",
+			},
+			{
+				name:        "conflicts with module file",
+				generators:  []config.GeneratorConfig{{Target: "config", Command: []string{"echo"}}},
+				files:       []string{"config"},
+				errContains: "target conflict: generator in module ssh and source file",
+			},
+			{
+				name: "duplicate generator target",
+				generators: []config.GeneratorConfig{
+					{Target: "out", Command: []string{"echo", "a"}},
+					{Target: "out", Command: []string{"echo", "b"}},
+				},
+				errContains: "target conflict: generators in modules ssh and ssh",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, _, modules := setup(t, tt.generators...)
+				for _, file := range tt.files {
+					require.NoError(t, os.WriteFile(filepath.Join(modules[0].Dir, file), []byte("content"), 0644))
+				}
+
+				result, err := Validate(modules, nil, false, false)
+				require.NoError(t, err)
+				assert.False(t, result.IsValid)
+				require.Len(t, result.Errors, 1)
+				assert.Contains(t, result.Errors[0], tt.errContains)
+			})
+		}
+	})
+}
+
+func TestRunFormatter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("formatter tests use POSIX commands")
+	}
+
+	tests := []struct {
+		name        string
+		command     []string
+		want        string
+		errContains []string
+	}{
+		{
+			name:    "passthrough",
+			command: []string{"cat"},
+			want:    "{\"a\": 1}\n",
+		},
+		{
+			name:    "stdout replaces content",
+			command: []string{"tr", "a-z", "A-Z"},
+			want:    "{\"A\": 1}\n",
+		},
+		{
+			name:        "non-zero exit rejects content",
+			command:     []string{"sh", "-c", "echo 'parse error' >&2; exit 2"},
+			errContains: []string{"formatter rejected content", "exit status 2", "parse error"},
+		},
+		{
+			name:        "empty command",
+			command:     nil,
+			errContains: []string{"format command is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := runFormatter(context.Background(), tt.command, t.TempDir(), []byte("{\"a\": 1}\n"))
+			if len(tt.errContains) > 0 {
+				require.Error(t, err)
+				for _, want := range tt.errContains {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(output))
+		})
+	}
+}
+
+func TestInstallFormatted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("formatter tests use POSIX commands")
+	}
+
+	// setup creates a module with a settings.json template and a plain link, formatting *.json with command
+	setup := func(t *testing.T, command ...string) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "app")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "settings.json.dot-tmpl"), []byte(`{"theme": "dark"}`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "notes.json"), []byte(`{}`), 0644))
+
+		return dotfilesDir, targetDir, []config.ModuleConfig{{
+			Dir:        moduleDir,
+			TargetDir:  targetDir,
+			Formatters: []config.FormatterConfig{{Pattern: "*.json", FormatCmd: command}},
+		}}
+	}
+
+	t.Run("passthrough formatter writes the rendered content", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, "cat")
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "settings.json"))
+		require.NoError(t, err)
+		assert.Equal(t, `{"theme": "dark"}`, string(content))
+
+		// Linked files are never formatted
+		require.Len(t, result.CreatedLinks, 1)
+		assert.Empty(t, result.CreatedLinks[0].FormatCmd)
+	})
+
+	t.Run("formatter output replaces content and is recorded", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, "tr", "a-z", "A-Z")
+		modules[0].Generators = []config.GeneratorConfig{{Target: "generated.json", Command: []string{"echo", `{"b": 2}`}}}
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "settings.json"))
+		require.NoError(t, err)
+		assert.Equal(t, `{"THEME": "DARK"}`, string(content))
+		generated, err := os.ReadFile(filepath.Join(targetDir, "generated.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "{\"B\": 2}\n", string(generated))
+
+		// The state SHA1 is of the formatted output, so uninstall treats it as unmodified
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		for _, entry := range stateFile.Files {
+			if entry.Target == filepath.Join(targetDir, "settings.json") {
+				assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(content)), entry.SHA1)
+			}
+		}
+		uninstallResult, err := Uninstall(dotfilesDir)
+		require.NoError(t, err)
+		assert.Len(t, uninstallResult.RemovedGenerated, 2)
+		assert.Empty(t, uninstallResult.BackedUpGenerated)
+	})
+
+	t.Run("failing formatter aborts without writing", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t, "sh", "-c", "echo 'invalid json' >&2; exit 1")
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "formatter rejected content")
+		assert.Contains(t, result.Errors[0], "invalid json")
+		assert.NoFileExists(t, filepath.Join(targetDir, "settings.json"))
+	})
+
+	t.Run("unknown format command is a validation error", func(t *testing.T) {
+		_, _, modules := setup(t, "dotman-no-such-formatter")
+
+		result, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "format command not found")
+	})
+}
+
+func TestInstallMergedBlocks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("generator tests use POSIX commands")
+	}
+
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "target")
+	target := filepath.Join(targetDir, "config")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	var modules []config.ModuleConfig
+	for _, name := range []string{"git", "work"} {
+		moduleDir := filepath.Join(dotfilesDir, name)
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		modules = append(modules, config.ModuleConfig{
+			Dir:        moduleDir,
+			TargetDir:  targetDir,
+			Generators: []config.GeneratorConfig{{Target: "config", Command: []string{"echo", "Host " + name}, Merge: true}},
+		})
+	}
+	require.NoError(t, os.WriteFile(target, []byte("Host mine\n"), 0600))
+
+	readTarget := func() string {
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		validation, err := Validate(modules, nil, false, false)
+		require.NoError(t, err)
+		assert.True(t, validation.IsValid, validation.Errors)
+		assert.False(t, validation.RequiresForce)
+		assert.Len(t, validation.MergeGeneratedOps, 2)
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.MergedBlocks, 2)
+		assert.Equal(t, "Host mine\n"+
+			"# >>> dotman:git >>>\nHost git\n# <<< dotman:git <<<\n"+
+			"# >>> dotman:work >>>\nHost work\n# <<< dotman:work <<<\n", readTarget())
+
+		// The mode of the user's file is kept
+		info, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 2)
+		for _, file := range stateFile.Files {
+			assert.Equal(t, state.TypeBlock, file.Type)
+			assert.Equal(t, target, file.Target)
+			assert.Equal(t, blockSHA1([]byte("Host "+file.Block+"\n")), file.SHA1)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		// Content added after the blocks keeps its place
+		require.NoError(t, os.WriteFile(target, []byte(readTarget()+"Host later\n"), 0600))
+		modules[0].Generators[0].Command = []string{"echo", "Host git2"}
+
+		result, err := Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.MergedBlocks, 1)
+		assert.Equal(t, "git", result.MergedBlocks[0].Module)
+		assert.Equal(t, "Host mine\n"+
+			"# >>> dotman:git >>>\nHost git2\n# <<< dotman:git <<<\n"+
+			"# >>> dotman:work >>>\nHost work\n# <<< dotman:work <<<\n"+
+			"Host later\n", readTarget())
+
+		// Nothing changes when the output is the same
+		result, err = Install(modules, nil, false, false, dotfilesDir)
+		require.NoError(t, err)
+		assert.True(t, result.NoChanges)
+	})
+
+	t.Run("removal", func(t *testing.T) {
+		result, err := Uninstall(dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.RemovedBlocks, 2)
+		assert.Empty(t, result.BackedUpGenerated)
+		assert.Equal(t, "Host mine\nHost later\n", readTarget())
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		assert.Empty(t, stateFile.Files)
+	})
+
+	t.Run("generators sharing a target must all merge", func(t *testing.T) {
+		mixed := append([]config.ModuleConfig(nil), modules...)
+		mixed[1].Generators = []config.GeneratorConfig{{Target: "config", Command: []string{"echo"}}}
+
+		result, err := Validate(mixed, nil, false, false)
+		require.NoError(t, err)
+		assert.False(t, result.IsValid)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "generators sharing a target must all set merge")
+	})
+}
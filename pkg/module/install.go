@@ -1,6 +1,8 @@
 package module
 
 import (
+	"fmt"
+
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"github.com/elmhuangyu/dotman/pkg/module/state"
@@ -14,7 +16,103 @@ type InstallResult struct {
 	Errors           []string
 	CreatedLinks     []FileOperation
 	CreatedTemplates []FileOperation
+	CreatedGenerated []FileOperation
+	// CopiedFiles are files copied instead of linked because their target is on another filesystem
+	CopiedFiles  []FileOperation
+	SkippedLinks []FileOperation
+	// ResumedOperations are operations left out by Resume because their target was already done
+	ResumedOperations []FileOperation
+	// SkippedTemplates are templates left out by SkipFailedTemplates, with the error as their Description
+	SkippedTemplates []FileOperation
+	// CreatedDirs are empty directories created for keep files
+	CreatedDirs []FileOperation
+	// MergedBlocks are blocks of generator output added to or changed in shared files
+	MergedBlocks []FileOperation
+	// ExcludedOperations are operations skipped because their target matches ExcludeTargets
+	ExcludedOperations []FileOperation
+	// Backups are the backup paths of existing targets replaced with Force
+	Backups []string
+	// FailedOperations are operations that could not be completed
+	FailedOperations []FileOperation
+	// Warnings are problems found by VerifyOnSkip and state file saves that failed; they don't
+	// fail the installation
+	Warnings []string
+	// StateNotSaved is set when the state file could not be written, leaving the applied
+	// operations untracked
+	StateNotSaved bool
+	// NoChanges is set when a successful installation created, copied, generated and replaced
+	// nothing because every target was already in place
+	NoChanges bool
+	// RolledBack is set when a transactional install failed and its applied operations were undone;
+	// with KeepGoing only the failed modules are undone
+	RolledBack bool
+	// Modules breaks the outcome down by module name; nil when installation stopped before any operation ran
+	Modules map[string]ModuleResult
+	// Per-module outcome, only populated when installing with KeepGoing
+	InstalledModules []string
+	FailedModules    []string
+	ModuleErrors     map[string][]string
+
+	// undo records applied operations of a transactional install; nil otherwise
+	undo *undoLog
+}
+
+// ModuleResult contains the outcome of installing a single module
+type ModuleResult struct {
+	IsSuccess        bool
+	Errors           []string
+	CreatedLinks     []FileOperation
+	CreatedTemplates []FileOperation
+	CreatedGenerated []FileOperation
+	CopiedFiles      []FileOperation
 	SkippedLinks     []FileOperation
+	CreatedDirs      []FileOperation
+	MergedBlocks     []FileOperation
+	// ExcludedOperations are operations skipped because their target matches ExcludeTargets
+	ExcludedOperations []FileOperation
+	SkippedTemplates   []FileOperation
+	FailedOperations   []FileOperation
+}
+
+// changed reports whether the installation modified any target
+func (r *InstallResult) changed() bool {
+	return len(r.CreatedLinks) > 0 || len(r.CopiedFiles) > 0 || len(r.CreatedTemplates) > 0 || len(r.CreatedGenerated) > 0 || len(r.CreatedDirs) > 0 || len(r.MergedBlocks) > 0 || len(r.Backups) > 0
+}
+
+// resumedSummary is the summary suffix of an installation that resumed already done operations
+func (r *InstallResult) resumedSummary() string {
+	if len(r.ResumedOperations) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d already done", len(r.ResumedOperations))
+}
+
+// skippedTemplatesSummary is the summary suffix of an installation that left out failed templates
+func (r *InstallResult) skippedTemplatesSummary() string {
+	if len(r.SkippedTemplates) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d failed templates skipped", len(r.SkippedTemplates))
+}
+
+// OneLine returns a stable, grep-friendly summary line of the installation
+func (r *InstallResult) OneLine() string {
+	return fmt.Sprintf("dotman install: created=%d copied=%d templates=%d generated=%d skipped=%d errors=%d backups=%d",
+		len(r.CreatedLinks), len(r.CopiedFiles), len(r.CreatedTemplates), len(r.CreatedGenerated), len(r.SkippedLinks), len(r.Errors), len(r.Backups))
+}
+
+// ExitStateNotSaved is the exit code of an installation that succeeded without saving its state file
+const ExitStateNotSaved = 3
+
+// ExitCode maps the result to ExitClean, ExitErrors or ExitStateNotSaved
+func (r *InstallResult) ExitCode() int {
+	switch {
+	case !r.IsSuccess:
+		return ExitErrors
+	case r.StateNotSaved:
+		return ExitStateNotSaved
+	}
+	return ExitClean
 }
 
 // Install performs the actual installation of dotfiles by creating symlinks and generating template files
@@ -33,7 +131,11 @@ func Install(modules []config.ModuleConfig, rootVars map[string]string, mkdir bo
 func InstallWithConfig(modules []config.ModuleConfig, config *InstallConfig) (*InstallResult, error) {
 	// Initialize dependencies
 	fileOp := filesystem.NewOperator()
-	templateRenderer := template.NewRenderer()
+	paths := config.ModulePaths
+	if paths == nil {
+		paths = ModulePaths(modules)
+	}
+	templateRenderer := template.NewRendererWithModules(paths)
 	stateMgr := state.NewStateManager()
 
 	// Create installer
@@ -41,11 +143,34 @@ func InstallWithConfig(modules []config.ModuleConfig, config *InstallConfig) (*I
 
 	// Create install request
 	req := &InstallRequest{
-		Modules:     modules,
-		RootVars:    config.Vars,
-		Mkdir:       config.Mkdir,
-		Force:       config.Force,
-		DotfilesDir: config.StatePath,
+		Modules:             modules,
+		RootVars:            config.Vars,
+		Mkdir:               config.Mkdir,
+		Force:               config.Force,
+		DotfilesDir:         config.StatePath,
+		KeepGoing:           config.KeepGoing,
+		MaxBackups:          config.MaxBackups,
+		CompressBackups:     config.CompressBackups,
+		TimestampBackups:    config.TimestampBackups,
+		BackupSuffix:        config.BackupSuffix,
+		MkdirAllowedRoots:   config.MkdirAllowedRoots,
+		StrictTargetDirs:    config.StrictTargetDirs,
+		KeepDangling:        config.KeepDangling,
+		PreflightTemplates:  config.PreflightTemplates,
+		SkipFailedTemplates: config.SkipFailedTemplates,
+		CheckWritable:       config.CheckWritable,
+		Transactional:       config.Transactional,
+		LinkMode:            config.LinkMode,
+		Profile:             config.Profile,
+		PrivilegedCmd:       config.PrivilegedCmd,
+		ExcludeTargets:      config.ExcludeTargets,
+		VerifyOnSkip:        config.VerifyOnSkip,
+		RecordVars:          config.RecordVars,
+		Resume:              config.Resume,
+		ShowDiff:            config.ShowDiff,
+		ModulePaths:         paths,
+		Context:             config.Context,
+		Logger:              config.Logger,
 	}
 
 	// Perform installation
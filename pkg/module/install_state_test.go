@@ -155,3 +155,96 @@ func TestInstallWithStateLogging(t *testing.T) {
 		}
 	})
 }
+
+func TestInstallReappliesRecordedMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "netrc.dot-tmpl"), []byte("machine {{.HOST}}"), 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+	vars := map[string]string{"HOST": "example.com"}
+	target := filepath.Join(targetDir, "netrc")
+	statePath := filepath.Join(tmpDir, "state.yaml")
+
+	result, err := Install(modules, vars, false, false, tmpDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	// The recorded mode is the intended one, e.g. tightened after the first install
+	stateFile, err := state.LoadStateFile(statePath)
+	require.NoError(t, err)
+	require.Len(t, stateFile.Files, 1)
+	stateFile.Files[0].Mode = "0600"
+	require.NoError(t, state.SaveStateFile(statePath, stateFile))
+
+	// A user chmod of the target is not kept on reinstall
+	require.NoError(t, os.Chmod(target, 0666))
+	result, err = Install(modules, vars, false, true, tmpDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	info, err := os.Stat(target)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestInstallKeepFileDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	targetDir := filepath.Join(tmpDir, "target")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "cache", "empty"), 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "cache", "empty", ".keep"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "rc"), []byte("rc"), 0644))
+	modules := []config.ModuleConfig{{Dir: sourceDir, TargetDir: targetDir}}
+	emptyDir := filepath.Join(targetDir, "cache", "empty")
+
+	result, err := Install(modules, map[string]string{}, true, false, tmpDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+	assert.Len(t, result.CreatedLinks, 1)
+	require.Len(t, result.CreatedDirs, 1)
+	assert.Equal(t, emptyDir, result.CreatedDirs[0].Target)
+
+	// The directory is created for real and the placeholder is not linked
+	info, err := os.Lstat(emptyDir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.NoFileExists(t, filepath.Join(emptyDir, ".keep"))
+
+	stateFile, err := state.LoadStateFile(filepath.Join(tmpDir, "state.yaml"))
+	require.NoError(t, err)
+	types := make(map[string]string)
+	for _, file := range stateFile.Files {
+		types[file.Target] = file.Type
+	}
+	assert.Equal(t, state.TypeDir, types[emptyDir])
+
+	t.Run("uninstall keeps directories that are not empty", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(emptyDir, "data"), nil, 0644))
+
+		result, err := Uninstall(tmpDir)
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Empty(t, result.RemovedDirs)
+		require.Len(t, result.SkippedDirs, 1)
+		assert.Equal(t, ReasonNotEmpty, result.SkippedDirs[0].ReasonCode)
+		assert.DirExists(t, emptyDir)
+	})
+
+	t.Run("uninstall removes empty directories", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(emptyDir, "data")))
+		result, err := Install(modules, map[string]string{}, true, false, tmpDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		uninstallResult, err := Uninstall(tmpDir)
+		require.NoError(t, err)
+		assert.True(t, uninstallResult.IsSuccess, uninstallResult.Errors)
+		assert.Len(t, uninstallResult.RemovedDirs, 1)
+		assert.NoDirExists(t, emptyDir)
+		assert.DirExists(t, filepath.Join(targetDir, "cache"))
+	})
+}
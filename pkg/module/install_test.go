@@ -1,11 +1,16 @@
 package module
 
 import (
+	"context"
+	"crypto/sha1"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
 	"github.com/elmhuangyu/dotman/pkg/state"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -218,6 +223,7 @@ func TestInstallForceMode(t *testing.T) {
 		// Verify backup files exist
 		backupFile1 := filepath.Join(targetDir, "file1.txt.bak")
 		backupFile2 := filepath.Join(targetDir, "file2.txt.bak")
+		assert.ElementsMatch(t, []string{backupFile1, backupFile2}, result.Backups)
 		assert.FileExists(t, backupFile1)
 		assert.FileExists(t, backupFile2)
 
@@ -451,3 +457,1247 @@ func TestInstallTemplateFiles(t *testing.T) {
 		assert.Equal(t, "User: testuser, Home: /home/testuser", string(content))
 	})
 }
+
+func TestInstallWithInRepoSymlinkSource(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	sharedDir := filepath.Join(dotfilesDir, "shared")
+	moduleDir := filepath.Join(dotfilesDir, "module")
+	targetDir := filepath.Join(tempDir, "target")
+
+	require.NoError(t, os.MkdirAll(sharedDir, 0755))
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	sharedFile := filepath.Join(sharedDir, "common.conf")
+	require.NoError(t, os.WriteFile(sharedFile, []byte("shared"), 0644))
+
+	// Module file is a relative symlink into another part of the repo
+	sourceLink := filepath.Join(moduleDir, "common.conf")
+	require.NoError(t, os.Symlink("../shared/common.conf", sourceLink))
+
+	modules := []config.ModuleConfig{
+		{Dir: moduleDir, TargetDir: targetDir},
+	}
+	targetFile := filepath.Join(targetDir, "common.conf")
+
+	// Install links the target to the resolved destination
+	result, err := Install(modules, map[string]string{}, false, false, dotfilesDir)
+	require.NoError(t, err)
+	assert.True(t, result.IsSuccess)
+	assert.Len(t, result.CreatedLinks, 1)
+
+	linkTarget, err := os.Readlink(targetFile)
+	require.NoError(t, err)
+	assert.Equal(t, sharedFile, linkTarget)
+
+	// A second validation sees the link as correct
+	validation, err := Validate(modules, map[string]string{}, false, false)
+	require.NoError(t, err)
+	assert.True(t, validation.IsValid)
+	assert.Len(t, validation.SkipOperations, 1)
+	assert.Empty(t, validation.ForceLinkOperations)
+
+	// Uninstall recognizes the resolved link as ours and removes it
+	uninstallResult, err := Uninstall(dotfilesDir)
+	require.NoError(t, err)
+	assert.True(t, uninstallResult.IsSuccess)
+	assert.Len(t, uninstallResult.RemovedLinks, 1)
+	assert.Empty(t, uninstallResult.SkippedLinks)
+	assert.NoFileExists(t, targetFile)
+	assert.FileExists(t, sharedFile)
+}
+
+func TestInstallKeepGoing(t *testing.T) {
+	// setup creates modules a, b, c and d; b targets a missing directory and d depends on b
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+		var modules []config.ModuleConfig
+		for _, name := range []string{"a", "b", "c", "d"} {
+			moduleDir := filepath.Join(dotfilesDir, name)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name+".conf"), []byte(name), 0644))
+			modules = append(modules, config.ModuleConfig{Dir: moduleDir, TargetDir: targetDir})
+		}
+		modules[1].TargetDir = filepath.Join(tempDir, "missing")
+		modules[3].DependsOn = []string{"b"}
+
+		return dotfilesDir, targetDir, modules
+	}
+
+	t.Run("failing module does not stop the others", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, KeepGoing: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Equal(t, []string{"a", "c"}, result.InstalledModules)
+		assert.Equal(t, []string{"b", "d"}, result.FailedModules)
+		require.Contains(t, result.ModuleErrors, "b")
+		assert.Contains(t, result.ModuleErrors["b"][0], "target directory does not exist")
+		assert.Equal(t, []string{"skipped because dependency b failed"}, result.ModuleErrors["d"])
+		assert.Len(t, result.Errors, 2)
+		assert.Contains(t, result.Summary, "2 of 4 modules failed (b, d)")
+
+		// Successful modules were installed and recorded
+		assert.Len(t, result.CreatedLinks, 2)
+		for _, name := range []string{"a", "c"} {
+			dest, err := os.Readlink(filepath.Join(targetDir, name+".conf"))
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dotfilesDir, name, name+".conf"), dest)
+		}
+		assert.NoFileExists(t, filepath.Join(targetDir, "d.conf"))
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, stateFile.Files, 2)
+	})
+
+	t.Run("without keep-going nothing is installed", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Empty(t, result.CreatedLinks)
+		assert.Empty(t, result.FailedModules)
+		assert.NoFileExists(t, filepath.Join(targetDir, "a.conf"))
+	})
+
+	t.Run("all modules succeed", func(t *testing.T) {
+		dotfilesDir, _, modules := setup(t)
+		modules[1].TargetDir = modules[0].TargetDir
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, KeepGoing: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Equal(t, []string{"a", "b", "c", "d"}, result.InstalledModules)
+		assert.Empty(t, result.FailedModules)
+		assert.Contains(t, result.Summary, "4 modules installed")
+	})
+
+	t.Run("cross-module conflicts fail the whole installation", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+		modules[1].TargetDir = targetDir
+		require.NoError(t, os.WriteFile(filepath.Join(modules[2].Dir, "a.conf"), []byte("c"), 0644))
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, KeepGoing: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Empty(t, result.CreatedLinks)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "target conflict")
+	})
+}
+
+func TestInstallDirMode(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	moduleDir := filepath.Join(dotfilesDir, "gnupg")
+	homeDir := filepath.Join(tempDir, "home")
+	targetDir := filepath.Join(homeDir, ".gnupg")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "private-keys-v1.d"), 0755))
+	require.NoError(t, os.MkdirAll(homeDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "gpg.conf"), []byte("use-agent"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "private-keys-v1.d", "README.dot-tmpl"), []byte("keys"), 0644))
+
+	modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir, DirMode: 0700}}
+
+	result, err := Install(modules, map[string]string{}, true, false, dotfilesDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	// Directories created for the module get its dir_mode
+	for _, dir := range []string{targetDir, filepath.Join(targetDir, "private-keys-v1.d")} {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), dir)
+	}
+
+	// Pre-existing directories are left alone
+	info, err := os.Stat(homeDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm()&0755)
+
+	// Uninstall removes the installed files regardless of the directory mode
+	uninstallResult, err := Uninstall(dotfilesDir)
+	require.NoError(t, err)
+	assert.True(t, uninstallResult.IsSuccess)
+	assert.NoFileExists(t, filepath.Join(targetDir, "gpg.conf"))
+	assert.NoFileExists(t, filepath.Join(targetDir, "private-keys-v1.d", "README"))
+}
+
+func TestInstallModuleResults(t *testing.T) {
+	// setup creates an nvim module with a link and a template, and a tmux module with a link
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		targetDir := filepath.Join(tempDir, "home")
+		nvimDir := filepath.Join(dotfilesDir, "nvim")
+		tmuxDir := filepath.Join(dotfilesDir, "tmux")
+		require.NoError(t, os.MkdirAll(nvimDir, 0755))
+		require.NoError(t, os.MkdirAll(tmuxDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(nvimDir, "init.lua"), []byte("-- nvim"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(nvimDir, "theme.lua.dot-tmpl"), []byte("-- theme"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(tmuxDir, "tmux.conf"), []byte("# tmux"), 0644))
+
+		modules := []config.ModuleConfig{
+			{Dir: nvimDir, TargetDir: targetDir},
+			{Dir: tmuxDir, TargetDir: targetDir},
+		}
+		return dotfilesDir, tempDir, modules
+	}
+
+	t.Run("operations are grouped by module", func(t *testing.T) {
+		dotfilesDir, _, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.Modules, 2)
+
+		nvim := result.Modules["nvim"]
+		assert.True(t, nvim.IsSuccess)
+		require.Len(t, nvim.CreatedLinks, 1)
+		assert.Equal(t, filepath.Join(modules[0].Dir, "init.lua"), nvim.CreatedLinks[0].Source)
+		require.Len(t, nvim.CreatedTemplates, 1)
+		assert.Equal(t, "nvim", nvim.CreatedTemplates[0].Module)
+		assert.Empty(t, nvim.Errors)
+
+		tmux := result.Modules["tmux"]
+		assert.True(t, tmux.IsSuccess)
+		require.Len(t, tmux.CreatedLinks, 1)
+		assert.Equal(t, filepath.Join(modules[1].Dir, "tmux.conf"), tmux.CreatedLinks[0].Source)
+		assert.Empty(t, tmux.CreatedTemplates)
+	})
+
+	t.Run("skipped links are attributed to their module", func(t *testing.T) {
+		dotfilesDir, _, modules := setup(t)
+		_, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(filepath.Join(modules[0].TargetDir, "theme.lua")))
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.Modules["nvim"].SkippedLinks, 1)
+		assert.Len(t, result.Modules["nvim"].CreatedTemplates, 1)
+		assert.Len(t, result.Modules["tmux"].SkippedLinks, 1)
+		assert.Empty(t, result.Modules["tmux"].CreatedLinks)
+	})
+
+	t.Run("failed module is reported with keep-going", func(t *testing.T) {
+		dotfilesDir, tempDir, modules := setup(t)
+		modules[1].TargetDir = filepath.Join(tempDir, "missing")
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, KeepGoing: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Modules, 2)
+
+		nvim := result.Modules["nvim"]
+		assert.True(t, nvim.IsSuccess)
+		assert.Len(t, nvim.CreatedLinks, 1)
+		assert.Len(t, nvim.CreatedTemplates, 1)
+
+		tmux := result.Modules["tmux"]
+		assert.False(t, tmux.IsSuccess)
+		assert.Empty(t, tmux.CreatedLinks)
+		require.Len(t, tmux.Errors, 1)
+		assert.Contains(t, tmux.Errors[0], "target directory does not exist")
+	})
+
+	t.Run("validation failures leave the breakdown empty", func(t *testing.T) {
+		dotfilesDir, tempDir, modules := setup(t)
+		modules[1].TargetDir = filepath.Join(tempDir, "missing")
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Nil(t, result.Modules)
+	})
+}
+
+func TestInstallModuleVars(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	var modules []config.ModuleConfig
+	for _, name := range []string{"work", "personal"} {
+		moduleDir := filepath.Join(dotfilesDir, name)
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name+".conf.dot-tmpl"), []byte("{{.USER}} <{{.EMAIL}}>"), 0644))
+		modules = append(modules, config.ModuleConfig{Dir: moduleDir, TargetDir: targetDir})
+	}
+	// Module vars override root vars for that module only
+	modules[0].Vars = map[string]string{"EMAIL": "alice@work.example"}
+
+	result, err := Install(modules, map[string]string{"USER": "alice", "EMAIL": "alice@home.example"}, false, false, dotfilesDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	content, err := os.ReadFile(filepath.Join(targetDir, "work.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice <alice@work.example>", string(content))
+	content, err = os.ReadFile(filepath.Join(targetDir, "personal.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice <alice@home.example>", string(content))
+}
+
+func TestInstallModulePaths(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep going %v", keepGoing), func(t *testing.T) {
+			tempDir := t.TempDir()
+			dotfilesDir := filepath.Join(tempDir, "dotfiles")
+			homeDir := filepath.Join(tempDir, "home")
+			nvimTarget := filepath.Join(homeDir, ".config", "nvim")
+			require.NoError(t, os.MkdirAll(nvimTarget, 0755))
+
+			nvimDir := filepath.Join(dotfilesDir, "nvim")
+			shellDir := filepath.Join(dotfilesDir, "shell")
+			require.NoError(t, os.MkdirAll(nvimDir, 0755))
+			require.NoError(t, os.MkdirAll(shellDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(nvimDir, "init.lua"), []byte("-- nvim"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(shellDir, "zshrc.dot-tmpl"), []byte("export MYVIMRC={{.Modules.nvim.TargetDir}}/init.lua"), 0644))
+			modules := []config.ModuleConfig{
+				{Dir: shellDir, TargetDir: homeDir},
+				{Dir: nvimDir, TargetDir: nvimTarget},
+			}
+
+			result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, KeepGoing: keepGoing})
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+
+			content, err := os.ReadFile(filepath.Join(homeDir, "zshrc"))
+			require.NoError(t, err)
+			assert.Equal(t, "export MYVIMRC="+nvimTarget+"/init.lua", string(content))
+		})
+	}
+}
+
+func TestInstallPerFileVars(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	moduleDir := filepath.Join(dotfilesDir, "nvim")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	for _, name := range []string{"init.lua.dot-tmpl", "plugins.lua.dot-tmpl"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte("{{.USER}}: {{.THEME}}"), 0644))
+	}
+	// The per-file vars override the module var for init.lua only
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "init.lua.vars.yaml"), []byte("THEME: solarized\n"), 0644))
+	// A vars file without a sibling template is an ordinary file
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "colors.vars.yaml"), []byte("RED: ff0000\n"), 0644))
+
+	modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir, Vars: map[string]string{"THEME": "dark"}}}
+	result, err := Install(modules, map[string]string{"USER": "alice", "THEME": "light"}, false, false, dotfilesDir)
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	content, err := os.ReadFile(filepath.Join(targetDir, "init.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice: solarized", string(content))
+	content, err = os.ReadFile(filepath.Join(targetDir, "plugins.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice: dark", string(content))
+
+	// The per-file vars are not mapped to a target
+	assert.NoFileExists(t, filepath.Join(targetDir, "init.lua.vars.yaml"))
+	assert.FileExists(t, filepath.Join(targetDir, "colors.vars.yaml"))
+
+	t.Run("invalid per-file vars fail validation", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "plugins.lua.vars.yaml"), []byte("bad-key: x\n"), 0644))
+
+		validation, err := Validate(modules, map[string]string{"USER": "alice"}, false, true)
+		require.NoError(t, err)
+		assert.False(t, validation.IsValid)
+		require.Len(t, validation.Errors, 1)
+		assert.Contains(t, validation.Errors[0], "vars key 'bad-key'")
+	})
+}
+
+func TestInstallMkdirAllowedRoots(t *testing.T) {
+	// setup creates a module with a nested file targeting a missing directory
+	setup := func(t *testing.T) (string, string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "nvim")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "lua"), 0755))
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "lua", "init.lua"), []byte("-- nvim"), 0644))
+
+		return tempDir, dotfilesDir, homeDir, []config.ModuleConfig{{Dir: moduleDir, TargetDir: filepath.Join(homeDir, ".config", "nvim")}}
+	}
+
+	t.Run("creates directories under an allowed root", func(t *testing.T) {
+		_, dotfilesDir, homeDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, MkdirAllowedRoots: []string{homeDir}})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.FileExists(t, filepath.Join(homeDir, ".config", "nvim", "lua", "init.lua"))
+	})
+
+	t.Run("refuses to create directories outside the allowed roots", func(t *testing.T) {
+		tempDir, dotfilesDir, homeDir, modules := setup(t)
+		allowed := []string{filepath.Join(tempDir, "elsewhere")}
+
+		validation, err := ValidateWithConfig(modules, &ValidateConfig{Mkdir: true, MkdirAllowedRoots: allowed})
+		require.NoError(t, err)
+		assert.False(t, validation.IsValid)
+		require.Len(t, validation.Errors, 1)
+		assert.Equal(t, fmt.Sprintf("mkdir would create %s outside mkdir_allowed_roots", filepath.Join(homeDir, ".config", "nvim", "lua")), validation.Errors[0])
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, MkdirAllowedRoots: allowed})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.NoDirExists(t, filepath.Join(homeDir, ".config"))
+	})
+
+	t.Run("existing directories outside the roots are fine", func(t *testing.T) {
+		tempDir, dotfilesDir, homeDir, modules := setup(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(homeDir, ".config", "nvim", "lua"), 0755))
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, MkdirAllowedRoots: []string{filepath.Join(tempDir, "elsewhere")}})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+	})
+
+	t.Run("no roots allow everything", func(t *testing.T) {
+		_, dotfilesDir, homeDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.FileExists(t, filepath.Join(homeDir, ".config", "nvim", "lua", "init.lua"))
+	})
+}
+
+func TestInstallPreflightTemplates(t *testing.T) {
+	// setup creates a module with a link and six templates; rendering the fifth fails
+	setup := func(t *testing.T) (string, string, *Installer, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "app")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "linked.conf"), []byte("linked"), 0644))
+		for n := 1; n <= 6; n++ {
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, fmt.Sprintf("t%d.conf.dot-tmpl", n)), []byte("{{.USER}}"), 0644))
+		}
+
+		renderer := &MockTemplateRenderer{
+			RenderFunc: func(templatePath string, vars map[string]string) ([]byte, error) {
+				if filepath.Base(templatePath) == "t5.conf.dot-tmpl" {
+					return nil, fmt.Errorf("template changed since validation")
+				}
+				return []byte(vars["USER"]), nil
+			},
+		}
+		installer := NewInstaller(filesystem.NewOperator(), renderer, &stateManagerAdapter{})
+
+		return dotfilesDir, targetDir, installer, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("failing template aborts before anything is written", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{
+			Modules:            modules,
+			RootVars:           map[string]string{"USER": "alice"},
+			DotfilesDir:        dotfilesDir,
+			PreflightTemplates: true,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "t5.conf.dot-tmpl")
+		assert.Contains(t, result.Summary, "1 templates failed to render")
+		require.Len(t, result.FailedOperations, 1)
+
+		entries, err := os.ReadDir(targetDir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+	})
+
+	t.Run("without preflight earlier files are written", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{
+			Modules:     modules,
+			RootVars:    map[string]string{"USER": "alice"},
+			DotfilesDir: dotfilesDir,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.FileExists(t, filepath.Join(targetDir, "linked.conf"))
+	})
+
+	t.Run("preflighted content is written", func(t *testing.T) {
+		dotfilesDir, targetDir, _, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{
+			Vars:               map[string]string{"USER": "alice"},
+			StatePath:          dotfilesDir,
+			PreflightTemplates: true,
+		})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.CreatedTemplates, 6)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "t5.conf"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", string(content))
+	})
+}
+
+func TestInstallSkipFailedTemplates(t *testing.T) {
+	// setup creates a module with a link, a good template and a template with a syntax error
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "app")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "linked.conf"), []byte("linked"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "good.conf.dot-tmpl"), []byte("{{.USER}}"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "bad.conf.dot-tmpl"), []byte("{{.USER"), 0644))
+		return dotfilesDir, targetDir, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("a bad template is skipped and the rest of the module installed", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{
+			Vars:                map[string]string{"USER": "alice"},
+			StatePath:           dotfilesDir,
+			SkipFailedTemplates: true,
+		})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.SkippedTemplates, 1)
+		assert.Equal(t, filepath.Join(targetDir, "bad.conf"), result.SkippedTemplates[0].Target)
+		assert.Contains(t, result.SkippedTemplates[0].Description, "template error")
+		assert.Len(t, result.Modules["app"].SkippedTemplates, 1)
+		require.Len(t, result.Warnings, 1)
+		assert.Contains(t, result.Summary, "1 failed templates skipped")
+
+		assert.Len(t, result.CreatedLinks, 1)
+		require.Len(t, result.CreatedTemplates, 1)
+		content, err := os.ReadFile(filepath.Join(targetDir, "good.conf"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", string(content))
+		assert.NoFileExists(t, filepath.Join(targetDir, "bad.conf"))
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, stateFile.Files, 2)
+	})
+
+	t.Run("without the option the bad template fails the installation", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{
+			Vars:      map[string]string{"USER": "alice"},
+			StatePath: dotfilesDir,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Empty(t, result.SkippedTemplates)
+		assert.NoFileExists(t, filepath.Join(targetDir, "good.conf"))
+	})
+
+	t.Run("a template failing to render after validation is skipped", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+		require.NoError(t, os.Remove(filepath.Join(modules[0].Dir, "bad.conf.dot-tmpl")))
+
+		renderer := &MockTemplateRenderer{
+			RenderFunc: func(templatePath string, vars map[string]string) ([]byte, error) {
+				return nil, fmt.Errorf("template changed since validation")
+			},
+		}
+		installer := NewInstaller(filesystem.NewOperator(), renderer, &stateManagerAdapter{})
+		result, err := installer.Install(&InstallRequest{
+			Modules:             modules,
+			RootVars:            map[string]string{"USER": "alice"},
+			DotfilesDir:         dotfilesDir,
+			SkipFailedTemplates: true,
+			PreflightTemplates:  true,
+		})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.SkippedTemplates, 1)
+		assert.Contains(t, result.SkippedTemplates[0].Description, "template changed since validation")
+		assert.Empty(t, result.CreatedTemplates)
+		assert.FileExists(t, filepath.Join(targetDir, "linked.conf"))
+	})
+}
+
+func TestInstallLinkModeAuto(t *testing.T) {
+	// setup creates a module with one file and an installer whose device check reports sameDevice
+	setup := func(t *testing.T, sameDevice bool) (string, string, *Installer, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "bashrc"), []byte("export EDITOR=vim"), 0644))
+
+		installer := NewInstaller(filesystem.NewOperator(), template.NewRenderer(), &stateManagerAdapter{})
+		installer.sameDevice = func(source, target string) (bool, error) {
+			return sameDevice, nil
+		}
+		return dotfilesDir, targetDir, installer, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("same filesystem links", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t, true)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, LinkMode: LinkModeAuto})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.CreatedLinks, 1)
+		assert.Empty(t, result.CopiedFiles)
+
+		dest, err := os.Readlink(filepath.Join(targetDir, "bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(modules[0].Dir, "bashrc"), dest)
+	})
+
+	t.Run("another filesystem copies", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t, false)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, LinkMode: LinkModeAuto})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Empty(t, result.CreatedLinks)
+		require.Len(t, result.CopiedFiles, 1)
+		assert.Len(t, result.Modules["shell"].CopiedFiles, 1)
+
+		target := filepath.Join(targetDir, "bashrc")
+		info, err := os.Lstat(target)
+		require.NoError(t, err)
+		assert.True(t, info.Mode().IsRegular())
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "export EDITOR=vim", string(content))
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, state.TypeCopy, stateFile.Files[0].Type)
+		assert.NotEmpty(t, stateFile.Files[0].SHA1)
+
+		// Uninstall removes the unmodified copy
+		uninstallResult, err := NewUninstaller(filesystem.NewOperator(), &stateManagerAdapter{}).Uninstall(&UninstallRequest{DotfilesDir: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, uninstallResult.IsSuccess, uninstallResult.Errors)
+		assert.NoFileExists(t, target)
+	})
+
+	t.Run("force replaces an existing file with a copy", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t, false)
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "bashrc"), []byte("old"), 0644))
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Force: true, LinkMode: LinkModeAuto})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.CopiedFiles, 1)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "export EDITOR=vim", string(content))
+		assert.FileExists(t, filepath.Join(targetDir, "bashrc.bak"))
+	})
+
+	t.Run("symlink mode never copies", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t, false)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.CreatedLinks, 1)
+		assert.Empty(t, result.CopiedFiles)
+
+		info, err := os.Lstat(filepath.Join(targetDir, "bashrc"))
+		require.NoError(t, err)
+		assert.NotZero(t, info.Mode()&os.ModeSymlink)
+	})
+}
+
+// cancellingOperator is the real file operator, cancelling a context once it has created cancelAfter symlinks
+type cancellingOperator struct {
+	filesystem.FileOperator
+	cancel      context.CancelFunc
+	cancelAfter int
+	created     int
+}
+
+func (o *cancellingOperator) CreateSymlink(source, target string) error {
+	if err := o.FileOperator.CreateSymlink(source, target); err != nil {
+		return err
+	}
+	o.created++
+	if o.created == o.cancelAfter {
+		o.cancel()
+	}
+	return nil
+}
+
+func TestInstallCancelled(t *testing.T) {
+	// setup creates a module with files a to d, linked in that order, and cancels after the second link
+	setup := func(t *testing.T) (string, string, *Installer, context.Context, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		for _, name := range []string{"a", "b", "c", "d"} {
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte(name), 0644))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		fileOp := &cancellingOperator{FileOperator: filesystem.NewOperator(), cancel: cancel, cancelAfter: 2}
+		installer := NewInstaller(fileOp, template.NewRenderer(), &stateManagerAdapter{})
+		return dotfilesDir, targetDir, installer, ctx, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("remaining operations do not run", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, ctx, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Context: ctx})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotNil(t, result)
+		assert.False(t, result.IsSuccess)
+		assert.Contains(t, result.Summary, "Installation cancelled")
+		assert.Len(t, result.CreatedLinks, 2)
+
+		for _, name := range []string{"a", "b"} {
+			_, err := os.Readlink(filepath.Join(targetDir, name))
+			assert.NoError(t, err, name)
+		}
+		for _, name := range []string{"c", "d"} {
+			_, err := os.Lstat(filepath.Join(targetDir, name))
+			assert.True(t, os.IsNotExist(err), name)
+		}
+
+		// The state file records what was applied before the cancellation
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, stateFile.Files, 2)
+	})
+
+	t.Run("transactional install is rolled back", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, ctx, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Transactional: true, Context: ctx})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.True(t, result.RolledBack)
+		for _, name := range []string{"a", "b", "c", "d"} {
+			_, err := os.Lstat(filepath.Join(targetDir, name))
+			assert.True(t, os.IsNotExist(err), name)
+		}
+	})
+
+	t.Run("keep going stops before the remaining modules", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, ctx, modules := setup(t)
+		otherDir := filepath.Join(dotfilesDir, "other")
+		require.NoError(t, os.MkdirAll(otherDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(otherDir, "z"), []byte("z"), 0644))
+		modules = append(modules, config.ModuleConfig{Dir: otherDir, TargetDir: targetDir, DependsOn: []string{"shell"}})
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, KeepGoing: true, Context: ctx})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Contains(t, result.Summary, "Installation cancelled")
+		assert.Empty(t, result.InstalledModules)
+		assert.NoFileExists(t, filepath.Join(targetDir, "z"))
+	})
+
+	t.Run("already cancelled context fails validation", func(t *testing.T) {
+		_, _, _, _, modules := setup(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := ValidateWithConfig(modules, &ValidateConfig{Context: ctx})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestInstallResultOneLine(t *testing.T) {
+	ops := func(n int) []FileOperation {
+		return make([]FileOperation, n)
+	}
+
+	tests := []struct {
+		name   string
+		result InstallResult
+		want   string
+	}{
+		{
+			name:   "empty result",
+			result: InstallResult{IsSuccess: true},
+			want:   "dotman install: created=0 copied=0 templates=0 generated=0 skipped=0 errors=0 backups=0",
+		},
+		{
+			name: "successful forced install",
+			result: InstallResult{
+				IsSuccess:        true,
+				CreatedLinks:     ops(5),
+				CreatedTemplates: ops(1),
+				SkippedLinks:     ops(2),
+				Backups:          []string{"/home/user/.bashrc.bak", "/home/user/.profile.bak", "/home/user/.zshrc.bak"},
+			},
+			want: "dotman install: created=5 copied=0 templates=1 generated=0 skipped=2 errors=0 backups=3",
+		},
+		{
+			name: "failed install",
+			result: InstallResult{
+				CreatedLinks:     ops(1),
+				CopiedFiles:      ops(2),
+				CreatedGenerated: ops(1),
+				Errors:           []string{"failed to create symlink", "failed to generate file"},
+			},
+			want: "dotman install: created=1 copied=2 templates=0 generated=1 skipped=0 errors=2 backups=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.result.OneLine())
+		})
+	}
+}
+
+func TestInstallNoChanges(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	moduleDir := filepath.Join(dotfilesDir, "zsh")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "zshrc"), []byte("# zsh"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "zshenv"), []byte("# env"), 0644))
+	modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+
+	for _, keepGoing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep-going=%v", keepGoing), func(t *testing.T) {
+			installConfig := &InstallConfig{StatePath: dotfilesDir, KeepGoing: keepGoing}
+			require.NoError(t, os.RemoveAll(filepath.Join(targetDir, "zshrc")))
+			require.NoError(t, os.RemoveAll(filepath.Join(targetDir, "zshenv")))
+
+			result, err := InstallWithConfig(modules, installConfig)
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+			assert.False(t, result.NoChanges, "first install creates links")
+
+			result, err = InstallWithConfig(modules, installConfig)
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+			assert.True(t, result.NoChanges, "reinstalling an installed setup changes nothing")
+			assert.Len(t, result.SkippedLinks, 2)
+
+			// A single missing link is a change
+			require.NoError(t, os.Remove(filepath.Join(targetDir, "zshrc")))
+			result, err = InstallWithConfig(modules, installConfig)
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+			assert.False(t, result.NoChanges)
+		})
+	}
+
+	t.Run("failed install is not a no-op", func(t *testing.T) {
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		require.NoError(t, os.Remove(filepath.Join(targetDir, "zshrc")))
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "zshrc"), []byte("# local"), 0644))
+		result, err = InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.False(t, result.NoChanges)
+	})
+}
+
+func TestInstallExcludeTargets(t *testing.T) {
+	// setup creates a module with a private subtree and two other files
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "app")
+		targetDir := filepath.Join(tempDir, "home", ".config", "app")
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "private"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "public"), 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		for _, name := range []string{"private/secret", "public/rc", "config.toml"} {
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte(name), 0644))
+		}
+		return dotfilesDir, targetDir, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("skips a target subtree", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+		exclude := []string{filepath.Join(targetDir, "private")}
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, ExcludeTargets: exclude})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		assert.NoFileExists(t, filepath.Join(targetDir, "private", "secret"))
+		assert.FileExists(t, filepath.Join(targetDir, "public", "rc"))
+		assert.FileExists(t, filepath.Join(targetDir, "config.toml"))
+		require.Len(t, result.ExcludedOperations, 1)
+		assert.Equal(t, filepath.Join(targetDir, "private", "secret"), result.ExcludedOperations[0].Target)
+		assert.Len(t, result.Modules["app"].ExcludedOperations, 1)
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		for _, file := range stateFile.Files {
+			assert.NotEqual(t, filepath.Join(targetDir, "private", "secret"), file.Target)
+		}
+	})
+
+	t.Run("skips targets matching a glob", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, ExcludeTargets: []string{filepath.Join(targetDir, "*.toml")}})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		assert.NoFileExists(t, filepath.Join(targetDir, "config.toml"))
+		assert.FileExists(t, filepath.Join(targetDir, "private", "secret"))
+		assert.FileExists(t, filepath.Join(targetDir, "public", "rc"))
+	})
+
+	t.Run("excluded conflicts don't require force", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "config.toml"), []byte("local"), 0644))
+
+		validation, err := ValidateWithConfig(modules, &ValidateConfig{Mkdir: true, ExcludeTargets: []string{filepath.Join(targetDir, "config.toml")}})
+		require.NoError(t, err)
+		assert.True(t, validation.IsValid, validation.Errors)
+		assert.False(t, validation.RequiresForce)
+		assert.Contains(t, validation.Summary, "1 targets excluded by filter")
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir, ExcludeTargets: []string{filepath.Join(targetDir, "config.toml")}})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		content, err := os.ReadFile(filepath.Join(targetDir, "config.toml"))
+		require.NoError(t, err)
+		assert.Equal(t, "local", string(content))
+	})
+
+	t.Run("uninstall leaves excluded targets installed", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+		result, err := InstallWithConfig(modules, &InstallConfig{Mkdir: true, StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		uninstallResult, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir, ExcludeTargets: []string{filepath.Join(targetDir, "private")}})
+		require.NoError(t, err)
+		require.True(t, uninstallResult.IsSuccess, uninstallResult.Errors)
+		assert.Len(t, uninstallResult.RemovedLinks, 2)
+		assert.FileExists(t, filepath.Join(targetDir, "private", "secret"))
+		assert.NoFileExists(t, filepath.Join(targetDir, "config.toml"))
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, filepath.Join(targetDir, "private", "secret"), stateFile.Files[0].Target)
+	})
+}
+
+func TestInstallVerifyOnSkip(t *testing.T) {
+	// setup creates a module whose only file is already correctly linked
+	setup := func(t *testing.T) (string, string, *Installer, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "bashrc"), []byte("export EDITOR=vim"), 0644))
+		require.NoError(t, os.Symlink(filepath.Join(moduleDir, "bashrc"), filepath.Join(targetDir, "bashrc")))
+
+		installer := NewInstaller(filesystem.NewOperator(), template.NewRenderer(), &stateManagerAdapter{})
+		return dotfilesDir, targetDir, installer, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("records the source hash", func(t *testing.T) {
+		dotfilesDir, _, installer, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, VerifyOnSkip: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.SkippedLinks, 1)
+		assert.Empty(t, result.Warnings)
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, fmt.Sprintf("%x", sha1.Sum([]byte("export EDITOR=vim"))), stateFile.Files[0].SourceSHA1)
+	})
+
+	t.Run("warns on an unexpected mode and owner", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+		installer.linkPerm = func(path string) (os.FileMode, error) {
+			return 0700, nil
+		}
+		installer.linkOwner = func(path string) (int, bool, error) {
+			return 0, true, nil
+		}
+		installer.currentUID = func() int { return 1000 }
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, VerifyOnSkip: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		target := filepath.Join(targetDir, "bashrc")
+		var expected []string
+		if perm, ok := filesystem.SymlinkPerm(); ok {
+			expected = append(expected, fmt.Sprintf("symlink %s has mode 0700, expected %04o", target, perm))
+		}
+		expected = append(expected, fmt.Sprintf("symlink %s is owned by uid 0, not the current user", target))
+		assert.Equal(t, expected, result.Warnings)
+	})
+
+	t.Run("nothing is verified by default", func(t *testing.T) {
+		dotfilesDir, _, installer, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Empty(t, stateFile.Files[0].SourceSHA1)
+	})
+}
+
+func TestInstallResume(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep going %v", keepGoing), func(t *testing.T) {
+			tempDir := t.TempDir()
+			dotfilesDir := filepath.Join(tempDir, "dotfiles")
+			moduleDir := filepath.Join(dotfilesDir, "shell")
+			targetDir := filepath.Join(tempDir, "home")
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.MkdirAll(targetDir, 0755))
+			for _, name := range []string{"bashrc", "zshrc", "profile"} {
+				require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte("# "+name), 0644))
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "inputrc.dot-tmpl"), []byte("{{.EDITOR}}"), 0644))
+			modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+			vars := map[string]string{"EDITOR": "vim"}
+
+			// A prior installation stopped after the template and bashrc
+			partial := []string{filepath.Join(targetDir, "zshrc"), filepath.Join(targetDir, "profile")}
+			result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, ExcludeTargets: partial})
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+			require.Len(t, result.CreatedLinks, 1)
+			require.Len(t, result.CreatedTemplates, 1)
+
+			// Without resuming, the generated template is a conflict
+			result, err = InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, KeepGoing: keepGoing})
+			require.NoError(t, err)
+			assert.False(t, result.IsSuccess)
+
+			result, err = InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, KeepGoing: keepGoing, Resume: true})
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+			assert.ElementsMatch(t, partial, targets(result.CreatedLinks))
+			assert.Empty(t, result.CreatedTemplates)
+			assert.Empty(t, result.SkippedLinks)
+			assert.ElementsMatch(t, []string{filepath.Join(targetDir, "bashrc"), filepath.Join(targetDir, "inputrc")}, targets(result.ResumedOperations))
+			assert.Contains(t, result.Summary, "2 already done")
+
+			stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+			require.NoError(t, err)
+			assert.Len(t, stateFile.Files, 4)
+		})
+	}
+}
+
+// targets returns the targets of ops
+func targets(ops []FileOperation) []string {
+	var paths []string
+	for _, op := range ops {
+		paths = append(paths, op.Target)
+	}
+	return paths
+}
+
+func TestInstallResumeModifiedTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	moduleDir := filepath.Join(dotfilesDir, "shell")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "inputrc.dot-tmpl"), []byte("{{.EDITOR}}"), 0644))
+	modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	vars := map[string]string{"EDITOR": "vim"}
+
+	result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir})
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+
+	// An edited target no longer matches its state entry, so it is not done
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "inputrc"), []byte("emacs"), 0644))
+	result, err = InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, Resume: true})
+	require.NoError(t, err)
+	assert.False(t, result.IsSuccess)
+	assert.Empty(t, result.ResumedOperations)
+}
+
+func TestInstallDanglingSymlink(t *testing.T) {
+	setup := func(t *testing.T) ([]config.ModuleConfig, string, string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "bashrc"), []byte("# bashrc"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "inputrc.dot-tmpl"), []byte("{{.EDITOR}}"), 0644))
+
+		// Stale links of a dotfiles directory that was moved away
+		missing := filepath.Join(tempDir, "old-dotfiles", "missing")
+		require.NoError(t, os.Symlink(missing, filepath.Join(targetDir, "bashrc")))
+		require.NoError(t, os.Symlink(missing, filepath.Join(targetDir, "inputrc")))
+		return []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}, dotfilesDir, targetDir, missing
+	}
+	vars := map[string]string{"EDITOR": "vim"}
+
+	t.Run("replaced without force or backup", func(t *testing.T) {
+		modules, dotfilesDir, targetDir, missing := setup(t)
+
+		validation, err := ValidateWithConfig(modules, &ValidateConfig{Vars: vars})
+		require.NoError(t, err)
+		assert.False(t, validation.HasConflicts())
+		require.Len(t, validation.CreateOperations, 1)
+		assert.True(t, validation.CreateOperations[0].Dangling)
+		assert.Equal(t, "replace dangling symlink to "+missing, validation.CreateOperations[0].Description)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.CreatedLinks, 1)
+		assert.Len(t, result.CreatedTemplates, 1)
+		assert.Empty(t, result.Backups)
+
+		dest, err := os.Readlink(filepath.Join(targetDir, "bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(modules[0].Dir, "bashrc"), dest)
+		content, err := os.ReadFile(filepath.Join(targetDir, "inputrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "vim", string(content))
+		// The template was not written through the stale link
+		assert.NoFileExists(t, missing)
+	})
+
+	t.Run("restored on rollback", func(t *testing.T) {
+		modules, dotfilesDir, targetDir, missing := setup(t)
+		// Linking zshrc, after bashrc, fails the transactional installation
+		require.NoError(t, os.WriteFile(filepath.Join(modules[0].Dir, "zshrc"), []byte("# zshrc"), 0644))
+		fileOp := &failingSymlinkOperator{FileOperator: filesystem.NewOperator(), failTarget: "zshrc"}
+		installer := NewInstaller(fileOp, template.NewRenderer(), &stateManagerAdapter{})
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, RootVars: vars, DotfilesDir: dotfilesDir, Transactional: true})
+		require.NoError(t, err)
+		require.False(t, result.IsSuccess)
+		assert.True(t, result.RolledBack)
+
+		for _, name := range []string{"bashrc", "inputrc"} {
+			dest, err := os.Readlink(filepath.Join(targetDir, name))
+			require.NoError(t, err)
+			assert.Equal(t, missing, dest)
+		}
+	})
+
+	t.Run("kept as conflicts", func(t *testing.T) {
+		modules, dotfilesDir, targetDir, _ := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, KeepDangling: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Contains(t, result.Errors, "conflicts detected - installation would overwrite existing files")
+
+		result, err = InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, KeepDangling: true, Force: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.Backups, 2)
+		assert.FileExists(t, filepath.Join(targetDir, "inputrc"))
+	})
+}
+
+func TestInstallShowDiff(t *testing.T) {
+	setup := func(t *testing.T) ([]config.ModuleConfig, string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "bashrc"), []byte("alias ll='ls -l'\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "inputrc.dot-tmpl"), []byte("editor={{.EDITOR}}\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "profile"), []byte("same\n"), 0644))
+
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "bashrc"), []byte("alias ll='ls -la'\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "inputrc"), []byte("editor=emacs\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "profile"), []byte("same\n"), 0644))
+		return []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}, dotfilesDir, targetDir
+	}
+	vars := map[string]string{"EDITOR": "vim"}
+
+	t.Run("diff of differing regular files", func(t *testing.T) {
+		modules, dotfilesDir, targetDir := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, Force: true, ShowDiff: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		diffs := make(map[string]string)
+		for _, op := range append(result.CreatedLinks, result.CreatedTemplates...) {
+			diffs[filepath.Base(op.Target)] = op.ConflictDiff
+		}
+		bashrc := filepath.Join(targetDir, "bashrc")
+		assert.Equal(t, "--- "+bashrc+"\n+++ "+bashrc+" (installed)\n@@ -1 +1 @@\n-alias ll='ls -la'\n+alias ll='ls -l'\n", diffs["bashrc"])
+		assert.Contains(t, diffs["inputrc"], "-editor=emacs\n+editor=vim\n")
+		// A file with the same content as the source has nothing to show
+		assert.Empty(t, diffs["profile"])
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "inputrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "editor=vim\n", string(content))
+	})
+
+	t.Run("no diff without show diff", func(t *testing.T) {
+		modules, dotfilesDir, _ := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{Vars: vars, StatePath: dotfilesDir, Force: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		for _, op := range append(result.CreatedLinks, result.CreatedTemplates...) {
+			assert.Empty(t, op.ConflictDiff, op.Target)
+		}
+	})
+}
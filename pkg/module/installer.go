@@ -1,9 +1,15 @@
 package module
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/logger"
@@ -11,6 +17,7 @@ import (
 	"github.com/elmhuangyu/dotman/pkg/module/state"
 	"github.com/elmhuangyu/dotman/pkg/module/template"
 	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/rs/zerolog"
 )
 
 // InstallRequest contains the parameters for an installation request
@@ -20,6 +27,65 @@ type InstallRequest struct {
 	Mkdir       bool
 	Force       bool
 	DotfilesDir string
+	// KeepGoing installs each module independently, continuing past failed modules
+	KeepGoing bool
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string
+	// MaxBackups limits backups per target before the oldest is rotated out; zero uses the default
+	MaxBackups int
+	// CompressBackups writes backups of replaced regular files gzip-compressed (.bak.gz)
+	CompressBackups bool
+	// TimestampBackups names backups target.YYYYMMDD-HHMMSS.bak instead of numbering them
+	TimestampBackups bool
+	// BackupSuffix replaces .bak in backup names; empty uses filesystem.DefaultBackupSuffix
+	BackupSuffix string
+	// MkdirAllowedRoots restricts the directories Mkdir may create; empty allows any
+	MkdirAllowedRoots []string
+	// StrictTargetDirs fails validation when a target_dir is inside that of another module
+	StrictTargetDirs bool
+	// CheckWritable fails validation, before anything is written, when a directory that would
+	// receive files can't be written by this process
+	CheckWritable bool
+	// KeepDangling treats targets that are symlinks to a missing file as conflicts requiring
+	// Force instead of replacing them without a backup
+	KeepDangling bool
+	// PreflightTemplates renders every template before writing anything, so a template
+	// that fails to render or format aborts the installation with nothing applied
+	PreflightTemplates bool
+	// SkipFailedTemplates leaves out a template that fails to validate or render, recording it
+	// in InstallResult.SkippedTemplates with a warning, and installs the rest of its module
+	SkipFailedTemplates bool
+	// Transactional records every applied operation and undoes them all, restoring the
+	// state file, when the installation fails. With KeepGoing each module is undone on its own.
+	Transactional bool
+	// LinkMode is how non-template files are installed; only LinkModeAuto copies, across filesystems
+	LinkMode LinkMode
+	// PrivilegedCmd is a command template such as "sudo ln -sfn %s %s", run with the source and
+	// target when creating a symlink fails with a permission error; empty disables the fallback
+	PrivilegedCmd string
+	// ExcludeTargets are absolute target paths or globs whose operations are dropped after
+	// mapping and recorded in InstallResult.ExcludedOperations instead of applied
+	ExcludeTargets []string
+	// VerifyOnSkip hashes the source of every already correct link into the state file and
+	// warns when the link has unexpected permissions or isn't owned by the current user
+	VerifyOnSkip bool
+	// RecordVars stores the merged vars of every template and generated file in its state
+	// entry, so repairs regenerate it with the same vars even after the configured vars change
+	RecordVars bool
+	// Resume continues an interrupted installation: targets the state file already tracks in
+	// their installed form are left alone and reported in InstallResult.ResumedOperations
+	Resume bool
+	// ModulePaths are the paths of every module, which templates read as .Modules and target
+	// directories are checked against; nil uses the paths of Modules
+	ModulePaths map[string]template.ModulePaths
+	// ShowDiff sets the ConflictDiff of force operations replacing a regular file with a link
+	// or template to the diff from the file to the source or rendered template
+	ShowDiff bool
+	// Context cancels the installation between operations, killing running commands;
+	// defaults to context.Background() when nil
+	Context context.Context
+	// Logger receives progress output; defaults to the global logger when nil
+	Logger *zerolog.Logger
 }
 
 // Installer handles the installation of dotfiles
@@ -27,24 +93,35 @@ type Installer struct {
 	fileOp   filesystem.FileOperator
 	template template.TemplateRenderer
 	stateMgr state.StateManager
+	// sameDevice reports whether a source and its target are on the same filesystem
+	sameDevice func(source, target string) (bool, error)
+	// runPrivileged runs the privileged symlink fallback command
+	runPrivileged func(ctx context.Context, command []string) ([]byte, error)
+	// linkOwner, linkPerm and currentUID back the verification of skipped links; replaceable in tests
+	linkOwner  func(path string) (int, bool, error)
+	linkPerm   func(path string) (os.FileMode, error)
+	currentUID func() int
 }
 
 // NewInstaller creates a new Installer instance
 func NewInstaller(fileOp filesystem.FileOperator, templateRenderer template.TemplateRenderer, stateMgr state.StateManager) *Installer {
 	return &Installer{
-		fileOp:   fileOp,
-		template: templateRenderer,
-		stateMgr: stateMgr,
+		fileOp:     fileOp,
+		template:   templateRenderer,
+		stateMgr:   stateMgr,
+		sameDevice: filesystem.SameDevice,
+		runPrivileged: func(ctx context.Context, command []string) ([]byte, error) {
+			return runCommand(ctx, command, "", config.DefaultGeneratorTimeout, nil)
+		},
+		linkOwner:  filesystem.LinkOwner,
+		linkPerm:   filesystem.LinkPerm,
+		currentUID: os.Getuid,
 	}
 }
 
 // Install performs the installation of dotfiles
 func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
-	log := logger.GetLogger()
-
-	// Initialize filesystem operators
-	symlinkMgr := filesystem.NewSymlinkManager(i.fileOp)
-	backupMgr := filesystem.NewBackupManager(i.fileOp)
+	log := logger.OrDefault(req.Logger)
 
 	log.Info().Int("modules", len(req.Modules)).Msg("Starting installation")
 
@@ -52,28 +129,140 @@ func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
 	var stateFile *dotmanState.StateFile
 	var statePath string
 	var err error
+	// unreadable is set when the state file on disk can't be loaded, so it is replaced instead of compacted
+	var unreadable bool
 
 	if req.DotfilesDir != "" {
-		statePath = filepath.Join(req.DotfilesDir, "state.yaml")
+		statePath = dotmanState.Path(req.DotfilesDir, req.Profile)
 		stateFile, err = i.stateMgr.Load(statePath)
+		if errors.Is(err, fs.ErrPermission) {
+			// Installing without the recorded state would lose track of what is already installed
+			return nil, fmt.Errorf("cannot read state file %s: %w; make it readable by the current user, e.g. chown it back after an install run with sudo", statePath, err)
+		}
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to load state file, continuing without state logging")
 			stateFile = nil
+			unreadable = true
 		}
 		if stateFile == nil {
 			stateFile = dotmanState.NewStateFile()
 		}
 	}
 
+	// State entries are appended after every operation, so a failing write is reported once at the end
+	saves := &saveTracker{StateManager: i.stateMgr}
+	installer := *i
+	installer.stateMgr = saves
+
+	var result *InstallResult
+	if req.KeepGoing {
+		result, err = installer.installEachModule(req, stateFile, statePath, log)
+	} else {
+		result, err = installer.installModules(req.Modules, req, stateFile, statePath, log)
+	}
+	// Operations were appended to the state log as they were applied
+	if unreadable {
+		if err := saves.Save(statePath, stateFile); err != nil {
+			log.Warn().Err(err).Msg("Failed to replace unreadable state file")
+		}
+	} else if statePath != "" {
+		if err := saves.Compact(statePath); err != nil {
+			log.Warn().Err(err).Msg("Failed to compact state log")
+		}
+	}
+	if saves.err != nil && result != nil {
+		result.StateNotSaved = true
+		message := fmt.Sprintf("state file %s could not be saved, so this installation is not tracked: %v", statePath, saves.err)
+		if errors.Is(saves.err, fs.ErrPermission) {
+			message += "; make it writable by the current user, e.g. chown it back after an install run with sudo"
+		}
+		result.addWarning(message, log)
+	}
+	return result, err
+}
+
+// saveTracker is a state manager that remembers the first error of its writes
+type saveTracker struct {
+	state.StateManager
+	err error
+}
+
+// Save saves the state file, remembering the first error
+func (t *saveTracker) Save(path string, stateFile *dotmanState.StateFile) error {
+	return t.track(t.StateManager.Save(path, stateFile))
+}
+
+// AppendEvent appends to the state log, remembering the first error
+func (t *saveTracker) AppendEvent(path string, stateFile *dotmanState.StateFile, event dotmanState.Event) error {
+	return t.track(t.StateManager.AppendEvent(path, stateFile, event))
+}
+
+// Compact folds the state log into the state file, remembering the first error
+func (t *saveTracker) Compact(path string) error {
+	return t.track(t.StateManager.Compact(path))
+}
+
+// track remembers err when it is the first error
+func (t *saveTracker) track(err error) error {
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return err
+}
+
+// installModules validates and installs modules as a single unit, stopping at the first failure
+func (i *Installer) installModules(modules []config.ModuleConfig, req *InstallRequest, stateFile *dotmanState.StateFile, statePath string, log zerolog.Logger) (*InstallResult, error) {
+	ctx := contextOrBackground(req.Context)
+
+	// Initialize filesystem operators
+	symlinkMgr := filesystem.NewSymlinkManager(i.fileOp)
+	backupMgr := filesystem.NewBackupManagerWithOptions(i.fileOp, filesystem.BackupOptions{MaxBackups: req.MaxBackups, Compress: req.CompressBackups, Timestamped: req.TimestampBackups, Suffix: req.BackupSuffix})
+
+	paths := req.ModulePaths
+	if paths == nil {
+		paths = ModulePaths(req.Modules)
+	}
+
 	// First validate the installation
-	validation, err := Validate(req.Modules, req.RootVars, req.Mkdir, req.Force)
+	validation, err := ValidateWithConfig(modules, &ValidateConfig{
+		Context:             ctx,
+		Mkdir:               req.Mkdir,
+		Force:               req.Force,
+		Vars:                req.RootVars,
+		MkdirAllowedRoots:   req.MkdirAllowedRoots,
+		StrictTargetDirs:    req.StrictTargetDirs,
+		KeepDangling:        req.KeepDangling,
+		StateDir:            req.DotfilesDir,
+		SkipFailedTemplates: req.SkipFailedTemplates,
+		CheckWritable:       req.CheckWritable,
+		CompressBackups:     req.CompressBackups,
+		TimestampBackups:    req.TimestampBackups,
+		BackupSuffix:        req.BackupSuffix,
+		Profile:             req.Profile,
+		ExcludeTargets:      req.ExcludeTargets,
+		ModulePaths:         paths,
+		Logger:              &log,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
+	for _, warning := range validation.Warnings {
+		log.Warn().Msg(warning)
+	}
+	for _, source := range validation.UnlinkedFiles {
+		log.Debug().Str("source", source).Msg("Not linking file matched by skip_link")
+	}
 
 	result := &InstallResult{
-		IsSuccess: true,
-		Errors:    []string{},
+		IsSuccess:          true,
+		Errors:             []string{},
+		ExcludedOperations: validation.ExcludedOps,
+	}
+	for _, operation := range validation.ExcludedOps {
+		log.Info().Str("source", operation.Source).Str("target", operation.Target).Msg("Skipped (excluded by filter)")
+	}
+	for _, operation := range validation.SkippedTemplates {
+		result.skipTemplate(operation, operation.Description, log)
 	}
 
 	// Check for validation errors or conflicts - if any exist, fail the installation
@@ -84,8 +273,13 @@ func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
 		return result, nil
 	}
 
+	if req.Resume && stateFile != nil {
+		result.ResumedOperations = i.resumeOperations(validation, stateFile)
+		log.Info().Int("operations", len(result.ResumedOperations)).Msg("Resuming installation, skipping operations already done")
+	}
+
 	// Check for conflicts in the operations
-	forceOps := len(validation.ForceLinkOperations) + len(validation.ForceTemplateOps)
+	forceOps := len(validation.ForceOperations())
 	if forceOps > 0 && !req.Force {
 		result.IsSuccess = false
 		result.Errors = append(result.Errors, "conflicts detected - installation would overwrite existing files")
@@ -93,6 +287,32 @@ func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
 		return result, nil
 	}
 
+	// Render templates up front, leaving out the ones that fail instead of failing their module,
+	// or, with PreflightTemplates, so a failure leaves nothing partially applied
+	if req.SkipFailedTemplates {
+		validation.CreateTemplateOps = i.renderOrSkipTemplates(ctx, validation.CreateTemplateOps, req.RootVars, result, log)
+		if req.Force {
+			validation.ForceTemplateOps = i.renderOrSkipTemplates(ctx, validation.ForceTemplateOps, req.RootVars, result, log)
+		}
+	} else if req.PreflightTemplates {
+		templateOps := [][]FileOperation{validation.CreateTemplateOps}
+		if req.Force {
+			templateOps = append(templateOps, validation.ForceTemplateOps)
+		}
+		for _, ops := range templateOps {
+			i.preflightTemplates(ctx, ops, req.RootVars, result)
+		}
+		if !result.IsSuccess {
+			result.Summary = fmt.Sprintf("Installation failed: %d templates failed to render", len(result.Errors))
+			log.Error().Strs("errors", result.Errors).Msg("Template preflight failed, nothing was written")
+			return result, nil
+		}
+	}
+
+	if req.Transactional {
+		result.undo = i.newUndoLog(stateFile, statePath)
+	}
+
 	result.SkippedLinks = validation.SkipOperations
 
 	// Record skipped files in state file
@@ -101,33 +321,62 @@ func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
 			if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeLink); err != nil {
 				log.Warn().Err(err).Msg("Failed to add mapping to state file for skipped operation")
 			}
-			if err := i.stateMgr.Save(statePath, stateFile); err != nil {
-				log.Warn().Err(err).Msg("Failed to save state file for skipped operation")
-			}
+		}
+		if req.VerifyOnSkip {
+			i.verifySkippedLink(operation, stateFile, result, log)
+		}
+		if stateFile != nil {
+			i.recordTarget(statePath, stateFile, operation.Target, log)
 		}
 		log.Info().Str("source", operation.Source).Str("target", operation.Target).Msg("Skipped (correct symlink already exists)")
 	}
 
-	// Perform the installation of symlinks
-	if err := i.installSymlinks(validation.CreateOperations, symlinkMgr, req.Mkdir, stateFile, statePath, result); err != nil {
-		return result, err
+	// Perform the installation of keep directories, symlinks, templates, command output and force operations.
+	// A cancelled context stops between operations, leaving the remaining ones unapplied.
+	err = i.installDirs(ctx, validation.CreateDirOps, req.Mkdir, stateFile, statePath, result, log)
+	if err == nil {
+		err = i.installSymlinks(ctx, validation.CreateOperations, symlinkMgr, req.Mkdir, req.LinkMode, req.PrivilegedCmd, stateFile, statePath, result, log)
+	}
+	if err == nil {
+		err = i.installTemplates(ctx, validation.CreateTemplateOps, req.RootVars, req.Mkdir, stateFile, statePath, result, log)
+	}
+	if err == nil {
+		err = i.installGenerated(ctx, validation.CreateGeneratedOps, req.Mkdir, stateFile, statePath, result, log)
+	}
+	if err == nil {
+		err = i.installMergedBlocks(ctx, validation.MergeGeneratedOps, req.Mkdir, stateFile, statePath, result, log)
+	}
+	if err == nil && req.Force {
+		if req.ShowDiff {
+			i.setConflictDiffs(ctx, validation.ForceLinkOperations, validation.ForceTemplateOps, req.RootVars)
+		}
+		err = i.handleForceOperations(ctx, validation.ForceLinkOperations, validation.ForceTemplateOps, validation.ForceGeneratedOps, symlinkMgr, backupMgr, req.RootVars, req.Mkdir, req.LinkMode, req.PrivilegedCmd, stateFile, statePath, result, log)
+	}
+	if err != nil {
+		result.IsSuccess = false
+		result.Errors = append(result.Errors, fmt.Sprintf("installation cancelled: %v", err))
 	}
 
-	// Perform template file generation
-	if err := i.installTemplates(validation.CreateTemplateOps, req.RootVars, req.Mkdir, stateFile, statePath, result); err != nil {
-		return result, err
+	if req.RecordVars && stateFile != nil {
+		i.recordVars(result, req.RootVars, stateFile, statePath, log)
 	}
 
-	// Handle force operations (both links and templates)
-	if req.Force {
-		if err := i.handleForceOperations(validation.ForceLinkOperations, validation.ForceTemplateOps, symlinkMgr, backupMgr, req.RootVars, req.Mkdir, stateFile, statePath, result); err != nil {
-			return result, err
-		}
+	if !result.IsSuccess && result.undo != nil {
+		i.rollback(result.undo, stateFile, statePath, result, log)
 	}
 
+	result.Modules = groupByModule(modules, result)
+
 	// Generate summary
+	if err != nil {
+		result.Summary = fmt.Sprintf("Installation cancelled: %d symlinks created, %d files copied, %d template files generated, %d command outputs generated before cancellation", len(result.CreatedLinks), len(result.CopiedFiles), len(result.CreatedTemplates), len(result.CreatedGenerated))
+		log.Warn().Err(err).Msg("Installation cancelled")
+		return result, err
+	}
 	if result.IsSuccess {
-		result.Summary = fmt.Sprintf("Installation successful: %d symlinks created, %d template files generated, %d skipped", len(result.CreatedLinks), len(result.CreatedTemplates), len(result.SkippedLinks))
+		result.NoChanges = !result.changed()
+		result.Summary = fmt.Sprintf("Installation successful: %d symlinks created, %d files copied, %d template files generated, %d command outputs generated, %d skipped", len(result.CreatedLinks), len(result.CopiedFiles), len(result.CreatedTemplates), len(result.CreatedGenerated), len(result.SkippedLinks))
+		result.Summary += result.resumedSummary() + result.skippedTemplatesSummary()
 	} else {
 		result.Summary = fmt.Sprintf("Installation failed: %d errors", len(result.Errors))
 	}
@@ -137,28 +386,284 @@ func (i *Installer) Install(req *InstallRequest) (*InstallResult, error) {
 	return result, nil
 }
 
+// installEachModule validates and installs every module independently in dependency order.
+// A failed module doesn't stop the others, but modules depending on it are skipped.
+func (i *Installer) installEachModule(req *InstallRequest, stateFile *dotmanState.StateFile, statePath string, log zerolog.Logger) (*InstallResult, error) {
+	modules, err := config.SortModules(req.Modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to order modules: %w", err)
+	}
+
+	result := &InstallResult{
+		IsSuccess:    true,
+		Errors:       []string{},
+		Modules:      make(map[string]ModuleResult),
+		ModuleErrors: make(map[string][]string),
+	}
+
+	// Conflicts between modules can't be attributed to a single module, so they fail the whole installation
+	mapping, err := BuildFileMapping(modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build file mappings: %w", err)
+	}
+	for target, sources := range mapping.GetTargetConflicts() {
+		result.IsSuccess = false
+		result.Errors = append(result.Errors, fmt.Sprintf("target conflict: %d source files map to the same target %s: %v", len(sources), target, sources))
+	}
+	if !result.IsSuccess {
+		result.Summary = fmt.Sprintf("Installation failed: %d validation errors", len(result.Errors))
+		return result, nil
+	}
+
+	ctx := contextOrBackground(req.Context)
+	failed := make(map[string]bool)
+	for _, module := range modules {
+		// A cancelled context leaves the remaining modules uninstalled
+		if ctx.Err() != nil {
+			break
+		}
+
+		name := module.Name()
+		moduleLog := log.With().Str("module", name).Logger()
+
+		var moduleErrors []string
+		if dep := failedDependency(module, failed); dep != "" {
+			moduleErrors = []string{fmt.Sprintf("skipped because dependency %s failed", dep)}
+		} else {
+			// Dependencies were already ordered above, and are not part of this single-module install
+			module.DependsOn = nil
+			moduleResult, err := i.installModules([]config.ModuleConfig{module}, req, stateFile, statePath, moduleLog)
+			// A cancelled module still returns what it applied before stopping
+			if err != nil && moduleResult == nil {
+				moduleErrors = []string{err.Error()}
+			} else {
+				result.CreatedLinks = append(result.CreatedLinks, moduleResult.CreatedLinks...)
+				result.CreatedTemplates = append(result.CreatedTemplates, moduleResult.CreatedTemplates...)
+				result.CreatedGenerated = append(result.CreatedGenerated, moduleResult.CreatedGenerated...)
+				result.CopiedFiles = append(result.CopiedFiles, moduleResult.CopiedFiles...)
+				result.SkippedLinks = append(result.SkippedLinks, moduleResult.SkippedLinks...)
+				result.ResumedOperations = append(result.ResumedOperations, moduleResult.ResumedOperations...)
+				result.SkippedTemplates = append(result.SkippedTemplates, moduleResult.SkippedTemplates...)
+				result.CreatedDirs = append(result.CreatedDirs, moduleResult.CreatedDirs...)
+				result.MergedBlocks = append(result.MergedBlocks, moduleResult.MergedBlocks...)
+				result.ExcludedOperations = append(result.ExcludedOperations, moduleResult.ExcludedOperations...)
+				result.Warnings = append(result.Warnings, moduleResult.Warnings...)
+				result.Backups = append(result.Backups, moduleResult.Backups...)
+				result.FailedOperations = append(result.FailedOperations, moduleResult.FailedOperations...)
+				if moduleResult.Modules != nil {
+					result.Modules[name] = moduleResult.Modules[name]
+				}
+				if !moduleResult.IsSuccess {
+					moduleErrors = moduleResult.Errors
+				}
+				result.RolledBack = result.RolledBack || moduleResult.RolledBack
+			}
+		}
+
+		if len(moduleErrors) == 0 {
+			result.InstalledModules = append(result.InstalledModules, name)
+			continue
+		}
+
+		// Errors raised before or outside the module's operations are only known here
+		failedModule := result.Modules[name]
+		failedModule.IsSuccess = false
+		failedModule.Errors = moduleErrors
+		result.Modules[name] = failedModule
+
+		failed[name] = true
+		result.IsSuccess = false
+		result.FailedModules = append(result.FailedModules, name)
+		result.ModuleErrors[name] = moduleErrors
+		for _, moduleError := range moduleErrors {
+			result.Errors = append(result.Errors, fmt.Sprintf("module %s: %s", name, moduleError))
+		}
+		moduleLog.Error().Strs("errors", moduleErrors).Msg("Module installation failed, continuing with remaining modules")
+	}
+
+	if err := ctx.Err(); err != nil {
+		result.IsSuccess = false
+		result.Summary = fmt.Sprintf("Installation cancelled: %d of %d modules installed", len(result.InstalledModules), len(modules))
+		log.Warn().Err(err).Msg("Installation cancelled")
+		return result, err
+	}
+
+	if result.IsSuccess {
+		result.NoChanges = !result.changed()
+		result.Summary = fmt.Sprintf("Installation successful: %d modules installed, %d symlinks created, %d files copied, %d template files generated, %d command outputs generated, %d skipped", len(result.InstalledModules), len(result.CreatedLinks), len(result.CopiedFiles), len(result.CreatedTemplates), len(result.CreatedGenerated), len(result.SkippedLinks))
+		result.Summary += result.resumedSummary() + result.skippedTemplatesSummary()
+	} else {
+		result.Summary = fmt.Sprintf("Installation failed: %d of %d modules failed (%s)", len(result.FailedModules), len(modules), strings.Join(result.FailedModules, ", "))
+	}
+
+	log.Info().Bool("success", result.IsSuccess).Int("failed_modules", len(result.FailedModules)).Msg("Installation completed")
+
+	return result, nil
+}
+
+// failedDependency returns the first dependency of module that failed to install
+func failedDependency(module config.ModuleConfig, failed map[string]bool) string {
+	for _, dep := range module.DependsOn {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// failOperation records an operation that could not be completed and marks the installation as unsuccessful
+func (r *InstallResult) failOperation(operation FileOperation, message string) {
+	r.IsSuccess = false
+	r.Errors = append(r.Errors, message)
+	operation.Description = message
+	r.FailedOperations = append(r.FailedOperations, operation)
+}
+
+// skipTemplate records a template left out of the installation because of the error in message
+func (r *InstallResult) skipTemplate(operation FileOperation, message string, log zerolog.Logger) {
+	operation.Description = message
+	r.SkippedTemplates = append(r.SkippedTemplates, operation)
+	r.addWarning("skipped template: "+message, log)
+}
+
+// addWarning records a problem that doesn't fail the installation
+func (r *InstallResult) addWarning(message string, log zerolog.Logger) {
+	r.Warnings = append(r.Warnings, message)
+	log.Warn().Msg(message)
+}
+
+// verifySkippedLink records the source hash of an already correct link in the state file and
+// warns when the link has unexpected permissions or isn't owned by the current user
+func (i *Installer) verifySkippedLink(operation FileOperation, stateFile *dotmanState.StateFile, result *InstallResult, log zerolog.Logger) {
+	sourceSHA1, err := filesystem.FileSHA1(i.fileOp, operation.Source)
+	if err != nil {
+		result.addWarning(fmt.Sprintf("failed to hash source %s of skipped link %s: %v", operation.Source, operation.Target, err), log)
+	} else if stateFile != nil {
+		stateFile.SetSourceSHA1(operation.Target, sourceSHA1)
+	}
+
+	if expected, ok := filesystem.SymlinkPerm(); ok {
+		perm, err := i.linkPerm(operation.Target)
+		if err != nil {
+			result.addWarning(fmt.Sprintf("failed to check permissions of %s: %v", operation.Target, err), log)
+		} else if perm != expected {
+			result.addWarning(fmt.Sprintf("symlink %s has mode %04o, expected %04o", operation.Target, perm, expected), log)
+		}
+	}
+
+	uid, ok, err := i.linkOwner(operation.Target)
+	if err != nil {
+		result.addWarning(fmt.Sprintf("failed to check owner of %s: %v", operation.Target, err), log)
+	} else if ok && uid != i.currentUID() {
+		result.addWarning(fmt.Sprintf("symlink %s is owned by uid %d, not the current user", operation.Target, uid), log)
+	}
+}
+
+// addBackup records the backup of a replaced target; targets that did not exist leave no backup
+func (r *InstallResult) addBackup(backupPath string) {
+	if backupPath != "" {
+		r.Backups = append(r.Backups, backupPath)
+	}
+}
+
+// groupByModule breaks the operations of result down by the module they originate from
+func groupByModule(modules []config.ModuleConfig, result *InstallResult) map[string]ModuleResult {
+	grouped := make(map[string]*ModuleResult, len(modules))
+	for _, module := range modules {
+		grouped[module.Name()] = &ModuleResult{IsSuccess: true}
+	}
+	moduleOf := func(operation FileOperation) *ModuleResult {
+		if _, ok := grouped[operation.Module]; !ok {
+			grouped[operation.Module] = &ModuleResult{IsSuccess: true}
+		}
+		return grouped[operation.Module]
+	}
+
+	for _, operation := range result.CreatedLinks {
+		m := moduleOf(operation)
+		m.CreatedLinks = append(m.CreatedLinks, operation)
+	}
+	for _, operation := range result.CreatedTemplates {
+		m := moduleOf(operation)
+		m.CreatedTemplates = append(m.CreatedTemplates, operation)
+	}
+	for _, operation := range result.CreatedGenerated {
+		m := moduleOf(operation)
+		m.CreatedGenerated = append(m.CreatedGenerated, operation)
+	}
+	for _, operation := range result.CopiedFiles {
+		m := moduleOf(operation)
+		m.CopiedFiles = append(m.CopiedFiles, operation)
+	}
+	for _, operation := range result.SkippedLinks {
+		m := moduleOf(operation)
+		m.SkippedLinks = append(m.SkippedLinks, operation)
+	}
+	for _, operation := range result.CreatedDirs {
+		m := moduleOf(operation)
+		m.CreatedDirs = append(m.CreatedDirs, operation)
+	}
+	for _, operation := range result.MergedBlocks {
+		m := moduleOf(operation)
+		m.MergedBlocks = append(m.MergedBlocks, operation)
+	}
+	for _, operation := range result.ExcludedOperations {
+		m := moduleOf(operation)
+		m.ExcludedOperations = append(m.ExcludedOperations, operation)
+	}
+	for _, operation := range result.SkippedTemplates {
+		m := moduleOf(operation)
+		m.SkippedTemplates = append(m.SkippedTemplates, operation)
+	}
+	for _, operation := range result.FailedOperations {
+		m := moduleOf(operation)
+		m.IsSuccess = false
+		m.FailedOperations = append(m.FailedOperations, operation)
+		m.Errors = append(m.Errors, operation.Description)
+	}
+
+	results := make(map[string]ModuleResult, len(grouped))
+	for name, m := range grouped {
+		results[name] = *m
+	}
+	return results
+}
+
 // installSymlinks installs regular symlinks
-func (i *Installer) installSymlinks(ops []FileOperation, symlinkMgr *filesystem.SymlinkManager, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult) error {
-	log := logger.GetLogger()
+func (i *Installer) installSymlinks(ctx context.Context, ops []FileOperation, symlinkMgr *filesystem.SymlinkManager, mkdir bool, linkMode LinkMode, privilegedCmd string, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
 
 	for _, operation := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		if err := symlinkMgr.CreateSymlinkWithMkdir(operation.Source, operation.Target, mkdir); err != nil {
-			result.IsSuccess = false
-			result.Errors = append(result.Errors, fmt.Sprintf("failed to create symlink %s -> %s: %v", operation.Source, operation.Target, err))
+		if err := i.removeDangling(operation, result, log); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to replace dangling symlink %s: %v", operation.Target, err))
+			break
+		}
+		fileType, err := i.linkOrCopy(ctx, operation, operation.Target, linkMode, privilegedCmd, symlinkMgr, mkdir, log)
+		if err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to create symlink %s -> %s: %v", operation.Source, operation.Target, err))
 		} else {
 			// Record successful symlink in state file
 			if stateFile != nil {
-				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeLink); err != nil {
-					log.Warn().Err(err).Msg("Failed to add mapping to state file")
+				if fileType == dotmanState.TypeCopy {
+					i.applyRecordedMode(stateFile, operation.Target, log)
 				}
-				if err := i.stateMgr.Save(statePath, stateFile); err != nil {
-					log.Warn().Err(err).Msg("Failed to save state file")
+				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, fileType); err != nil {
+					log.Warn().Err(err).Msg("Failed to add mapping to state file")
 				}
+				i.recordTarget(statePath, stateFile, operation.Target, log)
+			}
+			if fileType == dotmanState.TypeCopy {
+				result.CopiedFiles = append(result.CopiedFiles, operation)
+				result.undo.record("remove copied file "+operation.Target, i.undoCreate(operation.Target))
+			} else {
+				result.CreatedLinks = append(result.CreatedLinks, operation)
+				result.undo.record("remove symlink "+operation.Target, i.undoCreate(operation.Target))
+				log.Debug().Str("source", operation.Source).Str("target", operation.Target).Msg("Created symlink")
 			}
 		}
-		result.CreatedLinks = append(result.CreatedLinks, operation)
-		log.Debug().Str("source", operation.Source).Str("target", operation.Target).Msg("Created symlink")
 
 		if !result.IsSuccess {
 			break
@@ -168,25 +673,89 @@ func (i *Installer) installSymlinks(ops []FileOperation, symlinkMgr *filesystem.
 	return nil
 }
 
+// linkOrCopy links path to the operation's source, or copies the source when linkMode is
+// LinkModeAuto and path is on another filesystem. A link that can't be created for lack of
+// permission is created with privilegedCmd, if set. It returns the state type of the result.
+func (i *Installer) linkOrCopy(ctx context.Context, operation FileOperation, path string, linkMode LinkMode, privilegedCmd string, symlinkMgr *filesystem.SymlinkManager, mkdir bool, log zerolog.Logger) (string, error) {
+	if linkMode == LinkModeAuto {
+		sameDevice, err := i.sameDevice(operation.Source, path)
+		if err != nil {
+			return "", fmt.Errorf("failed to compare filesystems: %w", err)
+		}
+		if !sameDevice {
+			if err := i.ensureTargetDir(path, mkdir, operation.DirMode); err != nil {
+				return "", err
+			}
+			if err := i.fileOp.CopyFile(operation.Source, path); err != nil {
+				return "", fmt.Errorf("failed to copy file: %w", err)
+			}
+			log.Info().Str("source", operation.Source).Str("target", operation.Target).Msg("Target is on another filesystem, copied instead of linking")
+			return dotmanState.TypeCopy, nil
+		}
+	}
+
+	if err := symlinkMgr.CreateSymlinkWithDirMode(operation.Source, path, mkdir, operation.DirMode); err != nil {
+		if privilegedCmd == "" || !errors.Is(err, fs.ErrPermission) {
+			return "", err
+		}
+		if err := i.linkPrivileged(ctx, privilegedCmd, operation.Source, path, log); err != nil {
+			return "", err
+		}
+	}
+	return dotmanState.TypeLink, nil
+}
+
+// removeDangling removes the dangling symlink a create operation replaces, so the new target
+// isn't written through it, and records an undo step restoring the symlink. No backup is made,
+// as the symlink points to nothing.
+func (i *Installer) removeDangling(operation FileOperation, result *InstallResult, log zerolog.Logger) error {
+	if !operation.Dangling {
+		return nil
+	}
+	info, err := os.Lstat(operation.Target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	destination, ok := danglingSymlink(operation.Target, info)
+	if !ok {
+		return fmt.Errorf("target is no longer a dangling symlink")
+	}
+	if err := i.fileOp.RemoveFile(operation.Target); err != nil {
+		return err
+	}
+	result.undo.record("restore dangling symlink "+operation.Target, func() error {
+		return i.fileOp.CreateSymlink(destination, operation.Target)
+	})
+	log.Info().Str("target", operation.Target).Str("destination", destination).Msg("Replacing dangling symlink")
+	return nil
+}
+
 // installTemplates installs template files
-func (i *Installer) installTemplates(ops []FileOperation, vars map[string]string, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult) error {
-	log := logger.GetLogger()
+func (i *Installer) installTemplates(ctx context.Context, ops []FileOperation, vars map[string]string, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
 
 	for _, operation := range ops {
-		if err := i.createTemplateFile(operation.Source, operation.Target, vars, mkdir); err != nil {
-			result.IsSuccess = false
-			result.Errors = append(result.Errors, fmt.Sprintf("failed to create template file %s -> %s: %v", operation.Source, operation.Target, err))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := i.removeDangling(operation, result, log); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to replace dangling symlink %s: %v", operation.Target, err))
+			break
+		}
+		if err := i.createTemplateFile(ctx, operation, operation.Target, vars, mkdir); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to create template file %s -> %s: %v", operation.Source, operation.Target, err))
 		} else {
 			// Record successful template generation in state file
 			if stateFile != nil {
+				i.applyRecordedMode(stateFile, operation.Target, log)
 				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeGenerated); err != nil {
 					log.Warn().Err(err).Msg("Failed to add mapping to state file for template")
 				}
-				if err := i.stateMgr.Save(statePath, stateFile); err != nil {
-					log.Warn().Err(err).Msg("Failed to save state file for template")
-				}
+				i.recordTarget(statePath, stateFile, operation.Target, log)
 			}
 			result.CreatedTemplates = append(result.CreatedTemplates, operation)
+			result.undo.record("remove template file "+operation.Target, i.undoCreate(operation.Target))
 			log.Debug().Str("source", operation.Source).Str("target", operation.Target).Msg("Created template file")
 		}
 
@@ -199,29 +768,40 @@ func (i *Installer) installTemplates(ops []FileOperation, vars map[string]string
 }
 
 // handleForceOperations handles force operations for both links and templates
-func (i *Installer) handleForceOperations(forceLinkOps, forceTemplateOps []FileOperation, symlinkMgr *filesystem.SymlinkManager, backupMgr *filesystem.BackupManager, vars map[string]string, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult) error {
-	log := logger.GetLogger()
+func (i *Installer) handleForceOperations(ctx context.Context, forceLinkOps, forceTemplateOps, forceGeneratedOps []FileOperation, symlinkMgr *filesystem.SymlinkManager, backupMgr *filesystem.BackupManager, vars map[string]string, mkdir bool, linkMode LinkMode, privilegedCmd string, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
 
 	// Handle force link operations
 	for _, operation := range forceLinkOps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		_, err := backupMgr.BackupAndReplace(operation.Target, func() error {
-			return symlinkMgr.CreateSymlinkWithMkdir(operation.Source, operation.Target, mkdir)
+		fileType := dotmanState.TypeLink
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(operation.Target, func(path string) error {
+			var err error
+			fileType, err = i.linkOrCopy(ctx, operation, path, linkMode, privilegedCmd, symlinkMgr, mkdir, log)
+			return err
 		})
 		if err != nil {
-			result.IsSuccess = false
-			result.Errors = append(result.Errors, fmt.Sprintf("failed to backup and create symlink %s -> %s: %v", operation.Source, operation.Target, err))
+			result.failOperation(operation, fmt.Sprintf("failed to backup and create symlink %s -> %s: %v", operation.Source, operation.Target, err))
 		} else {
 			// Record successful symlink in state file
 			if stateFile != nil {
-				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeLink); err != nil {
-					log.Warn().Err(err).Msg("Failed to add mapping to state file")
+				if fileType == dotmanState.TypeCopy {
+					i.applyRecordedMode(stateFile, operation.Target, log)
 				}
-				if err := i.stateMgr.Save(statePath, stateFile); err != nil {
-					log.Warn().Err(err).Msg("Failed to save state file")
+				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, fileType); err != nil {
+					log.Warn().Err(err).Msg("Failed to add mapping to state file")
 				}
+				i.recordTarget(statePath, stateFile, operation.Target, log)
 			}
-			result.CreatedLinks = append(result.CreatedLinks, operation)
+			if fileType == dotmanState.TypeCopy {
+				result.CopiedFiles = append(result.CopiedFiles, operation)
+			} else {
+				result.CreatedLinks = append(result.CreatedLinks, operation)
+			}
+			result.addBackup(backupPath)
+			result.undo.record(fmt.Sprintf("restore %s from backup %s", operation.Target, backupPath), i.undoReplace(operation.Target, backupPath))
 			log.Warn().Str("source", operation.Source).Str("target", operation.Target).Msg("Backed up existing file and created symlink")
 		}
 
@@ -232,23 +812,26 @@ func (i *Installer) handleForceOperations(forceLinkOps, forceTemplateOps []FileO
 
 	// Handle force template operations
 	for _, operation := range forceTemplateOps {
-		_, err := backupMgr.BackupAndReplace(operation.Target, func() error {
-			return i.createTemplateFile(operation.Source, operation.Target, vars, mkdir)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(operation.Target, func(path string) error {
+			return i.createTemplateFile(ctx, operation, path, vars, mkdir)
 		})
 		if err != nil {
-			result.IsSuccess = false
-			result.Errors = append(result.Errors, fmt.Sprintf("failed to backup and create template file %s -> %s: %v", operation.Source, operation.Target, err))
+			result.failOperation(operation, fmt.Sprintf("failed to backup and create template file %s -> %s: %v", operation.Source, operation.Target, err))
 		} else {
 			// Record successful template generation in state file
 			if stateFile != nil {
+				i.applyRecordedMode(stateFile, operation.Target, log)
 				if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeGenerated); err != nil {
 					log.Warn().Err(err).Msg("Failed to add mapping to state file for template")
 				}
-				if err := i.stateMgr.Save(statePath, stateFile); err != nil {
-					log.Warn().Err(err).Msg("Failed to save state file for template")
-				}
+				i.recordTarget(statePath, stateFile, operation.Target, log)
 			}
 			result.CreatedTemplates = append(result.CreatedTemplates, operation)
+			result.addBackup(backupPath)
+			result.undo.record(fmt.Sprintf("restore %s from backup %s", operation.Target, backupPath), i.undoReplace(operation.Target, backupPath))
 			log.Warn().Str("source", operation.Source).Str("target", operation.Target).Msg("Backed up existing file and created template file")
 		}
 
@@ -257,34 +840,385 @@ func (i *Installer) handleForceOperations(forceLinkOps, forceTemplateOps []FileO
 		}
 	}
 
+	// Handle force generated file operations
+	for _, operation := range forceGeneratedOps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		backupPath, err := backupMgr.BackupAndReplaceAtomic(operation.Target, func(path string) error {
+			return i.createGeneratedFile(ctx, operation, path, mkdir)
+		})
+		if err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to backup and generate file %s: %v", operation.Target, err))
+		} else {
+			i.recordGenerated(operation, stateFile, statePath, log)
+			result.CreatedGenerated = append(result.CreatedGenerated, operation)
+			result.addBackup(backupPath)
+			result.undo.record(fmt.Sprintf("restore %s from backup %s", operation.Target, backupPath), i.undoReplace(operation.Target, backupPath))
+			log.Warn().Str("target", operation.Target).Msg("Backed up existing file and generated file from command output")
+		}
+
+		if !result.IsSuccess {
+			break
+		}
+	}
+	return nil
+}
+
+// setConflictDiffs sets the ConflictDiff of force operations whose target is a regular file,
+// comparing it with the source of links and the rendered content of templates. Templates keep
+// their rendered content, so they are rendered only once. Content that can't be read or
+// rendered gets no diff; installing it reports the error.
+func (i *Installer) setConflictDiffs(ctx context.Context, forceLinkOps, forceTemplateOps []FileOperation, vars map[string]string) {
+	for j := range forceLinkOps {
+		operation := &forceLinkOps[j]
+		if existing, ok := i.regularFileContent(operation.Target); ok {
+			if installed, err := i.fileOp.ReadFile(operation.Source); err == nil {
+				operation.ConflictDiff = unifiedDiff(operation.Target, existing, installed)
+			}
+		}
+	}
+	for j := range forceTemplateOps {
+		operation := &forceTemplateOps[j]
+		if existing, ok := i.regularFileContent(operation.Target); ok {
+			if operation.Rendered == nil {
+				operation.Rendered, _ = i.renderTemplate(ctx, *operation, vars)
+			}
+			if operation.Rendered != nil {
+				operation.ConflictDiff = unifiedDiff(operation.Target, existing, operation.Rendered)
+			}
+		}
+	}
+}
+
+// regularFileContent returns the content of path if it is a regular file
+func (i *Installer) regularFileContent(path string) ([]byte, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	content, err := i.fileOp.ReadFile(path)
+	return content, err == nil
+}
+
+// installDirs creates the empty directories of keep files and records them in the state file
+func (i *Installer) installDirs(ctx context.Context, ops []FileOperation, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
+	for _, operation := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !result.IsSuccess {
+			break
+		}
+		// The directory itself is what the keep file asks for; missing parents need mkdir, as
+		// for any other target
+		if err := i.ensureTargetDir(operation.Target, mkdir, operation.DirMode); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to create directory %s: %v", operation.Target, err))
+			break
+		}
+		if err := filesystem.EnsureDirectoryWithMode(i.fileOp, operation.Target, operation.DirMode); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to create directory %s: %v", operation.Target, err))
+			break
+		}
+
+		if stateFile != nil {
+			if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeDir); err != nil {
+				log.Warn().Err(err).Msg("Failed to add mapping to state file for directory")
+			}
+			i.recordTarget(statePath, stateFile, operation.Target, log)
+		}
+		result.CreatedDirs = append(result.CreatedDirs, operation)
+		result.undo.record("remove directory "+operation.Target, i.undoCreate(operation.Target))
+		log.Debug().Str("source", operation.Source).Str("target", operation.Target).Msg("Created directory for keep file")
+	}
+
+	return nil
+}
+
+// installGenerated writes files generated from command output
+func (i *Installer) installGenerated(ctx context.Context, ops []FileOperation, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
+	for _, operation := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Commands don't run once an earlier operation failed, including one of a previous phase
+		if !result.IsSuccess {
+			break
+		}
+		if err := i.removeDangling(operation, result, log); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to replace dangling symlink %s: %v", operation.Target, err))
+			break
+		}
+		if err := i.createGeneratedFile(ctx, operation, operation.Target, mkdir); err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to generate file %s: %v", operation.Target, err))
+			break
+		}
+
+		i.recordGenerated(operation, stateFile, statePath, log)
+		result.CreatedGenerated = append(result.CreatedGenerated, operation)
+		result.undo.record("remove generated file "+operation.Target, i.undoCreate(operation.Target))
+		log.Debug().Str("target", operation.Target).Strs("command", operation.Command).Msg("Generated file from command output")
+	}
+
+	return nil
+}
+
+// installMergedBlocks writes generator output into the module's block of shared files, leaving
+// the rest of each file alone. A block whose content didn't change is not rewritten.
+func (i *Installer) installMergedBlocks(ctx context.Context, ops []FileOperation, mkdir bool, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) error {
+	for _, operation := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Shared files aren't rewritten once an earlier operation failed
+		if !result.IsSuccess {
+			break
+		}
+		body, changed, err := i.mergeGeneratedBlock(ctx, operation, mkdir, result)
+		if err != nil {
+			result.failOperation(operation, fmt.Sprintf("failed to merge block %s into %s: %v", operation.Module, operation.Target, err))
+			break
+		}
+
+		if stateFile != nil {
+			stateFile.SetBlock(operation.Source, operation.Target, operation.Module, blockSHA1(body))
+			i.recordTarget(statePath, stateFile, operation.Target, log)
+		}
+		if changed {
+			result.MergedBlocks = append(result.MergedBlocks, operation)
+			log.Debug().Str("target", operation.Target).Str("block", operation.Module).Msg("Merged generator output into block")
+		}
+	}
+
 	return nil
 }
 
-// createTemplateFile creates a template file by rendering the template and writing to target
-func (i *Installer) createTemplateFile(source, target string, vars map[string]string, mkdir bool) error {
+// mergeGeneratedBlock runs the operation's command and merges its stdout into the module's
+// block of the target. It returns the block content and whether the target changed.
+func (i *Installer) mergeGeneratedBlock(ctx context.Context, operation FileOperation, mkdir bool, result *InstallResult) ([]byte, bool, error) {
+	if err := i.ensureTargetDir(operation.Target, mkdir, operation.DirMode); err != nil {
+		return nil, false, err
+	}
+
+	body, err := runGenerator(ctx, operation.Command, filepath.Dir(operation.Source), operation.Timeout)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := i.fileOp.ReadFile(operation.Target)
+	existed := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, fmt.Errorf("failed to read target: %w", err)
+	}
+
+	merged, err := mergeBlock(existing, operation.Module, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if existed && bytes.Equal(merged, existing) {
+		return body, false, nil
+	}
+
+	// WriteFile keeps the mode of an existing file, so 0644 only applies to new targets
+	if err := i.fileOp.WriteFile(operation.Target, merged, 0644); err != nil {
+		return nil, false, fmt.Errorf("failed to write target: %w", err)
+	}
+	if existed {
+		result.undo.record("restore "+operation.Target, func() error {
+			return i.fileOp.WriteFile(operation.Target, existing, 0644)
+		})
+	} else {
+		result.undo.record("remove merged file "+operation.Target, i.undoCreate(operation.Target))
+	}
+	return body, true, nil
+}
+
+// recordGenerated records a generated file in the state file; its SHA1 is taken from the written output
+func (i *Installer) recordGenerated(operation FileOperation, stateFile *dotmanState.StateFile, statePath string, log zerolog.Logger) {
+	if stateFile == nil {
+		return
+	}
+	i.applyRecordedMode(stateFile, operation.Target, log)
+	if err := i.stateMgr.AddMapping(stateFile, operation.Source, operation.Target, dotmanState.TypeGenerated); err != nil {
+		log.Warn().Err(err).Msg("Failed to add mapping to state file for generated file")
+	}
+	i.recordTarget(statePath, stateFile, operation.Target, log)
+}
 
-	// Ensure target directory exists
+// recordVars stores the vars each created template and generated file was rendered with in
+// its state entry. Generators run as commands get no vars, so nothing is stored for them.
+func (i *Installer) recordVars(result *InstallResult, rootVars map[string]string, stateFile *dotmanState.StateFile, statePath string, log zerolog.Logger) {
+	if len(result.CreatedTemplates) == 0 && len(result.CreatedGenerated) == 0 {
+		return
+	}
+	for _, operation := range result.CreatedTemplates {
+		vars := operation.Vars
+		if vars == nil {
+			vars = rootVars
+		}
+		stateFile.SetVars(operation.Target, maps.Clone(vars))
+		i.recordTarget(statePath, stateFile, operation.Target, log)
+	}
+	for _, operation := range result.CreatedGenerated {
+		if operation.Generator != nil {
+			stateFile.SetVars(operation.Target, maps.Clone(operation.Vars))
+			i.recordTarget(statePath, stateFile, operation.Target, log)
+		}
+	}
+}
+
+// recordTarget appends the entries the state file now holds for target to the state log, in
+// place of the ones logged before, instead of rewriting the whole state file after every
+// operation. Install folds the log into the state file once it is done.
+func (i *Installer) recordTarget(statePath string, stateFile *dotmanState.StateFile, target string, log zerolog.Logger) {
+	events := []dotmanState.Event{dotmanState.RemoveEvent(target)}
+	for _, entry := range stateFile.Files {
+		if entry.Target == target {
+			events = append(events, dotmanState.AddEvent(entry))
+		}
+	}
+	for _, event := range events {
+		if err := i.stateMgr.AppendEvent(statePath, stateFile, event); err != nil {
+			log.Warn().Err(err).Str("target", target).Msg("Failed to record state entry")
+			return
+		}
+	}
+}
+
+// applyRecordedMode sets a reinstalled generated or copied file to the mode recorded for it in
+// the state file, so a changed source mode or a chmod of the old target doesn't carry over.
+// Entries recorded without a mode keep the mode the file was written with.
+func (i *Installer) applyRecordedMode(stateFile *dotmanState.StateFile, target string, log zerolog.Logger) {
+	mode, ok := stateFile.RecordedMode(target)
+	if !ok {
+		return
+	}
+	if err := os.Chmod(target, mode); err != nil {
+		log.Warn().Err(err).Str("target", target).Msg("Failed to apply recorded file mode")
+	}
+}
+
+// createGeneratedFile runs the operation's command, or its registered Generator, and writes
+// the output to target
+func (i *Installer) createGeneratedFile(ctx context.Context, operation FileOperation, target string, mkdir bool) error {
+	if err := i.ensureTargetDir(target, mkdir, operation.DirMode); err != nil {
+		return err
+	}
+
+	var output []byte
+	var err error
+	if operation.Generator != nil {
+		output, err = operation.Generator.Generate(operation.Source, operation.Vars)
+		if err != nil {
+			err = fmt.Errorf("%s generator failed: %w", filepath.Ext(operation.Source), err)
+		}
+	} else {
+		output, err = runGenerator(ctx, operation.Command, filepath.Dir(operation.Source), operation.Timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	output, err = formatContent(ctx, operation, output)
+	if err != nil {
+		return err
+	}
+
+	if err := i.fileOp.WriteFile(target, output, 0644); err != nil {
+		return fmt.Errorf("failed to write generated file: %w", err)
+	}
+
+	return nil
+}
+
+// ensureTargetDir ensures the parent directory of target exists, creating it with dirMode when mkdir is set
+func (i *Installer) ensureTargetDir(target string, mkdir bool, dirMode os.FileMode) error {
 	targetDir := filepath.Dir(target)
 	if !i.fileOp.FileExists(targetDir) {
 		if mkdir {
-			if err := i.fileOp.EnsureDirectory(targetDir); err != nil {
+			if err := filesystem.EnsureDirectoryWithMode(i.fileOp, targetDir, dirMode); err != nil {
 				return fmt.Errorf("failed to create target directory %s: %w", targetDir, err)
 			}
 		} else {
 			return fmt.Errorf("target directory does not exist: %s", targetDir)
 		}
 	}
+	return nil
+}
 
-	// Render the template
-	content, err := i.template.Render(source, vars)
-	if err != nil {
-		return fmt.Errorf("failed to render template: %w", err)
+// createTemplateFile writes the operation's template to target, using its preflighted content when set
+func (i *Installer) createTemplateFile(ctx context.Context, operation FileOperation, target string, vars map[string]string, mkdir bool) error {
+	if err := i.ensureTargetDir(target, mkdir, operation.DirMode); err != nil {
+		return err
+	}
+
+	content := operation.Rendered
+	if content == nil {
+		var err error
+		if content, err = i.renderTemplate(ctx, operation, vars); err != nil {
+			return err
+		}
 	}
 
 	// Write the rendered content to the target file
-	if err := os.WriteFile(target, content, 0644); err != nil {
+	if err := i.fileOp.WriteFile(target, content, 0644); err != nil {
 		return fmt.Errorf("failed to write template file: %w", err)
 	}
 
 	return nil
 }
+
+// renderTemplate renders the operation's template and formats the result.
+// The template is rendered with the operation's own vars when set, otherwise with vars.
+func (i *Installer) renderTemplate(ctx context.Context, operation FileOperation, vars map[string]string) ([]byte, error) {
+	if operation.Vars != nil {
+		vars = operation.Vars
+	}
+
+	content, err := i.template.Render(operation.Source, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return formatContent(ctx, operation, content)
+}
+
+// renderOrSkipTemplates renders every operation into its Rendered content and returns the ones
+// that rendered; the others are recorded as skipped templates
+func (i *Installer) renderOrSkipTemplates(ctx context.Context, ops []FileOperation, vars map[string]string, result *InstallResult, log zerolog.Logger) []FileOperation {
+	var rendered []FileOperation
+	for _, operation := range ops {
+		content, err := i.renderTemplate(ctx, operation, vars)
+		if err != nil {
+			result.skipTemplate(operation, fmt.Sprintf("failed to render template %s -> %s: %v", operation.Source, operation.Target, err), log)
+			continue
+		}
+		operation.Rendered = content
+		rendered = append(rendered, operation)
+	}
+	return rendered
+}
+
+// preflightTemplates renders every operation into its Rendered content, recording each failure
+func (i *Installer) preflightTemplates(ctx context.Context, ops []FileOperation, vars map[string]string, result *InstallResult) {
+	for index := range ops {
+		content, err := i.renderTemplate(ctx, ops[index], vars)
+		if err != nil {
+			result.failOperation(ops[index], fmt.Sprintf("failed to render template %s -> %s: %v", ops[index].Source, ops[index].Target, err))
+			continue
+		}
+		ops[index].Rendered = content
+	}
+}
+
+// formatContent passes content through the operation's format command, if it has one
+func formatContent(ctx context.Context, operation FileOperation, content []byte) ([]byte, error) {
+	if len(operation.FormatCmd) == 0 {
+		return content, nil
+	}
+	formatted, err := runFormatter(ctx, operation.FormatCmd, filepath.Dir(operation.Source), content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format %s: %w", operation.Target, err)
+	}
+	return formatted, nil
+}
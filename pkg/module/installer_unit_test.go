@@ -1,13 +1,17 @@
 package module
 
 import (
+	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,9 +22,12 @@ func TestInstaller_InstallSymlinks(t *testing.T) {
 		name           string
 		operations     []FileOperation
 		mkdir          bool
+		privilegedCmd  string
 		setupMocks     func(*MockFileOperator, *MockStateManager)
 		expectedResult func(*testing.T, *InstallResult)
-		expectedError  string
+		// expectedPrivileged is the privileged fallback command that must run, nil if none
+		expectedPrivileged []string
+		expectedError      string
 	}{
 		{
 			name: "successful symlink creation",
@@ -73,6 +80,9 @@ func TestInstaller_InstallSymlinks(t *testing.T) {
 				assert.False(t, result.IsSuccess)
 				assert.Len(t, result.Errors, 1)
 				assert.Contains(t, result.Errors[0], "permission denied")
+				assert.Empty(t, result.CreatedLinks)
+				require.Len(t, result.FailedOperations, 1)
+				assert.Equal(t, result.Errors[0], result.FailedOperations[0].Description)
 			},
 		},
 		{
@@ -104,6 +114,79 @@ func TestInstaller_InstallSymlinks(t *testing.T) {
 				assert.Len(t, result.CreatedLinks, 1)
 			},
 		},
+		{
+			name: "permission denied falls back to the privileged command",
+			operations: []FileOperation{
+				{
+					Type:   OperationCreateLink,
+					Source: "/source/hosts",
+					Target: "/etc/hosts",
+				},
+			},
+			privilegedCmd: "sudo ln -sfn %s %s",
+			setupMocks: func(fo *MockFileOperator, sm *MockStateManager) {
+				fo.FileExistsFunc = func(path string) bool {
+					return path == "/etc"
+				}
+				fo.CreateSymlinkFunc = func(source, target string) error {
+					return &os.LinkError{Op: "symlink", Old: source, New: target, Err: syscall.EACCES}
+				}
+				sm.AddMappingFunc = func(stateFile *dotmanState.StateFile, source, target, fileType string) error {
+					return nil
+				}
+			},
+			expectedPrivileged: []string{"sudo", "ln", "-sfn", "/source/hosts", "/etc/hosts"},
+			expectedResult: func(t *testing.T, result *InstallResult) {
+				assert.True(t, result.IsSuccess, result.Errors)
+				assert.Len(t, result.CreatedLinks, 1)
+			},
+		},
+		{
+			name: "permission denied without a privileged command fails",
+			operations: []FileOperation{
+				{
+					Type:   OperationCreateLink,
+					Source: "/source/hosts",
+					Target: "/etc/hosts",
+				},
+			},
+			setupMocks: func(fo *MockFileOperator, sm *MockStateManager) {
+				fo.FileExistsFunc = func(path string) bool {
+					return path == "/etc"
+				}
+				fo.CreateSymlinkFunc = func(source, target string) error {
+					return &os.LinkError{Op: "symlink", Old: source, New: target, Err: syscall.EACCES}
+				}
+			},
+			expectedResult: func(t *testing.T, result *InstallResult) {
+				assert.False(t, result.IsSuccess)
+				require.Len(t, result.Errors, 1)
+				assert.Contains(t, result.Errors[0], "permission denied")
+			},
+		},
+		{
+			name: "other failures don't use the privileged command",
+			operations: []FileOperation{
+				{
+					Type:   OperationCreateLink,
+					Source: "/source/hosts",
+					Target: "/etc/hosts",
+				},
+			},
+			privilegedCmd: "sudo ln -sfn %s %s",
+			setupMocks: func(fo *MockFileOperator, sm *MockStateManager) {
+				fo.FileExistsFunc = func(path string) bool {
+					return path == "/etc"
+				}
+				fo.CreateSymlinkFunc = func(source, target string) error {
+					return &os.LinkError{Op: "symlink", Old: source, New: target, Err: syscall.EROFS}
+				}
+			},
+			expectedResult: func(t *testing.T, result *InstallResult) {
+				assert.False(t, result.IsSuccess)
+				assert.Empty(t, result.CreatedLinks)
+			},
+		},
 		{
 			name: "mkdir fails when directory creation fails",
 			operations: []FileOperation{
@@ -140,27 +223,36 @@ func TestInstaller_InstallSymlinks(t *testing.T) {
 			tt.setupMocks(mockFileOp, mockStateMgr)
 
 			// Create installer with mocks
+			var privileged []string
 			installer := &Installer{
 				fileOp:   mockFileOp,
 				stateMgr: mockStateMgr,
+				runPrivileged: func(ctx context.Context, command []string) ([]byte, error) {
+					privileged = command
+					return nil, nil
+				},
 			}
 
 			// Create test objects
 			stateFile := dotmanState.NewStateFile()
 			statePath := "/test/state.yaml"
-			result := &InstallResult{}
+			result := &InstallResult{IsSuccess: true}
 
 			// Create symlink manager with mocked file operator
 			symlinkMgr := filesystem.NewSymlinkManager(mockFileOp)
 
 			// Call method
 			err := installer.installSymlinks(
+				context.Background(),
 				tt.operations,
 				symlinkMgr,
 				tt.mkdir,
+				LinkModeSymlink,
+				tt.privilegedCmd,
 				stateFile,
 				statePath,
 				result,
+				zerolog.Nop(),
 			)
 
 			// Check expectations
@@ -173,6 +265,7 @@ func TestInstaller_InstallSymlinks(t *testing.T) {
 					tt.expectedResult(t, result)
 				}
 			}
+			assert.Equal(t, tt.expectedPrivileged, privileged)
 		})
 	}
 }
@@ -247,6 +340,36 @@ func TestInstaller_InstallTemplates(t *testing.T) {
 				assert.Contains(t, result.Errors[0], "template syntax error")
 			},
 		},
+		{
+			name: "template write fails",
+			operations: []FileOperation{
+				{
+					Type:   OperationCreateTemplate,
+					Source: "/source/config.dot-tmpl",
+					Target: "/target/config",
+				},
+			},
+			vars:  map[string]string{"USER": "testuser"},
+			mkdir: true,
+			setupMocks: func(fo *MockFileOperator, tr *MockTemplateRenderer, sm *MockStateManager) {
+				tr.RenderFunc = func(templatePath string, vars map[string]string) ([]byte, error) {
+					return []byte("User: testuser"), nil
+				}
+				fo.FileExistsFunc = func(path string) bool {
+					return true
+				}
+				fo.WriteFileFunc = func(path string, data []byte, perm os.FileMode) error {
+					return errors.New("no space left on device")
+				}
+			},
+			expectedResult: func(t *testing.T, result *InstallResult) {
+				assert.False(t, result.IsSuccess)
+				assert.Empty(t, result.CreatedTemplates)
+				require.Len(t, result.Errors, 1)
+				assert.Contains(t, result.Errors[0], "failed to write template file")
+				assert.Contains(t, result.Errors[0], "no space left on device")
+			},
+		},
 		{
 			name: "target directory doesn't exist and mkdir is false",
 			operations: []FileOperation{
@@ -313,12 +436,14 @@ func TestInstaller_InstallTemplates(t *testing.T) {
 
 			// Call the method
 			err := installer.installTemplates(
+				context.Background(),
 				tt.operations,
 				tt.vars,
 				tt.mkdir,
 				stateFile,
 				statePath,
 				result,
+				zerolog.Nop(),
 			)
 
 			// Check expectations
@@ -435,6 +560,53 @@ func TestInstaller_Install(t *testing.T) {
 				assert.True(t, result.IsSuccess)
 			},
 		},
+		{
+			name: "installation fails fast when state file is not readable",
+			request: func() *InstallRequest {
+				tempDir := t.TempDir()
+				return &InstallRequest{
+					Modules:     []config.ModuleConfig{{Dir: tempDir + "/module", TargetDir: tempDir + "/target"}},
+					RootVars:    map[string]string{"USER": "testuser"},
+					DotfilesDir: tempDir,
+				}
+			}(),
+			setupMocks: func(fo *MockFileOperator, tr *MockTemplateRenderer, sm *MockStateManager) {
+				fo.CreateSymlinkFunc = func(source, target string) error {
+					return errors.New("nothing may be installed")
+				}
+				sm.LoadFunc = func(path string) (*dotmanState.StateFile, error) {
+					return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+				}
+			},
+			expectedError: "cannot read state file",
+		},
+		{
+			name: "state file that cannot be saved is a warning",
+			request: func() *InstallRequest {
+				tempDir := t.TempDir()
+				return &InstallRequest{
+					Modules:     []config.ModuleConfig{{Dir: tempDir + "/module", TargetDir: tempDir + "/target"}},
+					RootVars:    map[string]string{"USER": "testuser"},
+					DotfilesDir: tempDir,
+				}
+			}(),
+			setupMocks: func(fo *MockFileOperator, tr *MockTemplateRenderer, sm *MockStateManager) {
+				fo.FileExistsFunc = func(path string) bool {
+					return filepath.Base(path) == "target"
+				}
+				sm.CompactFunc = func(path string) error {
+					return &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+				}
+			},
+			expectedResult: func(t *testing.T, result *InstallResult) {
+				assert.True(t, result.IsSuccess, result.Errors)
+				assert.True(t, result.StateNotSaved)
+				require.Len(t, result.Warnings, 1)
+				assert.Contains(t, result.Warnings[0], "could not be saved")
+				assert.Contains(t, result.Warnings[0], "writable")
+				assert.Equal(t, ExitStateNotSaved, result.ExitCode())
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -485,3 +657,89 @@ func TestInstaller_Install(t *testing.T) {
 		})
 	}
 }
+
+// TestGroupByModule tests that operations are broken down by their originating module
+func TestGroupByModule(t *testing.T) {
+	modules := []config.ModuleConfig{{Dir: "/dotfiles/nvim"}, {Dir: "/dotfiles/tmux"}, {Dir: "/dotfiles/git"}}
+	result := &InstallResult{
+		CreatedLinks: []FileOperation{
+			{Source: "/dotfiles/nvim/init.lua", Module: "nvim"},
+			{Source: "/dotfiles/nvim/lazy.lua", Module: "nvim"},
+		},
+		CreatedGenerated: []FileOperation{{Source: "/dotfiles/tmux/Dotfile", Module: "tmux"}},
+		SkippedLinks:     []FileOperation{{Source: "/dotfiles/tmux/tmux.conf", Module: "tmux"}},
+	}
+	result.failOperation(FileOperation{Source: "/dotfiles/tmux/theme.conf", Module: "tmux"}, "failed to create symlink")
+
+	grouped := groupByModule(modules, result)
+	require.Len(t, grouped, 3)
+
+	assert.True(t, grouped["nvim"].IsSuccess)
+	assert.Len(t, grouped["nvim"].CreatedLinks, 2)
+
+	assert.False(t, grouped["tmux"].IsSuccess)
+	assert.Len(t, grouped["tmux"].CreatedGenerated, 1)
+	assert.Len(t, grouped["tmux"].SkippedLinks, 1)
+	assert.Len(t, grouped["tmux"].FailedOperations, 1)
+	assert.Equal(t, []string{"failed to create symlink"}, grouped["tmux"].Errors)
+
+	// Modules without operations are still reported
+	assert.Equal(t, ModuleResult{IsSuccess: true}, grouped["git"])
+}
+
+// TestInstallPhasesStopAfterFailure tests that no phase applies operations once an earlier
+// operation failed
+func TestInstallPhasesStopAfterFailure(t *testing.T) {
+	// setup returns an installer on the real filesystem, a result failed by an earlier phase
+	// and the directory operations target
+	setup := func(t *testing.T) (*Installer, *InstallResult, string) {
+		installer := NewInstaller(filesystem.NewOperator(), &MockTemplateRenderer{}, &stateManagerAdapter{})
+		result := &InstallResult{IsSuccess: true}
+		result.failOperation(FileOperation{Type: OperationCreateLink, Target: "/earlier"}, "failed to create symlink")
+		return installer, result, t.TempDir()
+	}
+
+	tests := []struct {
+		name  string
+		phase func(installer *Installer, result *InstallResult, targetDir string) error
+		// applied lists the operations the phase recorded in result
+		applied func(result *InstallResult) []FileOperation
+	}{
+		{
+			name: "empty directories",
+			phase: func(installer *Installer, result *InstallResult, targetDir string) error {
+				ops := []FileOperation{{Type: OperationCreateDir, Target: filepath.Join(targetDir, "cache"), DirMode: 0755}}
+				return installer.installDirs(context.Background(), ops, false, nil, "", result, zerolog.Nop())
+			},
+			applied: func(result *InstallResult) []FileOperation { return result.CreatedDirs },
+		},
+		{
+			name: "generated files",
+			phase: func(installer *Installer, result *InstallResult, targetDir string) error {
+				ops := []FileOperation{{Type: OperationCreateGenerated, Target: filepath.Join(targetDir, "out"), Command: []string{"echo", "generated"}}}
+				return installer.installGenerated(context.Background(), ops, false, nil, "", result, zerolog.Nop())
+			},
+			applied: func(result *InstallResult) []FileOperation { return result.CreatedGenerated },
+		},
+		{
+			name: "merged blocks",
+			phase: func(installer *Installer, result *InstallResult, targetDir string) error {
+				ops := []FileOperation{{Type: OperationMergeGenerated, Target: filepath.Join(targetDir, "config"), Module: "git", Command: []string{"echo", "Host git"}}}
+				return installer.installMergedBlocks(context.Background(), ops, false, nil, "", result, zerolog.Nop())
+			},
+			applied: func(result *InstallResult) []FileOperation { return result.MergedBlocks },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installer, result, targetDir := setup(t)
+
+			require.NoError(t, tt.phase(installer, result, targetDir))
+			assert.Empty(t, tt.applied(result))
+			entries, err := os.ReadDir(targetDir)
+			require.NoError(t, err)
+			assert.Empty(t, entries, "nothing is written after a failure")
+		})
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	moduleState "github.com/elmhuangyu/dotman/pkg/module/state"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
 	"github.com/elmhuangyu/dotman/pkg/state"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -424,3 +428,109 @@ func TestMultipleInstallUninstallCycles(t *testing.T) {
 		assert.NoFileExists(t, targetFile2)
 	})
 }
+
+func TestUninstallWithHashCache(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	target := filepath.Join(targetDir, "config")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.WriteFile(target, []byte("generated"), 0644))
+	require.NoError(t, os.Chtimes(target, modTime, modTime))
+
+	stateFile := state.NewStateFile()
+	stateFile.Files = []state.FileMapping{{
+		Source: filepath.Join(dotfilesDir, "module", "config.dot-tmpl"),
+		Target: target,
+		Type:   state.TypeGenerated,
+		SHA1:   "cached-sha1",
+	}}
+	require.NoError(t, state.SaveStateFile(filepath.Join(dotfilesDir, "state.yaml"), stateFile))
+
+	// Seed the cache with a hash matching the state file so a cache hit skips the backup
+	cachePath := filepath.Join(dotfilesDir, state.HashCacheFileName)
+	cache := state.NewHashCache()
+	cache.Entries[target] = state.HashCacheEntry{Size: int64(len("generated")), ModTime: modTime, SHA1: "cached-sha1"}
+	require.NoError(t, state.SaveHashCache(cachePath, cache))
+
+	result, err := UninstallWithConfig(&UninstallConfig{
+		BackupModified: true,
+		StatePath:      dotfilesDir,
+		HashCache:      true,
+	})
+	require.NoError(t, err)
+	assert.True(t, result.IsSuccess)
+	assert.Len(t, result.RemovedGenerated, 1)
+	assert.Empty(t, result.BackedUpGenerated)
+
+	// The removed target is pruned from the saved cache
+	saved, err := state.LoadHashCache(cachePath)
+	require.NoError(t, err)
+	assert.Empty(t, saved.Entries)
+}
+
+// countingStateManager counts the state writes of the state manager it wraps
+type countingStateManager struct {
+	moduleState.StateManager
+	saves, appends, compactions int
+}
+
+func (m *countingStateManager) Save(path string, stateFile *state.StateFile) error {
+	m.saves++
+	return m.StateManager.Save(path, stateFile)
+}
+
+func (m *countingStateManager) AppendEvent(path string, stateFile *state.StateFile, event state.Event) error {
+	m.appends++
+	return m.StateManager.AppendEvent(path, stateFile, event)
+}
+
+func (m *countingStateManager) Compact(path string) error {
+	m.compactions++
+	return m.StateManager.Compact(path)
+}
+
+func TestInstallUninstallAppendToStateLog(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	moduleDir := filepath.Join(dotfilesDir, "module")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(moduleDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	for _, name := range []string{"a", "b", "c.dot-tmpl"} {
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte(name), 0644))
+	}
+	statePath := state.Path(dotfilesDir, "")
+
+	// Every operation is appended to the log, which is compacted once at the end
+	stateMgr := &countingStateManager{StateManager: &stateManagerAdapter{}}
+	installer := NewInstaller(filesystem.NewOperator(), template.NewRenderer(), stateMgr)
+	result, err := installer.Install(&InstallRequest{
+		Modules:     []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}},
+		DotfilesDir: dotfilesDir,
+	})
+	require.NoError(t, err)
+	require.True(t, result.IsSuccess, result.Errors)
+	assert.Zero(t, stateMgr.saves)
+	assert.GreaterOrEqual(t, stateMgr.appends, 3)
+	assert.Equal(t, 1, stateMgr.compactions)
+	assert.NoFileExists(t, state.LogPath(statePath))
+	stateFile, err := state.LoadStateFile(statePath)
+	require.NoError(t, err)
+	assert.Len(t, stateFile.Files, 3)
+
+	stateMgr = &countingStateManager{StateManager: &stateManagerAdapter{}}
+	uninstallResult, err := NewUninstaller(filesystem.NewOperator(), stateMgr).Uninstall(&UninstallRequest{DotfilesDir: dotfilesDir, BackupModified: true})
+	require.NoError(t, err)
+	require.True(t, uninstallResult.IsSuccess, uninstallResult.Errors)
+	assert.Zero(t, stateMgr.saves)
+	assert.Equal(t, 3, stateMgr.appends)
+	assert.Equal(t, 1, stateMgr.compactions)
+	assert.NoFileExists(t, state.LogPath(statePath))
+	stateFile, err = state.LoadStateFile(statePath)
+	require.NoError(t, err)
+	assert.Empty(t, stateFile.Files)
+}
@@ -0,0 +1,79 @@
+package module
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureGlobalLogger redirects the global logger into a buffer for the duration of the test
+func captureGlobalLogger(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	original := logger.Logger
+	logger.Logger = zerolog.New(&buf)
+	t.Cleanup(func() {
+		logger.Logger = original
+	})
+	return &buf
+}
+
+func TestRequestLogger(t *testing.T) {
+	setup := func(t *testing.T) (string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		moduleDir := filepath.Join(tempDir, "dotfiles", "module")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "file1.txt"), []byte("content1"), 0644))
+
+		return filepath.Join(tempDir, "dotfiles"), []config.ModuleConfig{
+			{Dir: moduleDir, TargetDir: targetDir},
+		}
+	}
+
+	t.Run("discard logger produces no output", func(t *testing.T) {
+		buf := captureGlobalLogger(t)
+		dotfilesDir, modules := setup(t)
+		discard := zerolog.Nop()
+
+		validateResult, err := ValidateWithConfig(modules, &ValidateConfig{Vars: map[string]string{}, Logger: &discard})
+		require.NoError(t, err)
+		assert.True(t, validateResult.IsValid)
+
+		installResult, err := InstallWithConfig(modules, &InstallConfig{
+			Vars:      map[string]string{},
+			StatePath: dotfilesDir,
+			Logger:    &discard,
+		})
+		require.NoError(t, err)
+		assert.True(t, installResult.IsSuccess)
+
+		uninstallResult, err := UninstallWithConfig(&UninstallConfig{
+			BackupModified: true,
+			StatePath:      dotfilesDir,
+			Logger:         &discard,
+		})
+		require.NoError(t, err)
+		assert.True(t, uninstallResult.IsSuccess)
+		assert.Len(t, uninstallResult.RemovedLinks, 1)
+
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("nil logger falls back to the global logger", func(t *testing.T) {
+		buf := captureGlobalLogger(t)
+		dotfilesDir, modules := setup(t)
+
+		_, err := Install(modules, map[string]string{}, false, false, dotfilesDir)
+		require.NoError(t, err)
+
+		assert.Contains(t, buf.String(), "Starting installation")
+	})
+}
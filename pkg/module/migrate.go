@@ -0,0 +1,76 @@
+package module
+
+import (
+	"fmt"
+
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// MigrateStateRelative rewrites the state file in dotfilesDir so that sources are
+// stored relative to the dotfiles dir and targets relative to the home dir.
+// Paths outside those roots stay absolute. Relative state files are rehydrated
+// to absolute paths on load, making them portable between machines.
+func MigrateStateRelative(dotfilesDir string) error {
+	return MigrateStateRelativeForProfile(dotfilesDir, "")
+}
+
+// MigrateStateRelativeForProfile is MigrateStateRelative for the state file of profile
+func MigrateStateRelativeForProfile(dotfilesDir, profile string) error {
+	statePath := state.Path(dotfilesDir, profile)
+	stateFile, err := state.LoadStateFile(statePath)
+	if err != nil {
+		return fmt.Errorf("failed to load state file: %w", err)
+	}
+	if stateFile == nil {
+		return fmt.Errorf("no state file found at %s", statePath)
+	}
+
+	stateFile.Relative = true
+	if err := state.SaveStateFile(statePath, stateFile); err != nil {
+		return fmt.Errorf("failed to save state file: %w", err)
+	}
+
+	return nil
+}
+
+// DedupeState removes duplicate entries for the same target from the state file in
+// dotfilesDir, keeping the most recent one, and returns how many entries were removed.
+// Duplicates with differing sources, e.g. from a hand-edited or merged state file,
+// are reported as warnings since only the kept source will be uninstalled.
+func DedupeState(dotfilesDir string) (int, error) {
+	return DedupeStateForProfile(dotfilesDir, "")
+}
+
+// DedupeStateForProfile is DedupeState for the state file of profile
+func DedupeStateForProfile(dotfilesDir, profile string) (int, error) {
+	log := logger.GetLogger()
+
+	statePath := state.Path(dotfilesDir, profile)
+	stateFile, err := state.LoadStateFile(statePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load state file: %w", err)
+	}
+	if stateFile == nil {
+		return 0, fmt.Errorf("no state file found at %s", statePath)
+	}
+
+	removed := 0
+	for _, duplicate := range stateFile.Dedupe() {
+		removed += len(duplicate.Removed)
+		if duplicate.Conflicting() {
+			for _, mapping := range duplicate.Removed {
+				log.Warn().Str("target", duplicate.Target).Str("kept_source", duplicate.Kept.Source).Str("removed_source", mapping.Source).Msg("Conflicting state entries for target")
+			}
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := state.SaveStateFile(statePath, stateFile); err != nil {
+		return 0, fmt.Errorf("failed to save state file: %w", err)
+	}
+
+	return removed, nil
+}
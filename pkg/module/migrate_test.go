@@ -0,0 +1,135 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateStateRelative(t *testing.T) {
+	t.Run("rewrites state file with relative paths", func(t *testing.T) {
+		tempDir := t.TempDir()
+		homeDir := filepath.Join(tempDir, "home")
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		t.Setenv("HOME", homeDir)
+
+		statePath := filepath.Join(dotfilesDir, "state.yaml")
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "vim", "vimrc"), filepath.Join(homeDir, ".vimrc"), state.TypeLink)
+		require.NoError(t, state.SaveStateFile(statePath, stateFile))
+
+		require.NoError(t, MigrateStateRelative(dotfilesDir))
+
+		data, err := os.ReadFile(statePath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "relative: true")
+		assert.Contains(t, string(data), "source: vim/vimrc")
+		assert.Contains(t, string(data), "target: .vimrc")
+
+		// Loading rehydrates absolute paths
+		loaded, err := state.LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dotfilesDir, "vim", "vimrc"), loaded.Files[0].Source)
+		assert.Equal(t, filepath.Join(homeDir, ".vimrc"), loaded.Files[0].Target)
+	})
+
+	t.Run("missing state file", func(t *testing.T) {
+		err := MigrateStateRelative(t.TempDir())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no state file found")
+	})
+}
+
+func TestDedupeState(t *testing.T) {
+	t.Run("removes duplicate and conflicting target entries", func(t *testing.T) {
+		dotfilesDir := t.TempDir()
+		statePath := filepath.Join(dotfilesDir, "state.yaml")
+		require.NoError(t, os.WriteFile(statePath, []byte(`version: 1.0.0
+files:
+  - source: /dotfiles/vim/vimrc
+    target: /home/user/.vimrc
+    type: link
+  - source: /dotfiles/zsh/zshrc
+    target: /home/user/.zshrc
+    type: link
+  - source: /dotfiles/vim/vimrc
+    target: /home/user/.vimrc
+    type: link
+  - source: /dotfiles/zsh-work/zshrc
+    target: /home/user/.zshrc
+    type: link
+`), 0644))
+
+		removed, err := DedupeState(dotfilesDir)
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+
+		stateFile, err := state.LoadStateFile(statePath)
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 2)
+		assert.Equal(t, "/home/user/.vimrc", stateFile.Files[0].Target)
+		assert.Equal(t, "/home/user/.zshrc", stateFile.Files[1].Target)
+		assert.Equal(t, "/dotfiles/zsh-work/zshrc", stateFile.Files[1].Source)
+	})
+
+	t.Run("leaves a clean state file untouched", func(t *testing.T) {
+		dotfilesDir := t.TempDir()
+		statePath := filepath.Join(dotfilesDir, "state.yaml")
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping("/dotfiles/vim/vimrc", "/home/user/.vimrc", state.TypeLink)
+		require.NoError(t, state.SaveStateFile(statePath, stateFile))
+		before, err := os.ReadFile(statePath)
+		require.NoError(t, err)
+
+		removed, err := DedupeState(dotfilesDir)
+		require.NoError(t, err)
+		assert.Zero(t, removed)
+
+		after, err := os.ReadFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("missing state file", func(t *testing.T) {
+		_, err := DedupeState(t.TempDir())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no state file found")
+	})
+}
+
+func TestMigrateStateForProfile(t *testing.T) {
+	dotfilesDir := t.TempDir()
+	defaultPath := state.Path(dotfilesDir, "")
+	workPath := state.Path(dotfilesDir, "work")
+	duplicated := `version: 1.0.0
+files:
+  - source: /dotfiles/vim/vimrc
+    target: /home/user/.vimrc
+    type: link
+  - source: /dotfiles/vim/vimrc
+    target: /home/user/.vimrc
+    type: link
+`
+	for _, statePath := range []string{defaultPath, workPath} {
+		require.NoError(t, os.WriteFile(statePath, []byte(duplicated), 0644))
+	}
+
+	removed, err := DedupeStateForProfile(dotfilesDir, "work")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.NoError(t, MigrateStateRelativeForProfile(dotfilesDir, "work"))
+
+	work, err := os.ReadFile(workPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(work), "relative: true")
+
+	// The state file of the default profile is left alone
+	defaultState, err := state.LoadStateFile(defaultPath)
+	require.NoError(t, err)
+	assert.Len(t, defaultState.Files, 2)
+	assert.False(t, defaultState.Relative)
+}
@@ -1,21 +1,28 @@
 package module
 
 import (
-	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"bytes"
+	"io"
 	"os"
+
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
 )
 
 // MockFileOperator is a mock implementation of filesystem.FileOperator
 type MockFileOperator struct {
-	CreateSymlinkFunc   func(source, target string) error
-	RemoveFileFunc      func(path string) error
-	CreateBackupFunc    func(path string) (string, error)
-	EnsureDirectoryFunc func(path string) error
-	CopyFileFunc        func(src, dst string) error
-	FileExistsFunc      func(path string) bool
-	IsSymlinkFunc       func(path string) bool
-	ReadlinkFunc        func(path string) (string, error)
-	WriteFileFunc       func(path string, data []byte, perm os.FileMode) error
+	CreateSymlinkFunc       func(source, target string) error
+	RemoveFileFunc          func(path string) error
+	CreateBackupFunc        func(path string) (string, error)
+	EnsureDirectoryFunc     func(path string) error
+	EnsureDirectoryModeFunc func(path string, perm os.FileMode) error
+	CopyFileFunc            func(src, dst string) error
+	FileExistsFunc          func(path string) bool
+	IsSymlinkFunc           func(path string) bool
+	ReadlinkFunc            func(path string) (string, error)
+	ReadFileFunc            func(path string) ([]byte, error)
+	OpenFunc                func(path string) (io.ReadCloser, error)
+	WriteFileFunc           func(path string, data []byte, perm os.FileMode) error
+	RenameFunc              func(oldPath, newPath string) error
 }
 
 func (m *MockFileOperator) CreateSymlink(source, target string) error {
@@ -46,6 +53,13 @@ func (m *MockFileOperator) EnsureDirectory(path string) error {
 	return nil
 }
 
+func (m *MockFileOperator) EnsureDirectoryMode(path string, perm os.FileMode) error {
+	if m.EnsureDirectoryModeFunc != nil {
+		return m.EnsureDirectoryModeFunc(path, perm)
+	}
+	return nil
+}
+
 func (m *MockFileOperator) CopyFile(src, dst string) error {
 	if m.CopyFileFunc != nil {
 		return m.CopyFileFunc(src, dst)
@@ -74,6 +88,25 @@ func (m *MockFileOperator) Readlink(path string) (string, error) {
 	return "", nil
 }
 
+func (m *MockFileOperator) ReadFile(path string) ([]byte, error) {
+	if m.ReadFileFunc != nil {
+		return m.ReadFileFunc(path)
+	}
+	return nil, nil
+}
+
+// Open streams the content ReadFile returns unless OpenFunc is set
+func (m *MockFileOperator) Open(path string) (io.ReadCloser, error) {
+	if m.OpenFunc != nil {
+		return m.OpenFunc(path)
+	}
+	content, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
 func (m *MockFileOperator) WriteFile(path string, data []byte, perm os.FileMode) error {
 	if m.WriteFileFunc != nil {
 		return m.WriteFileFunc(path, data, perm)
@@ -81,6 +114,13 @@ func (m *MockFileOperator) WriteFile(path string, data []byte, perm os.FileMode)
 	return nil
 }
 
+func (m *MockFileOperator) Rename(oldPath, newPath string) error {
+	if m.RenameFunc != nil {
+		return m.RenameFunc(oldPath, newPath)
+	}
+	return nil
+}
+
 // MockTemplateRenderer is a mock implementation of template.TemplateRenderer
 type MockTemplateRenderer struct {
 	RenderFunc   func(templatePath string, vars map[string]string) ([]byte, error)
@@ -107,6 +147,8 @@ type MockStateManager struct {
 	SaveFunc           func(path string, stateFile *dotmanState.StateFile) error
 	AddMappingFunc     func(stateFile *dotmanState.StateFile, source, target, fileType string) error
 	RemoveMappingsFunc func(stateFile *dotmanState.StateFile, targets []string) error
+	AppendEventFunc    func(path string, stateFile *dotmanState.StateFile, event dotmanState.Event) error
+	CompactFunc        func(path string) error
 }
 
 func (m *MockStateManager) Load(path string) (*dotmanState.StateFile, error) {
@@ -123,6 +165,20 @@ func (m *MockStateManager) Save(path string, stateFile *dotmanState.StateFile) e
 	return nil
 }
 
+func (m *MockStateManager) AppendEvent(path string, stateFile *dotmanState.StateFile, event dotmanState.Event) error {
+	if m.AppendEventFunc != nil {
+		return m.AppendEventFunc(path, stateFile, event)
+	}
+	return nil
+}
+
+func (m *MockStateManager) Compact(path string) error {
+	if m.CompactFunc != nil {
+		return m.CompactFunc(path)
+	}
+	return nil
+}
+
 func (m *MockStateManager) AddMapping(stateFile *dotmanState.StateFile, source, target, fileType string) error {
 	if m.AddMappingFunc != nil {
 		return m.AddMappingFunc(stateFile, source, target, fileType)
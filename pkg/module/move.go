@@ -0,0 +1,105 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// MoveTarget moves the managed link oldTarget to newTarget, pointing to the same source, and
+// updates its state entry in the state file of dotfilesDir
+func MoveTarget(dotfilesDir, oldTarget, newTarget string) error {
+	return MoveTargetWithConfig(&MoveConfig{StatePath: dotfilesDir, OldTarget: oldTarget, NewTarget: newTarget})
+}
+
+// MoveTargetWithConfig moves a managed link using the provided configuration. The old target
+// must be tracked as a link and still point to its source, and the new target must not exist
+// or be tracked. Only the target of the state entry changes; the rest of it is kept as is.
+// Nothing is left changed when the move fails.
+func MoveTargetWithConfig(cfg *MoveConfig) error {
+	log := logger.OrDefault(cfg.Logger)
+
+	oldTarget, err := filepath.Abs(cfg.OldTarget)
+	if err != nil {
+		return fmt.Errorf("failed to resolve old target %s: %w", cfg.OldTarget, err)
+	}
+	newTarget, err := filepath.Abs(cfg.NewTarget)
+	if err != nil {
+		return fmt.Errorf("failed to resolve new target %s: %w", cfg.NewTarget, err)
+	}
+	if oldTarget == newTarget {
+		return fmt.Errorf("%s is already the target", oldTarget)
+	}
+
+	statePath := dotmanState.Path(cfg.StatePath, cfg.Profile)
+	stateFile, err := dotmanState.LoadStateFile(statePath)
+	if err != nil {
+		return fmt.Errorf("failed to load state file: %w", err)
+	}
+	if stateFile == nil {
+		return fmt.Errorf("%s is not managed: no state file found", oldTarget)
+	}
+
+	index := -1
+	for i, entry := range stateFile.Files {
+		if entry.Target == newTarget {
+			return fmt.Errorf("%s is already managed from %s", newTarget, entry.Source)
+		}
+		if entry.Target == oldTarget && index < 0 {
+			index = i
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("%s is not managed", oldTarget)
+	}
+	entry := stateFile.Files[index]
+	if entry.Type != dotmanState.TypeLink {
+		return fmt.Errorf("%s is managed as %s, only links can be moved", oldTarget, entry.Type)
+	}
+
+	fileOp := filesystem.NewOperator()
+	symlinkMgr := filesystem.NewSymlinkManager(fileOp)
+	valid, reason, err := symlinkMgr.ValidateSymlink(oldTarget, entry.Source)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", oldTarget, err)
+	}
+	if !valid {
+		return fmt.Errorf("%s is not a correct link to %s: %s", oldTarget, entry.Source, reason)
+	}
+	if _, err := os.Lstat(newTarget); err == nil {
+		return fmt.Errorf("%s already exists", newTarget)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %w", newTarget, err)
+	}
+
+	// Link the new target before removing the old one, so a failure leaves the old link in place
+	if err := symlinkMgr.CreateSymlinkWithMkdir(entry.Source, newTarget, cfg.Mkdir); err != nil {
+		return fmt.Errorf("failed to link %s: %w", newTarget, err)
+	}
+	if err := symlinkMgr.RemoveSymlink(oldTarget); err != nil {
+		undoMove(symlinkMgr, entry.Source, oldTarget, newTarget, false)
+		return fmt.Errorf("failed to remove %s: %w", oldTarget, err)
+	}
+
+	stateFile.Files[index].Target = newTarget
+	if err := dotmanState.SaveStateFile(statePath, stateFile); err != nil {
+		undoMove(symlinkMgr, entry.Source, oldTarget, newTarget, true)
+		return fmt.Errorf("failed to save state file: %w", err)
+	}
+
+	log.Info().Str("source", entry.Source).Str("old_target", oldTarget).Str("new_target", newTarget).Msg("Moved target")
+	return nil
+}
+
+// undoMove removes the new link of a failed move and, when the old one was already removed,
+// links it again
+func undoMove(symlinkMgr *filesystem.SymlinkManager, source, oldTarget, newTarget string, relink bool) {
+	if relink {
+		symlinkMgr.CreateSymlinkWithMkdir(source, oldTarget, false)
+	}
+	symlinkMgr.RemoveSymlink(newTarget)
+}
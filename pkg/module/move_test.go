@@ -0,0 +1,102 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoveTarget(t *testing.T) {
+	// setup links ~/.vimrc to vim/vimrc and tracks it with a source hash, next to a generated file
+	setup := func(t *testing.T) (string, string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(filepath.Join(dotfilesDir, "vim"), 0755))
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+
+		vimrc := filepath.Join(dotfilesDir, "vim", "vimrc")
+		require.NoError(t, os.WriteFile(vimrc, []byte("set nu"), 0644))
+		require.NoError(t, os.Symlink(vimrc, filepath.Join(homeDir, ".vimrc")))
+		require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".gitconfig"), []byte("[user]"), 0644))
+
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(vimrc, filepath.Join(homeDir, ".vimrc"), state.TypeLink)
+		stateFile.SetSourceSHA1(filepath.Join(homeDir, ".vimrc"), "abc")
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "git", "gitconfig.dot-tmpl"), filepath.Join(homeDir, ".gitconfig"), state.TypeGenerated)
+		require.NoError(t, state.SaveStateFile(state.Path(dotfilesDir, ""), stateFile))
+		return dotfilesDir, homeDir, vimrc
+	}
+
+	t.Run("moves a link and its state entry", func(t *testing.T) {
+		dotfilesDir, homeDir, vimrc := setup(t)
+		newTarget := filepath.Join(homeDir, ".config", "nvim", "init.vim")
+
+		require.NoError(t, MoveTargetWithConfig(&MoveConfig{
+			StatePath: dotfilesDir,
+			OldTarget: filepath.Join(homeDir, ".vimrc"),
+			NewTarget: newTarget,
+			Mkdir:     true,
+		}))
+
+		assert.NoFileExists(t, filepath.Join(homeDir, ".vimrc"))
+		destination, err := os.Readlink(newTarget)
+		require.NoError(t, err)
+		assert.Equal(t, vimrc, destination)
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 2)
+		assert.Equal(t, state.FileMapping{Source: vimrc, Target: newTarget, Type: state.TypeLink, SourceSHA1: "abc"}, stateFile.Files[0])
+	})
+
+	t.Run("refuses invalid moves", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			prepare   func(t *testing.T, homeDir string)
+			oldTarget string
+			newTarget string
+			wantErr   string
+		}{
+			{name: "untracked target", oldTarget: ".zshrc", newTarget: ".zshrc2", wantErr: "is not managed"},
+			{name: "generated file", oldTarget: ".gitconfig", newTarget: ".gitconfig2", wantErr: "only links can be moved"},
+			{name: "tracked new target", oldTarget: ".vimrc", newTarget: ".gitconfig", wantErr: "is already managed"},
+			{name: "existing new target", oldTarget: ".vimrc", newTarget: ".exrc", wantErr: "already exists",
+				prepare: func(t *testing.T, homeDir string) {
+					require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".exrc"), []byte("local"), 0644))
+				}},
+			{name: "link replaced by a file", oldTarget: ".vimrc", newTarget: ".exrc", wantErr: "is not a correct link",
+				prepare: func(t *testing.T, homeDir string) {
+					require.NoError(t, os.Remove(filepath.Join(homeDir, ".vimrc")))
+					require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".vimrc"), []byte("local"), 0644))
+				}},
+			{name: "missing directory without mkdir", oldTarget: ".vimrc", newTarget: filepath.Join(".vim", "vimrc"), wantErr: "target directory does not exist"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				dotfilesDir, homeDir, _ := setup(t)
+				if tt.prepare != nil {
+					tt.prepare(t, homeDir)
+				}
+				before, err := os.ReadFile(state.Path(dotfilesDir, ""))
+				require.NoError(t, err)
+
+				err = MoveTargetWithConfig(&MoveConfig{
+					StatePath: dotfilesDir,
+					OldTarget: filepath.Join(homeDir, tt.oldTarget),
+					NewTarget: filepath.Join(homeDir, tt.newTarget),
+				})
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				after, err := os.ReadFile(state.Path(dotfilesDir, ""))
+				require.NoError(t, err)
+				assert.Equal(t, string(before), string(after))
+			})
+		}
+	})
+}
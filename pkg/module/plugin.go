@@ -0,0 +1,50 @@
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Generator produces the content of a target from a source file in a format dotman doesn't
+// know itself, such as a Starlark script
+type Generator interface {
+	Generate(source string, vars map[string]string) ([]byte, error)
+}
+
+var (
+	generatorsMu sync.RWMutex
+	// generators are the registered generators keyed by source file extension; empty by default
+	generators = make(map[string]Generator)
+)
+
+// RegisterGenerator makes generator produce the target of every module source file with
+// extension ext, such as ".star", instead of linking it. The target name drops the extension,
+// so config.json.star generates config.json. The output is tracked like the output of a module
+// generator command. Templates (.dot-tmpl) are always rendered. A nil generator unregisters ext.
+func RegisterGenerator(ext string, generator Generator) {
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext[1:], "./\\") {
+		panic(fmt.Sprintf("module: invalid generator extension %q", ext))
+	}
+
+	generatorsMu.Lock()
+	defer generatorsMu.Unlock()
+	if generator == nil {
+		delete(generators, ext)
+		return
+	}
+	generators[ext] = generator
+}
+
+// sourceGenerator returns the generator registered for the extension of source
+func sourceGenerator(source string) (Generator, bool) {
+	ext := filepath.Ext(source)
+	if ext == "" {
+		return nil, false
+	}
+	generatorsMu.RLock()
+	defer generatorsMu.RUnlock()
+	generator, ok := generators[ext]
+	return generator, ok
+}
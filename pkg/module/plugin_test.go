@@ -0,0 +1,134 @@
+package module
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upperGenerator upper-cases its source and appends the USER var
+type upperGenerator struct {
+	err error
+}
+
+func (g upperGenerator) Generate(source string, vars map[string]string) ([]byte, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.ToUpper(string(content)) + " " + vars["USER"]), nil
+}
+
+// registerGenerator registers generator for ext for the duration of the test
+func registerGenerator(t *testing.T, ext string, generator Generator) {
+	RegisterGenerator(ext, generator)
+	t.Cleanup(func() { RegisterGenerator(ext, nil) })
+}
+
+func TestRegisteredGenerator(t *testing.T) {
+	// setup creates a module with a generator source and a plain file
+	setup := func(t *testing.T) (string, string, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "app")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "config.json.up"), []byte("hello"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "plain"), []byte("plain"), 0644))
+		return dotfilesDir, targetDir, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir, Vars: map[string]string{"USER": "alice"}}}
+	}
+
+	t.Run("installs and uninstalls generator output", func(t *testing.T) {
+		registerGenerator(t, ".up", upperGenerator{})
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.CreatedGenerated, 1)
+		assert.Len(t, result.CreatedLinks, 1)
+
+		target := filepath.Join(targetDir, "config.json")
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "HELLO alice", string(content))
+		assert.NoFileExists(t, filepath.Join(targetDir, "config.json.up"))
+
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		var entry state.FileMapping
+		for _, file := range stateFile.Files {
+			if file.Target == target {
+				entry = file
+			}
+		}
+		assert.Equal(t, state.TypeGenerated, entry.Type)
+		assert.Equal(t, filepath.Join(modules[0].Dir, "config.json.up"), entry.Source)
+		assert.NotEmpty(t, entry.SHA1)
+
+		uninstallResult, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, uninstallResult.IsSuccess, uninstallResult.Errors)
+		assert.Len(t, uninstallResult.RemovedGenerated, 1)
+		assert.NoFileExists(t, target)
+		assert.NoFileExists(t, filepath.Join(targetDir, "plain"))
+	})
+
+	t.Run("existing target requires force", func(t *testing.T) {
+		registerGenerator(t, ".up", upperGenerator{})
+		dotfilesDir, targetDir, modules := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "config.json"), []byte("old"), 0644))
+
+		validation, err := ValidateWithConfig(modules, &ValidateConfig{})
+		require.NoError(t, err)
+		require.Len(t, validation.ForceGeneratedOps, 1)
+		assert.Contains(t, validation.ForceGeneratedOps[0].Description, "output of the .up generator would overwrite")
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, Force: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		content, err := os.ReadFile(filepath.Join(targetDir, "config.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "HELLO alice", string(content))
+	})
+
+	t.Run("generator errors fail the installation", func(t *testing.T) {
+		registerGenerator(t, ".up", upperGenerator{err: errors.New("syntax error")})
+		dotfilesDir, _, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], ".up generator failed: syntax error")
+	})
+
+	t.Run("unregistered extensions are linked", func(t *testing.T) {
+		dotfilesDir, targetDir, modules := setup(t)
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Empty(t, result.CreatedGenerated)
+		assert.Len(t, result.CreatedLinks, 2)
+		_, err = os.Readlink(filepath.Join(targetDir, "config.json.up"))
+		assert.NoError(t, err)
+	})
+}
+
+func TestRegisterGeneratorRejectsInvalidExtensions(t *testing.T) {
+	for _, ext := range []string{"", ".", "star", ".a.b", "./x"} {
+		assert.Panics(t, func() { RegisterGenerator(ext, upperGenerator{}) }, ext)
+	}
+}
@@ -0,0 +1,42 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/rs/zerolog"
+)
+
+// privilegedCommand fills the two %s placeholders of template, such as "sudo ln -sfn %s %s",
+// with source and target. Both are whole arguments, so paths with spaces stay intact.
+func privilegedCommand(template, source, target string) []string {
+	fields := strings.Fields(template)
+	command := make([]string, 0, len(fields))
+	args := []string{source, target}
+	for _, field := range fields {
+		if field == config.PrivilegedCmdPlaceholder && len(args) > 0 {
+			field, args = args[0], args[1:]
+		}
+		command = append(command, field)
+	}
+	return command
+}
+
+// linkPrivileged links target to source with the privileged command template, after
+// creating the link directly failed with a permission error
+func (i *Installer) linkPrivileged(ctx context.Context, template, source, target string, log zerolog.Logger) error {
+	absSource, err := filesystem.ResolveSource(source)
+	if err != nil {
+		return err
+	}
+
+	command := privilegedCommand(template, absSource, target)
+	log.Warn().Str("target", target).Strs("command", command).Msg("Permission denied, linking with the privileged command")
+	if _, err := i.runPrivileged(ctx, command); err != nil {
+		return fmt.Errorf("privileged command failed: %w", err)
+	}
+	return nil
+}
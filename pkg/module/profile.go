@@ -0,0 +1,64 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// profileLabel names profile in messages
+func profileLabel(profile string) string {
+	if profile == "" {
+		return "the default profile"
+	}
+	return fmt.Sprintf("profile %q", profile)
+}
+
+// crossProfileConflicts returns an error for every operation target that the state file of
+// another profile in dotfilesDir manages, since installing or uninstalling either profile
+// would break the other
+func crossProfileConflicts(dotfilesDir, profile string, operations []FileOperation) ([]string, error) {
+	if len(operations) == 0 {
+		return nil, nil
+	}
+
+	paths, err := state.ProfilePaths(dotfilesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	others := make([]string, 0, len(paths))
+	for other := range paths {
+		if other != profile {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+
+	// A target managed by several other profiles is reported for the first of them
+	owners := make(map[string]string)
+	for _, other := range others {
+		stateFile, err := state.LoadStateFile(paths[other])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state file of %s: %w", profileLabel(other), err)
+		}
+		if stateFile == nil {
+			continue
+		}
+		for _, file := range stateFile.Files {
+			if _, ok := owners[file.Target]; !ok {
+				owners[file.Target] = other
+			}
+		}
+	}
+
+	var conflicts []string
+	for _, operation := range operations {
+		if other, ok := owners[operation.Target]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("target conflict: %s is managed by %s", operation.Target, profileLabel(other)))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
@@ -0,0 +1,93 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallProfiles(t *testing.T) {
+	// setup creates work and home modules that both install into the same target directory
+	setup := func(t *testing.T) (string, string, config.ModuleConfig, config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+		modules := make(map[string]config.ModuleConfig)
+		for name, files := range map[string][]string{"work": {"gitconfig-work", "vpn.conf"}, "home": {"gitconfig-home"}} {
+			moduleDir := filepath.Join(dotfilesDir, name)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			for _, file := range files {
+				require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte(name), 0644))
+			}
+			modules[name] = config.ModuleConfig{Dir: moduleDir, TargetDir: targetDir}
+		}
+		return dotfilesDir, targetDir, modules["work"], modules["home"]
+	}
+
+	t.Run("each profile tracks its own state file", func(t *testing.T) {
+		dotfilesDir, targetDir, work, home := setup(t)
+
+		for profile, module := range map[string]config.ModuleConfig{"work": work, "home": home} {
+			result, err := InstallWithConfig([]config.ModuleConfig{module}, &InstallConfig{StatePath: dotfilesDir, Profile: profile})
+			require.NoError(t, err)
+			require.True(t, result.IsSuccess, result.Errors)
+		}
+
+		workState, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.work.yaml"))
+		require.NoError(t, err)
+		require.NotNil(t, workState)
+		assert.Len(t, workState.Files, 2)
+		homeState, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.home.yaml"))
+		require.NoError(t, err)
+		require.NotNil(t, homeState)
+		assert.Len(t, homeState.Files, 1)
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+
+		// Uninstalling one profile leaves the other installed
+		result, err := UninstallWithConfig(&UninstallConfig{StatePath: dotfilesDir, Profile: "work"})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.RemovedLinks, 2)
+		assert.NoFileExists(t, filepath.Join(targetDir, "gitconfig-work"))
+		assert.FileExists(t, filepath.Join(targetDir, "gitconfig-home"))
+
+		stats, err := StatsForProfile(dotfilesDir, "home")
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.Links)
+	})
+
+	t.Run("targets of another profile conflict", func(t *testing.T) {
+		dotfilesDir, targetDir, work, home := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(home.Dir, "vpn.conf"), []byte("home"), 0644))
+
+		result, err := InstallWithConfig([]config.ModuleConfig{work}, &InstallConfig{StatePath: dotfilesDir, Profile: "work"})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		// Even with force, installing home would take over the vpn.conf work manages
+		result, err = InstallWithConfig([]config.ModuleConfig{home}, &InstallConfig{StatePath: dotfilesDir, Profile: "home", Force: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.Equal(t, []string{"target conflict: " + filepath.Join(targetDir, "vpn.conf") + ` is managed by profile "work"`}, result.Errors)
+		dest, err := os.Readlink(filepath.Join(targetDir, "vpn.conf"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(work.Dir, "vpn.conf"), dest)
+
+		validation, err := ValidateWithConfig([]config.ModuleConfig{home}, &ValidateConfig{StateDir: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, validation.IsValid)
+		assert.Contains(t, validation.Errors, "target conflict: "+filepath.Join(targetDir, "vpn.conf")+` is managed by profile "work"`)
+
+		// Reinstalling the profile that manages the target is not a conflict
+		result, err = InstallWithConfig([]config.ModuleConfig{work}, &InstallConfig{StatePath: dotfilesDir, Profile: "work"})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+	})
+}
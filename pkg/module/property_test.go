@@ -3,7 +3,9 @@ package module
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 	"testing/quick"
 
@@ -13,6 +15,28 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// generateRequest fills every field of a request struct that testing/quick can
+// generate, leaving the others (such as the logger) at their zero value
+func generateRequest(typ reflect.Type, rand *rand.Rand, size int) reflect.Value {
+	v := reflect.New(typ).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		if value, ok := quick.Value(typ.Field(i).Type, rand); ok {
+			v.Field(i).Set(value)
+		}
+	}
+	return v
+}
+
+// Generate implements quick.Generator for InstallRequest
+func (InstallRequest) Generate(rand *rand.Rand, size int) reflect.Value {
+	return generateRequest(reflect.TypeOf(InstallRequest{}), rand, size)
+}
+
+// Generate implements quick.Generator for UninstallRequest
+func (UninstallRequest) Generate(rand *rand.Rand, size int) reflect.Value {
+	return generateRequest(reflect.TypeOf(UninstallRequest{}), rand, size)
+}
+
 // TestInstaller_PropertyBasedTests runs property-based tests for edge cases
 func TestInstaller_PropertyBasedTests(t *testing.T) {
 	// Test that installer handles empty module list gracefully
@@ -0,0 +1,95 @@
+package module
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// PruneState uninstalls the files tracked for modules that the DotRoot in dotfilesDir now
+// excludes, and drops their state entries
+func PruneState(dotfilesDir string) (*UninstallResult, error) {
+	return PruneStateWithConfig(&UninstallConfig{StatePath: dotfilesDir, BackupModified: true})
+}
+
+// PruneStateWithConfig prunes the entries of excluded modules using the provided configuration.
+// An entry belongs to the module whose discovered directory is the nearest one containing its
+// source; entries with a source outside the directory the modules are loaded from, SourceDir
+// or else the dotfiles directory, are left as they are.
+func PruneStateWithConfig(cfg *UninstallConfig) (*UninstallResult, error) {
+	log := logger.OrDefault(cfg.Logger)
+
+	rootConfig, err := config.LoadRootConfig(cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sources are recorded as absolute paths, so the module directories must be too
+	sourceDir, err := filepath.Abs(moduleSourceDir(cfg.StatePath, cfg.SourceDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+	moduleDirs, err := config.ModuleDirNames(sourceDir, rootConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover modules: %w", err)
+	}
+
+	stateFile, err := dotmanState.LoadStateFile(dotmanState.Path(cfg.StatePath, cfg.Profile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+
+	var targets []string
+	if stateFile != nil {
+		for _, entry := range stateFile.Files {
+			if name, ok := excludedModuleOf(sourceDir, entry.Source, moduleDirs, &rootConfig); ok {
+				log.Info().Str("module", name).Str("target", entry.Target).Msg("Pruning entry of excluded module")
+				targets = append(targets, entry.Target)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return &UninstallResult{
+			IsSuccess: true,
+			Errors:    []string{},
+			Summary:   "No state entries of excluded modules found",
+		}, nil
+	}
+
+	uninstaller := NewUninstaller(filesystem.NewOperator(), &stateManagerAdapter{})
+	return uninstaller.Uninstall(&UninstallRequest{
+		DotfilesDir:      cfg.StatePath,
+		BackupModified:   cfg.BackupModified,
+		HashCache:        cfg.HashCache,
+		VerifyOwner:      cfg.VerifyOwner,
+		MaxBackups:       cfg.MaxBackups,
+		CompressBackups:  cfg.CompressBackups,
+		TimestampBackups: cfg.TimestampBackups,
+		BackupSuffix:     cfg.BackupSuffix,
+		Profile:          cfg.Profile,
+		ExcludeTargets:   cfg.ExcludeTargets,
+		OnlyTargets:      targets,
+		Context:          cfg.Context,
+		Logger:           cfg.Logger,
+	})
+}
+
+// excludedModuleOf returns the name of the excluded module source belongs to, if any. Only the
+// module directories in moduleDirs, mapped to their names, own sources; a subdirectory of a
+// module that happens to share the name of an excluded module doesn't.
+func excludedModuleOf(sourceDir, source string, moduleDirs map[string]string, rootConfig *config.RootConfig) (string, bool) {
+	root := filepath.Clean(sourceDir)
+	for dir := filepath.Dir(filepath.Clean(source)); dir != root; dir = filepath.Dir(dir) {
+		if !isUnderAnyRoot(dir, []string{root}) {
+			return "", false
+		}
+		if name, ok := moduleDirs[dir]; ok {
+			return name, rootConfig.IsModuleExcluded(name)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneState(t *testing.T) {
+	// setup installs the modules vim and work from dotfilesDir into homeDir
+	setup := func(t *testing.T) (string, string) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		homeDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(homeDir, 0755))
+
+		var modules []config.ModuleConfig
+		for name, file := range map[string]string{"vim": "vimrc", "work": "work.conf"} {
+			moduleDir := filepath.Join(dotfilesDir, name)
+			require.NoError(t, os.MkdirAll(moduleDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, file), []byte(name), 0644))
+			modules = append(modules, config.ModuleConfig{Dir: moduleDir, TargetDir: homeDir})
+		}
+
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		return dotfilesDir, homeDir
+	}
+
+	t.Run("entries of an excluded module are uninstalled", func(t *testing.T) {
+		dotfilesDir, homeDir := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("exclude_modules:\n  - work\n"), 0644))
+
+		result, err := PruneState(dotfilesDir)
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.RemovedLinks, 1)
+		assert.Equal(t, filepath.Join(homeDir, "work.conf"), result.RemovedLinks[0].Target)
+
+		assert.NoFileExists(t, filepath.Join(homeDir, "work.conf"))
+		assert.FileExists(t, filepath.Join(homeDir, "vimrc"))
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, filepath.Join(homeDir, "vimrc"), stateFile.Files[0].Target)
+	})
+
+	t.Run("a module subdirectory named like an excluded module is kept", func(t *testing.T) {
+		dotfilesDir, homeDir := setup(t)
+		nested := filepath.Join(dotfilesDir, "vim", "work", "conf")
+		require.NoError(t, os.MkdirAll(filepath.Dir(nested), 0755))
+		require.NoError(t, os.WriteFile(nested, []byte("nested"), 0644))
+		modules := []config.ModuleConfig{{Dir: filepath.Join(dotfilesDir, "vim"), TargetDir: homeDir}}
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, Mkdir: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("exclude_modules:\n  - work\n"), 0644))
+
+		result2, err := PruneState(dotfilesDir)
+		require.NoError(t, err)
+		assert.True(t, result2.IsSuccess, result2.Errors)
+		require.Len(t, result2.RemovedLinks, 1)
+		assert.Equal(t, filepath.Join(homeDir, "work.conf"), result2.RemovedLinks[0].Target)
+		assert.FileExists(t, filepath.Join(homeDir, "work", "conf"))
+	})
+
+	t.Run("modules are discovered in the source dir", func(t *testing.T) {
+		sourceDir, homeDir := setup(t)
+		stateDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(stateDir, "DotRoot"), []byte("exclude_modules:\n  - work\n"), 0644))
+		require.NoError(t, os.Rename(filepath.Join(sourceDir, "state.yaml"), filepath.Join(stateDir, "state.yaml")))
+
+		result, err := PruneStateWithConfig(&UninstallConfig{StatePath: stateDir, SourceDir: sourceDir, BackupModified: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.RemovedLinks, 1)
+		assert.Equal(t, filepath.Join(homeDir, "work.conf"), result.RemovedLinks[0].Target)
+		assert.FileExists(t, filepath.Join(homeDir, "vimrc"))
+	})
+
+	t.Run("nothing is pruned without excluded modules", func(t *testing.T) {
+		dotfilesDir, homeDir := setup(t)
+
+		result, err := PruneState(dotfilesDir)
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess)
+		assert.Empty(t, result.RemovedLinks)
+		assert.FileExists(t, filepath.Join(homeDir, "work.conf"))
+		stateFile, err := state.LoadStateFile(state.Path(dotfilesDir, ""))
+		require.NoError(t, err)
+		assert.Len(t, stateFile.Files, 2)
+	})
+}
@@ -0,0 +1,133 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
+)
+
+// RenderPreview renders a single template with the root and module vars it would be
+// installed with and returns the result without writing anything. A relative
+// templatePath is resolved against dotfilesDir.
+func RenderPreview(dotfilesDir, templatePath string) ([]byte, error) {
+	return RenderPreviewWithSourceDir(dotfilesDir, "", templatePath)
+}
+
+// RenderPreviewWithSourceDir renders a template like RenderPreview, with the modules loaded
+// from sourceDir as install --source-dir would; an empty sourceDir uses dotfilesDir. A
+// relative templatePath is resolved against the source directory.
+func RenderPreviewWithSourceDir(dotfilesDir, sourceDir, templatePath string) ([]byte, error) {
+	dotfilesDir, err := filepath.Abs(dotfilesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dotfiles directory: %w", err)
+	}
+	sourceDir, err = filepath.Abs(moduleSourceDir(dotfilesDir, sourceDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+	if !filepath.IsAbs(templatePath) {
+		templatePath = filepath.Join(sourceDir, templatePath)
+	}
+
+	if !isTemplateFile(templatePath) {
+		return nil, fmt.Errorf("%s is not a template, expected the .dot-tmpl suffix", templatePath)
+	}
+	if _, err := os.Stat(templatePath); err != nil {
+		return nil, fmt.Errorf("failed to stat template: %w", err)
+	}
+
+	// The modules give the template the paths it can read as .Modules
+	cfg, err := config.LoadDirWithSourceDir(dotfilesDir, sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	rootConfig := cfg.RootConfig
+
+	moduleConfig, err := templateModule(templatePath, sourceDir, rootConfig.Vars)
+	if err != nil {
+		return nil, err
+	}
+	if moduleConfig == nil {
+		return nil, fmt.Errorf("%s is not inside a module of %s", templatePath, sourceDir)
+	}
+
+	vars, err := fileTemplateVars(templatePath, moduleConfig.TemplateVars(rootConfig.Vars))
+	if err != nil {
+		return nil, err
+	}
+	return template.NewRendererWithModules(ModulePaths(cfg.Modules)).Render(templatePath, vars)
+}
+
+// fileTemplateVars merges the per-file vars colocated with a template, such as
+// init.lua.vars.yaml for init.lua.dot-tmpl, over the root and module vars
+func fileTemplateVars(source string, vars map[string]string) (map[string]string, error) {
+	fileVars, err := config.LoadVarsFile(varsFilePath(source))
+	if err != nil {
+		return nil, err
+	}
+	if len(fileVars) == 0 {
+		return vars, nil
+	}
+
+	merged := make(map[string]string, len(vars)+len(fileVars))
+	for key, value := range vars {
+		merged[key] = value
+	}
+	for key, value := range fileVars {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// ModulePaths returns the paths of modules keyed by module name, for templates to read as .Modules
+func ModulePaths(modules []config.ModuleConfig) map[string]template.ModulePaths {
+	paths := make(map[string]template.ModulePaths, len(modules))
+	for _, module := range modules {
+		paths[module.Name()] = template.ModulePaths{
+			Dir:        module.Dir,
+			TargetDir:  module.TargetDir,
+			TargetFile: module.TargetFile,
+		}
+	}
+	return paths
+}
+
+// loadModulePaths returns the paths of the modules of dotfilesDir keyed by module name, loaded
+// from sourceDir when set
+func loadModulePaths(dotfilesDir, sourceDir string) (map[string]template.ModulePaths, error) {
+	cfg, err := config.LoadDirWithSourceDir(dotfilesDir, moduleSourceDir(dotfilesDir, sourceDir))
+	if err != nil {
+		return nil, err
+	}
+	return ModulePaths(cfg.Modules), nil
+}
+
+// moduleSourceDir returns the directory the modules are loaded from: sourceDir when set and
+// dotfilesDir otherwise
+func moduleSourceDir(dotfilesDir, sourceDir string) string {
+	if sourceDir != "" {
+		return sourceDir
+	}
+	return dotfilesDir
+}
+
+// templateModule loads the config of the module containing a template, looking for the
+// nearest Dotfile between the template and dotfilesDir; nil when there is none
+func templateModule(source, dotfilesDir string, vars map[string]string) (*config.ModuleConfig, error) {
+	for dir := filepath.Dir(source); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if rel, err := filepath.Rel(dotfilesDir, dir); err != nil || !filepath.IsLocal(rel) {
+			break
+		}
+		moduleConfig, err := config.LoadConfigWithVars(dir, vars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load module config: %w", err)
+		}
+		if moduleConfig != nil {
+			return moduleConfig, nil
+		}
+	}
+	return nil, nil
+}
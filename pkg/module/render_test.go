@@ -0,0 +1,131 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPreview(t *testing.T) {
+	// setup creates a dotfiles dir with root vars and an nvim module overriding one of them
+	setup := func(t *testing.T) string {
+		dotfilesDir := t.TempDir()
+		moduleDir := filepath.Join(dotfilesDir, "nvim")
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "lua"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte(`vars:
+  USER: alice
+  THEME: light
+`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "Dotfile"), []byte(`target_dir: "/home/alice/.config/nvim"
+vars:
+  THEME: dark
+  LEADER: ","
+`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "lua", "init.lua.dot-tmpl"), []byte(`-- {{.USER}}: {{.THEME}} {{.LEADER}}`), 0644))
+		return dotfilesDir
+	}
+
+	t.Run("renders with root and module vars", func(t *testing.T) {
+		dotfilesDir := setup(t)
+
+		content, err := RenderPreview(dotfilesDir, "nvim/lua/init.lua.dot-tmpl")
+		require.NoError(t, err)
+		assert.Equal(t, `-- alice: dark ,`, string(content))
+
+		// Nothing is written next to the template or in the target
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "nvim", "lua", "init.lua"))
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+	})
+
+	t.Run("per-file vars override module vars", func(t *testing.T) {
+		dotfilesDir := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "nvim", "lua", "init.lua.vars.yaml"), []byte("THEME: solarized\n"), 0644))
+
+		content, err := RenderPreview(dotfilesDir, "nvim/lua/init.lua.dot-tmpl")
+		require.NoError(t, err)
+		assert.Equal(t, `-- alice: solarized ,`, string(content))
+	})
+
+	t.Run("reads the paths of other modules", func(t *testing.T) {
+		dotfilesDir := setup(t)
+		shellDir := filepath.Join(dotfilesDir, "shell")
+		require.NoError(t, os.MkdirAll(shellDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(shellDir, "Dotfile"), []byte(`target_dir: "/home/alice"`), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(shellDir, ".zshrc.dot-tmpl"), []byte(`export MYVIMRC={{.Modules.nvim.TargetDir}}/init.lua`), 0644))
+
+		content, err := RenderPreview(dotfilesDir, "shell/.zshrc.dot-tmpl")
+		require.NoError(t, err)
+		assert.Equal(t, `export MYVIMRC=/home/alice/.config/nvim/init.lua`, string(content))
+	})
+
+	t.Run("modules in a source dir", func(t *testing.T) {
+		sourceDir := setup(t)
+		dotfilesDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte("vars:\n  USER: bob\n"), 0644))
+
+		// The template path is relative to the source dir and vars come from the dotfiles dir
+		content, err := RenderPreviewWithSourceDir(dotfilesDir, sourceDir, "nvim/lua/init.lua.dot-tmpl")
+		require.NoError(t, err)
+		assert.Equal(t, `-- bob: dark ,`, string(content))
+	})
+
+	t.Run("absolute template path", func(t *testing.T) {
+		dotfilesDir := setup(t)
+
+		content, err := RenderPreview(dotfilesDir, filepath.Join(dotfilesDir, "nvim", "lua", "init.lua.dot-tmpl"))
+		require.NoError(t, err)
+		assert.Equal(t, `-- alice: dark ,`, string(content))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			setup       func(t *testing.T, dotfilesDir string)
+			template    string
+			errContains string
+		}{
+			{
+				name:        "not a template",
+				setup:       func(t *testing.T, dotfilesDir string) {},
+				template:    "nvim/Dotfile",
+				errContains: "is not a template",
+			},
+			{
+				name:        "missing template",
+				setup:       func(t *testing.T, dotfilesDir string) {},
+				template:    "nvim/missing.dot-tmpl",
+				errContains: "failed to stat template",
+			},
+			{
+				name: "outside any module",
+				setup: func(t *testing.T, dotfilesDir string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "loose.dot-tmpl"), []byte("x"), 0644))
+				},
+				template:    "loose.dot-tmpl",
+				errContains: "is not inside a module",
+			},
+			{
+				name: "undefined var",
+				setup: func(t *testing.T, dotfilesDir string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "nvim", "bad.dot-tmpl"), []byte("{{.MISSING}}"), 0644))
+				},
+				template:    "nvim/bad.dot-tmpl",
+				errContains: "failed to execute template",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				dotfilesDir := setup(t)
+				tt.setup(t, dotfilesDir)
+
+				_, err := RenderPreview(dotfilesDir, tt.template)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+			})
+		}
+	})
+}
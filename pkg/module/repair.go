@@ -0,0 +1,76 @@
+package module
+
+import (
+	"fmt"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/elmhuangyu/dotman/pkg/module/state"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
+)
+
+// RepairResult contains the results of a repair
+type RepairResult struct {
+	IsSuccess bool
+	Summary   string
+	Errors    []string
+	// RepairedLinks are symlinks that were missing, repointed or replaced by another file
+	RepairedLinks []FileOperation
+	// RegeneratedFiles are generated files that were missing or regenerated after modification
+	RegeneratedFiles []FileOperation
+	// SkippedGenerated are modified generated files left alone because regeneration was not requested
+	SkippedGenerated []FileOperation
+	// Backups are the paths conflicting files were moved to before being replaced
+	Backups []string
+	// FixedModes are generated or copied files whose permissions drifted from the recorded mode
+	FixedModes []FileOperation
+}
+
+// Repair restores the symlinks and generated files recorded in the state file to their installed form
+func Repair(dotfilesDir string) (*RepairResult, error) {
+	rootConfig, err := config.LoadRootConfig(dotfilesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load root config: %w", err)
+	}
+
+	return RepairWithConfig(&RepairConfig{
+		StatePath:        dotfilesDir,
+		Vars:             rootConfig.Vars,
+		MaxBackups:       rootConfig.MaxBackups,
+		CompressBackups:  rootConfig.CompressBackups,
+		TimestampBackups: rootConfig.TimestampBackups,
+		BackupSuffix:     rootConfig.BackupSuffix,
+	})
+}
+
+// RepairWithConfig performs a repair using the provided configuration
+func RepairWithConfig(config *RepairConfig) (*RepairResult, error) {
+	// Initialize dependencies
+	fileOp := filesystem.NewOperator()
+	// Regenerated templates read the module paths as they are now; a configuration that no
+	// longer loads leaves .Modules undefined, failing only the templates that read it
+	templateRenderer := template.NewRenderer()
+	if paths, err := loadModulePaths(config.StatePath, config.SourceDir); err == nil {
+		templateRenderer = template.NewRendererWithModules(paths)
+	}
+	stateMgr := state.NewStateManager()
+
+	// Repairs reuse the installer's link and file creation
+	installer := NewInstaller(fileOp, templateRenderer, stateMgr)
+
+	req := &RepairRequest{
+		DotfilesDir:      config.StatePath,
+		Vars:             config.Vars,
+		Regenerate:       config.Regenerate,
+		MaxBackups:       config.MaxBackups,
+		CompressBackups:  config.CompressBackups,
+		TimestampBackups: config.TimestampBackups,
+		BackupSuffix:     config.BackupSuffix,
+		Profile:          config.Profile,
+		SourceDir:        config.SourceDir,
+		Context:          config.Context,
+		Logger:           config.Logger,
+	}
+
+	return installer.Repair(req)
+}
@@ -0,0 +1,233 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	setup := func(t *testing.T) (dotfilesDir, moduleDir, targetDir string) {
+		tempDir := t.TempDir()
+		dotfilesDir = filepath.Join(tempDir, "dotfiles")
+		moduleDir = filepath.Join(dotfilesDir, "module")
+		targetDir = filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "file1.txt"), []byte("content1"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "file2.txt"), []byte("content2"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "greeting.dot-tmpl"), []byte("hello {{.NAME}}"), 0644))
+
+		modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+		result, err := Install(modules, map[string]string{"NAME": "world"}, false, false, dotfilesDir)
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess)
+		return dotfilesDir, moduleDir, targetDir
+	}
+
+	assertLinked := func(t *testing.T, target, source string) {
+		link, err := os.Readlink(target)
+		require.NoError(t, err)
+		assert.Equal(t, source, link)
+	}
+
+	t.Run("nothing to repair", func(t *testing.T) {
+		dotfilesDir, _, _ := setup(t)
+
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: map[string]string{"NAME": "world"}})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess)
+		assert.Empty(t, result.RepairedLinks)
+		assert.Empty(t, result.RegeneratedFiles)
+		assert.Equal(t, "Nothing to repair", result.Summary)
+	})
+
+	t.Run("repointed and deleted links", func(t *testing.T) {
+		dotfilesDir, moduleDir, targetDir := setup(t)
+		elsewhere := filepath.Join(t.TempDir(), "elsewhere")
+		require.NoError(t, os.WriteFile(elsewhere, []byte("other"), 0644))
+
+		target1 := filepath.Join(targetDir, "file1.txt")
+		target2 := filepath.Join(targetDir, "file2.txt")
+		require.NoError(t, os.Remove(target1))
+		require.NoError(t, os.Symlink(elsewhere, target1))
+		require.NoError(t, os.Remove(target2))
+
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.RepairedLinks, 2)
+		assert.Empty(t, result.Backups)
+
+		assertLinked(t, target1, filepath.Join(moduleDir, "file1.txt"))
+		assertLinked(t, target2, filepath.Join(moduleDir, "file2.txt"))
+
+		// The file the repointed link referenced is untouched
+		content, err := os.ReadFile(elsewhere)
+		require.NoError(t, err)
+		assert.Equal(t, "other", string(content))
+	})
+
+	t.Run("link replaced by a real file is backed up", func(t *testing.T) {
+		dotfilesDir, moduleDir, targetDir := setup(t)
+		target := filepath.Join(targetDir, "file1.txt")
+		require.NoError(t, os.Remove(target))
+		require.NoError(t, os.WriteFile(target, []byte("local edits"), 0644))
+
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.RepairedLinks, 1)
+		assert.Equal(t, OperationForceLink, result.RepairedLinks[0].Type)
+		require.Len(t, result.Backups, 1)
+
+		assertLinked(t, target, filepath.Join(moduleDir, "file1.txt"))
+		backup, err := os.ReadFile(result.Backups[0])
+		require.NoError(t, err)
+		assert.Equal(t, "local edits", string(backup))
+	})
+
+	t.Run("generated files", func(t *testing.T) {
+		dotfilesDir, _, targetDir := setup(t)
+		vars := map[string]string{"NAME": "world"}
+		target := filepath.Join(targetDir, "greeting")
+
+		// Modified generated files are left alone unless regeneration is requested
+		require.NoError(t, os.WriteFile(target, []byte("hand edited"), 0644))
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess)
+		assert.Len(t, result.SkippedGenerated, 1)
+		assert.Empty(t, result.RegeneratedFiles)
+
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars, Regenerate: true})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.RegeneratedFiles, 1)
+		require.Len(t, result.Backups, 1)
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(content))
+
+		// Missing generated files are always regenerated, and the state hash stays current
+		require.NoError(t, os.Remove(target))
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		assert.Len(t, result.RegeneratedFiles, 1)
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, stateFile.Files, 3)
+
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.Equal(t, "Nothing to repair", result.Summary)
+	})
+
+	t.Run("mode drift", func(t *testing.T) {
+		dotfilesDir, _, targetDir := setup(t)
+		vars := map[string]string{"NAME": "world"}
+		statePath := filepath.Join(dotfilesDir, "state.yaml")
+		target := filepath.Join(targetDir, "greeting")
+
+		// setRecordedMode rewrites the mode recorded for the generated target
+		setRecordedMode := func(t *testing.T, mode string) {
+			stateFile, err := state.LoadStateFile(statePath)
+			require.NoError(t, err)
+			for index := range stateFile.Files {
+				if stateFile.Files[index].Target == target {
+					stateFile.Files[index].Mode = mode
+				}
+			}
+			require.NoError(t, state.SaveStateFile(statePath, stateFile))
+		}
+		assertMode := func(t *testing.T, want os.FileMode) {
+			info, err := os.Stat(target)
+			require.NoError(t, err)
+			assert.Equal(t, want, info.Mode().Perm())
+		}
+
+		setRecordedMode(t, "0640")
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.True(t, result.IsSuccess, result.Errors)
+		require.Len(t, result.FixedModes, 1)
+		assert.Equal(t, OperationFixMode, result.FixedModes[0].Type)
+		assert.Contains(t, result.FixedModes[0].Description, "differs from recorded mode 0640")
+		assertMode(t, 0640)
+
+		// A regenerated file gets the recorded mode, not the default one
+		require.NoError(t, os.Remove(target))
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.Len(t, result.RegeneratedFiles, 1)
+		assertMode(t, 0640)
+
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.Equal(t, "Nothing to repair", result.Summary)
+
+		// Legacy entries without a recorded mode are left as they are
+		setRecordedMode(t, "")
+		require.NoError(t, os.Chmod(target, 0600))
+		result, err = RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: vars})
+		require.NoError(t, err)
+		assert.Equal(t, "Nothing to repair", result.Summary)
+		assertMode(t, 0600)
+	})
+
+	t.Run("recorded vars", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "module")
+		targetDir := filepath.Join(tempDir, "target")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "greeting.dot-tmpl"), []byte("hello {{.NAME}}"), 0644))
+
+		modules := []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir, Vars: map[string]string{"NAME": "module"}}}
+		result, err := InstallWithConfig(modules, &InstallConfig{StatePath: dotfilesDir, Vars: map[string]string{"NAME": "world"}, RecordVars: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+
+		stateFile, err := state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, map[string]string{"NAME": "module"}, stateFile.Files[0].Vars)
+
+		// The recorded vars win over vars configured after the installation, and survive the repair
+		target := filepath.Join(targetDir, "greeting")
+		require.NoError(t, os.Remove(target))
+		repairResult, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir, Vars: map[string]string{"NAME": "changed"}})
+		require.NoError(t, err)
+		assert.True(t, repairResult.IsSuccess, repairResult.Errors)
+		assert.Len(t, repairResult.RegeneratedFiles, 1)
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "hello module", string(content))
+
+		stateFile, err = state.LoadStateFile(filepath.Join(dotfilesDir, "state.yaml"))
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 1)
+		assert.Equal(t, map[string]string{"NAME": "module"}, stateFile.Files[0].Vars)
+	})
+
+	t.Run("missing source fails", func(t *testing.T) {
+		dotfilesDir, moduleDir, targetDir := setup(t)
+		require.NoError(t, os.Remove(filepath.Join(targetDir, "file1.txt")))
+		require.NoError(t, os.Remove(filepath.Join(moduleDir, "file1.txt")))
+
+		result, err := RepairWithConfig(&RepairConfig{StatePath: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "no longer exists")
+	})
+}
@@ -0,0 +1,358 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/rs/zerolog"
+)
+
+// RepairRequest contains the request parameters for a repair
+type RepairRequest struct {
+	DotfilesDir string
+	// Vars are the root variables used to re-render templates
+	Vars map[string]string
+	// Regenerate overwrites generated files that were modified since installation
+	Regenerate bool
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string
+	// SourceDir is the directory the modules of regenerated sources are looked up in; empty
+	// uses DotfilesDir
+	SourceDir string
+	// MaxBackups limits backups per target before the oldest is rotated out; zero uses the default
+	MaxBackups int
+	// CompressBackups writes backups of replaced regular files gzip-compressed (.bak.gz)
+	CompressBackups bool
+	// TimestampBackups names backups target.YYYYMMDD-HHMMSS.bak instead of numbering them
+	TimestampBackups bool
+	// BackupSuffix replaces .bak in backup names; empty uses filesystem.DefaultBackupSuffix
+	BackupSuffix string
+	// Context cancels the repair between entries, killing running commands;
+	// defaults to context.Background() when nil
+	Context context.Context
+	// Logger receives progress output; defaults to the global logger when nil
+	Logger *zerolog.Logger
+}
+
+// Repair restores drifted state file entries: missing or repointed symlinks are recreated,
+// conflicting files are backed up, and missing (or, with Regenerate, modified) generated
+// files are produced again from their template or generator
+func (i *Installer) Repair(req *RepairRequest) (*RepairResult, error) {
+	log := logger.OrDefault(req.Logger)
+	ctx := contextOrBackground(req.Context)
+
+	statePath := dotmanState.Path(req.DotfilesDir, req.Profile)
+	stateFile, err := i.stateMgr.Load(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+
+	if stateFile == nil {
+		log.Info().Msg("No state file found - no tracked installations to repair")
+		return &RepairResult{
+			IsSuccess: true,
+			Summary:   "No tracked installations found",
+		}, nil
+	}
+
+	result := &RepairResult{
+		IsSuccess: true,
+		Errors:    []string{},
+	}
+
+	symlinkMgr := filesystem.NewSymlinkManager(i.fileOp)
+	backupMgr := filesystem.NewBackupManagerWithOptions(i.fileOp, filesystem.BackupOptions{MaxBackups: req.MaxBackups, Compress: req.CompressBackups, Timestamped: req.TimestampBackups, Suffix: req.BackupSuffix})
+
+	// Iterate over a copy since regenerating a file refreshes its state entry
+	entries := append([]dotmanState.FileMapping(nil), stateFile.Files...)
+	for _, entry := range entries {
+		// A cancelled context leaves the remaining entries unrepaired
+		if err := ctx.Err(); err != nil {
+			result.fail(fmt.Sprintf("repair cancelled: %v", err), log)
+			generateRepairSummary(result)
+			return result, err
+		}
+
+		switch entry.Type {
+		case dotmanState.TypeLink:
+			i.repairLink(entry, symlinkMgr, backupMgr, result, log)
+		case dotmanState.TypeGenerated:
+			i.repairGenerated(ctx, entry, req, stateFile, statePath, backupMgr, result, log)
+		case dotmanState.TypeCopy:
+			i.repairMode(entry, result, log)
+		}
+	}
+
+	generateRepairSummary(result)
+	return result, nil
+}
+
+// repairLink recreates a tracked symlink that is missing, points elsewhere or was replaced by a file
+func (i *Installer) repairLink(entry dotmanState.FileMapping, symlinkMgr *filesystem.SymlinkManager, backupMgr *filesystem.BackupManager, result *RepairResult, log zerolog.Logger) {
+	valid, reason, err := symlinkMgr.ValidateSymlink(entry.Target, entry.Source)
+	if err != nil {
+		result.fail(fmt.Sprintf("failed to check symlink %s: %v", entry.Target, err), log)
+		return
+	}
+	if valid {
+		return
+	}
+
+	if !i.fileOp.FileExists(entry.Source) {
+		result.fail(fmt.Sprintf("cannot repair %s: source %s no longer exists", entry.Target, entry.Source), log)
+		return
+	}
+
+	operation := FileOperation{
+		Type:        OperationCreateLink,
+		Source:      entry.Source,
+		Target:      entry.Target,
+		Description: reason,
+	}
+
+	targetInfo, err := os.Lstat(entry.Target)
+	switch {
+	case os.IsNotExist(err):
+		err = symlinkMgr.CreateSymlinkWithMkdir(entry.Source, entry.Target, true)
+	case err != nil:
+	case targetInfo.Mode()&os.ModeSymlink != 0:
+		// A repointed link holds no content of its own, so it is replaced without a backup
+		operation.Type = OperationForceLink
+		if err = symlinkMgr.RemoveSymlink(entry.Target); err == nil {
+			err = symlinkMgr.CreateSymlinkWithMkdir(entry.Source, entry.Target, true)
+		}
+	default:
+		operation.Type = OperationForceLink
+		var backupPath string
+		backupPath, err = backupMgr.BackupAndReplaceAtomic(entry.Target, func(path string) error {
+			return symlinkMgr.CreateSymlinkWithMkdir(entry.Source, path, true)
+		})
+		if err == nil {
+			result.Backups = append(result.Backups, backupPath)
+		}
+	}
+	if err != nil {
+		result.fail(fmt.Sprintf("failed to repair symlink %s -> %s: %v", entry.Source, entry.Target, err), log)
+		return
+	}
+
+	result.RepairedLinks = append(result.RepairedLinks, operation)
+	log.Info().Str("source", entry.Source).Str("target", entry.Target).Str("reason", reason).Msg("Repaired symlink")
+}
+
+// repairGenerated regenerates a tracked generated file that is missing, or modified when req.Regenerate is set
+func (i *Installer) repairGenerated(ctx context.Context, entry dotmanState.FileMapping, req *RepairRequest, stateFile *dotmanState.StateFile, statePath string, backupMgr *filesystem.BackupManager, result *RepairResult, log zerolog.Logger) {
+	operation := FileOperation{
+		Type:        OperationCreateGenerated,
+		Source:      entry.Source,
+		Target:      entry.Target,
+		Description: "generated file is missing",
+	}
+
+	_, err := os.Lstat(entry.Target)
+	exists := err == nil
+	if exists {
+		currentSHA1, err := filesystem.FileSHA1(i.fileOp, entry.Target)
+		if err != nil {
+			result.fail(fmt.Sprintf("failed to check generated file %s: %v", entry.Target, err), log)
+			return
+		}
+		if entry.SHA1 == "" || currentSHA1 == entry.SHA1 {
+			i.repairMode(entry, result, log)
+			return
+		}
+
+		operation.Type = OperationForceGenerated
+		operation.Description = "generated file was modified since installation"
+		if !req.Regenerate {
+			result.SkippedGenerated = append(result.SkippedGenerated, operation)
+			log.Warn().Str("target", entry.Target).Msg("Generated file was modified, not regenerating")
+			return
+		}
+	}
+
+	create, err := i.regenerateFunc(ctx, entry, moduleSourceDir(req.DotfilesDir, req.SourceDir), req.Vars)
+	if err != nil {
+		result.fail(fmt.Sprintf("cannot regenerate %s: %v", entry.Target, err), log)
+		return
+	}
+
+	if exists {
+		var backupPath string
+		backupPath, err = backupMgr.BackupAndReplaceAtomic(entry.Target, create)
+		if err == nil {
+			result.Backups = append(result.Backups, backupPath)
+		}
+	} else {
+		err = create(entry.Target)
+	}
+	if err != nil {
+		result.fail(fmt.Sprintf("failed to regenerate %s: %v", entry.Target, err), log)
+		return
+	}
+
+	// Keep the recorded mode, and refresh the recorded SHA1 so the regenerated file is not treated as modified
+	i.applyRecordedMode(stateFile, entry.Target, log)
+	if err := i.stateMgr.RemoveMappings(stateFile, []string{entry.Target}); err != nil {
+		log.Warn().Err(err).Msg("Failed to remove mapping from state file")
+	}
+	if err := i.stateMgr.AddMapping(stateFile, entry.Source, entry.Target, dotmanState.TypeGenerated); err != nil {
+		log.Warn().Err(err).Msg("Failed to add mapping to state file for regenerated file")
+	}
+	if entry.Vars != nil {
+		stateFile.SetVars(entry.Target, entry.Vars)
+	}
+	if err := i.stateMgr.Save(statePath, stateFile); err != nil {
+		log.Warn().Err(err).Msg("Failed to save state file for regenerated file")
+	}
+
+	result.RegeneratedFiles = append(result.RegeneratedFiles, operation)
+	log.Info().Str("target", entry.Target).Str("reason", operation.Description).Msg("Regenerated file")
+}
+
+// repairMode resets a tracked file whose permissions drifted from the mode recorded at installation;
+// entries recorded without a mode are left alone
+func (i *Installer) repairMode(entry dotmanState.FileMapping, result *RepairResult, log zerolog.Logger) {
+	mode, ok := entry.FileMode()
+	if !ok {
+		return
+	}
+	info, err := os.Stat(entry.Target)
+	if err != nil || info.Mode().Perm() == mode {
+		// Missing copies are not tracked for content, so there is no mode to fix either
+		return
+	}
+
+	operation := FileOperation{
+		Type:        OperationFixMode,
+		Source:      entry.Source,
+		Target:      entry.Target,
+		Description: fmt.Sprintf("mode %s differs from recorded mode %s", dotmanState.FormatMode(info.Mode()), entry.Mode),
+	}
+	if err := os.Chmod(entry.Target, mode); err != nil {
+		result.fail(fmt.Sprintf("failed to reset mode of %s: %v", entry.Target, err), log)
+		return
+	}
+
+	result.FixedModes = append(result.FixedModes, operation)
+	log.Info().Str("target", entry.Target).Str("reason", operation.Description).Msg("Reset file mode")
+}
+
+// regenerateFunc returns a function that writes the content of a generated state entry to a path,
+// using the template it was rendered from or the module generator that produced it. Vars recorded
+// in the entry take the place of the configured ones.
+func (i *Installer) regenerateFunc(ctx context.Context, entry dotmanState.FileMapping, sourceDir string, vars map[string]string) (func(path string) error, error) {
+	if isTemplateFile(entry.Source) {
+		if !i.fileOp.FileExists(entry.Source) {
+			return nil, fmt.Errorf("template %s no longer exists", entry.Source)
+		}
+		operation := FileOperation{
+			Source: entry.Source,
+			Target: entry.Target,
+		}
+		// Render with the owning module's vars, per-file vars, dir_mode and formatter, as the install did
+		moduleConfig, err := templateModule(entry.Source, sourceDir, vars)
+		if err != nil {
+			return nil, err
+		}
+		templateVars := vars
+		if moduleConfig != nil {
+			operation.DirMode = os.FileMode(moduleConfig.DirMode)
+			operation.FormatCmd = moduleFormatCmd(*moduleConfig, entry.Target)
+			templateVars = moduleConfig.TemplateVars(vars)
+		}
+		// Vars recorded at installation already include the module and per-file vars
+		if entry.Vars != nil {
+			operation.Vars = entry.Vars
+		} else if operation.Vars, err = fileTemplateVars(entry.Source, templateVars); err != nil {
+			return nil, err
+		}
+		return func(path string) error {
+			return i.createTemplateFile(ctx, operation, path, vars, true)
+		}, nil
+	}
+
+	// Sources with a registered Generator are regenerated with it and the owning module's vars
+	if generator, ok := sourceGenerator(entry.Source); ok {
+		if !i.fileOp.FileExists(entry.Source) {
+			return nil, fmt.Errorf("source %s no longer exists", entry.Source)
+		}
+		operation := FileOperation{
+			Source:    entry.Source,
+			Target:    entry.Target,
+			Generator: generator,
+			Vars:      vars,
+		}
+		moduleConfig, err := templateModule(entry.Source, sourceDir, vars)
+		if err != nil {
+			return nil, err
+		}
+		if moduleConfig != nil {
+			operation.DirMode = os.FileMode(moduleConfig.DirMode)
+			operation.FormatCmd = moduleFormatCmd(*moduleConfig, entry.Target)
+			operation.Vars = moduleConfig.TemplateVars(vars)
+		}
+		if entry.Vars != nil {
+			operation.Vars = entry.Vars
+		}
+		return func(path string) error {
+			return i.createGeneratedFile(ctx, operation, path, true)
+		}, nil
+	}
+
+	// Generator output is recorded with the module's Dotfile as its source
+	moduleConfig, err := config.LoadConfigWithVars(filepath.Dir(entry.Source), vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load module config: %w", err)
+	}
+	if moduleConfig != nil {
+		for _, generator := range moduleConfig.Generators {
+			if filepath.Join(moduleConfig.TargetDir, generator.Target) != entry.Target {
+				continue
+			}
+			operation := FileOperation{
+				Source:    entry.Source,
+				Target:    entry.Target,
+				Command:   generator.Command,
+				Timeout:   generator.TimeoutDuration(),
+				DirMode:   os.FileMode(moduleConfig.DirMode),
+				FormatCmd: moduleFormatCmd(*moduleConfig, entry.Target),
+			}
+			return func(path string) error {
+				return i.createGeneratedFile(ctx, operation, path, true)
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no template or generator in %s produces it", filepath.Dir(entry.Source))
+}
+
+// fail records a repair error and marks the repair as unsuccessful
+func (r *RepairResult) fail(message string, log zerolog.Logger) {
+	r.IsSuccess = false
+	r.Errors = append(r.Errors, message)
+	log.Error().Msg(message)
+}
+
+// generateRepairSummary generates a summary of the repair results
+func generateRepairSummary(result *RepairResult) {
+	if !result.IsSuccess {
+		result.Summary = fmt.Sprintf("Repair completed with %d errors: %d links repaired, %d files regenerated, %d modes reset",
+			len(result.Errors), len(result.RepairedLinks), len(result.RegeneratedFiles), len(result.FixedModes))
+		return
+	}
+
+	if len(result.RepairedLinks) == 0 && len(result.RegeneratedFiles) == 0 && len(result.SkippedGenerated) == 0 && len(result.FixedModes) == 0 {
+		result.Summary = "Nothing to repair"
+		return
+	}
+
+	result.Summary = fmt.Sprintf("Repair completed: %d links repaired, %d files regenerated, %d modes reset, %d modified files skipped",
+		len(result.RepairedLinks), len(result.RegeneratedFiles), len(result.FixedModes), len(result.SkippedGenerated))
+}
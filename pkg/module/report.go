@@ -0,0 +1,64 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatYAML = "yaml"
+)
+
+// MarshalReport serializes a result (e.g. a ValidateResult) in the given format
+func MarshalReport(report interface{}, format string) ([]byte, error) {
+	switch format {
+	case ReportFormatJSON, "":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal report as json: %w", err)
+		}
+		return append(data, '\n'), nil
+	case ReportFormatYAML:
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal report as yaml: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// WriteReport writes a result to path in the given format. Parent directories
+// are created as needed and the file is replaced atomically.
+func WriteReport(path string, report interface{}, format string) error {
+	data, err := MarshalReport(report, format)
+	if err != nil {
+		return err
+	}
+
+	// Ensure directory exists
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	// Write to temporary file first
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temporary report file: %w", err)
+	}
+
+	// Atomic rename
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath) // Clean up temp file
+		return fmt.Errorf("failed to rename report file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package module
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteReport(t *testing.T) {
+	report := &ValidateResult{
+		IsValid: false,
+		Summary: "Validation Summary: 2 total file operations\n",
+		Errors:  []string{},
+		CreateOperations: []FileOperation{
+			{Type: OperationCreateLink, Source: "/src/a", Target: "/dst/a", Description: "create new symlink"},
+		},
+		ForceLinkOperations: []FileOperation{
+			{Type: OperationForceLink, Source: "/src/b", Target: "/dst/b", Description: "target exists as regular file"},
+		},
+		CreateTemplateOps: []FileOperation{},
+		ForceTemplateOps:  []FileOperation{},
+		SkipOperations:    []FileOperation{},
+	}
+
+	tests := []struct {
+		name      string
+		format    string
+		unmarshal func([]byte, interface{}) error
+	}{
+		{name: "json", format: ReportFormatJSON, unmarshal: json.Unmarshal},
+		{name: "default format is json", format: "", unmarshal: json.Unmarshal},
+		{name: "yaml", format: ReportFormatYAML, unmarshal: yaml.Unmarshal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "reports", "nested", "validate.out")
+
+			err := WriteReport(path, report, tt.format)
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			var parsed ValidateResult
+			require.NoError(t, tt.unmarshal(data, &parsed))
+			assert.Equal(t, *report, parsed)
+
+			// No temporary file is left behind
+			assert.NoFileExists(t, path+".tmp")
+		})
+	}
+}
+
+func TestWriteReport_Error(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.txt")
+
+	err := WriteReport(path, &ValidateResult{}, "xml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported report format: xml")
+	assert.NoFileExists(t, path)
+}
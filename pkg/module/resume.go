@@ -0,0 +1,63 @@
+package module
+
+import (
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// resumeOperations takes the operations whose target the state file already tracks in its
+// installed form out of validation and returns them, so an interrupted installation is
+// continued with the remaining operations only. Correct symlinks are done when tracked as links
+// of the same source; replaced copies, templates and generator output are done when tracked for
+// the same source with the SHA1 of their current content. Operations that would create a
+// missing target are never done.
+func (i *Installer) resumeOperations(validation *ValidateResult, stateFile *dotmanState.StateFile) []FileOperation {
+	entries := make(map[string]dotmanState.FileMapping, len(stateFile.Files))
+	for _, entry := range stateFile.Files {
+		// Blocks share their target with other blocks and are always merged again
+		if entry.Type != dotmanState.TypeBlock {
+			entries[entry.Target] = entry
+		}
+	}
+
+	var done []FileOperation
+	keep := func(ops []FileOperation, installedTypes ...string) []FileOperation {
+		var kept []FileOperation
+		for _, op := range ops {
+			if i.installedAs(op, entries, installedTypes) {
+				done = append(done, op)
+			} else {
+				kept = append(kept, op)
+			}
+		}
+		return kept
+	}
+
+	validation.SkipOperations = keep(validation.SkipOperations, dotmanState.TypeLink)
+	validation.ForceLinkOperations = keep(validation.ForceLinkOperations, dotmanState.TypeCopy)
+	validation.ForceTemplateOps = keep(validation.ForceTemplateOps, dotmanState.TypeGenerated)
+	validation.ForceGeneratedOps = keep(validation.ForceGeneratedOps, dotmanState.TypeGenerated)
+	validation.RequiresForce = validation.HasConflicts()
+	sortFileOperations(done)
+	return done
+}
+
+// installedAs reports whether the state entry of op's target has one of types, the same source
+// and, for files dotman wrote, the SHA1 of the target's current content
+func (i *Installer) installedAs(op FileOperation, entries map[string]dotmanState.FileMapping, types []string) bool {
+	entry, ok := entries[op.Target]
+	if !ok || entry.Source != op.Source {
+		return false
+	}
+	for _, fileType := range types {
+		if entry.Type != fileType {
+			continue
+		}
+		if fileType == dotmanState.TypeLink {
+			return true
+		}
+		sha1, err := filesystem.FileSHA1(i.fileOp, op.Target)
+		return err == nil && entry.SHA1 != "" && sha1 == entry.SHA1
+	}
+	return false
+}
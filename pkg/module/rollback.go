@@ -0,0 +1,102 @@
+package module
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/rs/zerolog"
+)
+
+// undoLog records how to revert each change made by a transactional install
+type undoLog struct {
+	steps []undoStep
+	// stateFiles and stateExisted capture the state file before the install
+	stateFiles   []dotmanState.FileMapping
+	stateExisted bool
+}
+
+// undoStep reverts a single applied operation
+type undoStep struct {
+	description string
+	undo        func() error
+}
+
+// record adds an undo step; it does nothing when the install is not transactional
+func (l *undoLog) record(description string, undo func() error) {
+	if l == nil {
+		return
+	}
+	l.steps = append(l.steps, undoStep{description: description, undo: undo})
+}
+
+// newUndoLog captures the state file so it can be restored on rollback
+func (i *Installer) newUndoLog(stateFile *dotmanState.StateFile, statePath string) *undoLog {
+	undo := &undoLog{}
+	if stateFile != nil {
+		undo.stateFiles = append([]dotmanState.FileMapping(nil), stateFile.Files...)
+		undo.stateExisted = i.fileOp.FileExists(statePath)
+	}
+	return undo
+}
+
+// undoCreate returns an undo step removing a file the install created at target
+func (i *Installer) undoCreate(target string) func() error {
+	return func() error {
+		return i.fileOp.RemoveFile(target)
+	}
+}
+
+// undoReplace returns an undo step moving the backup of a replaced file back to target
+func (i *Installer) undoReplace(target, backupPath string) func() error {
+	return func() error {
+		if err := i.fileOp.RemoveFile(target); err != nil {
+			return err
+		}
+		return filesystem.NewBackupManager(i.fileOp).RestoreBackup(backupPath, target)
+	}
+}
+
+// rollback replays the undo log newest first and restores the state file, leaving the
+// targets as they were before the install. Directories created by the install are kept.
+func (i *Installer) rollback(undo *undoLog, stateFile *dotmanState.StateFile, statePath string, result *InstallResult, log zerolog.Logger) {
+	for index := len(undo.steps) - 1; index >= 0; index-- {
+		step := undo.steps[index]
+		if err := step.undo(); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("rollback: failed to %s: %v", step.description, err))
+			log.Error().Err(err).Msg("Failed to " + step.description)
+			continue
+		}
+		log.Debug().Msg("Rolled back: " + step.description)
+	}
+
+	if stateFile != nil {
+		stateFile.Files = undo.stateFiles
+		var err error
+		if undo.stateExisted {
+			// Saving supersedes the entries appended to the state log
+			err = i.stateMgr.Save(statePath, stateFile)
+		} else {
+			// The entries of the install may be only in the state log, or already compacted
+			for _, path := range []string{dotmanState.LogPath(statePath), statePath} {
+				if removeErr := i.fileOp.RemoveFile(path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+					err = removeErr
+				}
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("rollback: failed to restore state file: %v", err))
+		}
+	}
+
+	result.RolledBack = true
+	result.CreatedLinks = nil
+	result.CreatedTemplates = nil
+	result.CreatedGenerated = nil
+	result.CopiedFiles = nil
+	result.CreatedDirs = nil
+	result.MergedBlocks = nil
+	result.Backups = nil
+	log.Warn().Int("operations", len(undo.steps)).Msg("Installation failed, rolled back applied operations")
+}
@@ -0,0 +1,134 @@
+package module
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/elmhuangyu/dotman/pkg/module/template"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSymlinkOperator is the real file operator, except that linking to failTarget fails
+type failingSymlinkOperator struct {
+	filesystem.FileOperator
+	failTarget string
+}
+
+func (o *failingSymlinkOperator) CreateSymlink(source, target string) error {
+	if filepath.Base(target) == o.failTarget {
+		return errors.New("disk full")
+	}
+	return o.FileOperator.CreateSymlink(source, target)
+}
+
+func TestInstallTransactional(t *testing.T) {
+	// setup creates a module with files a, b and c; linking c, the third operation, fails
+	setup := func(t *testing.T) (string, string, *Installer, []config.ModuleConfig) {
+		tempDir := t.TempDir()
+		dotfilesDir := filepath.Join(tempDir, "dotfiles")
+		moduleDir := filepath.Join(dotfilesDir, "shell")
+		targetDir := filepath.Join(tempDir, "home")
+		require.NoError(t, os.MkdirAll(moduleDir, 0755))
+		require.NoError(t, os.MkdirAll(targetDir, 0755))
+		for _, name := range []string{"a", "b", "c"} {
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, name), []byte(name), 0644))
+		}
+
+		fileOp := &failingSymlinkOperator{FileOperator: filesystem.NewOperator(), failTarget: "c"}
+		installer := NewInstaller(fileOp, template.NewRenderer(), &stateManagerAdapter{})
+		return dotfilesDir, targetDir, installer, []config.ModuleConfig{{Dir: moduleDir, TargetDir: targetDir}}
+	}
+
+	t.Run("failure rolls back created links and the state file", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+		statePath := filepath.Join(dotfilesDir, "state.yaml")
+		existing := state.NewStateFile()
+		existing.AddFileMapping("/dotfiles/git/gitconfig", "/home/user/.gitconfig", state.TypeLink)
+		require.NoError(t, state.SaveStateFile(statePath, existing))
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Transactional: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.True(t, result.RolledBack)
+		assert.Empty(t, result.CreatedLinks)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0], "disk full")
+
+		for _, name := range []string{"a", "b", "c"} {
+			_, err := os.Lstat(filepath.Join(targetDir, name))
+			assert.True(t, os.IsNotExist(err), name)
+		}
+		// The entries the install appended to the state log are dropped with it
+		after, err := state.LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, existing.Files, after.Files)
+		assert.NoFileExists(t, state.LogPath(statePath))
+	})
+
+	t.Run("failure restores replaced files from their backups", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "a"), []byte("original"), 0644))
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Force: true, Transactional: true})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.True(t, result.RolledBack)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "a"))
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(content))
+		assert.NoFileExists(t, filepath.Join(targetDir, "a.bak"))
+		_, err = os.Lstat(filepath.Join(targetDir, "b"))
+		assert.True(t, os.IsNotExist(err))
+
+		// There was no state file before the install, so there is none after it
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.log"))
+	})
+
+	t.Run("failure restores replaced files from compressed backups", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, "a"), []byte("original"), 0644))
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Force: true, Transactional: true, CompressBackups: true})
+		require.NoError(t, err)
+		assert.True(t, result.RolledBack)
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "a"))
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(content))
+		assert.NoFileExists(t, filepath.Join(targetDir, "a.bak.gz"))
+	})
+
+	t.Run("without transactional applied links are kept", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir})
+		require.NoError(t, err)
+		assert.False(t, result.IsSuccess)
+		assert.False(t, result.RolledBack)
+		for _, name := range []string{"a", "b"} {
+			dest, err := os.Readlink(filepath.Join(targetDir, name))
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(modules[0].Dir, name), dest)
+		}
+	})
+
+	t.Run("successful install is kept", func(t *testing.T) {
+		dotfilesDir, targetDir, installer, modules := setup(t)
+		installer.fileOp = filesystem.NewOperator()
+
+		result, err := installer.Install(&InstallRequest{Modules: modules, DotfilesDir: dotfilesDir, Transactional: true})
+		require.NoError(t, err)
+		require.True(t, result.IsSuccess, result.Errors)
+		assert.False(t, result.RolledBack)
+		assert.Len(t, result.CreatedLinks, 3)
+		assert.FileExists(t, filepath.Join(targetDir, "c"))
+	})
+}
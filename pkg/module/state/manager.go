@@ -10,6 +10,10 @@ type StateManager interface {
 	Save(path string, stateFile *state.StateFile) error
 	AddMapping(stateFile *state.StateFile, source, target, fileType string) error
 	RemoveMappings(stateFile *state.StateFile, targets []string) error
+	// AppendEvent records a single change in the state log instead of rewriting the state file
+	AppendEvent(path string, stateFile *state.StateFile, event state.Event) error
+	// Compact folds the state log into the state file
+	Compact(path string) error
 }
 
 // DefaultStateManager implements the StateManager interface
@@ -30,6 +34,16 @@ func (sm *DefaultStateManager) Save(path string, stateFile *state.StateFile) err
 	return state.SaveStateFile(path, stateFile)
 }
 
+// AppendEvent appends a change of the state file to its log
+func (sm *DefaultStateManager) AppendEvent(path string, stateFile *state.StateFile, event state.Event) error {
+	return state.AppendEvent(path, stateFile, event)
+}
+
+// Compact folds the log of the state file at path into a new snapshot
+func (sm *DefaultStateManager) Compact(path string) error {
+	return state.CompactStateFile(path)
+}
+
 // AddMapping adds a file mapping to the state file
 func (sm *DefaultStateManager) AddMapping(stateFile *state.StateFile, source, target, fileType string) error {
 	stateFile.AddFileMapping(source, target, fileType)
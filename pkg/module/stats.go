@@ -0,0 +1,100 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
+	"github.com/elmhuangyu/dotman/pkg/state"
+)
+
+// RepoStats contains aggregate numbers about a dotfiles repository and its installation
+type RepoStats struct {
+	Modules      int `json:"modules"`
+	SourceFiles  int `json:"source_files"`
+	Templates    int `json:"templates"`
+	ManagedFiles int `json:"managed_files"`
+	Links        int `json:"links"`
+	Generated    int `json:"generated"`
+	Copied       int `json:"copied"`
+	Dirs         int `json:"dirs"`
+	Blocks       int `json:"blocks"`
+	Backups      int `json:"backups"`
+	// LastStateChange is when the state file was last written, which an uninstall, move or
+	// repair does as well as an install; zero without a state file
+	LastStateChange time.Time `json:"last_state_change,omitzero"`
+}
+
+// Stats summarizes the dotfiles repository configuration together with its state file.
+// It never modifies anything; a missing state file results in zeroed install counts.
+func Stats(dotfilesDir string) (*RepoStats, error) {
+	return StatsForProfile(dotfilesDir, "")
+}
+
+// StatsForProfile is Stats with the install counts taken from the state file of profile
+func StatsForProfile(dotfilesDir, profile string) (*RepoStats, error) {
+	cfg, err := config.LoadDir(dotfilesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	mapping, err := BuildFileMapping(cfg.Modules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build file mappings: %w", err)
+	}
+
+	stats := &RepoStats{
+		Modules:     len(cfg.Modules),
+		SourceFiles: len(mapping.GetAllMappings()),
+		Templates:   len(mapping.GetTemplateMappings()),
+	}
+
+	statePath := state.Path(dotfilesDir, profile)
+	stateFile, err := state.LoadStateFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+
+	// Collect every target we know about to count their backups
+	targets := make(map[string]bool)
+	for _, target := range mapping.GetAllMappings() {
+		targets[target] = true
+	}
+
+	if stateFile != nil {
+		if info, err := os.Stat(statePath); err == nil {
+			stats.LastStateChange = info.ModTime()
+		}
+
+		stats.ManagedFiles = len(stateFile.Files)
+		for _, file := range stateFile.Files {
+			switch file.Type {
+			case state.TypeLink:
+				stats.Links++
+			case state.TypeGenerated:
+				stats.Generated++
+			case state.TypeCopy:
+				stats.Copied++
+			case state.TypeDir:
+				stats.Dirs++
+			case state.TypeBlock:
+				stats.Blocks++
+			}
+			targets[file.Target] = true
+		}
+	}
+
+	backupMgr := filesystem.NewBackupManagerWithOptions(filesystem.NewOperator(), filesystem.BackupOptions{Suffix: cfg.RootConfig.BackupSuffix})
+	for target := range targets {
+		backups, err := backupMgr.ListBackups(target)
+		if err != nil {
+			// Target directory may not exist yet, there is nothing to count
+			continue
+		}
+		stats.Backups += len(backups)
+	}
+
+	return stats, nil
+}
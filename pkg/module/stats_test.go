@@ -0,0 +1,93 @@
+package module
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupStatsRepo creates a dotfiles repo with two modules, a template and a pre-existing backup
+func setupStatsRepo(t *testing.T) (string, string) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "target")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	shellDir := filepath.Join(dotfilesDir, "shell")
+	require.NoError(t, os.MkdirAll(shellDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(shellDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(shellDir, "bashrc"), []byte("bash"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(shellDir, "profile.dot-tmpl"), []byte("user={{.USER}}"), 0644))
+
+	gitDir := filepath.Join(dotfilesDir, "git")
+	require.NoError(t, os.MkdirAll(gitDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(gitDir, "Dotfile"), []byte(`target_dir: "`+targetDir+`"`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(gitDir, "gitconfig"), []byte("git"), 0644))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dotfilesDir, "DotRoot"), []byte(`vars:
+  USER: "tester"`), 0644))
+
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "bashrc.bak"), []byte("old bash"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "gitconfig.bak.1"), []byte("old git"), 0644))
+
+	return dotfilesDir, targetDir
+}
+
+func TestStats(t *testing.T) {
+	t.Run("repo without state file", func(t *testing.T) {
+		dotfilesDir, _ := setupStatsRepo(t)
+
+		stats, err := Stats(dotfilesDir)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Modules)
+		assert.Equal(t, 3, stats.SourceFiles)
+		assert.Equal(t, 1, stats.Templates)
+		assert.Equal(t, 0, stats.ManagedFiles)
+		assert.Equal(t, 0, stats.Links)
+		assert.Equal(t, 0, stats.Generated)
+		assert.Equal(t, 0, stats.Copied)
+		assert.Equal(t, 2, stats.Backups)
+		assert.True(t, stats.LastStateChange.IsZero())
+		data, err := json.Marshal(stats)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "last_state_change")
+
+		// Stats is read-only
+		assert.NoFileExists(t, filepath.Join(dotfilesDir, "state.yaml"))
+	})
+
+	t.Run("repo with mixed state entries", func(t *testing.T) {
+		dotfilesDir, targetDir := setupStatsRepo(t)
+
+		stateFile := state.NewStateFile()
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "shell", "bashrc"), filepath.Join(targetDir, "bashrc"), state.TypeLink)
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "git", "gitconfig"), filepath.Join(targetDir, "gitconfig"), state.TypeLink)
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "shell", "profile.dot-tmpl"), filepath.Join(targetDir, "profile"), state.TypeGenerated)
+		stateFile.AddFileMapping(filepath.Join(dotfilesDir, "shell", "extra"), filepath.Join(targetDir, "extra"), state.TypeCopy)
+		require.NoError(t, state.SaveStateFile(filepath.Join(dotfilesDir, "state.yaml"), stateFile))
+
+		stats, err := Stats(dotfilesDir)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Modules)
+		assert.Equal(t, 4, stats.ManagedFiles)
+		assert.Equal(t, 2, stats.Links)
+		assert.Equal(t, 1, stats.Generated)
+		assert.Equal(t, 1, stats.Copied)
+		assert.Equal(t, 2, stats.Backups)
+		assert.False(t, stats.LastStateChange.IsZero())
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		stats, err := Stats(filepath.Join(t.TempDir(), "missing"))
+		require.Error(t, err)
+		assert.Nil(t, stats)
+		assert.Contains(t, err.Error(), "failed to load configuration")
+	})
+}
@@ -5,17 +5,45 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 )
 
+// ModulesKey is the name templates read the paths of the other modules under, as in {{.Modules.nvim.TargetDir}}
+const ModulesKey = "Modules"
+
 // Renderer implements TemplateRenderer interface
-type Renderer struct{}
+type Renderer struct {
+	// modules are the module paths templates read as .Modules; nil leaves .Modules undefined
+	modules map[string]ModulePaths
+}
 
 // NewRenderer creates a new template renderer
 func NewRenderer() *Renderer {
 	return &Renderer{}
 }
 
+// NewRendererWithModules creates a template renderer that also gives templates the paths of
+// modules, keyed by module name, as .Modules
+func NewRendererWithModules(modules map[string]ModulePaths) *Renderer {
+	return &Renderer{modules: modules}
+}
+
+// data returns what the template at absPath is executed with: a copy of vars, the
+// ORIGINAL_FILE_PATH variable and the module paths. A var named Modules hides the module paths,
+// so templates written before they existed keep working.
+func (r *Renderer) data(absPath string, vars map[string]string) map[string]any {
+	data := make(map[string]any, len(vars)+2)
+	for k, v := range vars {
+		data[k] = v
+	}
+	data["ORIGINAL_FILE_PATH"] = fmt.Sprintf("Original file: %s", absPath)
+	if _, ok := vars[ModulesKey]; !ok && r.modules != nil {
+		data[ModulesKey] = r.modules
+	}
+	return data
+}
+
 // Render renders a Go text template file using the provided variables
 func (r *Renderer) Render(templatePath string, vars map[string]string) ([]byte, error) {
 	// Read the template file
@@ -30,12 +58,8 @@ func (r *Renderer) Render(templatePath string, vars map[string]string) ([]byte,
 		return nil, fmt.Errorf("failed to get absolute path for %s: %w", templatePath, err)
 	}
 
-	// Create a copy of vars to avoid modifying the original map
-	templateVars := make(map[string]string)
-	for k, v := range vars {
-		templateVars[k] = v
-	}
-	templateVars["ORIGINAL_FILE_PATH"] = fmt.Sprintf("Original file: %s", absPath)
+	// Build the data from a copy of vars to avoid modifying the original map
+	templateVars := r.data(absPath, vars)
 
 	// Parse the template with missingkey=error option
 	tmpl, err := template.New("template").Option("missingkey=error").Parse(string(templateContent))
@@ -52,6 +76,26 @@ func (r *Renderer) Render(templatePath string, vars map[string]string) ([]byte,
 	return buf.Bytes(), nil
 }
 
+// RenderString renders an inline Go text template using the provided variables.
+// Referencing a variable that is not defined is an error.
+func RenderString(name, text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to execute template %s: %w", name, err)
+	}
+
+	return buf.String(), nil
+}
+
 // Validate validates a template file syntax and required variables
 func (r *Renderer) Validate(templatePath string, vars map[string]string) error {
 	// Read the template file
@@ -66,12 +110,8 @@ func (r *Renderer) Validate(templatePath string, vars map[string]string) error {
 		return fmt.Errorf("failed to get absolute path for %s: %w", templatePath, err)
 	}
 
-	// Create a copy of vars to avoid modifying the original map
-	templateVars := make(map[string]string)
-	for k, v := range vars {
-		templateVars[k] = v
-	}
-	templateVars["ORIGINAL_FILE_PATH"] = fmt.Sprintf("Original file: %s", absPath)
+	// Build the data from a copy of vars to avoid modifying the original map
+	templateVars := r.data(absPath, vars)
 
 	// Parse the template to check syntax
 	tmpl, err := template.New("template").Option("missingkey=error").Parse(string(templateContent))
@@ -79,6 +119,11 @@ func (r *Renderer) Validate(templatePath string, vars map[string]string) error {
 		return fmt.Errorf("template syntax error in %s: %w", templatePath, err)
 	}
 
+	// Report every undefined variable at once, instead of only the first one execution hits
+	if missing := missingVars(tmpl.Tree, templateVars); len(missing) > 0 {
+		return fmt.Errorf("template %s references undefined variables: %s", templatePath, strings.Join(missing, ", "))
+	}
+
 	// Try to execute the template to check for missing variables
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateVars); err != nil {
@@ -182,3 +182,161 @@ func TestRenderer_VarsNotModified(t *testing.T) {
 	assert.Equal(t, originalVars, vars)
 	assert.NotContains(t, vars, "ORIGINAL_FILE_PATH")
 }
+
+func TestRenderString(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		vars        map[string]string
+		expected    string
+		errContains string
+	}{
+		{
+			name:     "variable substitution",
+			text:     "{{.HOME}}/.config/{{.PROFILE}}",
+			vars:     map[string]string{"HOME": "/home/alice", "PROFILE": "work"},
+			expected: "/home/alice/.config/work",
+		},
+		{
+			name:     "literal text with nil vars",
+			text:     "/home/alice/.config",
+			vars:     nil,
+			expected: "/home/alice/.config",
+		},
+		{
+			name:        "missing variable",
+			text:        "{{.MISSING}}",
+			vars:        map[string]string{},
+			errContains: `map has no entry for key "MISSING"`,
+		},
+		{
+			name:        "syntax error",
+			text:        "{{.HOME",
+			vars:        map[string]string{},
+			errContains: "failed to parse template target_dir",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RenderString("target_dir", tt.text, tt.vars)
+			if tt.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestRenderer_ValidateReportsAllMissingVars(t *testing.T) {
+	tempDir := t.TempDir()
+	renderer := NewRenderer()
+
+	tests := []struct {
+		name        string
+		template    string
+		vars        map[string]string
+		wantMissing string
+	}{
+		{
+			name:        "several missing variables are listed sorted and once",
+			template:    "{{.USER}} {{.EDITOR}} {{.USER}} {{.HOME}}",
+			vars:        map[string]string{"HOME": "/home/alice"},
+			wantMissing: "EDITOR, USER",
+		},
+		{
+			name:        "variables in conditions and $ references",
+			template:    "{{if .WORK}}{{.EMAIL}}{{end}}{{range .HOSTS}}{{$.PROXY}}{{end}}",
+			vars:        map[string]string{"HOSTS": "a"},
+			wantMissing: "EMAIL, PROXY, WORK",
+		},
+		{
+			name:        "dot inside with is not the template data",
+			template:    "{{with .NAME}}{{.Length}}{{end}}",
+			vars:        map[string]string{},
+			wantMissing: "NAME",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templatePath := filepath.Join(tempDir, "test.tmpl")
+			require.NoError(t, os.WriteFile(templatePath, []byte(test.template), 0644))
+
+			err := renderer.Validate(templatePath, test.vars)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "references undefined variables: "+test.wantMissing)
+		})
+	}
+}
+
+func TestRenderer_Modules(t *testing.T) {
+	tempDir := t.TempDir()
+	modules := map[string]ModulePaths{
+		"nvim":       {Dir: "/dotfiles/nvim", TargetDir: "/home/alice/.config/nvim"},
+		"git-config": {Dir: "/dotfiles/git-config", TargetDir: "/home/alice", TargetFile: "/home/alice/.gitconfig"},
+	}
+
+	tests := []struct {
+		name        string
+		renderer    *Renderer
+		template    string
+		vars        map[string]string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "another module's target dir",
+			renderer: NewRendererWithModules(modules),
+			template: "export VIMINIT='source {{.Modules.nvim.TargetDir}}/init.vim' # {{.USER}}",
+			vars:     map[string]string{"USER": "alice"},
+			expected: "export VIMINIT='source /home/alice/.config/nvim/init.vim' # alice",
+		},
+		{
+			name:     "module name that is not an identifier",
+			renderer: NewRendererWithModules(modules),
+			template: `{{(index .Modules "git-config").TargetFile}}`,
+			expected: "/home/alice/.gitconfig",
+		},
+		{
+			name:     "a var named Modules hides the modules",
+			renderer: NewRendererWithModules(modules),
+			template: "{{.Modules}}",
+			vars:     map[string]string{"Modules": "all"},
+			expected: "all",
+		},
+		{
+			name:        "unknown module",
+			renderer:    NewRendererWithModules(modules),
+			template:    "{{.Modules.zsh.TargetDir}}",
+			expectError: true,
+		},
+		{
+			name:        "renderer without modules",
+			renderer:    NewRenderer(),
+			template:    "{{.Modules.nvim.TargetDir}}",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templatePath := filepath.Join(tempDir, "test.tmpl")
+			require.NoError(t, os.WriteFile(templatePath, []byte(test.template), 0644))
+
+			result, err := test.renderer.Render(templatePath, test.vars)
+			validateErr := test.renderer.Validate(templatePath, test.vars)
+			if test.expectError {
+				assert.Error(t, err)
+				assert.Error(t, validateErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, validateErr)
+			assert.Equal(t, test.expected, string(result))
+		})
+	}
+}
@@ -1,5 +1,14 @@
 package template
 
+// ModulePaths are the resolved paths of a module, which templates read by module name, as in
+// {{.Modules.nvim.TargetDir}}
+type ModulePaths struct {
+	Dir       string
+	TargetDir string
+	// TargetFile is the target of a single-file module; empty otherwise
+	TargetFile string
+}
+
 // TemplateRenderer interface for template operations
 type TemplateRenderer interface {
 	Render(templatePath string, vars map[string]string) ([]byte, error)
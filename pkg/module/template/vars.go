@@ -0,0 +1,85 @@
+package template
+
+import (
+	"sort"
+	"text/template/parse"
+)
+
+// referencedVars returns the sorted top-level variables a parsed template reads from its data,
+// such as NAME in {{.NAME}} or {{$.NAME}}
+func referencedVars(tree *parse.Tree) []string {
+	seen := make(map[string]bool)
+	if tree != nil {
+		collectVars(tree.Root, true, seen)
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// missingVars returns the sorted variables referenced by a parsed template that vars does not define
+func missingVars(tree *parse.Tree, vars map[string]any) []string {
+	var missing []string
+	for _, name := range referencedVars(tree) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// collectVars walks node and records referenced variables; rootDot reports whether dot is
+// still the template data, which is no longer the case inside range and with blocks
+func collectVars(node parse.Node, rootDot bool, seen map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectVars(child, rootDot, seen)
+		}
+	case *parse.ActionNode:
+		collectVars(n.Pipe, rootDot, seen)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectVars(cmd, rootDot, seen)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectVars(arg, rootDot, seen)
+		}
+	case *parse.ChainNode:
+		collectVars(n.Node, rootDot, seen)
+	case *parse.FieldNode:
+		if rootDot {
+			seen[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		// $ always refers to the template data
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			seen[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		collectVars(n.Pipe, rootDot, seen)
+		collectVars(n.List, rootDot, seen)
+		collectVars(n.ElseList, rootDot, seen)
+	case *parse.RangeNode:
+		collectVars(n.Pipe, rootDot, seen)
+		collectVars(n.List, false, seen)
+		collectVars(n.ElseList, rootDot, seen)
+	case *parse.WithNode:
+		collectVars(n.Pipe, rootDot, seen)
+		collectVars(n.List, false, seen)
+		collectVars(n.ElseList, rootDot, seen)
+	case *parse.TemplateNode:
+		collectVars(n.Pipe, rootDot, seen)
+	}
+}
@@ -1,6 +1,12 @@
 package module
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+
+	"github.com/elmhuangyu/dotman/pkg/module/template"
+	"github.com/rs/zerolog"
+)
 
 // OperationType represents the type of operation performed
 type OperationType string
@@ -11,16 +17,64 @@ const (
 	OperationForceLink      OperationType = "force_link"
 	OperationForceTemplate  OperationType = "force_template"
 	OperationSkip           OperationType = "skip"
+	// Generated operations write the stdout of a module generator command
+	OperationCreateGenerated OperationType = "create_generated"
+	OperationForceGenerated  OperationType = "force_generated"
+	// OperationMergeGenerated writes generator output into the module's block of a shared file
+	OperationMergeGenerated OperationType = "merge_generated"
+	// OperationFixMode resets the permissions of a tracked file to the mode recorded in state
+	OperationFixMode OperationType = "fix_mode"
+	// OperationCreateDir creates the empty target directory of a keep file
+	OperationCreateDir OperationType = "create_dir"
+)
+
+// LinkMode decides how files that are not templates are installed
+type LinkMode string
+
+const (
+	// LinkModeSymlink always links targets to their sources
+	LinkModeSymlink LinkMode = "symlink"
+	// LinkModeAuto links targets on the filesystem of their source and copies across filesystems
+	LinkModeAuto LinkMode = "auto"
+)
+
+// ReasonCode classifies why an operation was skipped or needed special handling, so callers
+// can branch on it instead of matching the human-readable reason
+type ReasonCode string
+
+const (
+	// ReasonMissing is a target that no longer exists
+	ReasonMissing ReasonCode = "missing"
+	// ReasonNotASymlink is a tracked symlink replaced by another kind of file
+	ReasonNotASymlink ReasonCode = "not_a_symlink"
+	// ReasonWrongTarget is a tracked symlink pointing somewhere other than its source
+	ReasonWrongTarget ReasonCode = "wrong_target"
+	// ReasonNotARegularFile is a tracked generated or copied file replaced by something else
+	ReasonNotARegularFile ReasonCode = "not_a_regular_file"
+	// ReasonHashMismatch is a generated or copied file modified since it was installed
+	ReasonHashMismatch ReasonCode = "hash_mismatch"
+	// ReasonWrongOwner is a symlink owned by another user
+	ReasonWrongOwner ReasonCode = "wrong_owner"
+	// ReasonNotADirectory is a tracked directory replaced by a file
+	ReasonNotADirectory ReasonCode = "not_a_directory"
+	// ReasonNotEmpty is a tracked directory that is no longer empty
+	ReasonNotEmpty ReasonCode = "not_empty"
+	// ReasonCheckFailed is a target that could not be inspected
+	ReasonCheckFailed ReasonCode = "check_failed"
+	// ReasonUnknownType is a state entry of a type this version doesn't know
+	ReasonUnknownType ReasonCode = "unknown_type"
 )
 
 // OperationResult unified result type for all operations
 type OperationResult struct {
-	Type     OperationType          `json:"type"`
-	Source   string                 `json:"source"`
-	Target   string                 `json:"target"`
-	Success  bool                   `json:"success"`
-	Error    error                  `json:"error,omitempty"`
-	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Type    OperationType `json:"type"`
+	Source  string        `json:"source"`
+	Target  string        `json:"target"`
+	Success bool          `json:"success"`
+	Error   error         `json:"error,omitempty"`
+	// ReasonCode classifies skipped and backed up operations; the message is in Metadata["reason"]
+	ReasonCode ReasonCode             `json:"reason_code,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // ResultSummary for consistent reporting across operations
@@ -104,10 +158,178 @@ type InstallConfig struct {
 	DryRun    bool              `json:"dry_run"`
 	Vars      map[string]string `json:"vars,omitempty"`
 	StatePath string            `json:"state_path"`
+	KeepGoing bool              `json:"keep_going"`
+	// MaxBackups limits backups per target before rotation; zero uses the default
+	MaxBackups int `json:"max_backups"`
+	// CompressBackups writes backups of replaced regular files gzip-compressed
+	CompressBackups bool `json:"compress_backups"`
+	// TimestampBackups names backups after the time they were made
+	TimestampBackups bool `json:"timestamp_backups"`
+	// BackupSuffix replaces .bak in backup names
+	BackupSuffix string `json:"backup_suffix"`
+	// MkdirAllowedRoots restricts the directories Mkdir may create; empty allows any
+	MkdirAllowedRoots []string `json:"mkdir_allowed_roots,omitempty"`
+	// StrictTargetDirs fails validation when a target_dir is inside that of another module
+	StrictTargetDirs bool `json:"strict_target_dirs,omitempty"`
+	// KeepDangling treats dangling target symlinks as conflicts instead of replacing them
+	KeepDangling bool `json:"keep_dangling,omitempty"`
+	// PreflightTemplates renders every template before any file is written
+	PreflightTemplates bool `json:"preflight_templates"`
+	// SkipFailedTemplates leaves out templates that fail instead of failing their module
+	SkipFailedTemplates bool `json:"skip_failed_templates,omitempty"`
+	// CheckWritable fails validation when a directory that would receive files isn't writable
+	CheckWritable bool `json:"check_writable,omitempty"`
+	// Transactional undoes the applied operations when the installation fails
+	Transactional bool `json:"transactional"`
+	// LinkMode is how non-template files are installed; empty links them
+	LinkMode LinkMode `json:"link_mode,omitempty"`
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string `json:"profile,omitempty"`
+	// PrivilegedCmd creates symlinks that fail with a permission error, e.g. "sudo ln -sfn %s %s";
+	// empty disables the fallback
+	PrivilegedCmd string `json:"privileged_cmd,omitempty"`
+	// ExcludeTargets are absolute target paths or globs left alone by this run: matching
+	// operations are dropped after mapping and reported instead of applied
+	ExcludeTargets []string `json:"exclude_targets,omitempty"`
+	// VerifyOnSkip records the source hash of already correct links and warns about their
+	// permissions and owner
+	VerifyOnSkip bool `json:"verify_on_skip"`
+	// RecordVars stores the merged vars of templates and generated files in the state file
+	RecordVars bool `json:"record_vars"`
+	// Resume skips operations whose target the state file already tracks as installed
+	Resume bool `json:"resume"`
+	// ShowDiff records the diff of every regular file replaced by a link or template
+	ShowDiff bool `json:"show_diff,omitempty"`
+	// ModulePaths are the paths of every module, not only the installed ones, which templates
+	// read as .Modules and target directories are checked against; nil uses the installed modules
+	ModulePaths map[string]template.ModulePaths `json:"-"`
+	// Context cancels the installation between operations; defaults to context.Background()
+	Context context.Context `json:"-"`
+	Logger  *zerolog.Logger `json:"-"`
+}
+
+// ValidateConfig contains configuration for validate (dry-run) operations
+type ValidateConfig struct {
+	Mkdir bool              `json:"mkdir"`
+	Force bool              `json:"force"`
+	Vars  map[string]string `json:"vars,omitempty"`
+	// MkdirAllowedRoots restricts the directories Mkdir may create; empty allows any
+	MkdirAllowedRoots []string `json:"mkdir_allowed_roots,omitempty"`
+	// StateDir, when set, is the dotfiles directory whose state files of profiles other than
+	// Profile are checked for targets this installation would also manage
+	StateDir string `json:"state_dir,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	// ExcludeTargets are absolute target paths or globs whose operations are dropped
+	ExcludeTargets []string `json:"exclude_targets,omitempty"`
+	// StrictTargetDirs reports a target_dir inside the target_dir of another module as an
+	// error instead of a warning
+	StrictTargetDirs bool `json:"strict_target_dirs,omitempty"`
+	// KeepDangling reports targets that are symlinks to a missing file as conflicts instead
+	// of replacing them
+	KeepDangling bool `json:"keep_dangling,omitempty"`
+	// SkipFailedTemplates reports templates that fail validation in SkippedTemplates instead
+	// of as errors
+	SkipFailedTemplates bool `json:"skip_failed_templates,omitempty"`
+	// CheckWritable reports directories that would receive files but can't be written by this
+	// process as errors
+	CheckWritable bool `json:"check_writable,omitempty"`
+	// CompressBackups, TimestampBackups and BackupSuffix describe the backups conflicts are
+	// compared with, as they configure the backups of an installation
+	CompressBackups  bool   `json:"compress_backups,omitempty"`
+	TimestampBackups bool   `json:"timestamp_backups,omitempty"`
+	BackupSuffix     string `json:"backup_suffix,omitempty"`
+	// ModulePaths are the paths of every module, which templates read as .Modules and target
+	// directories are checked against; nil uses the paths of the validated modules
+	ModulePaths map[string]template.ModulePaths `json:"-"`
+	// Context cancels the validation between mappings; defaults to context.Background()
+	Context context.Context `json:"-"`
+	Logger  *zerolog.Logger `json:"-"`
+}
+
+// LogValidateConfig contains configuration for logging validation results
+type LogValidateConfig struct {
+	// Explain logs every operation with the reason it was chosen, not just conflicts
+	Explain bool            `json:"explain"`
+	Logger  *zerolog.Logger `json:"-"`
 }
 
 // UninstallConfig contains configuration for uninstall operations
 type UninstallConfig struct {
 	BackupModified bool   `json:"backup_modified"`
 	StatePath      string `json:"state_path"`
+	HashCache      bool   `json:"hash_cache"`
+	VerifyOwner    bool   `json:"verify_owner"`
+	MaxBackups     int    `json:"max_backups"`
+	// CompressBackups writes backups of modified generated files gzip-compressed
+	CompressBackups bool `json:"compress_backups"`
+	// TimestampBackups names backups after the time they were made
+	TimestampBackups bool `json:"timestamp_backups"`
+	// BackupSuffix replaces .bak in backup names
+	BackupSuffix string `json:"backup_suffix"`
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string `json:"profile,omitempty"`
+	// KeepBlocks leaves merged blocks and their state entries in place
+	KeepBlocks bool `json:"keep_blocks,omitempty"`
+	// ExcludeTargets are absolute target paths or globs whose entries are left in place
+	ExcludeTargets []string `json:"exclude_targets,omitempty"`
+	// Strict fails the uninstallation when any entry is skipped or fails to be removed
+	Strict bool `json:"strict,omitempty"`
+	// ValidateAgainstConfig warns about state entries the config no longer declares
+	ValidateAgainstConfig bool `json:"validate_against_config,omitempty"`
+	// SourceDir is the directory modules are loaded from when set, instead of StatePath
+	SourceDir string `json:"source_dir,omitempty"`
+	// Context cancels the uninstallation between removals; defaults to context.Background()
+	Context context.Context `json:"-"`
+	Logger  *zerolog.Logger `json:"-"`
+}
+
+// AuditConfig contains configuration for state file audits
+type AuditConfig struct {
+	StatePath string `json:"state_path"`
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string          `json:"profile,omitempty"`
+	Logger  *zerolog.Logger `json:"-"`
+}
+
+// RepairConfig contains configuration for repair operations
+type RepairConfig struct {
+	StatePath string            `json:"state_path"`
+	Vars      map[string]string `json:"vars,omitempty"`
+	// Regenerate overwrites generated files that were modified since installation
+	Regenerate bool `json:"regenerate"`
+	MaxBackups int  `json:"max_backups"`
+	// CompressBackups writes backups of replaced regular files gzip-compressed
+	CompressBackups bool `json:"compress_backups"`
+	// TimestampBackups names backups after the time they were made
+	TimestampBackups bool `json:"timestamp_backups"`
+	// BackupSuffix replaces .bak in backup names
+	BackupSuffix string `json:"backup_suffix"`
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string `json:"profile,omitempty"`
+	// SourceDir is the directory modules are loaded from when set, instead of StatePath
+	SourceDir string `json:"source_dir,omitempty"`
+	// Context cancels the repair between entries; defaults to context.Background()
+	Context context.Context `json:"-"`
+	Logger  *zerolog.Logger `json:"-"`
+}
+
+// MoveConfig contains configuration for moving a managed target
+type MoveConfig struct {
+	StatePath string `json:"state_path"`
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string `json:"profile,omitempty"`
+	// OldTarget is the managed link to move and NewTarget the path it is moved to
+	OldTarget string `json:"old_target"`
+	NewTarget string `json:"new_target"`
+	// Mkdir creates the missing parent directories of NewTarget
+	Mkdir  bool            `json:"mkdir"`
+	Logger *zerolog.Logger `json:"-"`
+}
+
+// contextOrBackground returns ctx, or context.Background() when it is nil
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
 }
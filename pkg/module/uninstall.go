@@ -1,6 +1,8 @@
 package module
 
 import (
+	"fmt"
+
 	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"github.com/elmhuangyu/dotman/pkg/state"
 )
@@ -15,7 +17,27 @@ type UninstallResult struct {
 	RemovedGenerated  []FileOperation
 	SkippedGenerated  []OperationResult
 	BackedUpGenerated []OperationResult
-	FailedRemovals    []OperationResult
+	// RemovedDirs are empty directories created for keep files; SkippedDirs are ones left in
+	// place, usually because they are no longer empty
+	RemovedDirs []FileOperation
+	SkippedDirs []OperationResult
+	// RemovedBlocks are blocks taken out of shared files; SkippedBlocks are ones whose file or
+	// markers are gone
+	RemovedBlocks  []FileOperation
+	SkippedBlocks  []OperationResult
+	FailedRemovals []OperationResult
+	// Warnings are problems that don't fail the uninstallation, such as state entries of an
+	// unknown type, which are left tracked
+	Warnings []string
+	// UndeclaredEntries are the state entries the config no longer declares, only checked with
+	// ValidateAgainstConfig
+	UndeclaredEntries []state.FileMapping
+}
+
+// OneLine returns a stable, grep-friendly summary line of the uninstallation
+func (r *UninstallResult) OneLine() string {
+	return fmt.Sprintf("dotman uninstall: removed=%d generated=%d skipped=%d errors=%d backups=%d",
+		len(r.RemovedLinks), len(r.RemovedGenerated), len(r.SkippedLinks)+len(r.SkippedGenerated), len(r.Errors), len(r.BackedUpGenerated))
 }
 
 // Uninstall performs the uninstallation of dotfiles using the state file
@@ -38,8 +60,22 @@ func UninstallWithConfig(config *UninstallConfig) (*UninstallResult, error) {
 
 	// Create request
 	req := &UninstallRequest{
-		DotfilesDir:    config.StatePath,
-		BackupModified: config.BackupModified,
+		DotfilesDir:           config.StatePath,
+		BackupModified:        config.BackupModified,
+		HashCache:             config.HashCache,
+		VerifyOwner:           config.VerifyOwner,
+		MaxBackups:            config.MaxBackups,
+		CompressBackups:       config.CompressBackups,
+		TimestampBackups:      config.TimestampBackups,
+		BackupSuffix:          config.BackupSuffix,
+		Profile:               config.Profile,
+		KeepBlocks:            config.KeepBlocks,
+		ExcludeTargets:        config.ExcludeTargets,
+		Strict:                config.Strict,
+		ValidateAgainstConfig: config.ValidateAgainstConfig,
+		SourceDir:             config.SourceDir,
+		Context:               config.Context,
+		Logger:                config.Logger,
 	}
 
 	// Perform uninstallation
@@ -57,6 +93,14 @@ func (s *stateManagerAdapter) Save(path string, stateFile *state.StateFile) erro
 	return state.SaveStateFile(path, stateFile)
 }
 
+func (s *stateManagerAdapter) AppendEvent(path string, stateFile *state.StateFile, event state.Event) error {
+	return state.AppendEvent(path, stateFile, event)
+}
+
+func (s *stateManagerAdapter) Compact(path string) error {
+	return state.CompactStateFile(path)
+}
+
 func (s *stateManagerAdapter) AddMapping(stateFile *state.StateFile, source, target, fileType string) error {
 	return state.AddMapping(stateFile, source, target, fileType)
 }
@@ -1,11 +1,13 @@
 package module
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 
 	"github.com/elmhuangyu/dotman/pkg/logger"
 	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
@@ -18,6 +20,41 @@ import (
 type UninstallRequest struct {
 	DotfilesDir    string
 	BackupModified bool
+	// Profile selects the state file (state.<profile>.yaml); empty uses state.yaml
+	Profile string
+	// HashCache reuses generated file hashes from the on-disk cache when size and mtime are unchanged
+	HashCache bool
+	// VerifyOwner skips symlinks not owned by the current user, for shared machines
+	VerifyOwner bool
+	// MaxBackups limits backups per target before the oldest is rotated out; zero uses the default
+	MaxBackups int
+	// CompressBackups writes backups of replaced regular files gzip-compressed (.bak.gz)
+	CompressBackups bool
+	// TimestampBackups names backups target.YYYYMMDD-HHMMSS.bak instead of numbering them
+	TimestampBackups bool
+	// BackupSuffix replaces .bak in backup names; empty uses filesystem.DefaultBackupSuffix
+	BackupSuffix string
+	// KeepBlocks leaves merged blocks and their state entries in place, for the cleanup before
+	// a reinstall, which updates blocks where they are instead of moving them to the end
+	KeepBlocks bool
+	// ExcludeTargets are absolute target paths or globs whose entries are left installed and tracked
+	ExcludeTargets []string
+	// OnlyTargets restricts the uninstallation to the entries of these target paths; empty
+	// uninstalls every entry
+	OnlyTargets []string
+	// Strict fails the uninstallation when any entry is skipped or fails to be removed, after
+	// every safe removal was still performed, so scripts know the targets aren't clean
+	Strict bool
+	// ValidateAgainstConfig loads the config in DotfilesDir and warns about the entries it no
+	// longer declares, such as ones of removed modules; they are still uninstalled
+	ValidateAgainstConfig bool
+	// SourceDir is the directory the config checked by ValidateAgainstConfig loads its modules
+	// from; empty uses DotfilesDir
+	SourceDir string
+	// Context cancels the uninstallation between removals; defaults to context.Background() when nil
+	Context context.Context
+	// Logger receives progress output; defaults to the global logger when nil
+	Logger *zerolog.Logger
 }
 
 // SymlinkValidationResult contains the result of symlink validation
@@ -30,6 +67,7 @@ type SymlinkValidationResult struct {
 type GeneratedFileValidationResult struct {
 	IsValid        bool
 	Reason         string
+	Code           ReasonCode
 	BackupRequired bool
 }
 
@@ -37,22 +75,27 @@ type GeneratedFileValidationResult struct {
 type Uninstaller struct {
 	fileOp   filesystem.FileOperator
 	stateMgr state.StateManager
+	// linkOwner and currentUID back owner verification; replaceable in tests
+	linkOwner  func(path string) (int, bool, error)
+	currentUID func() int
 }
 
 // NewUninstaller creates a new Uninstaller instance
 func NewUninstaller(fileOp filesystem.FileOperator, stateMgr state.StateManager) *Uninstaller {
 	return &Uninstaller{
-		fileOp:   fileOp,
-		stateMgr: stateMgr,
+		fileOp:     fileOp,
+		stateMgr:   stateMgr,
+		linkOwner:  filesystem.LinkOwner,
+		currentUID: os.Getuid,
 	}
 }
 
 // Uninstall performs the uninstallation of dotfiles using the state file
 func (u *Uninstaller) Uninstall(req *UninstallRequest) (*UninstallResult, error) {
-	log := logger.GetLogger()
+	log := logger.OrDefault(req.Logger)
 
 	// Load state file
-	statePath := filepath.Join(req.DotfilesDir, "state.yaml")
+	statePath := dotmanState.Path(req.DotfilesDir, req.Profile)
 	stateFile, err := u.stateMgr.Load(statePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load state file: %w", err)
@@ -68,31 +111,112 @@ func (u *Uninstaller) Uninstall(req *UninstallRequest) (*UninstallResult, error)
 
 	log.Debug().Int("tracked_files", len(stateFile.Files)).Msg("Loaded state file")
 
+	// Entries of excluded targets, or of targets not selected by OnlyTargets, are neither
+	// removed nor dropped from the saved state file
+	pending := stateFile
+	if len(req.ExcludeTargets) > 0 || len(req.OnlyTargets) > 0 {
+		pending = &dotmanState.StateFile{Version: stateFile.Version, Relative: stateFile.Relative}
+		for _, fileMapping := range stateFile.Files {
+			if excludedTarget(fileMapping.Target, req.ExcludeTargets) {
+				continue
+			}
+			if len(req.OnlyTargets) > 0 && !slices.Contains(req.OnlyTargets, fileMapping.Target) {
+				continue
+			}
+			pending.Files = append(pending.Files, fileMapping)
+		}
+	}
+
 	result := &UninstallResult{
 		IsSuccess: true,
 		Errors:    []string{},
 	}
 
+	if req.ValidateAgainstConfig && len(pending.Files) > 0 {
+		undeclared, err := undeclaredEntries(req.DotfilesDir, req.SourceDir, pending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate state against config: %w", err)
+		}
+		for _, fileMapping := range undeclared {
+			warning := fmt.Sprintf("state entry %s -> %s is not declared by the config", fileMapping.Source, fileMapping.Target)
+			result.Warnings = append(result.Warnings, warning)
+			log.Warn().Msg(warning)
+		}
+		result.UndeclaredEntries = undeclared
+	}
+
+	// No uninstall step handles an unknown type, so such entries would stay tracked silently
+	for _, fileMapping := range pending.UnknownTypes() {
+		warning := fmt.Sprintf("state entry for %s has unknown type %q and was left in place; fix or remove it in %s", fileMapping.Target, fileMapping.Type, statePath)
+		result.Warnings = append(result.Warnings, warning)
+		log.Warn().Msg(warning)
+	}
+
 	// Initialize filesystem operators
 	symlinkMgr := filesystem.NewSymlinkManager(u.fileOp)
-	backupMgr := filesystem.NewBackupManager(u.fileOp)
+	backupMgr := filesystem.NewBackupManagerWithOptions(u.fileOp, filesystem.BackupOptions{MaxBackups: req.MaxBackups, Compress: req.CompressBackups, Timestamped: req.TimestampBackups, Suffix: req.BackupSuffix})
+
+	// Process symlinks, then generated files. A cancelled context stops between removals;
+	// the state file still drops the entries removed until then.
+	ctx := contextOrBackground(req.Context)
+	// Each removal is appended to the state log as it happens, so an interrupted uninstallation
+	// still drops the entries it removed; the log is folded into the state file at the end
+	record := func(event dotmanState.Event) {
+		if err := u.stateMgr.AppendEvent(statePath, stateFile, event); err != nil {
+			log.Warn().Err(err).Str("target", event.Target).Msg("Failed to record removal in state log")
+		}
+	}
+	cancelErr := u.uninstallSymlinks(ctx, pending, symlinkMgr, req.VerifyOwner, result, record, log)
 
-	// Process symlinks
-	if err := u.uninstallSymlinks(stateFile, symlinkMgr, result); err != nil {
-		return nil, fmt.Errorf("failed to uninstall symlinks: %w", err)
+	// Load the hash cache if enabled
+	var hashCache *dotmanState.HashCache
+	cachePath := filepath.Join(req.DotfilesDir, dotmanState.HashCacheFileName)
+	if req.HashCache {
+		hashCache, err = u.loadHashCache(cachePath)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to load hash cache, hashing all generated files")
+			hashCache = dotmanState.NewHashCache()
+		}
 	}
 
-	// Process generated files
-	if err := u.uninstallGeneratedFiles(stateFile, backupMgr, result); err != nil {
-		return nil, fmt.Errorf("failed to uninstall generated files: %w", err)
+	if cancelErr == nil {
+		cancelErr = u.uninstallGeneratedFiles(ctx, pending, backupMgr, result, hashCache, record, log)
+	}
+	if cancelErr == nil && !req.KeepBlocks {
+		cancelErr = u.uninstallBlocks(ctx, pending, backupMgr, result, record, log)
+	}
+	if cancelErr == nil {
+		cancelErr = u.uninstallDirs(ctx, pending, result, record, log)
 	}
 
-	// Update state file to remove successfully uninstalled entries
-	if err := u.updateStateFile(statePath, stateFile, result, log); err != nil {
+	if hashCache != nil {
+		hashCache.Prune()
+		if err := u.saveHashCache(cachePath, hashCache); err != nil {
+			log.Warn().Err(err).Msg("Failed to save hash cache")
+		}
+	}
+
+	// Fold the removals into the state file
+	if err := u.stateMgr.Compact(statePath); err != nil {
 		log.Warn().Err(err).Msg("Failed to update state file after uninstallation")
 		// Don't fail the operation, but log the warning
 	}
 
+	if cancelErr != nil {
+		result.IsSuccess = false
+		result.Errors = append(result.Errors, fmt.Sprintf("uninstallation cancelled: %v", cancelErr))
+		result.Summary = fmt.Sprintf("Uninstallation cancelled: %d symlinks and %d generated files removed before cancellation", len(result.RemovedLinks), len(result.RemovedGenerated))
+		log.Warn().Err(cancelErr).Msg("Uninstallation cancelled")
+		return result, cancelErr
+	}
+
+	if req.Strict {
+		if leftBehind := len(result.SkippedLinks) + len(result.SkippedGenerated) + len(result.FailedRemovals); leftBehind > 0 {
+			result.IsSuccess = false
+			result.Errors = append(result.Errors, fmt.Sprintf("strict mode: %d entries were skipped or failed to be removed", leftBehind))
+		}
+	}
+
 	// Generate summary
 	u.generateSummary(result)
 
@@ -100,8 +224,11 @@ func (u *Uninstaller) Uninstall(req *UninstallRequest) (*UninstallResult, error)
 }
 
 // uninstallSymlinks processes all symlink mappings in the state file
-func (u *Uninstaller) uninstallSymlinks(stateFile *dotmanState.StateFile, symlinkMgr *filesystem.SymlinkManager, result *UninstallResult) error {
+func (u *Uninstaller) uninstallSymlinks(ctx context.Context, stateFile *dotmanState.StateFile, symlinkMgr *filesystem.SymlinkManager, verifyOwner bool, result *UninstallResult, record func(dotmanState.Event), log zerolog.Logger) error {
 	for _, fileMapping := range stateFile.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		if fileMapping.Type != dotmanState.TypeLink {
 			continue
@@ -115,28 +242,56 @@ func (u *Uninstaller) uninstallSymlinks(stateFile *dotmanState.StateFile, symlin
 		}
 
 		// Validate symlink before removal
-		if err := u.validateBeforeRemoval(fileMapping, symlinkMgr, result, operation); err != nil {
+		if err := u.validateBeforeRemoval(fileMapping, symlinkMgr, verifyOwner, result, operation, log); err != nil {
 			continue // Skip this symlink, error already recorded
 		}
 
 		// Remove the symlink
-		if err := u.removeSymlink(symlinkMgr, fileMapping.Target, result, operation); err != nil {
+		if err := u.removeSymlink(symlinkMgr, fileMapping.Target, result, operation, log); err != nil {
 			continue // Error already recorded
 		}
 
 		result.RemovedLinks = append(result.RemovedLinks, operation)
-		log := logger.GetLogger()
+		record(dotmanState.RemoveEvent(fileMapping.Target))
 		log.Debug().Str("target", fileMapping.Target).Msg("Successfully removed symlink")
 	}
 
 	return nil
 }
 
-// uninstallGeneratedFiles processes all generated file mappings in the state file
-func (u *Uninstaller) uninstallGeneratedFiles(stateFile *dotmanState.StateFile, backupMgr *filesystem.BackupManager, result *UninstallResult) error {
+// loadHashCache reads the hash cache through the file operator, returning an empty cache if it does not exist
+func (u *Uninstaller) loadHashCache(path string) (*dotmanState.HashCache, error) {
+	data, err := u.fileOp.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dotmanState.NewHashCache(), nil
+		}
+		return nil, fmt.Errorf("failed to read hash cache: %w", err)
+	}
+	return dotmanState.ParseHashCache(data)
+}
+
+// saveHashCache writes the hash cache through the file operator
+func (u *Uninstaller) saveHashCache(path string, hashCache *dotmanState.HashCache) error {
+	data, err := hashCache.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := u.fileOp.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+	return nil
+}
+
+// uninstallGeneratedFiles processes all generated and copied file mappings in the state file
+func (u *Uninstaller) uninstallGeneratedFiles(ctx context.Context, stateFile *dotmanState.StateFile, backupMgr *filesystem.BackupManager, result *UninstallResult, hashCache *dotmanState.HashCache, record func(dotmanState.Event), log zerolog.Logger) error {
 	for _, fileMapping := range stateFile.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		if fileMapping.Type != dotmanState.TypeGenerated {
+		// Copies are owned by dotman like generated files, and removed the same way
+		if fileMapping.Type != dotmanState.TypeGenerated && fileMapping.Type != dotmanState.TypeCopy {
 			continue
 		}
 
@@ -148,100 +303,284 @@ func (u *Uninstaller) uninstallGeneratedFiles(stateFile *dotmanState.StateFile,
 		}
 
 		// Validate generated file before removal
-		validationResult := u.validateGeneratedFile(fileMapping)
+		validationResult := u.validateGeneratedFile(fileMapping, hashCache)
 		if !validationResult.IsValid {
 			result.SkippedGenerated = append(result.SkippedGenerated, OperationResult{
-				Type:     operation.Type,
-				Source:   operation.Source,
-				Target:   operation.Target,
-				Success:  false,
-				Error:    fmt.Errorf("validation failed: %s", validationResult.Reason),
-				Metadata: map[string]interface{}{"reason": validationResult.Reason},
+				Type:       operation.Type,
+				Source:     operation.Source,
+				Target:     operation.Target,
+				Success:    false,
+				Error:      fmt.Errorf("validation failed: %s", validationResult.Reason),
+				ReasonCode: validationResult.Code,
+				Metadata:   map[string]interface{}{"reason": validationResult.Reason},
 			})
-			log := logger.GetLogger()
 			log.Warn().Str("target", fileMapping.Target).Str("reason", validationResult.Reason).Msg("Skipping generated file removal")
 			continue
 		}
 
 		// Check if file content has been modified and create backup if needed
 		if validationResult.BackupRequired {
-			if err := u.createBackupForGeneratedFile(backupMgr, fileMapping.Target, result, operation); err != nil {
+			if err := u.createBackupForGeneratedFile(backupMgr, fileMapping.Target, result, operation, log); err != nil {
 				continue // Error already recorded
 			}
 		}
 
 		// Remove the generated file
-		if err := u.removeGeneratedFile(fileMapping.Target, result, operation); err != nil {
+		if err := u.removeGeneratedFile(fileMapping.Target, result, operation, log); err != nil {
 			continue // Error already recorded
 		}
 
 		result.RemovedGenerated = append(result.RemovedGenerated, operation)
-		log := logger.GetLogger()
+		record(dotmanState.RemoveEvent(fileMapping.Target))
 		log.Debug().Str("target", fileMapping.Target).Msg("Successfully removed generated file")
 	}
 
 	return nil
 }
 
-// calculateSHA1 computes the SHA1 hash of a file's content
-func calculateSHA1(filePath string) (string, error) {
+// uninstallBlocks takes each tracked block out of its shared file, leaving other blocks and
+// user content in place. A modified block is backed up with its file first, and a file left
+// with nothing but whitespace is removed.
+func (u *Uninstaller) uninstallBlocks(ctx context.Context, stateFile *dotmanState.StateFile, backupMgr *filesystem.BackupManager, result *UninstallResult, record func(dotmanState.Event), log zerolog.Logger) error {
+	for _, fileMapping := range stateFile.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fileMapping.Type != dotmanState.TypeBlock {
+			continue
+		}
+
+		operation := FileOperation{
+			Type:        OperationMergeGenerated,
+			Source:      fileMapping.Source,
+			Target:      fileMapping.Target,
+			Module:      fileMapping.Block,
+			Description: fmt.Sprintf("Remove block %s from %s", fileMapping.Block, fileMapping.Target),
+		}
+
+		content, mode, body, code, reason := u.readBlock(fileMapping)
+		if code != "" {
+			result.SkippedBlocks = append(result.SkippedBlocks, OperationResult{
+				Type:       operation.Type,
+				Source:     operation.Source,
+				Target:     operation.Target,
+				Success:    false,
+				Error:      fmt.Errorf("validation failed: %s", reason),
+				ReasonCode: code,
+				Metadata:   map[string]interface{}{"reason": reason, "block": fileMapping.Block},
+			})
+			log.Warn().Str("target", fileMapping.Target).Str("block", fileMapping.Block).Str("reason", reason).Msg("Skipping block removal")
+			continue
+		}
+
+		if fileMapping.SHA1 != "" && blockSHA1(body) != fileMapping.SHA1 {
+			if err := u.createBackupForGeneratedFile(backupMgr, fileMapping.Target, result, operation, log); err != nil {
+				continue // Error already recorded
+			}
+		}
+
+		remaining, _, _ := removeBlock(content, fileMapping.Block)
+		var err error
+		if len(bytes.TrimSpace(remaining)) == 0 {
+			err = u.fileOp.RemoveFile(fileMapping.Target)
+		} else {
+			err = u.fileOp.WriteFile(fileMapping.Target, remaining, mode)
+		}
+		if err != nil {
+			result.FailedRemovals = append(result.FailedRemovals, OperationResult{
+				Type:     operation.Type,
+				Source:   operation.Source,
+				Target:   operation.Target,
+				Success:  false,
+				Error:    err,
+				Metadata: map[string]interface{}{"reason": err.Error(), "block": fileMapping.Block},
+			})
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to remove block %s from %s: %v", fileMapping.Block, fileMapping.Target, err))
+			log.Error().Err(err).Str("target", fileMapping.Target).Str("block", fileMapping.Block).Msg("Failed to remove block")
+			continue
+		}
+
+		result.RemovedBlocks = append(result.RemovedBlocks, operation)
+		record(dotmanState.RemoveBlockEvent(fileMapping.Target, fileMapping.Block))
+		log.Debug().Str("target", fileMapping.Target).Str("block", fileMapping.Block).Msg("Successfully removed block")
+	}
+
+	return nil
+}
 
-	file, err := os.Open(filePath)
+// readBlock reads the shared file of a block entry and the block's content; a non-empty
+// reason code and description tell why the block can't be removed
+func (u *Uninstaller) readBlock(fileMapping dotmanState.FileMapping) ([]byte, os.FileMode, []byte, ReasonCode, string) {
+	info, err := os.Stat(fileMapping.Target)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file for SHA1 calculation: %w", err)
+		if os.IsNotExist(err) {
+			return nil, 0, nil, ReasonMissing, "target file does not exist"
+		}
+		return nil, 0, nil, ReasonCheckFailed, fmt.Sprintf("failed to stat target: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, 0, nil, ReasonNotARegularFile, "target exists but is not a regular file"
 	}
-	defer file.Close()
 
-	hasher := sha1.New()
+	content, err := u.fileOp.ReadFile(fileMapping.Target)
+	if err != nil {
+		return nil, 0, nil, ReasonCheckFailed, fmt.Sprintf("failed to read target: %v", err)
+	}
+	body, ok, err := blockBody(content, fileMapping.Block)
+	if err != nil {
+		return nil, 0, nil, ReasonCheckFailed, err.Error()
+	}
+	if !ok {
+		return nil, 0, nil, ReasonMissing, fmt.Sprintf("block %s is no longer in the file", fileMapping.Block)
+	}
+	return content, info.Mode().Perm(), body, "", ""
+}
 
-	// Use a buffered reader for reading
-	buf := make([]byte, 32*1024) // 32KB buffer
-	for {
+// uninstallDirs removes the directories created for keep files once the files in them are
+// gone, deepest first so nested keep directories empty their parents. Directories that still
+// contain anything are left in place.
+func (u *Uninstaller) uninstallDirs(ctx context.Context, stateFile *dotmanState.StateFile, result *UninstallResult, record func(dotmanState.Event), log zerolog.Logger) error {
+	var dirs []dotmanState.FileMapping
+	for _, fileMapping := range stateFile.Files {
+		if fileMapping.Type == dotmanState.TypeDir {
+			dirs = append(dirs, fileMapping)
+		}
+	}
+	sort.Slice(dirs, func(a, b int) bool {
+		return dirs[a].Target > dirs[b].Target
+	})
 
-		n, err := file.Read(buf)
-		if n > 0 {
-			if _, writeErr := hasher.Write(buf[:n]); writeErr != nil {
-				return "", fmt.Errorf("failed to write to hasher: %w", writeErr)
-			}
+	for _, fileMapping := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("failed to read file for SHA1 calculation: %w", err)
+
+		operation := FileOperation{
+			Type:        OperationCreateDir,
+			Source:      fileMapping.Source,
+			Target:      fileMapping.Target,
+			Description: fmt.Sprintf("Remove directory %s", fileMapping.Target),
 		}
+
+		if code, reason := checkEmptyDir(fileMapping.Target); code != "" {
+			result.SkippedDirs = append(result.SkippedDirs, OperationResult{
+				Type:       operation.Type,
+				Source:     operation.Source,
+				Target:     operation.Target,
+				Success:    false,
+				Error:      fmt.Errorf("validation failed: %s", reason),
+				ReasonCode: code,
+				Metadata:   map[string]interface{}{"reason": reason},
+			})
+			log.Info().Str("target", fileMapping.Target).Str("reason", reason).Msg("Keeping directory")
+			continue
+		}
+
+		if err := u.fileOp.RemoveFile(fileMapping.Target); err != nil {
+			result.FailedRemovals = append(result.FailedRemovals, OperationResult{
+				Type:     operation.Type,
+				Source:   operation.Source,
+				Target:   operation.Target,
+				Success:  false,
+				Error:    err,
+				Metadata: map[string]interface{}{"reason": err.Error()},
+			})
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to remove directory %s: %v", fileMapping.Target, err))
+			log.Error().Err(err).Str("target", fileMapping.Target).Msg("Failed to remove directory")
+			continue
+		}
+
+		result.RemovedDirs = append(result.RemovedDirs, operation)
+		record(dotmanState.RemoveEvent(fileMapping.Target))
+		log.Debug().Str("target", fileMapping.Target).Msg("Successfully removed directory")
 	}
 
-	hash := hasher.Sum(nil)
-	return fmt.Sprintf("%x", hash), nil
+	return nil
+}
+
+// checkEmptyDir returns a reason code and description unless dir is an empty directory
+func checkEmptyDir(dir string) (ReasonCode, string) {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ReasonMissing, "directory does not exist"
+		}
+		return ReasonCheckFailed, fmt.Sprintf("failed to stat directory: %v", err)
+	}
+	if !info.IsDir() {
+		return ReasonNotADirectory, "target exists but is not a directory"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ReasonCheckFailed, fmt.Sprintf("failed to read directory: %v", err)
+	}
+	if len(entries) > 0 {
+		return ReasonNotEmpty, "directory is not empty"
+	}
+	return "", ""
 }
 
 // validateBeforeRemoval validates a symlink before removal
-func (u *Uninstaller) validateBeforeRemoval(fileMapping dotmanState.FileMapping, symlinkMgr *filesystem.SymlinkManager, result *UninstallResult, operation FileOperation) error {
-	isValid, reason, err := symlinkMgr.ValidateSymlink(fileMapping.Target, fileMapping.Source)
+func (u *Uninstaller) validateBeforeRemoval(fileMapping dotmanState.FileMapping, symlinkMgr *filesystem.SymlinkManager, verifyOwner bool, result *UninstallResult, operation FileOperation, log zerolog.Logger) error {
+	state, reason, err := symlinkMgr.CheckSymlink(fileMapping.Target, fileMapping.Source)
+	isValid := err == nil && state == filesystem.SymlinkValid
+	code := symlinkReasonCode(state)
 	if err != nil {
 		reason = fmt.Sprintf("failed to validate symlink: %v", err)
-		isValid = false
+		code = ReasonCheckFailed
+	}
+
+	if isValid && verifyOwner {
+		isValid, code, reason = u.verifyOwner(fileMapping.Target)
 	}
 
 	if !isValid {
 		result.SkippedLinks = append(result.SkippedLinks, OperationResult{
-			Type:     operation.Type,
-			Source:   operation.Source,
-			Target:   operation.Target,
-			Success:  false,
-			Error:    fmt.Errorf("validation failed: %s", reason),
-			Metadata: map[string]interface{}{"reason": reason},
+			Type:       operation.Type,
+			Source:     operation.Source,
+			Target:     operation.Target,
+			Success:    false,
+			Error:      fmt.Errorf("validation failed: %s", reason),
+			ReasonCode: code,
+			Metadata:   map[string]interface{}{"reason": reason},
 		})
-		log := logger.GetLogger()
 		log.Warn().Str("target", fileMapping.Target).Str("reason", reason).Msg("Skipping symlink removal")
 		return fmt.Errorf("validation failed: %s", reason)
 	}
 	return nil
 }
 
+// symlinkReasonCode returns the reason code of an invalid symlink state
+func symlinkReasonCode(state filesystem.SymlinkState) ReasonCode {
+	switch state {
+	case filesystem.SymlinkMissing:
+		return ReasonMissing
+	case filesystem.SymlinkNotALink:
+		return ReasonNotASymlink
+	case filesystem.SymlinkWrongTarget:
+		return ReasonWrongTarget
+	}
+	return ""
+}
+
+// verifyOwner checks that target is owned by the current user
+func (u *Uninstaller) verifyOwner(target string) (bool, ReasonCode, string) {
+	uid, ok, err := u.linkOwner(target)
+	if err != nil {
+		return false, ReasonCheckFailed, fmt.Sprintf("failed to read symlink owner: %v", err)
+	}
+	if !ok {
+		// Ownership is not available on this platform, so there is nothing to verify
+		return true, "", ""
+	}
+	if current := u.currentUID(); uid != current {
+		return false, ReasonWrongOwner, fmt.Sprintf("symlink is owned by uid %d, not the current user (uid %d)", uid, current)
+	}
+	return true, "", ""
+}
+
 // removeSymlink removes a symlink and records the result
-func (u *Uninstaller) removeSymlink(symlinkMgr *filesystem.SymlinkManager, target string, result *UninstallResult, operation FileOperation) error {
+func (u *Uninstaller) removeSymlink(symlinkMgr *filesystem.SymlinkManager, target string, result *UninstallResult, operation FileOperation, log zerolog.Logger) error {
 	if err := symlinkMgr.RemoveSymlink(target); err != nil {
 		result.FailedRemovals = append(result.FailedRemovals, OperationResult{
 			Type:     operation.Type,
@@ -252,15 +591,19 @@ func (u *Uninstaller) removeSymlink(symlinkMgr *filesystem.SymlinkManager, targe
 			Metadata: map[string]interface{}{"reason": err.Error()},
 		})
 		result.Errors = append(result.Errors, fmt.Sprintf("failed to remove symlink %s: %v", target, err))
-		log := logger.GetLogger()
 		log.Error().Err(err).Str("target", target).Msg("Failed to remove symlink")
 		return err
 	}
 	return nil
 }
 
+// calculateSHA1 computes the SHA1 hash of a file's content
+func (u *Uninstaller) calculateSHA1(filePath string) (string, error) {
+	return filesystem.FileSHA1(u.fileOp, filePath)
+}
+
 // validateGeneratedFile validates a generated file for removal
-func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping) GeneratedFileValidationResult {
+func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping, hashCache *dotmanState.HashCache) GeneratedFileValidationResult {
 	// Check if target exists
 	targetInfo, err := os.Stat(fileMapping.Target)
 	if err != nil {
@@ -268,12 +611,14 @@ func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping)
 			return GeneratedFileValidationResult{
 				IsValid:        false,
 				Reason:         "target file does not exist",
+				Code:           ReasonMissing,
 				BackupRequired: false,
 			}
 		}
 		return GeneratedFileValidationResult{
 			IsValid:        false,
 			Reason:         fmt.Sprintf("failed to stat target: %v", err),
+			Code:           ReasonCheckFailed,
 			BackupRequired: false,
 		}
 	}
@@ -283,17 +628,24 @@ func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping)
 		return GeneratedFileValidationResult{
 			IsValid:        false,
 			Reason:         "target exists but is not a regular file",
+			Code:           ReasonNotARegularFile,
 			BackupRequired: false,
 		}
 	}
 
 	// Check SHA1 if available (for integrity verification)
 	if fileMapping.SHA1 != "" {
-		currentSHA1, err := calculateSHA1(fileMapping.Target)
+		var currentSHA1 string
+		if hashCache != nil {
+			currentSHA1, err = hashCache.SHA1(fileMapping.Target)
+		} else {
+			currentSHA1, err = u.calculateSHA1(fileMapping.Target)
+		}
 		if err != nil {
 			return GeneratedFileValidationResult{
 				IsValid:        false,
 				Reason:         fmt.Sprintf("failed to calculate SHA1: %v", err),
+				Code:           ReasonCheckFailed,
 				BackupRequired: false,
 			}
 		}
@@ -302,6 +654,7 @@ func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping)
 			return GeneratedFileValidationResult{
 				IsValid:        true, // Valid for removal, but backup required
 				Reason:         "file content has been modified",
+				Code:           ReasonHashMismatch,
 				BackupRequired: true,
 			}
 		}
@@ -314,7 +667,7 @@ func (u *Uninstaller) validateGeneratedFile(fileMapping dotmanState.FileMapping)
 }
 
 // createBackupForGeneratedFile creates a backup for a modified generated file
-func (u *Uninstaller) createBackupForGeneratedFile(backupMgr *filesystem.BackupManager, target string, result *UninstallResult, operation FileOperation) error {
+func (u *Uninstaller) createBackupForGeneratedFile(backupMgr *filesystem.BackupManager, target string, result *UninstallResult, operation FileOperation, log zerolog.Logger) error {
 	backupPath, err := backupMgr.CreateBackup(target)
 	if err != nil {
 		result.FailedRemovals = append(result.FailedRemovals, OperationResult{
@@ -326,25 +679,24 @@ func (u *Uninstaller) createBackupForGeneratedFile(backupMgr *filesystem.BackupM
 			Metadata: map[string]interface{}{"reason": fmt.Sprintf("failed to create backup: %v", err)},
 		})
 		result.Errors = append(result.Errors, fmt.Sprintf("failed to backup generated file %s: %v", target, err))
-		log := logger.GetLogger()
 		log.Error().Err(err).Str("target", target).Msg("Failed to create backup for modified generated file")
 		return err
 	}
 
 	result.BackedUpGenerated = append(result.BackedUpGenerated, OperationResult{
-		Type:     operation.Type,
-		Source:   operation.Source,
-		Target:   operation.Target,
-		Success:  true,
-		Metadata: map[string]interface{}{"reason": fmt.Sprintf("backed up to %s", backupPath), "backup_path": backupPath},
+		Type:       operation.Type,
+		Source:     operation.Source,
+		Target:     operation.Target,
+		Success:    true,
+		ReasonCode: ReasonHashMismatch,
+		Metadata:   map[string]interface{}{"reason": fmt.Sprintf("backed up to %s", backupPath), "backup_path": backupPath},
 	})
-	log := logger.GetLogger()
 	log.Warn().Str("target", target).Str("backup", backupPath).Msg("Created backup for modified generated file")
 	return nil
 }
 
 // removeGeneratedFile removes a generated file and records the result
-func (u *Uninstaller) removeGeneratedFile(target string, result *UninstallResult, operation FileOperation) error {
+func (u *Uninstaller) removeGeneratedFile(target string, result *UninstallResult, operation FileOperation, log zerolog.Logger) error {
 	if err := u.fileOp.RemoveFile(target); err != nil {
 		result.FailedRemovals = append(result.FailedRemovals, OperationResult{
 			Type:     operation.Type,
@@ -355,42 +707,12 @@ func (u *Uninstaller) removeGeneratedFile(target string, result *UninstallResult
 			Metadata: map[string]interface{}{"reason": err.Error()},
 		})
 		result.Errors = append(result.Errors, fmt.Sprintf("failed to remove generated file %s: %v", target, err))
-		log := logger.GetLogger()
 		log.Error().Err(err).Str("target", target).Msg("Failed to remove generated file")
 		return err
 	}
 	return nil
 }
 
-// updateStateFile removes successfully uninstalled entries from the state file
-func (u *Uninstaller) updateStateFile(statePath string, stateFile *dotmanState.StateFile, result *UninstallResult, log zerolog.Logger) error {
-	if len(result.RemovedLinks) == 0 && len(result.RemovedGenerated) == 0 {
-		return nil
-	}
-
-	// Collect all removed targets
-	var removedTargets []string
-	for _, op := range result.RemovedLinks {
-		removedTargets = append(removedTargets, op.Target)
-	}
-	for _, op := range result.RemovedGenerated {
-		removedTargets = append(removedTargets, op.Target)
-	}
-
-	// Remove mappings from state file
-	if err := u.stateMgr.RemoveMappings(stateFile, removedTargets); err != nil {
-		return fmt.Errorf("failed to remove mappings from state: %w", err)
-	}
-
-	// Save the updated state file
-	if err := u.stateMgr.Save(statePath, stateFile); err != nil {
-		return fmt.Errorf("failed to save updated state file: %w", err)
-	}
-
-	log.Debug().Int("remaining_files", len(stateFile.Files)).Msg("Updated state file")
-	return nil
-}
-
 // generateSummary generates a summary of the uninstallation results
 func (u *Uninstaller) generateSummary(result *UninstallResult) {
 	totalRemoved := len(result.RemovedLinks) + len(result.RemovedGenerated)
@@ -407,4 +729,10 @@ func (u *Uninstaller) generateSummary(result *UninstallResult) {
 			totalSkipped, len(result.SkippedLinks), len(result.SkippedGenerated),
 			len(result.BackedUpGenerated), len(result.FailedRemovals))
 	}
+	if len(result.RemovedDirs) > 0 || len(result.SkippedDirs) > 0 {
+		result.Summary += fmt.Sprintf(", %d empty directories removed, %d kept", len(result.RemovedDirs), len(result.SkippedDirs))
+	}
+	if len(result.RemovedBlocks) > 0 || len(result.SkippedBlocks) > 0 {
+		result.Summary += fmt.Sprintf(", %d blocks removed, %d skipped", len(result.RemovedBlocks), len(result.SkippedBlocks))
+	}
 }
@@ -1,13 +1,16 @@
 package module
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	dotmanState "github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -152,9 +155,13 @@ func TestUninstaller_UninstallSymlinks(t *testing.T) {
 
 			// Call method
 			err := uninstaller.uninstallSymlinks(
+				context.Background(),
 				tt.stateFile,
 				symlinkMgr,
+				false,
 				result,
+				func(dotmanState.Event) {},
+				zerolog.Nop(),
 			)
 
 			// Check expectations
@@ -327,9 +334,13 @@ func TestUninstaller_UninstallGeneratedFiles(t *testing.T) {
 
 			// Call method
 			err := uninstaller.uninstallGeneratedFiles(
+				context.Background(),
 				tt.stateFile,
 				backupMgr,
 				result,
+				nil,
+				func(dotmanState.Event) {},
+				zerolog.Nop(),
 			)
 
 			// Check expectations
@@ -505,3 +516,374 @@ func TestUninstaller_Uninstall(t *testing.T) {
 		})
 	}
 }
+
+// TestUninstaller_VerifyOwner tests owner verification with an injected owner lookup
+func TestUninstaller_VerifyOwner(t *testing.T) {
+	tests := []struct {
+		name          string
+		verifyOwner   bool
+		linkOwner     func(path string) (int, bool, error)
+		expectRemoved bool
+		expectReason  string
+		expectCode    ReasonCode
+	}{
+		{
+			name:        "owned by current user",
+			verifyOwner: true,
+			linkOwner: func(path string) (int, bool, error) {
+				return 1000, true, nil
+			},
+			expectRemoved: true,
+		},
+		{
+			name:        "owned by another user",
+			verifyOwner: true,
+			linkOwner: func(path string) (int, bool, error) {
+				return 1001, true, nil
+			},
+			expectReason: "symlink is owned by uid 1001, not the current user (uid 1000)",
+			expectCode:   ReasonWrongOwner,
+		},
+		{
+			name:        "owner lookup fails",
+			verifyOwner: true,
+			linkOwner: func(path string) (int, bool, error) {
+				return 0, false, errors.New("permission denied")
+			},
+			expectReason: "failed to read symlink owner: permission denied",
+			expectCode:   ReasonCheckFailed,
+		},
+		{
+			name:        "owner unavailable on platform",
+			verifyOwner: true,
+			linkOwner: func(path string) (int, bool, error) {
+				return 0, false, nil
+			},
+			expectRemoved: true,
+		},
+		{
+			name:        "verification disabled",
+			verifyOwner: false,
+			linkOwner: func(path string) (int, bool, error) {
+				return 1001, true, nil
+			},
+			expectRemoved: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			source := filepath.Join(tempDir, "source")
+			target := filepath.Join(tempDir, "target")
+			require.NoError(t, os.WriteFile(source, []byte("content"), 0644))
+			require.NoError(t, os.Symlink(source, target))
+
+			stateFile := dotmanState.NewStateFile()
+			stateFile.AddFileMapping(source, target, dotmanState.TypeLink)
+
+			uninstaller := NewUninstaller(filesystem.NewOperator(), &MockStateManager{
+				LoadFunc: func(path string) (*dotmanState.StateFile, error) {
+					return stateFile, nil
+				},
+			})
+			uninstaller.linkOwner = tt.linkOwner
+			uninstaller.currentUID = func() int { return 1000 }
+
+			nop := zerolog.Nop()
+			result, err := uninstaller.Uninstall(&UninstallRequest{
+				DotfilesDir: tempDir,
+				VerifyOwner: tt.verifyOwner,
+				Logger:      &nop,
+			})
+			require.NoError(t, err)
+
+			if tt.expectRemoved {
+				assert.Len(t, result.RemovedLinks, 1)
+				assert.NoFileExists(t, target)
+				return
+			}
+
+			assert.Empty(t, result.RemovedLinks)
+			require.Len(t, result.SkippedLinks, 1)
+			assert.Equal(t, tt.expectReason, result.SkippedLinks[0].Metadata["reason"])
+			assert.Equal(t, tt.expectCode, result.SkippedLinks[0].ReasonCode)
+			_, err = os.Lstat(target)
+			assert.NoError(t, err, "symlink must be left in place")
+		})
+	}
+}
+
+func TestUninstaller_ReasonCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup creates the target of a tracked source and returns the state entry type
+		setup      func(t *testing.T, source, target string) string
+		expectCode ReasonCode
+	}{
+		{
+			name: "missing symlink",
+			setup: func(t *testing.T, source, target string) string {
+				return dotmanState.TypeLink
+			},
+			expectCode: ReasonMissing,
+		},
+		{
+			name: "symlink replaced by a file",
+			setup: func(t *testing.T, source, target string) string {
+				require.NoError(t, os.WriteFile(target, []byte("local"), 0644))
+				return dotmanState.TypeLink
+			},
+			expectCode: ReasonNotASymlink,
+		},
+		{
+			name: "symlink pointing elsewhere",
+			setup: func(t *testing.T, source, target string) string {
+				require.NoError(t, os.Symlink(filepath.Join(filepath.Dir(source), "other"), target))
+				return dotmanState.TypeLink
+			},
+			expectCode: ReasonWrongTarget,
+		},
+		{
+			name: "missing generated file",
+			setup: func(t *testing.T, source, target string) string {
+				return dotmanState.TypeGenerated
+			},
+			expectCode: ReasonMissing,
+		},
+		{
+			name: "generated file replaced by a directory",
+			setup: func(t *testing.T, source, target string) string {
+				require.NoError(t, os.Mkdir(target, 0755))
+				return dotmanState.TypeGenerated
+			},
+			expectCode: ReasonNotARegularFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			source := filepath.Join(tempDir, "source")
+			target := filepath.Join(tempDir, "target")
+			require.NoError(t, os.WriteFile(source, []byte("content"), 0644))
+			fileType := tt.setup(t, source, target)
+
+			stateFile := dotmanState.NewStateFile()
+			stateFile.AddFileMapping(source, target, fileType)
+			uninstaller := NewUninstaller(filesystem.NewOperator(), &MockStateManager{
+				LoadFunc: func(path string) (*dotmanState.StateFile, error) {
+					return stateFile, nil
+				},
+			})
+
+			nop := zerolog.Nop()
+			result, err := uninstaller.Uninstall(&UninstallRequest{DotfilesDir: tempDir, Logger: &nop})
+			require.NoError(t, err)
+
+			skipped := append(result.SkippedLinks, result.SkippedGenerated...)
+			require.Len(t, skipped, 1)
+			assert.Equal(t, tt.expectCode, skipped[0].ReasonCode)
+			assert.NotEmpty(t, skipped[0].Metadata["reason"])
+		})
+	}
+
+	t.Run("modified generated file is backed up", func(t *testing.T) {
+		tempDir := t.TempDir()
+		target := filepath.Join(tempDir, "target")
+		require.NoError(t, os.WriteFile(target, []byte("rendered"), 0644))
+		stateFile := dotmanState.NewStateFile()
+		stateFile.AddFileMapping(filepath.Join(tempDir, "source.dot-tmpl"), target, dotmanState.TypeGenerated)
+		require.NoError(t, os.WriteFile(target, []byte("edited"), 0644))
+
+		var events []dotmanState.Event
+		uninstaller := NewUninstaller(filesystem.NewOperator(), &MockStateManager{
+			LoadFunc: func(path string) (*dotmanState.StateFile, error) {
+				return stateFile, nil
+			},
+			AppendEventFunc: func(path string, stateFile *dotmanState.StateFile, event dotmanState.Event) error {
+				events = append(events, event)
+				return nil
+			},
+		})
+		nop := zerolog.Nop()
+		result, err := uninstaller.Uninstall(&UninstallRequest{DotfilesDir: tempDir, Logger: &nop})
+		require.NoError(t, err)
+
+		require.Len(t, result.BackedUpGenerated, 1)
+		assert.Equal(t, ReasonHashMismatch, result.BackedUpGenerated[0].ReasonCode)
+
+		// The edits survive in the backup, while the file is removed and no longer tracked
+		require.Len(t, result.RemovedGenerated, 1)
+		assert.NoFileExists(t, target)
+		backup, err := os.ReadFile(target + ".bak")
+		require.NoError(t, err)
+		assert.Equal(t, "edited", string(backup))
+		assert.Equal(t, []dotmanState.Event{dotmanState.RemoveEvent(target)}, events)
+	})
+}
+
+// cancellingRemoveOperator is the real file operator, cancelling a context after its first removal
+type cancellingRemoveOperator struct {
+	filesystem.FileOperator
+	cancel context.CancelFunc
+}
+
+func (o *cancellingRemoveOperator) RemoveFile(path string) error {
+	defer o.cancel()
+	return o.FileOperator.RemoveFile(path)
+}
+
+func TestUninstaller_UninstallCancelled(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	stateFile := dotmanState.NewStateFile()
+	for _, name := range []string{"a", "b", "c"} {
+		source := filepath.Join(dotfilesDir, name)
+		target := filepath.Join(targetDir, name)
+		require.NoError(t, os.WriteFile(source, []byte(name), 0644))
+		require.NoError(t, os.Symlink(source, target))
+		stateFile.AddFileMapping(source, target, dotmanState.TypeLink)
+	}
+	statePath := filepath.Join(dotfilesDir, "state.yaml")
+	require.NoError(t, dotmanState.SaveStateFile(statePath, stateFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	uninstaller := NewUninstaller(&cancellingRemoveOperator{FileOperator: filesystem.NewOperator(), cancel: cancel}, &stateManagerAdapter{})
+
+	result, err := uninstaller.Uninstall(&UninstallRequest{DotfilesDir: dotfilesDir, Context: ctx})
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, result)
+	assert.False(t, result.IsSuccess)
+	assert.Contains(t, result.Summary, "Uninstallation cancelled")
+	require.Len(t, result.RemovedLinks, 1)
+
+	// Only the first link was removed, and the state file still tracks the others
+	assert.NoFileExists(t, filepath.Join(targetDir, "a"))
+	for _, name := range []string{"b", "c"} {
+		_, err := os.Readlink(filepath.Join(targetDir, name))
+		assert.NoError(t, err, name)
+	}
+	saved, err := dotmanState.LoadStateFile(statePath)
+	require.NoError(t, err)
+	require.Len(t, saved.Files, 2)
+	assert.Equal(t, filepath.Join(targetDir, "b"), saved.Files[0].Target)
+}
+
+// TestUninstaller_UnknownType tests that entries of an unknown type are reported and kept tracked
+func TestUninstaller_UnknownType(t *testing.T) {
+	tempDir := t.TempDir()
+	dotfilesDir := filepath.Join(tempDir, "dotfiles")
+	targetDir := filepath.Join(tempDir, "home")
+	require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+	source := filepath.Join(dotfilesDir, "a")
+	target := filepath.Join(targetDir, "a")
+	require.NoError(t, os.WriteFile(source, []byte("a"), 0644))
+	require.NoError(t, os.Symlink(source, target))
+	stateFile := dotmanState.NewStateFile()
+	stateFile.AddFileMapping(source, target, dotmanState.TypeLink)
+	stateFile.AddFileMapping(filepath.Join(dotfilesDir, "b"), filepath.Join(targetDir, "b"), "symlink")
+	statePath := filepath.Join(dotfilesDir, "state.yaml")
+	require.NoError(t, dotmanState.SaveStateFile(statePath, stateFile))
+
+	uninstaller := NewUninstaller(filesystem.NewOperator(), &stateManagerAdapter{})
+	result, err := uninstaller.Uninstall(&UninstallRequest{DotfilesDir: dotfilesDir})
+	require.NoError(t, err)
+	assert.True(t, result.IsSuccess)
+	assert.Len(t, result.RemovedLinks, 1)
+	require.Len(t, result.Warnings, 1)
+	assert.Contains(t, result.Warnings[0], `unknown type "symlink"`)
+
+	// The unknown entry stays in the state file instead of being dropped
+	saved, err := dotmanState.LoadStateFile(statePath)
+	require.NoError(t, err)
+	require.Len(t, saved.Files, 1)
+	assert.Equal(t, "symlink", saved.Files[0].Type)
+}
+
+// TestUninstaller_Strict tests that strict mode fails on a skipped entry after the safe removals
+func TestUninstaller_Strict(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict %v", strict), func(t *testing.T) {
+			tempDir := t.TempDir()
+			dotfilesDir := filepath.Join(tempDir, "dotfiles")
+			targetDir := filepath.Join(tempDir, "home")
+			require.NoError(t, os.MkdirAll(dotfilesDir, 0755))
+			require.NoError(t, os.MkdirAll(targetDir, 0755))
+
+			stateFile := dotmanState.NewStateFile()
+			for _, name := range []string{"a", "b"} {
+				source := filepath.Join(dotfilesDir, name)
+				require.NoError(t, os.WriteFile(source, []byte(name), 0644))
+				stateFile.AddFileMapping(source, filepath.Join(targetDir, name), dotmanState.TypeLink)
+			}
+			require.NoError(t, os.Symlink(filepath.Join(dotfilesDir, "a"), filepath.Join(targetDir, "a")))
+			// b was replaced by a regular file, so its removal is skipped
+			require.NoError(t, os.WriteFile(filepath.Join(targetDir, "b"), []byte("edited"), 0644))
+			require.NoError(t, dotmanState.SaveStateFile(filepath.Join(dotfilesDir, "state.yaml"), stateFile))
+
+			uninstaller := NewUninstaller(filesystem.NewOperator(), &stateManagerAdapter{})
+			result, err := uninstaller.Uninstall(&UninstallRequest{DotfilesDir: dotfilesDir, Strict: strict})
+			require.NoError(t, err)
+			assert.Len(t, result.RemovedLinks, 1)
+			assert.Len(t, result.SkippedLinks, 1)
+			assert.NoFileExists(t, filepath.Join(targetDir, "a"))
+			assert.FileExists(t, filepath.Join(targetDir, "b"))
+
+			assert.Equal(t, !strict, result.IsSuccess)
+			if strict {
+				assert.Equal(t, []string{"strict mode: 1 entries were skipped or failed to be removed"}, result.Errors)
+				assert.Contains(t, result.Summary, "Uninstall completed with errors")
+			} else {
+				assert.Empty(t, result.Errors)
+			}
+		})
+	}
+}
+
+func TestUninstallResultOneLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		result UninstallResult
+		want   string
+	}{
+		{
+			name:   "empty result",
+			result: UninstallResult{IsSuccess: true},
+			want:   "dotman uninstall: removed=0 generated=0 skipped=0 errors=0 backups=0",
+		},
+		{
+			name: "skipped links and generated files are counted together",
+			result: UninstallResult{
+				IsSuccess:         true,
+				RemovedLinks:      make([]FileOperation, 4),
+				RemovedGenerated:  make([]FileOperation, 2),
+				SkippedLinks:      make([]OperationResult, 1),
+				SkippedGenerated:  make([]OperationResult, 2),
+				BackedUpGenerated: make([]OperationResult, 1),
+			},
+			want: "dotman uninstall: removed=4 generated=2 skipped=3 errors=0 backups=1",
+		},
+		{
+			name: "failed removals",
+			result: UninstallResult{
+				FailedRemovals: make([]OperationResult, 1),
+				Errors:         []string{"failed to remove symlink /home/user/.bashrc: permission denied"},
+			},
+			want: "dotman uninstall: removed=0 generated=0 skipped=0 errors=1 backups=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.result.OneLine())
+		})
+	}
+}
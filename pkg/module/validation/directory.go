@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 )
 
 // DirectoryValidator handles validation of directory structures
@@ -37,9 +38,10 @@ func (dv *DirectoryValidator) ValidateTargetDirectories(modules []config.ModuleC
 // validateDirectoryStructure validates that a directory and all its parents are directories, not symlinks
 func (dv *DirectoryValidator) validateDirectoryStructure(dir string, mkdir bool) error {
 	// Start from the target directory and go up to root
+	dir = filepath.Clean(dir)
 	current := dir
 	for {
-		if current == "" || current == "/" || current == "." {
+		if current == "" || current == "." || filesystem.IsRoot(current) {
 			break
 		}
 
@@ -65,8 +67,8 @@ func (dv *DirectoryValidator) validateDirectoryStructure(dir string, mkdir bool)
 			return fmt.Errorf("failed to stat %s: %w", current, err)
 		}
 
-		// Check if it's a symlink first
-		if info.Mode()&os.ModeSymlink != 0 {
+		// Check if it's a symlink (or Windows junction) first
+		if filesystem.IsLinkLike(info) {
 			return fmt.Errorf("path %s is a symlink, must be a regular directory", current)
 		}
 
@@ -3,6 +3,7 @@ package validation
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/elmhuangyu/dotman/pkg/config"
@@ -162,6 +163,26 @@ func TestDirectoryValidator_ValidateDirectory(t *testing.T) {
 		err := validator.ValidateDirectory(nonExistent, true)
 		assert.NoError(t, err) // Should not fail when mkdir is true
 	})
+
+	t.Run("filesystem root terminates the walk", func(t *testing.T) {
+		root := string(filepath.Separator)
+		if runtime.GOOS == "windows" {
+			root = filepath.VolumeName(tempDir) + `\`
+		}
+
+		err := validator.ValidateDirectory(root, false)
+		assert.NoError(t, err)
+	})
+
+	t.Run("windows drive root path", func(t *testing.T) {
+		if runtime.GOOS != "windows" {
+			t.Skip("drive roots only exist on windows")
+		}
+
+		dir := filepath.Join(filepath.VolumeName(tempDir)+`\`, "dotman-missing-target")
+		err := validator.ValidateDirectory(dir, true)
+		assert.NoError(t, err)
+	})
 }
 
 func TestDirectoryError(t *testing.T) {
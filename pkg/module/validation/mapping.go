@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"github.com/elmhuangyu/dotman/pkg/module/template"
 )
 
@@ -28,8 +29,8 @@ func (mv *MappingValidator) ValidateFileMapping(source, target string, isTemplat
 		return module.FileOperation{}, fmt.Errorf("source file does not exist: %s", source)
 	}
 
-	// Check source file info
-	sourceInfo, err := os.Lstat(source)
+	// Check source file info, following in-repo symlinks to what would be linked
+	sourceInfo, err := os.Stat(source)
 	if err != nil {
 		return module.FileOperation{}, fmt.Errorf("failed to stat source file %s: %w", source, err)
 	}
@@ -75,7 +76,7 @@ func (mv *MappingValidator) ValidateFileMapping(source, target string, isTemplat
 			Type:        module.OperationForceTemplate,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as file (template would overwrite)",
+			Description: fmt.Sprintf("target exists as %s (template would overwrite)", filesystem.DescribeFileType(targetInfo)),
 		}, nil
 	}
 
@@ -93,12 +94,17 @@ func (mv *MappingValidator) ValidateFileMapping(source, target string, isTemplat
 			return module.FileOperation{}, fmt.Errorf("failed to resolve absolute path for source %s: %w", source, err)
 		}
 
-		absCurrentTarget, err := filepath.Abs(currentTarget)
+		resolvedSource, err := filesystem.ResolveSource(source)
+		if err != nil {
+			return module.FileOperation{}, fmt.Errorf("failed to resolve source %s: %w", source, err)
+		}
+
+		absCurrentTarget, err := filesystem.LinkDestination(target, currentTarget)
 		if err != nil {
 			return module.FileOperation{}, fmt.Errorf("failed to resolve absolute path for current target %s: %w", currentTarget, err)
 		}
 
-		if absSource == absCurrentTarget {
+		if absCurrentTarget == resolvedSource || absCurrentTarget == absSource {
 			// Correct symlink already exists
 			return module.FileOperation{
 				Type:        module.OperationSkip,
@@ -106,6 +112,14 @@ func (mv *MappingValidator) ValidateFileMapping(source, target string, isTemplat
 				Target:      target,
 				Description: "correct symlink already exists",
 			}, nil
+		} else if filesystem.SameRealPath(absCurrentTarget, source) {
+			// A relative link, or one through a symlinked directory, to the same file is kept
+			return module.FileOperation{
+				Type:        module.OperationSkip,
+				Source:      source,
+				Target:      target,
+				Description: fmt.Sprintf("symlink to %s already resolves to the source", currentTarget),
+			}, nil
 		} else {
 			// Symlink exists but points to wrong file, treat as conflict
 			return module.FileOperation{
@@ -121,7 +135,7 @@ func (mv *MappingValidator) ValidateFileMapping(source, target string, isTemplat
 			Type:        module.OperationForceLink,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as regular file",
+			Description: fmt.Sprintf("target exists as %s", filesystem.DescribeFileType(targetInfo)),
 		}, nil
 	}
 }
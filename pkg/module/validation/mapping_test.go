@@ -45,6 +45,19 @@ func TestMappingValidator_ValidateFileMapping(t *testing.T) {
 		assert.Equal(t, module.OperationSkip, operation.Type)
 	})
 
+	t.Run("target exists as relative symlink to the source", func(t *testing.T) {
+		linkDir := filepath.Join(tempDir, "links")
+		require.NoError(t, os.MkdirAll(linkDir, 0755))
+		targetFile := filepath.Join(linkDir, "relative_link.txt")
+
+		// Resolved against the link's directory, not the working directory
+		require.NoError(t, os.Symlink(filepath.Join("..", "source.txt"), targetFile))
+
+		operation, err := validator.ValidateFileMapping(sourceFile, targetFile, false, map[string]string{})
+		require.NoError(t, err)
+		assert.Equal(t, module.OperationSkip, operation.Type)
+	})
+
 	t.Run("target exists as wrong symlink", func(t *testing.T) {
 		targetFile := filepath.Join(tempDir, "wrong_link.txt")
 		wrongSource := filepath.Join(tempDir, "wrong_source.txt")
@@ -87,7 +100,7 @@ func TestMappingValidator_ValidateFileMapping(t *testing.T) {
 		operation, err := validator.ValidateFileMapping(sourceFile, targetFile, true, map[string]string{})
 		require.NoError(t, err)
 		assert.Equal(t, module.OperationForceTemplate, operation.Type)
-		assert.Equal(t, "target exists as file (template would overwrite)", operation.Description)
+		assert.Equal(t, "target exists as regular file (template would overwrite)", operation.Description)
 	})
 
 	t.Run("source file does not exist", func(t *testing.T) {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/elmhuangyu/dotman/pkg/config"
 	"github.com/elmhuangyu/dotman/pkg/module"
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"github.com/elmhuangyu/dotman/pkg/module/template"
 )
 
@@ -55,6 +56,20 @@ func (v *Validator) ValidateInstallation(modules []config.ModuleConfig, vars map
 		result.Operations = append(result.Operations, operation)
 	}
 
+	// Extra links are plain links of an already mapped source
+	for source, targets := range mapping.GetExtraLinks() {
+		for _, target := range targets {
+			operation, err := v.validateFileMapping(source, target, false, vars)
+			if err != nil {
+				result.IsValid = false
+				result.Errors = append(result.Errors, fmt.Sprintf("validation error for %s -> %s: %v", source, target, err))
+				continue
+			}
+
+			result.Operations = append(result.Operations, operation)
+		}
+	}
+
 	return result, nil
 }
 
@@ -65,8 +80,8 @@ func (v *Validator) validateFileMapping(source, target string, isTemplate bool,
 		return module.FileOperation{}, fmt.Errorf("source file does not exist: %s", source)
 	}
 
-	// Check source file info
-	sourceInfo, err := os.Lstat(source)
+	// Check source file info, following in-repo symlinks to what would be linked
+	sourceInfo, err := os.Stat(source)
 	if err != nil {
 		return module.FileOperation{}, fmt.Errorf("failed to stat source file %s: %w", source, err)
 	}
@@ -112,7 +127,7 @@ func (v *Validator) validateFileMapping(source, target string, isTemplate bool,
 			Type:        module.OperationForceTemplate,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as file (template would overwrite)",
+			Description: fmt.Sprintf("target exists as %s (template would overwrite)", filesystem.DescribeFileType(targetInfo)),
 		}, nil
 	}
 
@@ -130,12 +145,17 @@ func (v *Validator) validateFileMapping(source, target string, isTemplate bool,
 			return module.FileOperation{}, fmt.Errorf("failed to resolve absolute path for source %s: %w", source, err)
 		}
 
-		absCurrentTarget, err := filepath.Abs(currentTarget)
+		resolvedSource, err := filesystem.ResolveSource(source)
+		if err != nil {
+			return module.FileOperation{}, fmt.Errorf("failed to resolve source %s: %w", source, err)
+		}
+
+		absCurrentTarget, err := filesystem.LinkDestination(target, currentTarget)
 		if err != nil {
 			return module.FileOperation{}, fmt.Errorf("failed to resolve absolute path for current target %s: %w", currentTarget, err)
 		}
 
-		if absSource == absCurrentTarget {
+		if absCurrentTarget == resolvedSource || absCurrentTarget == absSource {
 			// Correct symlink already exists
 			return module.FileOperation{
 				Type:        module.OperationSkip,
@@ -143,6 +163,14 @@ func (v *Validator) validateFileMapping(source, target string, isTemplate bool,
 				Target:      target,
 				Description: "correct symlink already exists",
 			}, nil
+		} else if filesystem.SameRealPath(absCurrentTarget, source) {
+			// A relative link, or one through a symlinked directory, to the same file is kept
+			return module.FileOperation{
+				Type:        module.OperationSkip,
+				Source:      source,
+				Target:      target,
+				Description: fmt.Sprintf("symlink to %s already resolves to the source", currentTarget),
+			}, nil
 		} else {
 			// Symlink exists but points to wrong file, treat as conflict
 			return module.FileOperation{
@@ -158,7 +186,7 @@ func (v *Validator) validateFileMapping(source, target string, isTemplate bool,
 			Type:        module.OperationForceLink,
 			Source:      source,
 			Target:      target,
-			Description: "target exists as regular file",
+			Description: fmt.Sprintf("target exists as %s", filesystem.DescribeFileType(targetInfo)),
 		}, nil
 	}
 }
@@ -180,9 +208,10 @@ func (v *Validator) ValidateTargetDirectories(modules []config.ModuleConfig, mkd
 // validateDirectoryStructure validates that a directory and all its parents are directories, not symlinks
 func (v *Validator) validateDirectoryStructure(dir string, mkdir bool) error {
 	// Start from the target directory and go up to root
+	dir = filepath.Clean(dir)
 	current := dir
 	for {
-		if current == "" || current == "/" || current == "." {
+		if current == "" || current == "." || filesystem.IsRoot(current) {
 			break
 		}
 
@@ -208,8 +237,8 @@ func (v *Validator) validateDirectoryStructure(dir string, mkdir bool) error {
 			return fmt.Errorf("failed to stat %s: %w", current, err)
 		}
 
-		// Check if it's a symlink first
-		if info.Mode()&os.ModeSymlink != 0 {
+		// Check if it's a symlink (or Windows junction) first
+		if filesystem.IsLinkLike(info) {
 			return fmt.Errorf("path %s is a symlink, must be a regular directory", current)
 		}
 
@@ -0,0 +1,120 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scpPattern matches scp-like git URLs such as git@github.com:me/dotfiles.git
+var scpPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/]`)
+
+// Cloner fetches git repositories
+type Cloner interface {
+	// Clone clones url into dir, which does not exist yet
+	Clone(ctx context.Context, url, dir string) error
+	// Pull updates the clone in dir
+	Pull(ctx context.Context, dir string) error
+}
+
+// GitCloner is a Cloner that runs the git command
+type GitCloner struct{}
+
+// Clone runs git clone
+func (GitCloner) Clone(ctx context.Context, url, dir string) error {
+	return runGit(ctx, "", "clone", "--", url, dir)
+}
+
+// Pull runs git pull --ff-only in dir
+func (GitCloner) Pull(ctx context.Context, dir string) error {
+	return runGit(ctx, dir, "pull", "--ff-only")
+}
+
+// runGit runs git with args in dir and reports failures with git's stderr
+func runGit(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, message)
+		}
+		return fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// IsGitURL reports whether location is a git URL rather than a local path
+func IsGitURL(location string) bool {
+	for _, prefix := range []string{"git://", "ssh://", "git+ssh://", "https://", "http://", "file://"} {
+		if strings.HasPrefix(location, prefix) {
+			return true
+		}
+	}
+	return scpPattern.MatchString(location)
+}
+
+// CacheDir returns the directory remote dotfiles repositories are cloned into:
+// $XDG_CACHE_HOME/dotman, or ~/.cache/dotman when XDG_CACHE_HOME is unset
+func CacheDir() (string, error) {
+	if cacheHome := os.Getenv("XDG_CACHE_HOME"); cacheHome != "" {
+		return filepath.Join(cacheHome, "dotman"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %w", err)
+	}
+	return filepath.Join(home, ".cache", "dotman"), nil
+}
+
+// Resolver turns git URLs into local clones
+type Resolver struct {
+	Cloner   Cloner
+	CacheDir string
+}
+
+// NewResolver creates a Resolver that runs git and clones into CacheDir
+func NewResolver() (*Resolver, error) {
+	cacheDir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{Cloner: GitCloner{}, CacheDir: cacheDir}, nil
+}
+
+// CloneDir returns the directory url is cloned into, named after a hash of url
+func (r *Resolver) CloneDir(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:])[:16])
+}
+
+// Resolve clones url into the cache, or pulls it if it was cloned before, and returns the clone
+func (r *Resolver) Resolve(ctx context.Context, url string) (string, error) {
+	dir := r.CloneDir(url)
+	if _, err := os.Stat(dir); err == nil {
+		if err := r.Cloner.Pull(ctx, dir); err != nil {
+			return "", fmt.Errorf("failed to update %s in %s: %w", url, dir, err)
+		}
+		return dir, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to check clone directory: %w", err)
+	}
+
+	if err := os.MkdirAll(r.CacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := r.Cloner.Clone(ctx, url, dir); err != nil {
+		// Don't mistake a partial clone for a complete one next time
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to clone %s: %w", url, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,110 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloner populates clone directories instead of running git
+type fakeCloner struct {
+	clones   []string
+	pulls    []string
+	cloneErr error
+}
+
+func (f *fakeCloner) Clone(ctx context.Context, url, dir string) error {
+	f.clones = append(f.clones, url)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if f.cloneErr != nil {
+		return f.cloneErr
+	}
+	return os.WriteFile(filepath.Join(dir, "DotRoot"), []byte(""), 0644)
+}
+
+func (f *fakeCloner) Pull(ctx context.Context, dir string) error {
+	f.pulls = append(f.pulls, dir)
+	return nil
+}
+
+func TestIsGitURL(t *testing.T) {
+	tests := []struct {
+		location string
+		expected bool
+	}{
+		{"git@github.com:me/dotfiles.git", true},
+		{"https://github.com/me/dotfiles.git", true},
+		{"ssh://git@example.com/dotfiles", true},
+		{"git://example.com/dotfiles", true},
+		{"file:///srv/dotfiles.git", true},
+		{"/home/me/dotfiles", false},
+		{"dotfiles", false},
+		{"./my:dotfiles", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsGitURL(tt.location))
+		})
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	dir, err := CacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheHome, "dotman"), dir)
+
+	home := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("HOME", home)
+	dir, err = CacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".cache", "dotman"), dir)
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	const url = "git@github.com:me/dotfiles.git"
+
+	t.Run("clones then pulls", func(t *testing.T) {
+		cloner := &fakeCloner{}
+		resolver := &Resolver{Cloner: cloner, CacheDir: filepath.Join(t.TempDir(), "dotman")}
+
+		dir, err := resolver.Resolve(context.Background(), url)
+		require.NoError(t, err)
+		assert.Equal(t, resolver.CloneDir(url), dir)
+		assert.FileExists(t, filepath.Join(dir, "DotRoot"))
+		assert.Equal(t, []string{url}, cloner.clones)
+		assert.Empty(t, cloner.pulls)
+
+		again, err := resolver.Resolve(context.Background(), url)
+		require.NoError(t, err)
+		assert.Equal(t, dir, again)
+		assert.Len(t, cloner.clones, 1)
+		assert.Equal(t, []string{dir}, cloner.pulls)
+	})
+
+	t.Run("different URLs get different clones", func(t *testing.T) {
+		resolver := &Resolver{Cloner: &fakeCloner{}, CacheDir: t.TempDir()}
+		assert.NotEqual(t, resolver.CloneDir(url), resolver.CloneDir("https://github.com/me/dotfiles.git"))
+	})
+
+	t.Run("failed clone is removed", func(t *testing.T) {
+		cloner := &fakeCloner{cloneErr: errors.New("permission denied")}
+		resolver := &Resolver{Cloner: cloner, CacheDir: t.TempDir()}
+
+		_, err := resolver.Resolve(context.Background(), url)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to clone")
+		assert.NoDirExists(t, resolver.CloneDir(url))
+	})
+}
@@ -0,0 +1,155 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HashCacheFileName is the name of the hash cache stored next to the state file
+const HashCacheFileName = ".dotman-cache.yaml"
+
+// racyWindow is how long after a hash is computed a file's mtime must stay older than
+// before the cached hash is trusted. Writes landing in the same mtime tick as the hash
+// would otherwise go unnoticed.
+const racyWindow = 2 * time.Second
+
+// HashCacheEntry records the SHA1 of a file along with the stat data it was computed from
+type HashCacheEntry struct {
+	Size     int64     `yaml:"size"`
+	ModTime  time.Time `yaml:"mod_time"`
+	SHA1     string    `yaml:"sha1"`
+	HashedAt time.Time `yaml:"hashed_at"`
+}
+
+// HashCache maps absolute file paths to their last computed SHA1.
+// A nil *HashCache is valid and always recomputes hashes.
+type HashCache struct {
+	Entries map[string]HashCacheEntry `yaml:"entries"`
+}
+
+// NewHashCache creates an empty hash cache
+func NewHashCache() *HashCache {
+	return &HashCache{
+		Entries: make(map[string]HashCacheEntry),
+	}
+}
+
+// LoadHashCache loads a hash cache from path, returning an empty cache if the file does not exist
+func LoadHashCache(path string) (*HashCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewHashCache(), nil
+		}
+		return nil, fmt.Errorf("failed to read hash cache: %w", err)
+	}
+
+	return ParseHashCache(data)
+}
+
+// ParseHashCache parses a hash cache from its YAML encoding; empty data yields an empty cache
+func ParseHashCache(data []byte) (*HashCache, error) {
+	cache := NewHashCache()
+	if err := yaml.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("failed to parse hash cache: %w", err)
+	}
+	if cache.Entries == nil {
+		cache.Entries = make(map[string]HashCacheEntry)
+	}
+
+	return cache, nil
+}
+
+// SaveHashCache writes the hash cache to path
+func SaveHashCache(path string, cache *HashCache) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create hash cache directory: %w", err)
+	}
+
+	data, err := cache.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+
+	return nil
+}
+
+// Marshal returns the YAML encoding of the hash cache
+func (c *HashCache) Marshal() ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal hash cache: %w", err)
+	}
+	return data, nil
+}
+
+// SHA1 returns the SHA1 of the file at path, reusing the cached hash when the
+// file's size and mtime are unchanged since it was last computed
+func (c *HashCache) SHA1(path string) (string, error) {
+	if c == nil {
+		return calculateSHA1(path)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for %s: %w", path, err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		delete(c.Entries, absPath)
+		return "", fmt.Errorf("failed to stat %s: %w", absPath, err)
+	}
+
+	if entry, ok := c.Entries[absPath]; ok && entry.matches(info) {
+		return entry.SHA1, nil
+	}
+
+	sha1, err := calculateSHA1(absPath)
+	if err != nil {
+		delete(c.Entries, absPath)
+		return "", err
+	}
+
+	hashedAt := time.Now()
+	// Files modified in the future (clock skew) or within the racy window are
+	// never cached, since a later write could keep the same size and mtime
+	if info.ModTime().After(hashedAt.Add(-racyWindow)) {
+		delete(c.Entries, absPath)
+		return sha1, nil
+	}
+
+	c.Entries[absPath] = HashCacheEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		SHA1:     sha1,
+		HashedAt: hashedAt,
+	}
+
+	return sha1, nil
+}
+
+// Prune drops entries for files that no longer exist
+func (c *HashCache) Prune() {
+	if c == nil {
+		return
+	}
+	for path := range c.Entries {
+		if _, err := os.Stat(path); err != nil {
+			delete(c.Entries, path)
+		}
+	}
+}
+
+// matches reports whether the entry was computed from a file with the same stat data
+func (e HashCacheEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
@@ -0,0 +1,159 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashCache(t *testing.T) {
+	// writeFile writes content and backdates its mtime out of the racy window
+	writeFile := func(t *testing.T, path, content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	t.Run("cache hit reuses hash when size and mtime are unchanged", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		writeFile(t, path, "aaaa", modTime)
+
+		cache := NewHashCache()
+		first, err := cache.SHA1(path)
+		require.NoError(t, err)
+		expected, err := calculateSHA1(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, first)
+		require.Contains(t, cache.Entries, path)
+
+		// Same size and mtime but different content: only a cache hit returns the old hash
+		writeFile(t, path, "bbbb", modTime)
+		second, err := cache.SHA1(path)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("modification invalidates the entry", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		writeFile(t, path, "aaaa", modTime)
+
+		cache := NewHashCache()
+		first, err := cache.SHA1(path)
+		require.NoError(t, err)
+
+		tests := []struct {
+			name    string
+			content string
+			modTime time.Time
+		}{
+			{"mtime change", "bbbb", modTime.Add(time.Minute)},
+			{"size change", "ccccc", modTime.Add(time.Minute)},
+		}
+		for _, tt := range tests {
+			writeFile(t, path, tt.content, tt.modTime)
+			got, err := cache.SHA1(path)
+			require.NoError(t, err, tt.name)
+			expected, err := calculateSHA1(path)
+			require.NoError(t, err, tt.name)
+			assert.Equal(t, expected, got, tt.name)
+			assert.NotEqual(t, first, got, tt.name)
+		}
+	})
+
+	t.Run("recently modified files are not cached", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		writeFile(t, path, "aaaa", time.Now())
+
+		cache := NewHashCache()
+		_, err := cache.SHA1(path)
+		require.NoError(t, err)
+		assert.NotContains(t, cache.Entries, path)
+	})
+
+	t.Run("future mtimes from clock skew are not cached", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		writeFile(t, path, "aaaa", time.Now().Add(time.Hour))
+
+		cache := NewHashCache()
+		_, err := cache.SHA1(path)
+		require.NoError(t, err)
+		assert.NotContains(t, cache.Entries, path)
+	})
+
+	t.Run("missing file drops the entry", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		writeFile(t, path, "aaaa", time.Now().Add(-time.Hour))
+
+		cache := NewHashCache()
+		_, err := cache.SHA1(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(path))
+
+		_, err = cache.SHA1(path)
+		assert.Error(t, err)
+		assert.NotContains(t, cache.Entries, path)
+	})
+
+	t.Run("nil cache always hashes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		writeFile(t, path, "aaaa", time.Now().Add(-time.Hour))
+
+		var cache *HashCache
+		got, err := cache.SHA1(path)
+		require.NoError(t, err)
+		expected, err := calculateSHA1(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+		cache.Prune()
+	})
+}
+
+func TestLoadSaveHashCache(t *testing.T) {
+	tempDir := t.TempDir()
+	cachePath := filepath.Join(tempDir, HashCacheFileName)
+
+	t.Run("missing cache file loads empty", func(t *testing.T) {
+		cache, err := LoadHashCache(cachePath)
+		require.NoError(t, err)
+		assert.Empty(t, cache.Entries)
+	})
+
+	t.Run("round trip and prune", func(t *testing.T) {
+		kept := filepath.Join(tempDir, "kept")
+		gone := filepath.Join(tempDir, "gone")
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		for _, path := range []string{kept, gone} {
+			require.NoError(t, os.WriteFile(path, []byte(path), 0644))
+			require.NoError(t, os.Chtimes(path, modTime, modTime))
+		}
+
+		cache := NewHashCache()
+		for _, path := range []string{kept, gone} {
+			_, err := cache.SHA1(path)
+			require.NoError(t, err)
+		}
+		require.NoError(t, os.Remove(gone))
+		cache.Prune()
+		require.NoError(t, SaveHashCache(cachePath, cache))
+
+		loaded, err := LoadHashCache(cachePath)
+		require.NoError(t, err)
+		require.Len(t, loaded.Entries, 1)
+		assert.Equal(t, cache.Entries[kept].SHA1, loaded.Entries[kept].SHA1)
+		assert.True(t, cache.Entries[kept].ModTime.Equal(loaded.Entries[kept].ModTime))
+	})
+
+	t.Run("invalid cache file", func(t *testing.T) {
+		badPath := filepath.Join(tempDir, "bad.yaml")
+		require.NoError(t, os.WriteFile(badPath, []byte("entries: [unclosed"), 0644))
+
+		_, err := LoadHashCache(badPath)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse hash cache")
+	})
+}
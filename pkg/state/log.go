@@ -0,0 +1,226 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// EventAdd puts Entry in the state file, replacing the entry of the same target and block
+	EventAdd = "add"
+	// EventRemove removes every entry of Target from the state file
+	EventRemove = "remove"
+	// EventRemoveBlock removes the entry of Block in the shared file Target, keeping its other blocks
+	EventRemoveBlock = "remove_block"
+)
+
+// Event is a change of the state file appended to its log instead of rewriting the whole file
+type Event struct {
+	Op     string       `json:"op"`
+	Entry  *FileMapping `json:"entry,omitempty"`
+	Target string       `json:"target,omitempty"`
+	Block  string       `json:"block,omitempty"`
+}
+
+// AddEvent returns the event that records entry in the state file
+func AddEvent(entry FileMapping) Event {
+	return Event{Op: EventAdd, Entry: &entry}
+}
+
+// RemoveEvent returns the event that removes the entries of target from the state file
+func RemoveEvent(target string) Event {
+	return Event{Op: EventRemove, Target: target}
+}
+
+// RemoveBlockEvent returns the event that removes the entry of block in the shared file target
+func RemoveBlockEvent(target, block string) Event {
+	return Event{Op: EventRemoveBlock, Target: target, Block: block}
+}
+
+// logHeader is the first line of a log; the log only applies to the snapshot of its generation,
+// so a log left behind by an interrupted compaction is never replayed over the newer snapshot
+type logHeader struct {
+	Generation int `json:"generation"`
+}
+
+// LogPath returns the path of the log of the state file at statePath: state.log for state.yaml
+func LogPath(statePath string) string {
+	return strings.TrimSuffix(statePath, filepath.Ext(statePath)) + ".log"
+}
+
+// AppendEvent appends event to the log of the state file at path and syncs it to disk. The log is
+// started over when it belongs to another generation than stateFile. Paths are logged absolute,
+// also for a Relative state file, and made portable again on compaction.
+func AppendEvent(path string, stateFile *StateFile, event Event) error {
+	if err := event.validate(); err != nil {
+		return err
+	}
+
+	logPath := LogPath(path)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	generation, err := readLogGeneration(logPath)
+	fresh := err != nil || generation != stateFile.Generation
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	if fresh {
+		if err := encoder.Encode(logHeader{Generation: stateFile.Generation}); err != nil {
+			return fmt.Errorf("failed to encode state log header: %w", err)
+		}
+	}
+	if err := encoder.Encode(event); err != nil {
+		return fmt.Errorf("failed to encode state log event: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	file, err := os.OpenFile(logPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open state log: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to append to state log: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync state log: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close state log: %w", err)
+	}
+	return nil
+}
+
+// CompactStateFile folds the log of the state file at path into a new snapshot and removes the
+// log; nothing is written when there is no log
+func CompactStateFile(path string) error {
+	if _, err := os.Stat(LogPath(path)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat state log: %w", err)
+	}
+	stateFile, err := LoadStateFile(path)
+	if err != nil {
+		return err
+	}
+	return SaveStateFile(path, stateFile)
+}
+
+// readLogGeneration returns the generation in the header of the log at logPath
+func readLogGeneration(logPath string) (int, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return 0, err
+	}
+	var header logHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return 0, err
+	}
+	return header.Generation, nil
+}
+
+// replayLog applies the events of the log at logPath to stateFile when the log belongs to its
+// generation. A torn last line, left by a crash in the middle of an append, is dropped; any
+// other unreadable line fails the replay.
+func replayLog(logPath string, stateFile *StateFile) error {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state log: %w", err)
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	var header logHeader
+	if err := json.Unmarshal(lines[0], &header); err != nil || !bytes.HasSuffix(lines[0], []byte("\n")) {
+		// Torn while starting the log, so it holds no complete event
+		return nil
+	}
+	if header.Generation != stateFile.Generation {
+		return nil
+	}
+
+	for n, line := range lines[1:] {
+		if len(line) == 0 {
+			continue
+		}
+		last := n == len(lines)-2
+		var event Event
+		err := json.Unmarshal(line, &event)
+		if err == nil {
+			err = event.validate()
+		}
+		if last && (err != nil || !bytes.HasSuffix(line, []byte("\n"))) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt state log line %d: %w", n+2, err)
+		}
+		stateFile.apply(event)
+	}
+	return nil
+}
+
+// validate checks that the event has the fields its operation needs
+func (e Event) validate() error {
+	switch e.Op {
+	case EventAdd:
+		if e.Entry == nil || e.Entry.Target == "" {
+			return errors.New("add event without an entry")
+		}
+	case EventRemove:
+		if e.Target == "" {
+			return errors.New("remove event without a target")
+		}
+	case EventRemoveBlock:
+		if e.Target == "" || e.Block == "" {
+			return errors.New("remove_block event without a target or block")
+		}
+	default:
+		return fmt.Errorf("unknown state log operation %q", e.Op)
+	}
+	return nil
+}
+
+// apply changes the state file by a validated event
+func (sf *StateFile) apply(event Event) {
+	switch event.Op {
+	case EventAdd:
+		for i, mapping := range sf.Files {
+			if mapping.Target == event.Entry.Target && mapping.Block == event.Entry.Block {
+				sf.Files[i] = *event.Entry
+				return
+			}
+		}
+		sf.Files = append(sf.Files, *event.Entry)
+	case EventRemove:
+		remainingFiles := sf.Files[:0]
+		for _, mapping := range sf.Files {
+			if mapping.Target != event.Target {
+				remainingFiles = append(remainingFiles, mapping)
+			}
+		}
+		sf.Files = remainingFiles
+	case EventRemoveBlock:
+		sf.RemoveBlock(event.Target, event.Block)
+	}
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPath(t *testing.T) {
+	assert.Equal(t, "/dots/state.log", LogPath("/dots/state.yaml"))
+	assert.Equal(t, "/dots/state.work.log", LogPath("/dots/state.work.yaml"))
+}
+
+func TestStateLog(t *testing.T) {
+	link := FileMapping{Source: "/dots/vim/vimrc", Target: "/home/.vimrc", Type: TypeLink}
+	generated := FileMapping{Source: "/dots/git/gitconfig.tmpl", Target: "/home/.gitconfig", Type: TypeGenerated, SHA1: "abc"}
+
+	setup := func(t *testing.T) (string, *StateFile) {
+		statePath := filepath.Join(t.TempDir(), "state.yaml")
+		stateFile := NewStateFile()
+		stateFile.Files = append(stateFile.Files, link)
+		require.NoError(t, SaveStateFile(statePath, stateFile))
+		return statePath, stateFile
+	}
+
+	t.Run("replays events over the snapshot", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(generated)))
+		updated := generated
+		updated.SHA1 = "def"
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(updated)))
+		require.NoError(t, AppendEvent(statePath, stateFile, RemoveEvent(link.Target)))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{updated}, loaded.Files)
+	})
+
+	t.Run("removes a single block of a shared file", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+		zsh := FileMapping{Source: "/dots/zsh/rc", Target: "/home/.profile", Type: TypeBlock, Block: "zsh"}
+		bash := FileMapping{Source: "/dots/bash/rc", Target: "/home/.profile", Type: TypeBlock, Block: "bash"}
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(zsh)))
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(bash)))
+		require.NoError(t, AppendEvent(statePath, stateFile, RemoveBlockEvent(zsh.Target, zsh.Block)))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{link, bash}, loaded.Files)
+	})
+
+	t.Run("replays a log without a snapshot", func(t *testing.T) {
+		statePath := filepath.Join(t.TempDir(), "state.yaml")
+		require.NoError(t, AppendEvent(statePath, NewStateFile(), AddEvent(link)))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		require.NotNil(t, loaded)
+		assert.Equal(t, []FileMapping{link}, loaded.Files)
+	})
+
+	t.Run("compaction folds the log into the snapshot", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(generated)))
+
+		require.NoError(t, CompactStateFile(statePath))
+		_, err := os.Stat(LogPath(statePath))
+		assert.True(t, os.IsNotExist(err))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, loaded.Generation)
+		assert.Equal(t, []FileMapping{link, generated}, loaded.Files)
+
+		// Compacting without a log leaves the snapshot alone
+		require.NoError(t, CompactStateFile(statePath))
+		loaded, err = LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, 1, loaded.Generation)
+	})
+
+	t.Run("ignores a log of an older generation", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+		require.NoError(t, AppendEvent(statePath, stateFile, RemoveEvent(link.Target)))
+		stale, err := os.ReadFile(LogPath(statePath))
+		require.NoError(t, err)
+
+		require.NoError(t, SaveStateFile(statePath, stateFile))
+		// A log left behind by a save interrupted before removing it
+		require.NoError(t, os.WriteFile(LogPath(statePath), stale, 0644))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{link}, loaded.Files)
+
+		// The next append starts the log over for the current generation
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(generated)))
+		loaded, err = LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{link, generated}, loaded.Files)
+	})
+
+	t.Run("recovers from a truncated log", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+		require.NoError(t, AppendEvent(statePath, stateFile, AddEvent(generated)))
+		require.NoError(t, AppendEvent(statePath, stateFile, RemoveEvent(link.Target)))
+
+		// Cut the last event in half, as a crash in the middle of an append would
+		data, err := os.ReadFile(LogPath(statePath))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(LogPath(statePath), data[:len(data)-10], 0644))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{link, generated}, loaded.Files)
+	})
+
+	t.Run("recovers from a log torn in its header", func(t *testing.T) {
+		statePath, _ := setup(t)
+		require.NoError(t, os.WriteFile(LogPath(statePath), []byte(`{"genera`), 0644))
+
+		loaded, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		assert.Equal(t, []FileMapping{link}, loaded.Files)
+	})
+
+	t.Run("fails on a corrupt event before the end of the log", func(t *testing.T) {
+		statePath, _ := setup(t)
+		log := "{\"generation\":0}\nnot json\n{\"op\":\"remove\",\"target\":\"/home/.vimrc\"}\n"
+		require.NoError(t, os.WriteFile(LogPath(statePath), []byte(log), 0644))
+
+		_, err := LoadStateFile(statePath)
+		assert.ErrorContains(t, err, "corrupt state log line 2")
+	})
+
+	t.Run("rejects invalid events", func(t *testing.T) {
+		statePath, stateFile := setup(t)
+		assert.Error(t, AppendEvent(statePath, stateFile, Event{Op: "rename"}))
+		assert.Error(t, AppendEvent(statePath, stateFile, Event{Op: EventRemove}))
+		assert.Error(t, AppendEvent(statePath, stateFile, Event{Op: EventRemoveBlock, Target: link.Target}))
+		_, err := os.Stat(LogPath(statePath))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultFileName is the state file of installations without a profile
+const DefaultFileName = "state.yaml"
+
+// profilePattern restricts profile names to ones that are safe in a file name
+var profilePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidateProfile checks that profile can be used in a state file name; empty is the default profile
+func ValidateProfile(profile string) error {
+	if profile != "" && !profilePattern.MatchString(profile) {
+		return fmt.Errorf("profile %q must start with a letter or digit and only contain letters, digits, '-' and '_'", profile)
+	}
+	return nil
+}
+
+// FileName returns the state file name of profile: state.yaml without a profile,
+// state.<profile>.yaml otherwise
+func FileName(profile string) string {
+	if profile == "" {
+		return DefaultFileName
+	}
+	return "state." + profile + ".yaml"
+}
+
+// Path returns the path of the state file of profile in dotfilesDir
+func Path(dotfilesDir, profile string) string {
+	return filepath.Join(dotfilesDir, FileName(profile))
+}
+
+// ProfilePaths returns the existing state files in dotfilesDir keyed by profile, with ""
+// for the default profile
+func ProfilePaths(dotfilesDir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dotfilesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read dotfiles directory: %w", err)
+	}
+
+	paths := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if profile, ok := ProfileOfFileName(entry.Name()); ok {
+			paths[profile] = filepath.Join(dotfilesDir, entry.Name())
+		}
+	}
+	return paths, nil
+}
+
+// IsReservedFileName reports whether name is one of the files dotman writes next to its
+// configuration: a state file, the log of one, or the hash cache
+func IsReservedFileName(name string) bool {
+	if name == HashCacheFileName {
+		return true
+	}
+	if base, ok := strings.CutSuffix(name, ".log"); ok {
+		name = base + ".yaml"
+	}
+	_, ok := ProfileOfFileName(name)
+	return ok
+}
+
+// ProfileOfFileName returns the profile whose state file is named name, "" for state.yaml;
+// ok is false when name is not a state file name
+func ProfileOfFileName(name string) (string, bool) {
+	if name == DefaultFileName {
+		return "", true
+	}
+	profile, ok := strings.CutPrefix(name, "state.")
+	if !ok {
+		return "", false
+	}
+	profile, ok = strings.CutSuffix(profile, ".yaml")
+	if !ok || !profilePattern.MatchString(profile) {
+		return "", false
+	}
+	return profile, true
+}
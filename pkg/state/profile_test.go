@@ -0,0 +1,69 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileName(t *testing.T) {
+	assert.Equal(t, "state.yaml", FileName(""))
+	assert.Equal(t, "state.work.yaml", FileName("work"))
+	assert.Equal(t, filepath.Join("/dotfiles", "state.home.yaml"), Path("/dotfiles", "home"))
+}
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		profile string
+		wantErr bool
+	}{
+		{profile: ""},
+		{profile: "work"},
+		{profile: "laptop-2_personal"},
+		{profile: "../work", wantErr: true},
+		{profile: "work.old", wantErr: true},
+		{profile: "-work", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			err := ValidateProfile(tt.profile)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIsReservedFileName(t *testing.T) {
+	for _, name := range []string{"state.yaml", "state.work.yaml", "state.log", "state.work.log", HashCacheFileName} {
+		assert.True(t, IsReservedFileName(name), name)
+	}
+	for _, name := range []string{"state.yml", "state.bad.name.log", "app.log", "state.yaml.example", ".dotman-cache.log"} {
+		assert.False(t, IsReservedFileName(name), name)
+	}
+}
+
+func TestProfilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"state.yaml", "state.work.yaml", "state.home.yaml", "state.bad.name.yaml", "other.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("files: []"), 0644))
+	}
+
+	paths, err := ProfilePaths(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"":     filepath.Join(dir, "state.yaml"),
+		"work": filepath.Join(dir, "state.work.yaml"),
+		"home": filepath.Join(dir, "state.home.yaml"),
+	}, paths)
+
+	paths, err = ProfilePaths(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+}
@@ -1,12 +1,13 @@
 package state
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/elmhuangyu/dotman/pkg/module/filesystem"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,28 +17,86 @@ const (
 
 	TypeLink      = "link"
 	TypeGenerated = "generated"
+	TypeCopy      = "copy"
+	// TypeDir is an empty directory created for a keep file; Source is the keep file
+	TypeDir = "dir"
+	// TypeBlock is a marked block of generator output merged into a shared file; a target
+	// has one entry per block
+	TypeBlock = "block"
 )
 
 type FileMapping struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
-	Type   string `yaml:"type"`           // link, generated
-	SHA1   string `yaml:"sha1,omitempty"` // only for generated file
+	Source string `yaml:"source" json:"source"`
+	Target string `yaml:"target" json:"target"`
+	Type   string `yaml:"type" json:"type"`                     // link, generated, copy, dir, block
+	SHA1   string `yaml:"sha1,omitempty" json:"sha1,omitempty"` // only for generated and copied files, and the content of blocks
+	// Mode is the octal permission bits the file was installed with, only for generated and
+	// copied files; empty for entries recorded before modes were tracked
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Block names the marked block of a block entry, which is its module
+	Block string `yaml:"block,omitempty" json:"block,omitempty"`
+	// SourceSHA1 is the SHA1 of a link's source when it was last verified, for detecting drift
+	// of the source content; empty when the link was never verified
+	SourceSHA1 string `yaml:"source_sha1,omitempty" json:"source_sha1,omitempty"`
+	// Vars are the merged vars a generated file was rendered with, recorded when the install
+	// asked for it so regeneration reproduces the file exactly; nil when not recorded
+	Vars map[string]string `yaml:"vars,omitempty" json:"vars,omitempty"`
+}
+
+// FileMode returns the recorded permission bits; ok is false when none were recorded
+func (m FileMapping) FileMode() (os.FileMode, bool) {
+	if m.Mode == "" {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(m.Mode, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(mode).Perm(), true
+}
+
+// FormatMode formats the permission bits of mode as recorded in FileMapping.Mode
+func FormatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// KnownType reports whether fileType is an entry type this version of dotman handles
+func KnownType(fileType string) bool {
+	switch fileType {
+	case TypeLink, TypeGenerated, TypeCopy, TypeDir, TypeBlock:
+		return true
+	}
+	return false
 }
 
 type StateFile struct {
-	Version string        `yaml:"version"`
-	Files   []FileMapping `yaml:"files"`
+	Version string `yaml:"version"`
+	// Relative stores sources relative to the dotfiles dir and targets relative
+	// to the home dir on disk, so the state file can move between machines.
+	// In memory paths are always absolute.
+	Relative bool `yaml:"relative,omitempty"`
+	// Generation counts the compactions of the state log; the log only applies to the
+	// snapshot of the same generation
+	Generation int           `yaml:"generation,omitempty"`
+	Files      []FileMapping `yaml:"files"`
 }
 
-// LoadStateFile loads the state file from the given path
+// LoadStateFile loads the state file from the given path, replaying its log over the snapshot
 func LoadStateFile(path string) (*StateFile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read state file: %w", err)
+		}
+		// Without a snapshot the log, if any, starts from an empty state file
+		if _, err := os.Stat(LogPath(path)); err != nil {
 			return nil, nil // State file doesn't exist, return nil
 		}
-		return nil, fmt.Errorf("failed to read state file: %w", err)
+		stateFile := NewStateFile()
+		if err := replayLog(LogPath(path), stateFile); err != nil {
+			return nil, err
+		}
+		return stateFile, nil
 	}
 
 	var stateFile StateFile
@@ -45,10 +104,27 @@ func LoadStateFile(path string) (*StateFile, error) {
 		return nil, fmt.Errorf("failed to parse state file: %w", err)
 	}
 
+	// Rehydrate portable paths against this machine's directories
+	if stateFile.Relative {
+		sourceRoot, targetRoot, err := relativeRoots(path)
+		if err != nil {
+			return nil, err
+		}
+		for i := range stateFile.Files {
+			stateFile.Files[i].Source = absolutize(sourceRoot, stateFile.Files[i].Source)
+			stateFile.Files[i].Target = absolutize(targetRoot, stateFile.Files[i].Target)
+		}
+	}
+
+	if err := replayLog(LogPath(path), &stateFile); err != nil {
+		return nil, err
+	}
+
 	return &stateFile, nil
 }
 
-// SaveStateFile saves the state file to the given path atomically
+// SaveStateFile saves the state file to the given path atomically. An existing log is superseded:
+// the snapshot is written with the next generation before the log is removed.
 func SaveStateFile(path string, stateFile *StateFile) error {
 	// Ensure directory exists
 	dir := filepath.Dir(path)
@@ -56,8 +132,32 @@ func SaveStateFile(path string, stateFile *StateFile) error {
 		return fmt.Errorf("failed to create state directory: %w", err)
 	}
 
+	logPath := LogPath(path)
+	_, err := os.Stat(logPath)
+	hasLog := err == nil
+	if hasLog {
+		stateFile.Generation++
+	}
+
+	// Store portable paths, leaving the in-memory state untouched
+	toWrite := stateFile
+	if stateFile.Relative {
+		sourceRoot, targetRoot, err := relativeRoots(path)
+		if err != nil {
+			return err
+		}
+		portable := *stateFile
+		portable.Files = make([]FileMapping, len(stateFile.Files))
+		for i, mapping := range stateFile.Files {
+			mapping.Source = relativize(sourceRoot, mapping.Source)
+			mapping.Target = relativize(targetRoot, mapping.Target)
+			portable.Files[i] = mapping
+		}
+		toWrite = &portable
+	}
+
 	// Marshal to YAML
-	data, err := yaml.Marshal(stateFile)
+	data, err := yaml.Marshal(toWrite)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state file: %w", err)
 	}
@@ -74,6 +174,12 @@ func SaveStateFile(path string, stateFile *StateFile) error {
 		return fmt.Errorf("failed to rename state file: %w", err)
 	}
 
+	if hasLog {
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove state log: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -111,26 +217,100 @@ func (sf *StateFile) AddFileMapping(source, target, fileType string) {
 		Type:   fileType,
 	}
 
-	// Calculate SHA1 for generated files
-	if fileType == TypeGenerated {
+	// Calculate SHA1 for generated and copied files, so modifications are detected on uninstall
+	if fileType == TypeGenerated || fileType == TypeCopy {
 		if sha1, err := calculateSHA1(absTarget); err != nil {
 			// Log warning but continue - SHA1 failure shouldn't break installation
 			fmt.Printf("Warning: failed to calculate SHA1 for %s: %v\n", absTarget, err)
 		} else {
 			mapping.SHA1 = sha1
 		}
+		// Record the installed mode, so it is reapplied on reinstall and drift can be repaired
+		if info, err := os.Stat(absTarget); err == nil {
+			mapping.Mode = FormatMode(info.Mode())
+		}
 	}
 
 	sf.Files = append(sf.Files, mapping)
 }
 
+// RecordedMode returns the mode recorded for target; ok is false when target is not tracked
+// or was recorded without a mode
+func (sf *StateFile) RecordedMode(target string) (os.FileMode, bool) {
+	if sf == nil {
+		return 0, false
+	}
+	for _, file := range sf.Files {
+		if file.Target == target {
+			return file.FileMode()
+		}
+	}
+	return 0, false
+}
+
+// SetSourceSHA1 records the source hash of the link entries of target
+func (sf *StateFile) SetSourceSHA1(target, sha1 string) {
+	for i := range sf.Files {
+		if sf.Files[i].Target == target && sf.Files[i].Type == TypeLink {
+			sf.Files[i].SourceSHA1 = sha1
+		}
+	}
+}
+
+// SetVars records the vars the generated entry of target was rendered with
+func (sf *StateFile) SetVars(target string, vars map[string]string) {
+	for i := range sf.Files {
+		if sf.Files[i].Target == target && sf.Files[i].Type == TypeGenerated {
+			sf.Files[i].Vars = vars
+		}
+	}
+}
+
+// SetBlock records the block of a shared target with the SHA1 of its content, replacing an
+// earlier entry for the same block
+func (sf *StateFile) SetBlock(source, target, block, sha1 string) {
+	sf.RemoveBlock(target, block)
+	sf.Files = append(sf.Files, FileMapping{
+		Source: source,
+		Target: target,
+		Type:   TypeBlock,
+		SHA1:   sha1,
+		Block:  block,
+	})
+}
+
+// UnknownTypes returns the entries whose type is not known, such as ones from a hand-edited
+// state file. They are loaded as is but no operation handles them.
+func (sf *StateFile) UnknownTypes() []FileMapping {
+	var unknown []FileMapping
+	for _, mapping := range sf.Files {
+		if !KnownType(mapping.Type) {
+			unknown = append(unknown, mapping)
+		}
+	}
+	return unknown
+}
+
+// RemoveBlock removes the entry of one block of target, leaving the other blocks of the target
+func (sf *StateFile) RemoveBlock(target, block string) {
+	remainingFiles := sf.Files[:0]
+	for _, mapping := range sf.Files {
+		if mapping.Type == TypeBlock && mapping.Target == target && mapping.Block == block {
+			continue
+		}
+		remainingFiles = append(remainingFiles, mapping)
+	}
+	sf.Files = remainingFiles
+}
+
 // AddMapping adds a file mapping to the state file (package-level function)
 func AddMapping(stateFile *StateFile, source, target, fileType string) error {
 	stateFile.AddFileMapping(source, target, fileType)
 	return nil
 }
 
-// RemoveMappings removes file mappings from the state file by target paths
+// RemoveMappings removes file mappings from the state file by target paths, including every
+// block of a shared target
 func RemoveMappings(stateFile *StateFile, targets []string) error {
 	// Create a set of targets to remove for efficient lookup
 	targetSet := make(map[string]bool)
@@ -161,19 +341,108 @@ func RemoveMappings(stateFile *StateFile, targets []string) error {
 	return nil
 }
 
-// calculateSHA1 computes the SHA1 hash of a file's content
-func calculateSHA1(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+// DuplicateTarget describes a target recorded by more than one state entry
+type DuplicateTarget struct {
+	Target string
+	// Kept is the entry left in the state file
+	Kept FileMapping
+	// Removed are the older entries for the same target
+	Removed []FileMapping
+}
+
+// Conflicting reports whether any removed entry recorded a different source than the kept one
+func (d DuplicateTarget) Conflicting() bool {
+	for _, mapping := range d.Removed {
+		if mapping.Source != d.Kept.Source {
+			return true
+		}
+	}
+	return false
+}
+
+// Dedupe keeps only the most recent entry for each target and returns the duplicates it removed.
+// Entries are appended as files are installed, so the last entry for a target is the most recent.
+// The blocks of a shared target are separate entries, deduplicated per block.
+func (sf *StateFile) Dedupe() []DuplicateTarget {
+	last := make(map[dedupeKey]int)
+	for i, mapping := range sf.Files {
+		last[keyOf(mapping)] = i
+	}
+
+	removed := make(map[dedupeKey][]FileMapping)
+	var order []dedupeKey
+	remainingFiles := make([]FileMapping, 0, len(last))
+	for i, mapping := range sf.Files {
+		key := keyOf(mapping)
+		if last[key] == i {
+			remainingFiles = append(remainingFiles, mapping)
+			continue
+		}
+		if _, seen := removed[key]; !seen {
+			order = append(order, key)
+		}
+		removed[key] = append(removed[key], mapping)
+	}
+
+	var duplicates []DuplicateTarget
+	for _, key := range order {
+		duplicates = append(duplicates, DuplicateTarget{
+			Target:  key.target,
+			Kept:    sf.Files[last[key]],
+			Removed: removed[key],
+		})
+	}
+
+	sf.Files = remainingFiles
+	return duplicates
+}
+
+// dedupeKey identifies the entries Dedupe treats as duplicates
+type dedupeKey struct {
+	target string
+	block  string
+}
+
+// keyOf returns the dedupe key of mapping
+func keyOf(mapping FileMapping) dedupeKey {
+	return dedupeKey{target: filepath.Clean(mapping.Target), block: mapping.Block}
+}
+
+// relativeRoots returns the directories relative state paths are resolved against:
+// the directory containing the state file for sources and the home dir for targets
+func relativeRoots(statePath string) (string, string, error) {
+	sourceRoot, err := filepath.Abs(filepath.Dir(statePath))
 	if err != nil {
-		return "", fmt.Errorf("failed to open file for SHA1 calculation: %w", err)
+		return "", "", fmt.Errorf("failed to resolve state directory: %w", err)
 	}
-	defer file.Close()
+	targetRoot, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get home directory for relative state: %w", err)
+	}
+	return sourceRoot, targetRoot, nil
+}
+
+// relativize returns path relative to root, or path unchanged if it lies outside root
+func relativize(root, path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
 
-	hasher := sha1.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", fmt.Errorf("failed to read file for SHA1 calculation: %w", err)
+// absolutize resolves a relative path against root, leaving absolute paths unchanged
+func absolutize(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
 	}
+	return filepath.Join(root, path)
+}
 
-	hash := hasher.Sum(nil)
-	return fmt.Sprintf("%x", hash), nil
+// calculateSHA1 computes the SHA1 hash of a file's content
+func calculateSHA1(filePath string) (string, error) {
+	return filesystem.FileSHA1(filesystem.NewOperator(), filePath)
 }
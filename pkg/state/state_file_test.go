@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadStateFile(t *testing.T) {
@@ -62,6 +63,28 @@ func TestLoadStateFile(t *testing.T) {
 		assert.Equal(t, testState.Files[1].SHA1, loadedState.Files[1].SHA1)
 	})
 
+	t.Run("unknown types are loaded and reported", func(t *testing.T) {
+		statePath := filepath.Join(t.TempDir(), "state.yaml")
+		require.NoError(t, os.WriteFile(statePath, []byte(`version: 1.0.0
+files:
+  - source: /source/file1
+    target: /target/file1
+    type: link
+  - source: /source/file2
+    target: /target/file2
+    type: symlink
+`), 0644))
+
+		stateFile, err := LoadStateFile(statePath)
+		require.NoError(t, err)
+		require.Len(t, stateFile.Files, 2)
+		unknown := stateFile.UnknownTypes()
+		require.Len(t, unknown, 1)
+		assert.Equal(t, "/target/file2", unknown[0].Target)
+		assert.False(t, KnownType("symlink"))
+		assert.True(t, KnownType(TypeBlock))
+	})
+
 	t.Run("invalid file returns error", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		statePath := filepath.Join(tmpDir, "state.yaml")
@@ -188,6 +211,29 @@ func TestAddFileMapping(t *testing.T) {
 		assert.NotEmpty(t, stateFile.Files[0].SHA1)
 	})
 
+	t.Run("records the mode of generated and copied files", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		testFile := filepath.Join(tmpDir, "copied.sh")
+		require.NoError(t, os.WriteFile(testFile, []byte("#!/bin/sh"), 0644))
+		require.NoError(t, os.Chmod(testFile, 0750))
+
+		stateFile := NewStateFile()
+		stateFile.AddFileMapping("/source/copied.sh", testFile, TypeCopy)
+		stateFile.AddFileMapping("/source/link", filepath.Join(tmpDir, "link"), TypeLink)
+
+		assert.Equal(t, "0750", stateFile.Files[0].Mode)
+		mode, ok := stateFile.RecordedMode(testFile)
+		assert.True(t, ok)
+		assert.Equal(t, os.FileMode(0750), mode)
+
+		// Links and legacy entries have no recorded mode
+		assert.Empty(t, stateFile.Files[1].Mode)
+		_, ok = stateFile.RecordedMode(filepath.Join(tmpDir, "link"))
+		assert.False(t, ok)
+		_, ok = FileMapping{Target: testFile, Type: TypeGenerated}.FileMode()
+		assert.False(t, ok)
+	})
+
 	t.Run("handles SHA1 calculation error gracefully", func(t *testing.T) {
 		stateFile := NewStateFile()
 
@@ -201,3 +247,130 @@ func TestAddFileMapping(t *testing.T) {
 		assert.Empty(t, stateFile.Files[0].SHA1) // SHA1 should be empty on error
 	})
 }
+
+func TestRelativeStateFile(t *testing.T) {
+	machineA := t.TempDir()
+	machineB := t.TempDir()
+
+	t.Setenv("HOME", filepath.Join(machineA, "home"))
+	dotfilesA := filepath.Join(machineA, "dotfiles")
+	statePathA := filepath.Join(dotfilesA, "state.yaml")
+
+	stateFile := NewStateFile()
+	stateFile.Relative = true
+	stateFile.Files = []FileMapping{
+		{
+			Source: filepath.Join(dotfilesA, "bash", "bashrc"),
+			Target: filepath.Join(machineA, "home", ".bashrc"),
+			Type:   TypeLink,
+		},
+		{
+			Source: filepath.Join(dotfilesA, "git", "gitconfig.dot-tmpl"),
+			Target: filepath.Join(machineA, "home", ".config", "git", "config"),
+			Type:   TypeGenerated,
+			SHA1:   "abc123",
+		},
+		{
+			Source: filepath.Join(dotfilesA, "system", "hosts"),
+			Target: "/etc/hosts",
+			Type:   TypeLink,
+		},
+	}
+	require.NoError(t, SaveStateFile(statePathA, stateFile))
+
+	// Saving keeps the in-memory paths absolute
+	assert.Equal(t, filepath.Join(dotfilesA, "bash", "bashrc"), stateFile.Files[0].Source)
+
+	// On disk, paths inside the roots are relative
+	var onDisk StateFile
+	data, err := os.ReadFile(statePathA)
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(data, &onDisk))
+	assert.True(t, onDisk.Relative)
+	assert.Equal(t, filepath.Join("bash", "bashrc"), onDisk.Files[0].Source)
+	assert.Equal(t, ".bashrc", onDisk.Files[0].Target)
+	assert.Equal(t, filepath.Join(".config", "git", "config"), onDisk.Files[1].Target)
+	assert.Equal(t, "/etc/hosts", onDisk.Files[2].Target)
+
+	// Copy the state file to a machine with different base directories
+	t.Setenv("HOME", filepath.Join(machineB, "home"))
+	dotfilesB := filepath.Join(machineB, "dotfiles")
+	statePathB := filepath.Join(dotfilesB, "state.yaml")
+	require.NoError(t, os.MkdirAll(dotfilesB, 0755))
+	require.NoError(t, os.WriteFile(statePathB, data, 0644))
+
+	loaded, err := LoadStateFile(statePathB)
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.True(t, loaded.Relative)
+	require.Len(t, loaded.Files, 3)
+	assert.Equal(t, filepath.Join(dotfilesB, "bash", "bashrc"), loaded.Files[0].Source)
+	assert.Equal(t, filepath.Join(machineB, "home", ".bashrc"), loaded.Files[0].Target)
+	assert.Equal(t, filepath.Join(machineB, "home", ".config", "git", "config"), loaded.Files[1].Target)
+	assert.Equal(t, "abc123", loaded.Files[1].SHA1)
+	assert.Equal(t, "/etc/hosts", loaded.Files[2].Target)
+
+	// Round trip back to machine A yields the original paths
+	require.NoError(t, SaveStateFile(statePathB, loaded))
+	data, err = os.ReadFile(statePathB)
+	require.NoError(t, err)
+	t.Setenv("HOME", filepath.Join(machineA, "home"))
+	require.NoError(t, os.WriteFile(statePathA, data, 0644))
+
+	roundTrip, err := LoadStateFile(statePathA)
+	require.NoError(t, err)
+	assert.Equal(t, stateFile.Files, roundTrip.Files)
+}
+
+func TestDedupe(t *testing.T) {
+	stateFile := &StateFile{
+		Version: version,
+		Files: []FileMapping{
+			{Source: "/dotfiles/vim/vimrc", Target: "/home/user/.vimrc", Type: TypeLink},
+			{Source: "/dotfiles/zsh/zshrc", Target: "/home/user/.zshrc", Type: TypeLink},
+			{Source: "/dotfiles/vim/vimrc", Target: "/home/user/.vimrc", Type: TypeLink},
+			{Source: "/dotfiles/old/zshrc", Target: "/home/user/.zshrc", Type: TypeLink},
+			{Source: "/dotfiles/git/gitconfig", Target: "/home/user/.gitconfig", Type: TypeLink},
+		},
+	}
+
+	duplicates := stateFile.Dedupe()
+
+	require.Len(t, duplicates, 2)
+	assert.Equal(t, "/home/user/.vimrc", duplicates[0].Target)
+	assert.Len(t, duplicates[0].Removed, 1)
+	assert.False(t, duplicates[0].Conflicting())
+
+	// The later entry wins, even though its source differs
+	assert.Equal(t, "/home/user/.zshrc", duplicates[1].Target)
+	assert.Equal(t, "/dotfiles/old/zshrc", duplicates[1].Kept.Source)
+	assert.Equal(t, "/dotfiles/zsh/zshrc", duplicates[1].Removed[0].Source)
+	assert.True(t, duplicates[1].Conflicting())
+
+	assert.Equal(t, []FileMapping{
+		{Source: "/dotfiles/vim/vimrc", Target: "/home/user/.vimrc", Type: TypeLink},
+		{Source: "/dotfiles/old/zshrc", Target: "/home/user/.zshrc", Type: TypeLink},
+		{Source: "/dotfiles/git/gitconfig", Target: "/home/user/.gitconfig", Type: TypeLink},
+	}, stateFile.Files)
+
+	// Deduplicating again finds nothing
+	assert.Empty(t, stateFile.Dedupe())
+}
+
+func TestBlocks(t *testing.T) {
+	stateFile := NewStateFile()
+	stateFile.SetBlock("/dotfiles/git/Dotfile", "/home/user/.ssh/config", "git", "a")
+	stateFile.SetBlock("/dotfiles/work/Dotfile", "/home/user/.ssh/config", "work", "b")
+	stateFile.SetBlock("/dotfiles/git/Dotfile", "/home/user/.ssh/config", "git", "c")
+
+	// Blocks of the same target are separate entries, replaced per block
+	assert.Equal(t, []FileMapping{
+		{Source: "/dotfiles/work/Dotfile", Target: "/home/user/.ssh/config", Type: TypeBlock, SHA1: "b", Block: "work"},
+		{Source: "/dotfiles/git/Dotfile", Target: "/home/user/.ssh/config", Type: TypeBlock, SHA1: "c", Block: "git"},
+	}, stateFile.Files)
+	assert.Empty(t, stateFile.Dedupe())
+
+	stateFile.RemoveBlock("/home/user/.ssh/config", "work")
+	require.Len(t, stateFile.Files, 1)
+	assert.Equal(t, "git", stateFile.Files[0].Block)
+}
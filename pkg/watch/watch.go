@@ -0,0 +1,202 @@
+// Package watch re-runs an installation whenever files in the dotfiles directory change
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elmhuangyu/dotman/pkg/config"
+	"github.com/elmhuangyu/dotman/pkg/logger"
+	"github.com/elmhuangyu/dotman/pkg/state"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// DefaultDebounce is how long changes must settle before the installation runs
+const DefaultDebounce = 500 * time.Millisecond
+
+// EventType classifies the progress events of Watch
+type EventType string
+
+const (
+	// EventChanged reports the settled changes about to trigger an installation
+	EventChanged EventType = "changed"
+	// EventInstalled reports an installation that finished without error
+	EventInstalled EventType = "installed"
+	// EventFailed reports an installation that returned an error
+	EventFailed EventType = "failed"
+	// EventError reports an error of the watcher itself; watching continues
+	EventError EventType = "error"
+)
+
+// Event is a progress event of Watch
+type Event struct {
+	Type EventType
+	// Paths are the changed paths of an EventChanged, sorted
+	Paths []string
+	// Err is the error of an EventFailed or EventError
+	Err error
+}
+
+// Options configures Watch
+type Options struct {
+	// Install runs the installation after changes settle; it should be idempotent
+	Install func(ctx context.Context) error
+	// Debounce is how long changes must settle first; zero uses DefaultDebounce
+	Debounce time.Duration
+	// OnEvent receives progress events; nil drops them
+	OnEvent func(Event)
+	// Context stops watching when cancelled; defaults to context.Background() when nil
+	Context context.Context
+	// Logger receives progress output; defaults to the global logger when nil
+	Logger *zerolog.Logger
+}
+
+// Watch watches dotfilesDir and its subdirectories, calling opts.Install once changes have
+// settled for the debounce duration. State files and version control metadata are ignored,
+// so the installation's own writes don't trigger it again. It blocks until the context is
+// cancelled, which is not an error.
+func Watch(dotfilesDir string, opts Options) error {
+	if opts.Install == nil {
+		return fmt.Errorf("watch requires an install function")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := addTree(watcher.Add, dotfilesDir); err != nil {
+		return err
+	}
+	return run(dotfilesDir, watcher.Events, watcher.Errors, watcher.Add, opts)
+}
+
+// run debounces events and runs the installation; add starts watching a directory
+func run(dotfilesDir string, events <-chan fsnotify.Event, errs <-chan error, add func(string) error, opts Options) error {
+	log := logger.OrDefault(opts.Logger)
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	emit := func(event Event) {
+		if opts.OnEvent != nil {
+			opts.OnEvent(event)
+		}
+	}
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if ignored(dotfilesDir, event) {
+				continue
+			}
+			// Directories created after watching started are watched too
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addTree(add, event.Name); err != nil {
+						log.Warn().Err(err).Str("path", event.Name).Msg("Failed to watch new directory")
+						emit(Event{Type: EventError, Err: err})
+					}
+				}
+			}
+			pending[event.Name] = true
+			timer.Reset(debounce)
+
+		case err, ok := <-errs:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Msg("File watcher error")
+			emit(Event{Type: EventError, Err: err})
+
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+
+			log.Info().Strs("paths", paths).Msg("Sources changed, reinstalling")
+			emit(Event{Type: EventChanged, Paths: paths})
+			if err := opts.Install(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Error().Err(err).Msg("Reinstall failed, waiting for further changes")
+				emit(Event{Type: EventFailed, Err: err})
+				continue
+			}
+			emit(Event{Type: EventInstalled})
+		}
+	}
+}
+
+// ignored reports whether event can't affect an installation: permission changes, version
+// control metadata, and the state and hash cache files the installation writes itself
+func ignored(dotfilesDir string, event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return true
+	}
+
+	rel, err := filepath.Rel(dotfilesDir, event.Name)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if slices.Contains(config.DefaultVCSExcludes, part) {
+			return true
+		}
+	}
+
+	// State files are saved through a temporary file next to them
+	if filepath.Dir(rel) == "." {
+		if state.IsReservedFileName(strings.TrimSuffix(rel, ".tmp")) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree watches root and every directory below it, except version control metadata
+func addTree(add func(string) error, root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && slices.Contains(config.DefaultVCSExcludes, entry.Name()) {
+			return filepath.SkipDir
+		}
+		if err := add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,159 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDebounce = 20 * time.Millisecond
+
+// harness drives run with synthetic fsnotify events
+type harness struct {
+	events  chan fsnotify.Event
+	errs    chan error
+	added   chan string
+	done    chan error
+	mu      sync.Mutex
+	got     []Event
+	install chan struct{}
+}
+
+func newHarness(t *testing.T, dotfilesDir string, installErr error) *harness {
+	ctx, cancel := context.WithCancel(context.Background())
+	log := zerolog.Nop()
+	h := &harness{
+		events:  make(chan fsnotify.Event),
+		errs:    make(chan error),
+		added:   make(chan string, 10),
+		done:    make(chan error, 1),
+		install: make(chan struct{}, 10),
+	}
+	opts := Options{
+		Install: func(ctx context.Context) error {
+			h.install <- struct{}{}
+			return installErr
+		},
+		Debounce: testDebounce,
+		OnEvent: func(event Event) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.got = append(h.got, event)
+		},
+		Context: ctx,
+		Logger:  &log,
+	}
+	add := func(path string) error {
+		h.added <- path
+		return nil
+	}
+	go func() {
+		h.done <- run(dotfilesDir, h.events, h.errs, add, opts)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-h.done)
+	})
+	return h
+}
+
+// installs waits for the debounce to pass a few times and returns the number of installations
+func (h *harness) installs() int {
+	time.Sleep(5 * testDebounce)
+	return len(h.install)
+}
+
+func (h *harness) recorded() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Event(nil), h.got...)
+}
+
+func TestWatchDebouncesChanges(t *testing.T) {
+	dotfilesDir := t.TempDir()
+	h := newHarness(t, dotfilesDir, nil)
+
+	vimrc := filepath.Join(dotfilesDir, "vim", "vimrc")
+	zshrc := filepath.Join(dotfilesDir, "zsh", "zshrc")
+	h.events <- fsnotify.Event{Name: vimrc, Op: fsnotify.Write}
+	h.events <- fsnotify.Event{Name: vimrc, Op: fsnotify.Write}
+	h.events <- fsnotify.Event{Name: zshrc, Op: fsnotify.Create}
+
+	assert.Equal(t, 1, h.installs())
+	assert.Equal(t, []Event{
+		{Type: EventChanged, Paths: []string{vimrc, zshrc}},
+		{Type: EventInstalled},
+	}, h.recorded())
+
+	// A later change installs again
+	h.events <- fsnotify.Event{Name: vimrc, Op: fsnotify.Write}
+	assert.Equal(t, 2, h.installs())
+}
+
+func TestWatchIgnoresOwnWrites(t *testing.T) {
+	dotfilesDir := t.TempDir()
+	h := newHarness(t, dotfilesDir, nil)
+
+	for _, name := range []string{
+		"state.yaml",
+		"state.yaml.tmp",
+		"state.work.yaml",
+		".dotman-cache.yaml",
+		filepath.Join(".git", "index"),
+		filepath.Join("vim", ".git", "HEAD"),
+	} {
+		h.events <- fsnotify.Event{Name: filepath.Join(dotfilesDir, name), Op: fsnotify.Write}
+	}
+	h.events <- fsnotify.Event{Name: filepath.Join(dotfilesDir, "vim", "vimrc"), Op: fsnotify.Chmod}
+
+	assert.Equal(t, 0, h.installs())
+	assert.Empty(t, h.recorded())
+}
+
+func TestWatchReportsFailedInstall(t *testing.T) {
+	dotfilesDir := t.TempDir()
+	installErr := errors.New("conflicts detected")
+	h := newHarness(t, dotfilesDir, installErr)
+
+	h.events <- fsnotify.Event{Name: filepath.Join(dotfilesDir, "vim", "vimrc"), Op: fsnotify.Write}
+	assert.Equal(t, 1, h.installs())
+	events := h.recorded()
+	require.Len(t, events, 2)
+	assert.Equal(t, Event{Type: EventFailed, Err: installErr}, events[1])
+
+	// Watching goes on after a failure
+	h.events <- fsnotify.Event{Name: filepath.Join(dotfilesDir, "vim", "vimrc"), Op: fsnotify.Write}
+	assert.Equal(t, 2, h.installs())
+}
+
+func TestWatchAddsNewDirectories(t *testing.T) {
+	dotfilesDir := t.TempDir()
+	h := newHarness(t, dotfilesDir, nil)
+
+	moduleDir := filepath.Join(dotfilesDir, "tmux")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "plugins"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, ".git"), 0755))
+	h.events <- fsnotify.Event{Name: moduleDir, Op: fsnotify.Create}
+
+	assert.Equal(t, 1, h.installs())
+	var added []string
+	for len(h.added) > 0 {
+		added = append(added, <-h.added)
+	}
+	assert.Equal(t, []string{moduleDir, filepath.Join(moduleDir, "plugins")}, added)
+}
+
+func TestWatchRequiresInstall(t *testing.T) {
+	err := Watch(t.TempDir(), Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "watch requires an install function")
+}